@@ -0,0 +1,160 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The client commands read and write these instead of the standard streams
+// directly so that the tests can capture them.
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+)
+
+// clientCommand is the "paste" command that turns the binary into a client
+// of a running instance's API.
+type clientCommand struct {
+	Server  string        `long:"server" env:"GOPB_SERVER" default:"http://localhost:8080" description:"URL of the go-pb instance"`
+	Token   string        `long:"token" env:"GOPB_TOKEN" description:"API token (JWT) of the user, pastes are anonymous without it"`
+	Timeout time.Duration `long:"timeout" env:"GOPB_CLIENT_TIMEOUT" default:"30s" description:"timeout of the API requests"`
+
+	Create clientCreate `command:"create" description:"create a paste from the standard input and print its URL"`
+	Get    clientGet    `command:"get" description:"print the body of a paste"`
+	Delete clientDelete `command:"delete" description:"delete one of your pastes"`
+}
+
+type clientCreate struct {
+	Title   string `long:"title" description:"paste title"`
+	Syntax  string `long:"syntax" default:"text" description:"syntax highlighting of the paste"`
+	Privacy string `long:"privacy" default:"public" choice:"private" choice:"public" choice:"unlisted" description:"paste privacy"`
+	Expires string `long:"expires" description:"paste expiration, e.g. 10m, 1d or never (default: the server's default)"`
+}
+
+type clientGet struct {
+	Password string `long:"password" description:"password of a protected paste"`
+	Args     struct {
+		ID string `positional-arg-name:"id" description:"paste ID or URL"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type clientDelete struct {
+	Args struct {
+		ID string `positional-arg-name:"id" description:"paste ID or URL"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute creates a paste from the standard input.
+func (c *clientCreate) Execute(_ []string) error {
+	body, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("reading the paste body: %w", err)
+	}
+	q := url.Values{}
+	q.Set("title", c.Title)
+	q.Set("syntax", c.Syntax)
+	q.Set("privacy", c.Privacy)
+	if c.Expires != "" {
+		q.Set("expires", c.Expires)
+	}
+	req, err := opts.Client.request(http.MethodPost, "/api/v1/paste?"+q.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := opts.Client.do(req, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(stdout, resp.Body)
+	return err
+}
+
+// Execute prints the body of a paste.
+func (c *clientGet) Execute(_ []string) error {
+	req, err := opts.Client.request(http.MethodGet, "/api/v1/paste/"+pasteID(c.Args.ID), nil)
+	if err != nil {
+		return err
+	}
+	if c.Password != "" {
+		req.SetBasicAuth("", c.Password)
+	}
+	resp, err := opts.Client.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var paste struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		return fmt.Errorf("decoding the paste: %w", err)
+	}
+	_, err = io.WriteString(stdout, paste.Body)
+	return err
+}
+
+// Execute deletes a paste.
+func (c *clientDelete) Execute(_ []string) error {
+	req, err := opts.Client.request(http.MethodDelete, "/api/v1/paste/"+pasteID(c.Args.ID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := opts.Client.do(req, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// pasteID returns the paste ID from an ID or a paste URL.
+func pasteID(s string) string {
+	s = strings.TrimRight(s, "/")
+	return url.PathEscape(s[strings.LastIndex(s, "/")+1:])
+}
+
+// request returns a request to the API with the user's token.
+func (c *clientCommand) request(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(c.Server, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating the request: %w", err)
+	}
+	if c.Token != "" {
+		req.Header.Set("X-JWT", c.Token)
+	}
+	return req, nil
+}
+
+// do sends the request and returns the response if it has the expected
+// status, otherwise the error reported by the server.
+func (c *clientCommand) do(req *http.Request, status int) (*http.Response, error) {
+	client := http.Client{Timeout: c.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling the API: %w", err)
+	}
+	if resp.StatusCode == status {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &apiErr) == nil && apiErr.Error != "" {
+		msg = apiErr.Error
+	}
+	return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// runClient runs the command line with the given standard input and returns
+// what the command printed. The client tests share the global options, so
+// they don't run in parallel.
+func runClient(t *testing.T, in string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	stdin, stdout = strings.NewReader(in), &out
+	t.Cleanup(func() { stdin, stdout = os.Stdin, os.Stdout })
+
+	p := newParser()
+	p.Options &^= flags.PrintErrors
+	_, err := p.ParseArgs(args)
+	return out.String(), err
+}
+
+// fakeAPI is a minimal stand-in for the paste API of a running instance.
+func fakeAPI(t *testing.T) *httptest.Server {
+	pastes := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/paste", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("title") != "Test title" || r.URL.Query().Get("privacy") != "unlisted" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		pastes["abc"] = string(body)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "http://"+r.Host+"/p/abc\n") //nolint:errcheck
+	})
+	mux.HandleFunc("/api/v1/paste/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/paste/")
+		body, ok := pastes[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "paste not found"}) //nolint:errcheck
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]string{"body": body}) //nolint:errcheck
+		case http.MethodDelete:
+			if r.Header.Get("X-JWT") != "secret-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "login required"}) //nolint:errcheck
+				return
+			}
+			delete(pastes, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	srv := fakeAPI(t)
+
+	out, err := runClient(t, "Test body\n", "paste", "--server", srv.URL, "create", "--title", "Test title", "--privacy", "unlisted")
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	url := strings.TrimSpace(out)
+	if url != srv.URL+"/p/abc" {
+		t.Errorf("expected the paste URL to be printed, got %q", out)
+	}
+
+	// both the ID and the full URL work
+	for _, id := range []string{"abc", url} {
+		out, err = runClient(t, "", "paste", "--server", srv.URL, "get", id)
+		if err != nil {
+			t.Fatalf("get %s failed: %v", id, err)
+		}
+		if out != "Test body\n" {
+			t.Errorf("expected the body to be printed as is, got %q", out)
+		}
+	}
+
+	_, err = runClient(t, "", "paste", "--server", srv.URL, "delete", "abc")
+	if err == nil || !strings.Contains(err.Error(), "login required") {
+		t.Errorf("expected the server error without a token, got %v", err)
+	}
+	if _, err = runClient(t, "", "paste", "--server", srv.URL, "--token", "secret-token", "delete", "abc"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	_, err = runClient(t, "", "paste", "--server", srv.URL, "get", "abc")
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found: paste not found") {
+		t.Errorf("expected the paste to be gone, got %v", err)
+	}
+}
+
+func TestClientParsing(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want flags.ErrorType
+	}{
+		{"no subcommand", []string{"paste"}, flags.ErrCommandRequired},
+		{"unknown subcommand", []string{"paste", "list"}, flags.ErrUnknownCommand},
+		{"get without id", []string{"paste", "get"}, flags.ErrRequired},
+		{"delete without id", []string{"paste", "delete"}, flags.ErrRequired},
+		{"wrong privacy", []string{"paste", "create", "--privacy", "secret"}, flags.ErrInvalidChoice},
+	}
+	for _, tc := range tests {
+		_, err := runClient(t, "", tc.args...)
+		var fe *flags.Error
+		if !errors.As(err, &fe) || fe.Type != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	if _, err := runClient(t, "", "paste", "--server", "http://127.0.0.1:1", "get", "abc"); err == nil {
+		t.Errorf("expected an error when the server is unreachable")
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -24,13 +25,17 @@ var (
 
 var opts struct {
 	Timeouts struct {
-		Shutdown  time.Duration `long:"shutdown" env:"SHUTDOWN" default:"10s" description:"server graceful shutdown timeout"`
-		HTTPRead  time.Duration `long:"http-read" env:"HTTP_READ" default:"15s" description:"duration for reading the entire request"`
-		HTTPWrite time.Duration `long:"http-write" env:"HTTP_WRITE" default:"15s" description:"duration before timing out writes of the response"`
-		HTTPIdle  time.Duration `long:"http-idle" env:"HTTP_IDLE" default:"60s" description:"amount of time to wait for the next request"`
+		Shutdown       time.Duration `long:"shutdown" env:"SHUTDOWN" default:"10s" description:"server graceful shutdown timeout, requests in flight are given this long to finish while new ones get 503"`
+		HTTPRead       time.Duration `long:"http-read" env:"HTTP_READ" default:"15s" description:"duration for reading the entire request"`
+		HTTPWrite      time.Duration `long:"http-write" env:"HTTP_WRITE" default:"15s" description:"duration before timing out writes of the response"`
+		HTTPIdle       time.Duration `long:"http-idle" env:"HTTP_IDLE" default:"60s" description:"amount of time to wait for the next request"`
+		HTTPReadHeader time.Duration `long:"http-read-header" env:"HTTP_READ_HEADER" default:"5s" description:"duration for reading request headers"`
 	} `group:"timeout" namespace:"timeout" env-namespace:"GOPB_TIMEOUT"`
 	Web struct {
 		Proto          string `long:"proto" env:"PROTO" default:"http" choice:"http" choice:"https" description:"protocol part of the Web server address (http/https)"`
+		TLSCert        string `long:"tls-cert" env:"TLS_CERT" description:"path to the TLS certificate, required with https unless a reverse proxy terminates TLS"`
+		TLSKey         string `long:"tls-key" env:"TLS_KEY" description:"path to the TLS private key"`
+		RedirectHTTP   uint16 `long:"redirect-http-port" env:"REDIRECT_HTTP_PORT" description:"port of a plain HTTP listener that redirects to https, needs the TLS certificate or autocert (default: none)"`
 		Host           string `long:"host" env:"HOST" default:"localhost" description:"hostname part of the Web server address"`
 		Port           uint16 `long:"port" env:"PORT" default:"8080" description:"port part of the Web server address"`
 		LogFile        string `long:"log-file" env:"LOG_FILE" default:"" description:"full path to the log file, default is stdout"`
@@ -39,13 +44,46 @@ var opts struct {
 		BrandTagline   string `long:"brand-tagline" env:"BRAND_TAGLINE" default:"A nice and simple pastebin alternative that you can host yourself." description:"brand tagline shown below the brand name"`
 		Assets         string `long:"assets" env:"ASSETS" default:"./assets" description:"path to the assets folder"`
 		Templates      string `long:"templates" env:"TEMPLATES" default:"./templates" description:"path to the templates folder"`
+		HotReload      bool   `long:"hot-reload" env:"HOT_RELOAD" description:"reload the templates on every page in debug log mode, for template development"`
+		Embedded       bool   `long:"embedded" env:"EMBEDDED" description:"use the templates and assets built into the binary, they are also used when the folders don't exist"`
 		BootstrapTheme string `long:"bootstrap-theme" env:"BOOTSTRAP_THEME" default:"original" choice:"flatly" choice:"litera" choice:"materia" choice:"original" choice:"sandstone" choice:"yeti" choice:"zephyr" description:"name of the bootstrap theme to use [flatly, litera, materia, sandstone, yeti or zephyr]"`
-		Logo           string `long:"logo" env:"LOGO" default:"bighead.svg" description:"name of the logo image file within the assets folder"`
+		Logo           string `long:"logo" env:"LOGO" default:"bighead.svg" description:"logo image file within the assets folder, absolute path or URL"`
+		Favicon        string `long:"favicon" env:"FAVICON" description:"path to the favicon, default is favicon/favicon.ico in the assets folder"`
+		RobotsTxt      string `long:"robots-txt" env:"ROBOTS_TXT" description:"content of /robots.txt, default keeps crawlers away from everything but the pastes"`
+		Support        string `long:"support-message" env:"SUPPORT_MESSAGE" description:"message shown on internal error pages, e.g. how to contact support"`
+		Footer         string `long:"footer" env:"FOOTER" description:"HTML added to the footer of every page, e.g. a privacy policy link, it is not escaped"`
+		Landing        string `long:"landing-markdown" env:"LANDING_MARKDOWN" description:"markdown file shown to anonymous visitors at / instead of the new paste form"`
+		RecentPastes   int    `long:"recent-pastes" env:"RECENT_PASTES" default:"10" description:"number of recent pastes shown in the sidebar"`
+		Webhook        string `long:"webhook" env:"WEBHOOK" description:"URL to post new pastes to as JSON, e.g. a chat or moderation integration"`
 		MaxBodySize    int64  `long:"max-body-size" env:"MAX_BODY_SIZE" default:"10240" description:"maximum size for request's body"`
+		CookieSecret   string `long:"cookie-secret" env:"COOKIE_SECRET" default:"" description:"secret used to sign session cookies, defaults to auth-secret, required in production"`
+		CookieDomain   string `long:"cookie-domain" env:"COOKIE_DOMAIN" default:"" description:"domain for session cookies, default is the request host"`
+		CSP            string `long:"csp" env:"CSP" default:"" description:"value of the Content-Security-Policy header, default allows the bundled assets and the Bootstrap CDN"`
+		Compression    bool   `long:"compression" env:"COMPRESSION" description:"compress large text responses with gzip or deflate"`
+		CompressionMin int    `long:"compression-min-size" env:"COMPRESSION_MIN_SIZE" default:"1024" description:"smallest response size in bytes to compress"`
+		TimeFormat     string `long:"time-format" env:"TIME_FORMAT" default:"Jan 2, 2006 15:04 MST" description:"Go layout of the times shown in UTC when the browser can't convert them to the reader's timezone"`
+		TrustProxy     bool   `long:"trust-proxy" env:"TRUST_PROXY" description:"trust X-Forwarded-For/Proto/Host headers, only enable behind a reverse proxy"`
+		Metrics        bool   `long:"metrics" env:"METRICS" description:"expose Prometheus metrics on /metrics"`
+		ReadOnly       bool   `long:"read-only" env:"READ_ONLY" description:"start in maintenance mode, pastes can be read but not created or changed; SIGUSR1 or the admin page toggle it"`
+		AvatarDir      string `long:"avatar-dir" env:"AVATAR_DIR" default:"./data/avatars" description:"directory where user avatars are stored"`
+		AvatarS3       struct {
+			Bucket    string `long:"bucket" env:"BUCKET" default:"" description:"S3 bucket for user avatars, overrides avatar-dir if set"`
+			Region    string `long:"region" env:"REGION" default:"us-east-1" description:"S3 region"`
+			Endpoint  string `long:"endpoint" env:"ENDPOINT" default:"" description:"S3 endpoint, for S3 compatible storage such as MinIO"`
+			AccessKey string `long:"access-key" env:"ACCESS_KEY" default:"" description:"S3 access key"`
+			SecretKey string `long:"secret-key" env:"SECRET_KEY" default:"" description:"S3 secret key"`
+		} `group:"avatar-s3" namespace:"avatar-s3" env-namespace:"AVATAR_S3"`
+		TrustedCIDRs   []string `long:"trusted-cidr" env:"TRUSTED_CIDRS" env-delim:"," description:"network of internal clients, e.g. 10.0.0.0/8, that aren't limited per IP and have trusted-max-body-size, can be repeated (default: none)"`
+		TrustedMaxBody int64    `long:"trusted-max-body-size" env:"TRUSTED_MAX_BODY_SIZE" default:"0" description:"maximum size for request's body from the trusted networks, 0 means no limit"`
+		AutocertDomain []string `long:"autocert-domain" env:"AUTOCERT_DOMAINS" env-delim:"," description:"domain to get a Let's Encrypt certificate for instead of tls-cert, the server must be reachable on it at port 443, can be repeated (default: none)"`
+		AutocertDir    string   `long:"autocert-dir" env:"AUTOCERT_DIR" default:"./data/autocert" description:"directory where the Let's Encrypt certificates are kept"`
 	} `group:"web" namespace:"web" env-namespace:"GOPB_WEB"`
 	DB struct {
-		Type       string `long:"type" env:"TYPE" default:"memory" choice:"memory" choice:"postgres" choice:"disk" description:"database type to use for storage"`
-		Connection string `long:"connection" env:"CONNECTION" default:"" description:"database connection string, ignored for memory"`
+		Type            string        `long:"type" env:"TYPE" default:"memory" choice:"memory" choice:"postgres" choice:"disk" description:"database type to use for storage"`
+		Connection      string        `long:"connection" env:"CONNECTION" default:"" description:"database connection string, ignored for memory"`
+		MaxOpenConns    int           `long:"max-open-conns" env:"MAX_OPEN_CONNS" default:"25" description:"maximum number of open postgres connections, negative for no limit"`
+		MaxIdleConns    int           `long:"max-idle-conns" env:"MAX_IDLE_CONNS" default:"10" description:"maximum number of idle postgres connections, negative to keep none"`
+		ConnMaxLifetime time.Duration `long:"conn-max-lifetime" env:"CONN_MAX_LIFETIME" default:"30m" description:"maximum time a postgres connection is reused, negative for no limit"`
 	} `group:"db" namespace:"db" env-namespace:"GOPB_DB"`
 	Auth struct {
 		Secret         string        `long:"secret" env:"SECRET" default:"" description:"secret used for JWT token generation/verification"`
@@ -59,26 +97,97 @@ var opts struct {
 		GoogleCSEC     string        `long:"google-csec" env:"GOOGLE_CSEC" default:"" description:"google client secret used for oauth"`
 		TwitterCID     string        `long:"twitter-cid" env:"TWITTER_CID" default:"" description:"twitter client id used for oauth"`
 		TwitterCSEC    string        `long:"twitter-csec" env:"TWITTER_CSEC" default:"" description:"twitter client secret used for oauth"`
+		GiteaCID       string        `long:"gitea-cid" env:"GITEA_CID" default:"" description:"gitea client id used for oauth, callback is {url}/auth/gitea/callback"`
+		GiteaCSEC      string        `long:"gitea-csec" env:"GITEA_CSEC" default:"" description:"gitea client secret used for oauth"`
+		GiteaURL       string        `long:"gitea-url" env:"GITEA_URL" default:"" description:"gitea instance url, e.g. https://gitea.example.com"`
+		GitLabCID      string        `long:"gitlab-cid" env:"GITLAB_CID" default:"" description:"gitlab client id used for oauth, callback is {url}/auth/gitlab/callback"`
+		GitLabCSEC     string        `long:"gitlab-csec" env:"GITLAB_CSEC" default:"" description:"gitlab client secret used for oauth"`
+		GitLabURL      string        `long:"gitlab-url" env:"GITLAB_URL" default:"https://gitlab.com" description:"gitlab instance url"`
+		Dev            bool          `long:"dev" env:"DEV" description:"enable dev oauth provider and run dev oauth2 server on :8084, never use in production"`
+		Local          bool          `long:"local" env:"LOCAL" description:"enable local accounts with email and password, login page is /u/login"`
+		VerifyEmail    bool          `long:"verify-email" env:"VERIFY_EMAIL" description:"new local accounts must confirm their email address before login, needs an SMTP server"`
+		BcryptCost     int           `long:"bcrypt-cost" env:"BCRYPT_COST" default:"10" description:"bcrypt cost of paste and user password hashes, between 4 and 31"`
+		Admins         []string      `long:"admin" env:"ADMINS" env-delim:"," description:"ID of a user with admin rights, can be repeated"`
+		FirstAdmin     bool          `long:"first-user-admin" env:"FIRST_USER_ADMIN" description:"make the first user to register or login an admin, to bootstrap a new installation"`
+		MaxFailures    int           `long:"login-max-failures" env:"LOGIN_MAX_FAILURES" default:"5" description:"wrong passwords from one IP address that lock a local login out, 0 means never"`
+		LoginWindow    time.Duration `long:"login-window" env:"LOGIN_WINDOW" default:"15m" description:"period in which the wrong passwords lock the login out"`
+		Lockout        time.Duration `long:"lockout" env:"LOCKOUT" default:"15m" description:"how long a local login stays locked out"`
 	} `group:"auth" namespace:"auth" env-namespace:"GOPB_AUTH"`
+	Paste struct {
+		MinExpiration     time.Duration `long:"min-expiration" env:"MIN_EXPIRATION" default:"0s" description:"shortest allowed paste expiration, 0 means no limit"`
+		MaxExpiration     time.Duration `long:"max-expiration" env:"MAX_EXPIRATION" default:"0s" description:"longest allowed paste expiration, 0 means no limit"`
+		AnonExpiration    time.Duration `long:"anon-max-expiration" env:"ANON_MAX_EXPIRATION" default:"0s" description:"anonymous pastes expire no later than that, even if they ask for never; logged in users are not affected, 0 means no cap"`
+		ExpiringSoon      time.Duration `long:"expiring-soon" env:"EXPIRING_SOON" default:"24h" description:"the list page shows the user's pastes expiring within that above the other pastes, 0 hides them"`
+		RequireLogin      bool          `long:"require-login" env:"REQUIRE_LOGIN" description:"only logged in users can create pastes, the form and the API ask anonymous users to login"`
+		Comments          bool          `long:"comments" env:"COMMENTS" description:"let logged in users comment on pastes, comments on private pastes are only shown to the owner"`
+		MaxPerUser        int           `long:"max-per-user" env:"MAX_PER_USER" default:"0" description:"maximum number of pastes per user, 0 means no limit"`
+		MaxAnonymous      int           `long:"max-anonymous" env:"MAX_ANONYMOUS" default:"0" description:"maximum number of anonymous pastes per IP address, 0 means no limit"`
+		MaxTitle          int           `long:"max-title-length" env:"MAX_TITLE_LENGTH" default:"255" description:"longest paste title in characters, 0 means no limit"`
+		TruncateTitle     bool          `long:"truncate-titles" env:"TRUNCATE_TITLES" description:"cut the titles longer than max-title-length instead of rejecting the paste"`
+		PrivacyAnon       string        `long:"privacy-anon" env:"PRIVACY_ANON" default:"public" choice:"private" choice:"public" choice:"unlisted" description:"privacy of anonymous pastes when the form doesn't set it"`
+		PrivacyUser       string        `long:"privacy-user" env:"PRIVACY_USER" default:"public" choice:"private" choice:"public" choice:"unlisted" description:"privacy of logged in users' pastes when the form doesn't set it"`
+		MaxReports        int           `long:"max-reports" env:"MAX_REPORTS" default:"5" description:"maximum number of abuse reports per IP address per hour, 0 means no limit"`
+		URLKey            string        `long:"url-key" env:"URL_KEY" default:"" description:"secret key to obfuscate paste IDs in the URLs, so that they don't reveal the creation order; existing URLs keep working"`
+		IDBits            int           `long:"id-bits" env:"ID_BITS" default:"0" description:"bit width of the random paste IDs (16-63), fewer bits give shorter URLs and more retried collisions, 0 means 63"`
+		TrashRetention    time.Duration `long:"trash-retention" env:"TRASH_RETENTION" default:"168h" description:"how long deleted pastes are kept in the trash before they are deleted for good, 0 means deletes are permanent"`
+		SkipOwnerViews    bool          `long:"skip-owner-views" env:"SKIP_OWNER_VIEWS" description:"don't count views of the paste owner"`
+		BotUserAgents     []string      `long:"bot-user-agents" env:"BOT_USER_AGENTS" env-delim:"," description:"User-Agent substrings of bots whose views are not counted (default: common crawlers, link previews and http clients)"`
+		AllowPrivacyAnon  []string      `long:"allow-privacy-anon" env:"ALLOW_PRIVACY_ANON" env-delim:"," choice:"private" choice:"public" choice:"unlisted" description:"privacy anonymous users may choose, can be repeated (default: all)"`
+		AllowSyntaxes     []string      `long:"allow-syntax" env:"ALLOW_SYNTAXES" env-delim:"," description:"syntax new pastes may use, e.g. none,go,python, can be repeated (default: all)"`
+		ConfirmSize       int64         `long:"confirm-size" env:"CONFIRM_SIZE" default:"0" description:"body size in bytes above which new pastes must be confirmed, 0 means no limit"`
+		SecretPatterns    []string      `long:"secret-pattern" env:"SECRET_PATTERNS" env-delim:";" description:"name=regexp of a secret that new pastes must be confirmed for, can be repeated (default: AWS keys, private keys, GitHub and Slack tokens)"`
+		NoSecretScan      bool          `long:"no-secret-scan" env:"NO_SECRET_SCAN" description:"don't ask to confirm pastes that look like they contain secrets"`
+		BlockedPatterns   []string      `long:"blocked-pattern" env:"BLOCKED_PATTERNS" env-delim:";" description:"regexp of the content public pastes must not have in the title or the body, e.g. (?i)casino, can be repeated"`
+		UnlistBlocked     bool          `long:"unlist-blocked" env:"UNLIST_BLOCKED" description:"make the public pastes with blocked content unlisted instead of rejecting them"`
+		Encoding          string        `long:"encoding" env:"ENCODING" default:"keep" choice:"keep" choice:"convert" choice:"replace" description:"bodies that are not UTF-8: keep them as they are, convert them from Windows-1252 or reject them, or convert them and replace what can't be"`
+		Expiration        string        `long:"default-expiration" env:"DEFAULT_EXPIRATION" default:"" description:"expiration of new pastes that don't set one, e.g. 1M for pastes to expire in a month by default (default: never)"`
+		StatsTTL          time.Duration `long:"stats-ttl" env:"STATS_TTL" default:"1m" description:"how long the instance stats of /api/v1/stats are cached, 0 means they are collected on every request"`
+		StatsAllSyntaxes  bool          `long:"stats-all-syntaxes" env:"STATS_ALL_SYNTAXES" description:"include private and unlisted pastes in the per syntax breakdown of the instance stats"`
+		ExpirationPresets []string      `long:"expiration-presets" env:"EXPIRATION_PRESETS" env-delim:"," description:"expiration options for the new paste form, e.g. 10m,1h,1d,1w,never (default: all presets within the allowed bounds)"`
+	} `group:"paste" namespace:"paste" env-namespace:"GOPB_PASTE"`
+	SMTP struct {
+		Host string `long:"host" env:"HOST" default:"" description:"SMTP server for outgoing emails, emails are written to the log if empty"`
+		Port int    `long:"port" env:"PORT" default:"587" description:"SMTP server port"`
+		User string `long:"user" env:"USER" default:"" description:"SMTP user, no authentication if empty"`
+		Pass string `long:"pass" env:"PASS" default:"" description:"SMTP password"`
+		From string `long:"from" env:"FROM" default:"go-pb@localhost" description:"sender address of the outgoing emails"`
+	} `group:"smtp" namespace:"smtp" env-namespace:"GOPB_SMTP"`
 	Debug   bool             `long:"debug" env:"GOPB_DEBUG" description:"debug mode"`
 	LogFile string           `long:"log-file" env:"GOPB_LOG_FILE" default:"" description:"full path to the log file, default is stdout"`
 	Disk    store.DiskConfig `group:"disk" namespace:"disk" env-namespace:"GOPB_DISK"`
+	Client  clientCommand    `command:"paste" description:"create, get or delete pastes on a running instance instead of running the server"`
 }
 
-func main() {
-	// Say hello
-	fmt.Printf("go-pb %s\n", version)
-
-	// Parse the flags
+// newParser returns the command line parser, the server runs when no
+// command is given.
+func newParser() *flags.Parser {
 	p := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash|flags.HelpFlag)
 	p.NamespaceDelimiter = "-"
 	p.EnvNamespaceDelimiter = "_"
+	p.SubcommandsOptional = true
+	return p
+}
+
+func main() {
+	// Parse the flags, the client commands run while parsing
+	p := newParser()
 	if _, err := p.Parse(); err != nil {
-		if err.(*flags.Error).Type != flags.ErrHelp {
+		var fe *flags.Error
+		if !errors.As(err, &fe) {
+			os.Exit(1) // a client command failed, the parser has printed the error
+		}
+		if fe.Type != flags.ErrHelp {
 			fmt.Printf("[ERROR] cli error: %v", err)
 		}
 		os.Exit(2)
 	}
+	if p.Active != nil {
+		return
+	}
+
+	// Say hello
+	fmt.Printf("go-pb %s\n", version)
+	opts.Disk.CreateDir = !opts.Disk.NoCreateDir
 
 	log := setupLog(opts.Debug, opts.LogFile)
 
@@ -88,37 +197,133 @@ func main() {
 
 	// Start the server
 	webServer := web.New(log, web.ServerOptions{
-		Addr:               opts.Web.Host + ":" + fmt.Sprintf("%d", opts.Web.Port),
-		Proto:              opts.Web.Proto,
-		ReadTimeout:        opts.Timeouts.HTTPRead,
-		WriteTimeout:       opts.Timeouts.HTTPWrite,
-		IdleTimeout:        opts.Timeouts.HTTPIdle,
-		LogFile:            opts.Web.LogFile,
-		LogMode:            opts.Web.LogMode,
-		BrandName:          opts.Web.BrandName,
-		BrandTagline:       opts.Web.BrandTagline,
-		Assets:             opts.Web.Assets,
-		Templates:          opts.Web.Templates,
-		Logo:               opts.Web.Logo,
-		MaxBodySize:        opts.Web.MaxBodySize,
-		BootstrapTheme:     opts.Web.BootstrapTheme,
-		Version:            version,
-		AuthSecret:         opts.Auth.Secret,
-		AuthTokenDuration:  opts.Auth.TokenDuration,
-		AuthCookieDuration: opts.Auth.CookieDuration,
-		AuthIssuer:         opts.Auth.Issuer,
-		AuthURL:            opts.Auth.URL,
-		DBType:             opts.DB.Type,
-		DBConn:             opts.DB.Connection,
-		GitHubCID:          opts.Auth.GitHubCID,
-		GitHubCSEC:         opts.Auth.GitHubCSEC,
-		GoogleCID:          opts.Auth.GoogleCID,
-		GoogleCSEC:         opts.Auth.GoogleCSEC,
-		TwitterCID:         opts.Auth.TwitterCID,
-		TwitterCSEC:        opts.Auth.TwitterCSEC,
-		DiskConfig:         opts.Disk,
+		Addr:                     opts.Web.Host + ":" + fmt.Sprintf("%d", opts.Web.Port),
+		Proto:                    opts.Web.Proto,
+		TLSCert:                  opts.Web.TLSCert,
+		TLSKey:                   opts.Web.TLSKey,
+		AutocertDomains:          opts.Web.AutocertDomain,
+		AutocertCacheDir:         opts.Web.AutocertDir,
+		RedirectHTTPPort:         int(opts.Web.RedirectHTTP),
+		ReadTimeout:              opts.Timeouts.HTTPRead,
+		WriteTimeout:             opts.Timeouts.HTTPWrite,
+		IdleTimeout:              opts.Timeouts.HTTPIdle,
+		ReadHeaderTimeout:        opts.Timeouts.HTTPReadHeader,
+		LogFile:                  opts.Web.LogFile,
+		LogMode:                  opts.Web.LogMode,
+		BrandName:                opts.Web.BrandName,
+		BrandTagline:             opts.Web.BrandTagline,
+		TimeFormat:               opts.Web.TimeFormat,
+		Assets:                   opts.Web.Assets,
+		Templates:                opts.Web.Templates,
+		UseEmbedded:              opts.Web.Embedded,
+		HotReloadTemplates:       opts.Web.HotReload,
+		Logo:                     opts.Web.Logo,
+		Favicon:                  opts.Web.Favicon,
+		FooterHTML:               opts.Web.Footer,
+		LandingMarkdownFile:      opts.Web.Landing,
+		RecentPastes:             opts.Web.RecentPastes,
+		SupportMessage:           opts.Web.Support,
+		RobotsTxt:                opts.Web.RobotsTxt,
+		WebhookURL:               opts.Web.Webhook,
+		MaxBodySize:              opts.Web.MaxBodySize,
+		TrustedCIDRs:             opts.Web.TrustedCIDRs,
+		TrustedMaxBodySize:       opts.Web.TrustedMaxBody,
+		BootstrapTheme:           opts.Web.BootstrapTheme,
+		Version:                  version,
+		AuthSecret:               opts.Auth.Secret,
+		CookieSecret:             opts.Web.CookieSecret,
+		CookieDomain:             opts.Web.CookieDomain,
+		ContentSecurityPolicy:    opts.Web.CSP,
+		AuthTokenDuration:        opts.Auth.TokenDuration,
+		AuthCookieDuration:       opts.Auth.CookieDuration,
+		AuthIssuer:               opts.Auth.Issuer,
+		AuthURL:                  opts.Auth.URL,
+		DBType:                   opts.DB.Type,
+		DBConn:                   opts.DB.Connection,
+		DBMaxOpenConns:           opts.DB.MaxOpenConns,
+		DBMaxIdleConns:           opts.DB.MaxIdleConns,
+		DBConnMaxLifetime:        opts.DB.ConnMaxLifetime,
+		GitHubCID:                opts.Auth.GitHubCID,
+		GitHubCSEC:               opts.Auth.GitHubCSEC,
+		GoogleCID:                opts.Auth.GoogleCID,
+		GoogleCSEC:               opts.Auth.GoogleCSEC,
+		TwitterCID:               opts.Auth.TwitterCID,
+		TwitterCSEC:              opts.Auth.TwitterCSEC,
+		GiteaCID:                 opts.Auth.GiteaCID,
+		GiteaCSEC:                opts.Auth.GiteaCSEC,
+		GiteaURL:                 opts.Auth.GiteaURL,
+		GitLabCID:                opts.Auth.GitLabCID,
+		GitLabCSEC:               opts.Auth.GitLabCSEC,
+		GitLabURL:                opts.Auth.GitLabURL,
+		EnableDevAuth:            opts.Auth.Dev,
+		EnableLocalAuth:          opts.Auth.Local,
+		RequireEmailVerification: opts.Auth.VerifyEmail,
+		BcryptCost:               opts.Auth.BcryptCost,
+		SMTPHost:                 opts.SMTP.Host,
+		SMTPPort:                 opts.SMTP.Port,
+		SMTPUser:                 opts.SMTP.User,
+		SMTPPass:                 opts.SMTP.Pass,
+		SMTPFrom:                 opts.SMTP.From,
+		Admins:                   opts.Auth.Admins,
+		FirstUserAdmin:           opts.Auth.FirstAdmin,
+		LoginMaxFailures:         opts.Auth.MaxFailures,
+		LoginWindow:              opts.Auth.LoginWindow,
+		LoginLockout:             opts.Auth.Lockout,
+		AvatarDir:                opts.Web.AvatarDir,
+		AvatarS3Bucket:           opts.Web.AvatarS3.Bucket,
+		AvatarS3Region:           opts.Web.AvatarS3.Region,
+		AvatarS3Endpoint:         opts.Web.AvatarS3.Endpoint,
+		AvatarS3AccessKey:        opts.Web.AvatarS3.AccessKey,
+		AvatarS3SecretKey:        opts.Web.AvatarS3.SecretKey,
+		MinExpiration:            opts.Paste.MinExpiration,
+		MaxExpiration:            opts.Paste.MaxExpiration,
+		AnonMaxExpiration:        opts.Paste.AnonExpiration,
+		ExpiringSoon:             opts.Paste.ExpiringSoon,
+		RequireLoginToPaste:      opts.Paste.RequireLogin,
+		EnableComments:           opts.Paste.Comments,
+		ExpirationPresets:        opts.Paste.ExpirationPresets,
+		DefaultExpiration:        opts.Paste.Expiration,
+		AllowedPrivacyAnon:       opts.Paste.AllowPrivacyAnon,
+		AllowedSyntaxes:          opts.Paste.AllowSyntaxes,
+		ConfirmSize:              opts.Paste.ConfirmSize,
+		SecretPatterns:           opts.Paste.SecretPatterns,
+		SkipSecretScan:           opts.Paste.NoSecretScan,
+		BlockedPatterns:          opts.Paste.BlockedPatterns,
+		UnlistBlocked:            opts.Paste.UnlistBlocked,
+		BodyEncoding:             opts.Paste.Encoding,
+		StatsTTL:                 opts.Paste.StatsTTL,
+		StatsAllSyntaxes:         opts.Paste.StatsAllSyntaxes,
+		DefaultPrivacyAnon:       opts.Paste.PrivacyAnon,
+		DefaultPrivacyUser:       opts.Paste.PrivacyUser,
+		MaxPastesPerUser:         opts.Paste.MaxPerUser,
+		MaxAnonymousPastes:       opts.Paste.MaxAnonymous,
+		MaxTitleLength:           opts.Paste.MaxTitle,
+		TruncateTitles:           opts.Paste.TruncateTitle,
+		MaxReports:               opts.Paste.MaxReports,
+		URLKey:                   opts.Paste.URLKey,
+		TrashRetention:           opts.Paste.TrashRetention,
+		IDBits:                   opts.Paste.IDBits,
+		ReadOnly:                 opts.Web.ReadOnly,
+		SkipOwnerViews:           opts.Paste.SkipOwnerViews,
+		BotUserAgents:            opts.Paste.BotUserAgents,
+		EnableMetrics:            opts.Web.Metrics,
+		EnableCompression:        opts.Web.Compression,
+		TrustProxyHeaders:        opts.Web.TrustProxy,
+		CompressionMinSize:       opts.Web.CompressionMin,
+		DiskConfig:               opts.Disk,
 	})
 
+	// SIGUSR1 turns the maintenance mode on and off.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			on := !webServer.ReadOnly()
+			webServer.SetReadOnly(on)
+			log.Logf("INFO maintenance mode set to %v", on)
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	errc := make(chan error, 1)
@@ -152,6 +357,11 @@ func main() {
 	} else {
 		log.Logf("INFO \tWeb server is down")
 	}
+	if err := webServer.Close(); err != nil {
+		log.Logf("ERROR \tFailed to close the store: %v\n", err)
+	} else {
+		log.Logf("INFO \tStore is closed")
+	}
 	log.Logf("INFO Sayōnara!")
 }
 
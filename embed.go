@@ -0,0 +1,14 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+// Package gopb embeds the templates and the static assets into the binary,
+// so that it can run without them on disk.
+package gopb
+
+import "embed"
+
+// Resources has the templates and the assets folders.
+//
+//go:embed templates assets
+var Resources embed.FS
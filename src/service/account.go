@@ -0,0 +1,263 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // used for user id hashing only, same as go-pkgz/auth
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8         // shortest password allowed for local accounts
+	resetTokenTTL     = time.Hour // how long a password reset link is valid
+)
+
+// Mailer sends emails to the users.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// RegisterRequest is an input to the Register method, normally comes from
+// the registration form.
+type RegisterRequest struct {
+	Email    string `json:"email" form:"email"`
+	Name     string `json:"name" form:"name"`
+	Password string `json:"password" form:"password"`
+}
+
+// LocalUserID returns the ID of the local account with the given email.
+// Emails are case insensitive.
+func LocalUserID(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	return "local_" + fmt.Sprintf("%x", sha1.Sum([]byte(email))) //nolint:gosec // not a secret
+}
+
+// randomToken returns a random hex encoded token.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newToken returns a random token prefixed with the user ID, so that the
+// user can be found by the token, and the hash of the token to store.
+func newToken(uid string) (token, hash string, err error) {
+	if token, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	token = uid + "." + token
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hash of the token as it is kept in the store.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenUserID returns the user ID part of the token.
+func tokenUserID(token string) string {
+	i := strings.LastIndex(token, ".")
+	if i < 1 {
+		return ""
+	}
+	return token[:i]
+}
+
+// Register creates a new local account. If email verification is enabled
+// the account is not verified and the verification link is sent to the
+// user, otherwise the user can login right away.
+func (s Service) Register(req RegisterRequest) (store.User, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || addr.Name != "" {
+		return store.User{}, fmt.Errorf("Service.Register: %w: [%s]", ErrWrongEmail, req.Email)
+	}
+	email := strings.ToLower(addr.Address)
+	if len(req.Password) < minPasswordLength {
+		return store.User{}, fmt.Errorf("Service.Register: %w: minimum is %d characters", ErrWeakPassword, minPasswordLength)
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = email[:strings.Index(email, "@")]
+	}
+
+	id := LocalUserID(email)
+	_, err = s.store.User(id)
+	if err == nil {
+		return store.User{}, fmt.Errorf("Service.Register: %w: [%s]", ErrUserExists, email)
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return store.User{}, storeError("Service.Register", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), s.bcryptCost)
+	if err != nil {
+		return store.User{}, fmt.Errorf("Service.Register: %w", err)
+	}
+	usr := store.User{
+		ID:       id,
+		Name:     name,
+		Email:    email,
+		Password: string(hash),
+		Verified: s.verifyURL == "",
+		Admin:    s.firstUser(),
+	}
+	var token string
+	if !usr.Verified {
+		if token, usr.VerifyToken, err = newToken(id); err != nil {
+			return store.User{}, fmt.Errorf("Service.Register: %w", err)
+		}
+	}
+	if _, err = s.store.SaveUser(usr); err != nil {
+		return store.User{}, storeError("Service.Register", err)
+	}
+
+	if token != "" && s.mailer != nil {
+		body := fmt.Sprintf("Hello %s,\n\nplease confirm your email address by opening the link below:\n\n%s?token=%s\n\n"+
+			"If you didn't create an account, just ignore this email.\n", usr.Name, s.verifyURL, token)
+		if err = s.mailer.Send(email, "Please verify your email address", body); err != nil {
+			return usr, fmt.Errorf("Service.Register: %w: (%v)", ErrSendFailure, err)
+		}
+	}
+	return usr, nil
+}
+
+// VerifyUser confirms the email address of the local account the token was
+// sent to. The token can only be used once.
+func (s Service) VerifyUser(token string) error {
+	uid := tokenUserID(token)
+	if uid == "" {
+		return fmt.Errorf("Service.VerifyUser: %w", ErrInvalidToken)
+	}
+	usr, err := s.store.User(uid)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return storeError("Service.VerifyUser", err)
+	}
+	if err != nil || usr.VerifyToken == "" ||
+		subtle.ConstantTimeCompare([]byte(usr.VerifyToken), []byte(hashToken(token))) != 1 {
+		return fmt.Errorf("Service.VerifyUser: %w", ErrInvalidToken)
+	}
+	usr.Verified = true
+	usr.VerifyToken = ""
+	if err = s.store.UpdateUser(usr, "verified", "verify_token"); err != nil {
+		return storeError("Service.VerifyUser", err)
+	}
+	return nil
+}
+
+// Authenticate checks the email and the password of a local account and
+// returns the user. Users that haven't confirmed their email address yet
+// get ErrNotVerified. After too many wrong passwords from the same IP address
+// the login is refused with ErrLockedOut, without checking the password, until
+// the lockout is over.
+func (s Service) Authenticate(email, password, ip string) (store.User, error) {
+	key := LocalUserID(email) + "|" + ip
+	if s.lockout.locked(key) {
+		return store.User{}, fmt.Errorf("Service.Authenticate: %w", ErrLockedOut)
+	}
+	usr, err := s.store.User(LocalUserID(email))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return store.User{}, storeError("Service.Authenticate", err)
+	}
+	if err != nil || usr.Password == "" {
+		s.lockout.fail(key)
+		return store.User{}, fmt.Errorf("Service.Authenticate: %w", ErrWrongCredentials)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(usr.Password), []byte(password)) != nil {
+		s.lockout.fail(key)
+		return store.User{}, fmt.Errorf("Service.Authenticate: %w", ErrWrongCredentials)
+	}
+	s.lockout.reset(key)
+	if !usr.Verified {
+		return store.User{}, fmt.Errorf("Service.Authenticate: %w", ErrNotVerified)
+	}
+	return usr, nil
+}
+
+// RequestPasswordReset emails a password reset link to the local account
+// with the given email. To not reveal which emails are registered, it
+// doesn't fail if there is no such account.
+func (s Service) RequestPasswordReset(email string) error {
+	usr, err := s.store.User(LocalUserID(email))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return storeError("Service.RequestPasswordReset", err)
+	}
+	if err != nil || usr.Password == "" {
+		return nil //nolint:nilerr // unknown emails are silently ignored
+	}
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("Service.RequestPasswordReset: %w", err)
+	}
+	err = s.store.SaveResetToken(store.ResetToken{
+		Hash:    hashToken(token),
+		UserID:  usr.ID,
+		Expires: time.Now().UTC().Add(resetTokenTTL),
+	})
+	if err != nil {
+		return storeError("Service.RequestPasswordReset", err)
+	}
+
+	if s.mailer != nil {
+		body := fmt.Sprintf("Hello %s,\n\nsomeone, hopefully you, asked to reset the password of your account. "+
+			"To choose a new password open the link below within an hour:\n\n%s?token=%s\n\n"+
+			"If you didn't ask for it, just ignore this email, your password stays the same.\n", usr.Name, s.resetURL, token)
+		if err = s.mailer.Send(usr.Email, "Reset your password", body); err != nil {
+			return fmt.Errorf("Service.RequestPasswordReset: %w: (%v)", ErrSendFailure, err)
+		}
+	}
+	return nil
+}
+
+// ResetPassword sets a new password for the local account the reset token
+// was sent to. Tokens can only be used once and expire after an hour.
+func (s Service) ResetPassword(token, newPassword string) error {
+	if len(newPassword) < minPasswordLength {
+		return fmt.Errorf("Service.ResetPassword: %w: minimum is %d characters", ErrWeakPassword, minPasswordLength)
+	}
+	hash := hashToken(token)
+	rt, err := s.store.ResetToken(hash)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.ResetPassword: %w", ErrInvalidToken)
+	}
+	if err != nil {
+		return storeError("Service.ResetPassword", err)
+	}
+	if err = s.store.DeleteResetToken(hash); err != nil {
+		return storeError("Service.ResetPassword", err)
+	}
+	if time.Now().After(rt.Expires) {
+		return fmt.Errorf("Service.ResetPassword: %w", ErrInvalidToken)
+	}
+	usr, err := s.store.User(rt.UserID)
+	if err != nil {
+		return fmt.Errorf("Service.ResetPassword: %w", ErrInvalidToken)
+	}
+
+	pwd, err := bcrypt.GenerateFromPassword([]byte(newPassword), s.bcryptCost)
+	if err != nil {
+		return fmt.Errorf("Service.ResetPassword: %w", err)
+	}
+	usr.Password = string(pwd)
+	// the link was emailed to the user, so the address is confirmed
+	usr.Verified = true
+	usr.VerifyToken = ""
+	if err = s.store.UpdateUser(usr, "password", "verified", "verify_token"); err != nil {
+		return storeError("Service.ResetPassword", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// commentedPaste returns the paste by its URL if the user can see its
+// comments, comments on private pastes are only shown to the owner. Pastes in
+// the trash have no comments and neither do password-protected ones, so that
+// they don't give away that the paste exists and what it is about.
+func (s Service) commentedPaste(op string, url string, uid string) (store.Paste, error) {
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		return store.Paste{}, fmt.Errorf("%s: %w: url [%s] (%v)", op, ErrPasteNotFound, url, err)
+	}
+	p, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Paste{}, fmt.Errorf("%s: %w: url [%s], id [%v]", op, ErrPasteNotFound, url, id)
+	}
+	if err != nil {
+		return store.Paste{}, storeError(op, err)
+	}
+	if p.Deleted() {
+		return store.Paste{}, fmt.Errorf("%s: %w: url [%s], id [%v] is in the trash", op, ErrPasteNotFound, url, id)
+	}
+	if p.Privacy == "private" && p.User.ID != uid {
+		return store.Paste{}, ErrPasteIsPrivate
+	}
+	if p.Password != "" {
+		return store.Paste{}, ErrPasteHasPassword
+	}
+	return p, nil
+}
+
+// AddComment adds the user's comment to the paste and returns it. Only
+// stored users can comment, and only on the pastes they can see.
+func (s Service) AddComment(url string, uid string, body string) (store.Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return store.Comment{}, ErrEmptyBody
+	}
+	if uid == "" {
+		return store.Comment{}, fmt.Errorf("Service.AddComment: %w: empty user id", ErrUserNotFound)
+	}
+	usr, err := s.store.User(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Comment{}, fmt.Errorf("Service.AddComment: %w: [%s]", ErrUserNotFound, uid)
+	}
+	if err != nil {
+		return store.Comment{}, storeError("Service.AddComment", err)
+	}
+	p, err := s.commentedPaste("Service.AddComment", url, uid)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	c := store.Comment{
+		PasteID:   p.ID,
+		UserID:    usr.ID,
+		UserName:  usr.Name,
+		Body:      body,
+		CreatedAt: time.Now().UTC(),
+	}
+	if c.ID, err = s.store.AddComment(c); err != nil {
+		return store.Comment{}, storeError("Service.AddComment", err)
+	}
+	return c, nil
+}
+
+// PasteComments returns the comments of the paste, oldest first. Comments on
+// private pastes are only returned to the owner.
+func (s Service) PasteComments(url string, uid string) ([]store.Comment, error) {
+	p, err := s.commentedPaste("Service.PasteComments", url, uid)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := s.store.Comments(p.ID)
+	if err != nil {
+		return nil, storeError("Service.PasteComments", err)
+	}
+	return comments, nil
+}
+
+// DeleteComment deletes a comment of the paste. Users can delete their own
+// comments, the owner of the paste can delete any of them. So can admins,
+// callers must make sure that the user is one before setting admin.
+func (s Service) DeleteComment(url string, id int64, uid string, admin bool) error {
+	if uid == "" {
+		return fmt.Errorf("Service.DeleteComment: %w: empty user id", ErrUserNotFound)
+	}
+	p, err := s.commentedPaste("Service.DeleteComment", url, uid)
+	if err != nil {
+		return err
+	}
+	comments, err := s.store.Comments(p.ID)
+	if err != nil {
+		return storeError("Service.DeleteComment", err)
+	}
+	for _, c := range comments {
+		if c.ID != id {
+			continue
+		}
+		if c.UserID != uid && p.User.ID != uid && !admin {
+			return fmt.Errorf("Service.DeleteComment: %w: id [%d]", ErrNotCommentAuthor, id)
+		}
+		if err = s.store.DeleteComment(id); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return storeError("Service.DeleteComment", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Service.DeleteComment: %w: url [%s], id [%d]", ErrCommentNotFound, url, id)
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffLines limits the size of the bodies to diff.
+const maxDiffLines = 5000
+
+// maxDiffCells limits len(a)*len(b) of the bodies to diff, the algorithm
+// needs that much memory.
+const maxDiffCells = 1000000
+
+// ErrDiffTooLarge means that the pastes are too big to diff.
+const ErrDiffTooLarge = Error("pastes are too large to compare")
+
+// ErrDiffBurner means that one of the pastes is deleted after reading.
+const ErrDiffBurner = Error("burner pastes can't be compared")
+
+// DiffPastes returns a unified diff between the bodies of two pastes. The
+// same privacy and password rules apply as for GetPaste, but the views are
+// not counted. Burner pastes are refused, a diff never deletes them.
+func (s Service) DiffPastes(aID, bID, uid, pwd string) (string, error) {
+	urls := []string{aID, bID}
+	ids := make([]int64, len(urls))
+	for i, url := range urls {
+		id, err := store.Paste{}.URL2ID(url)
+		if err != nil {
+			return "", fmt.Errorf("Service.DiffPastes: %w: url [%s] (%v)", ErrPasteNotFound, url, err)
+		}
+		ids[i] = id
+	}
+	pastes, err := s.store.GetMany(ids)
+	if err != nil {
+		return "", storeError("Service.DiffPastes", err)
+	}
+	found := make(map[int64]store.Paste, len(pastes))
+	for _, p := range pastes {
+		found[p.ID] = p
+	}
+
+	for i, id := range ids {
+		if _, ok := found[id]; !ok {
+			return "", fmt.Errorf("Service.DiffPastes: %w: url [%s], id [%v]", ErrPasteNotFound, urls[i], id)
+		}
+	}
+	// the size is checked before any view is counted
+	if err = checkDiffSize(splitLines(found[ids[0]].Body), splitLines(found[ids[1]].Body)); err != nil {
+		return "", err
+	}
+
+	// the same paste may be compared with itself, it is read only once
+	bodies := make(map[int64]string, len(ids))
+	for _, id := range ids {
+		if _, ok := bodies[id]; ok {
+			continue
+		}
+		p := found[id]
+		if p, err = s.readPaste(p, uid, pwd, readPeek); err != nil {
+			return "", err
+		}
+		if p.DeleteAfterRead {
+			return "", fmt.Errorf("Service.DiffPastes: %w: id [%d]", ErrDiffBurner, id)
+		}
+		bodies[id] = p.Body
+	}
+	return unifiedDiff(aID, bID, bodies[ids[0]], bodies[ids[1]])
+}
+
+// diffOp is a single line of an edit script: ' ' for unchanged, '-' for
+// deleted and '+' for inserted lines.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int // line numbers (0 based) in a and b before this line
+}
+
+// unifiedDiff returns the difference between a and b in the unified diff
+// format. It returns an empty string if a and b are the same.
+func unifiedDiff(aName, bName, a, b string) (string, error) {
+	al, bl := splitLines(a), splitLines(b)
+	if err := checkDiffSize(al, bl); err != nil {
+		return "", err
+	}
+	ops := diffLines(al, bl)
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		// find the next change
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+		}
+		// extend the hunk while changes are close enough to each other
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end += diffContext
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = next
+		}
+		writeHunk(&sb, ops[start:end])
+		i = end
+	}
+	return sb.String(), nil
+}
+
+// checkDiffSize returns ErrDiffTooLarge if a and b are too big to diff.
+func checkDiffSize(a, b []string) error {
+	if len(a) > maxDiffLines || len(b) > maxDiffLines || len(a)*len(b) > maxDiffCells {
+		return fmt.Errorf("Service.DiffPastes: %w: (%d and %d lines)", ErrDiffTooLarge, len(a), len(b))
+	}
+	return nil
+}
+
+// writeHunk writes a single hunk with its header.
+func writeHunk(sb *strings.Builder, ops []diffOp) {
+	var aCount, bCount int
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+	aStart, bStart := ops[0].a+1, ops[0].b+1
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, op := range ops {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+}
+
+// diffLines returns an edit script that turns a into b, based on the longest
+// common subsequence of lines.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// splitLines splits text into lines, ignoring the final new line.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidEncoding means that a paste body is not UTF-8 and can't be
+// converted to it.
+const ErrInvalidEncoding = Error("body is not valid UTF-8 text")
+
+// EncodingWindows1252 is the encoding recorded on the pastes converted from
+// Windows-1252, which covers Latin-1 as well.
+const EncodingWindows1252 = "windows-1252"
+
+// windows1252 maps the bytes 0x80-0x9F of Windows-1252 to runes, zero means
+// that the byte is undefined. The rest of the bytes are the same as in
+// Latin-1 and map to the runes with the same value.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// WithEncodingDetection makes NewPaste convert the bodies that are not
+// UTF-8 to it. Bodies without any UTF-8 characters are taken for
+// Windows-1252 and the encoding is recorded on the paste. The bytes that
+// can't be converted are replaced with U+FFFD if lossy is true, otherwise
+// the paste is rejected with ErrInvalidEncoding.
+func WithEncodingDetection(lossy bool) Option {
+	return func(s *Service) {
+		s.detectEncoding = true
+		s.lossyEncoding = lossy
+	}
+}
+
+// toUTF8 returns the body converted to UTF-8 and the encoding it was
+// converted from, empty if it is UTF-8 already. The body is returned as is
+// if the detection is off.
+func (s Service) toUTF8(body string) (string, string, error) {
+	if !s.detectEncoding || utf8.ValidString(body) {
+		return body, "", nil
+	}
+	// Windows-1252 text may look like a UTF-8 character by chance, but
+	// hardly ever does, so a body with some is broken UTF-8 instead
+	if !hasUTF8Runes(body) {
+		if res, ok := decodeWindows1252(body, s.lossyEncoding); ok {
+			return res, EncodingWindows1252, nil
+		}
+	} else if s.lossyEncoding {
+		return strings.ToValidUTF8(body, string(utf8.RuneError)), "", nil
+	}
+	return "", "", ErrInvalidEncoding
+}
+
+// hasUTF8Runes reports whether s has any valid multi-byte UTF-8 characters.
+func hasUTF8Runes(s string) bool {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r != utf8.RuneError && size > 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// decodeWindows1252 converts s from Windows-1252 to UTF-8. Undefined bytes
+// are replaced with U+FFFD if lossy is true, otherwise s can't be converted
+// and false is returned.
+func decodeWindows1252(s string, lossy bool) (string, bool) {
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/2)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+		case c < 0xA0:
+			r := windows1252[c-0x80]
+			if r == 0 {
+				if !lossy {
+					return "", false
+				}
+				r = utf8.RuneError
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String(), true
+}
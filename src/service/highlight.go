@@ -0,0 +1,363 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// language describes just enough of a programming language syntax to find
+// comments, strings, numbers and keywords.
+type language struct {
+	lineComments []string  // line comment markers, e.g. "//"
+	blockComment [2]string // block comment start and end, e.g. "/*" and "*/"
+	quotes       string    // characters that start and end a string
+	keywords     []string  // reserved words
+	ignoreCase   bool      // keywords match in any case
+}
+
+// cStyle returns a language with C-style comments.
+func cStyle(quotes string, keywords ...string) language {
+	return language{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       quotes,
+		keywords:     keywords,
+	}
+}
+
+var cKeywords = []string{"auto", "break", "case", "char", "const", "continue",
+	"default", "do", "double", "else", "enum", "extern", "float", "for", "goto",
+	"if", "inline", "int", "long", "register", "return", "short", "signed",
+	"sizeof", "static", "struct", "switch", "typedef", "union", "unsigned",
+	"void", "volatile", "while"}
+
+// languages maps syntax names, as used on the paste form, to their
+// definitions. Syntaxes not in the map are rendered as plain text.
+var languages = map[string]language{
+	"clike": cStyle(`"'`, cKeywords...),
+	"c":     cStyle(`"'`, cKeywords...),
+	"cpp": cStyle(`"'`, append([]string{"bool",
+		"catch", "class", "delete", "false", "friend", "namespace", "new",
+		"nullptr", "operator", "private", "protected", "public", "template",
+		"this", "throw", "true", "try", "using", "virtual"}, cKeywords...)...),
+	"csharp": cStyle(`"'`, "abstract",
+		"as", "bool", "break", "case", "catch", "class", "const", "continue",
+		"default", "do", "else", "enum", "false", "finally", "for", "foreach",
+		"if", "in", "int", "interface", "internal", "is", "namespace", "new",
+		"null", "override", "private", "protected", "public", "readonly",
+		"return", "static", "string", "struct", "switch", "this", "throw",
+		"true", "try", "using", "var", "virtual", "void", "while"),
+	"java": cStyle(`"'`, "abstract",
+		"boolean", "break", "case", "catch", "class", "continue", "default",
+		"do", "else", "enum", "extends", "false", "final", "finally", "for",
+		"if", "implements", "import", "instanceof", "int", "interface", "new",
+		"null", "package", "private", "protected", "public", "return",
+		"static", "super", "switch", "this", "throw", "throws", "true", "try",
+		"void", "while"),
+	"javascript": cStyle("\"'`",
+		"async", "await", "break", "case", "catch", "class", "const",
+		"continue", "default", "delete", "do", "else", "export", "extends",
+		"false", "finally", "for", "function", "if", "import", "in",
+		"instanceof", "let", "new", "null", "return", "super", "switch",
+		"this", "throw", "true", "try", "typeof", "undefined", "var", "while",
+		"yield"),
+	"typescript": cStyle("\"'`",
+		"any", "as", "async", "await", "boolean", "break", "case", "catch",
+		"class", "const", "continue", "default", "do", "else", "enum",
+		"export", "extends", "false", "for", "function", "if", "implements",
+		"import", "in", "interface", "let", "new", "null", "number", "private",
+		"public", "return", "string", "switch", "this", "throw", "true", "try",
+		"type", "undefined", "var", "void", "while"),
+	"go": cStyle("\"'`", "break",
+		"case", "chan", "const", "continue", "default", "defer", "else",
+		"fallthrough", "false", "for", "func", "go", "goto", "if", "import",
+		"interface", "map", "nil", "package", "range", "return", "select",
+		"struct", "switch", "true", "type", "var"),
+	"rust": cStyle(`"`, "as", "break",
+		"const", "continue", "crate", "else", "enum", "extern", "false", "fn",
+		"for", "if", "impl", "in", "let", "loop", "match", "mod", "move",
+		"mut", "pub", "ref", "return", "self", "Self", "static", "struct",
+		"super", "trait", "true", "type", "unsafe", "use", "where", "while"),
+	"kotlin": cStyle(`"'`, "as",
+		"break", "class", "continue", "do", "else", "false", "for", "fun",
+		"if", "in", "interface", "is", "null", "object", "package", "return",
+		"super", "this", "throw", "true", "try", "typealias", "val", "var",
+		"when", "while"),
+	"swift": cStyle(`"`, "as", "break",
+		"case", "class", "continue", "default", "defer", "do", "else", "enum",
+		"extension", "false", "for", "func", "guard", "if", "import", "in",
+		"init", "let", "nil", "protocol", "return", "self", "struct", "switch",
+		"throw", "true", "try", "var", "where", "while"),
+	"php": {
+		lineComments: []string{"//", "#"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		keywords: []string{"array",
+			"as", "break", "case", "class", "const", "continue", "default", "do",
+			"echo", "else", "elseif", "false", "for", "foreach", "function", "if",
+			"include", "namespace", "new", "null", "private", "protected",
+			"public", "require", "return", "static", "switch", "true", "use",
+			"var", "while"},
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: []string{"and", "as",
+			"assert", "async", "await", "break", "class", "continue", "def", "del",
+			"elif", "else", "except", "False", "finally", "for", "from", "global",
+			"if", "import", "in", "is", "lambda", "None", "nonlocal", "not", "or",
+			"pass", "raise", "return", "True", "try", "while", "with", "yield"},
+	},
+	"ruby": {
+		lineComments: []string{"#"},
+		blockComment: [2]string{"=begin", "=end"},
+		quotes:       `"'`,
+		keywords: []string{"and",
+			"begin", "break", "case", "class", "def", "do", "else", "elsif", "end",
+			"ensure", "false", "for", "if", "in", "module", "next", "nil", "not",
+			"or", "redo", "require", "rescue", "retry", "return", "self", "super",
+			"then", "true", "unless", "until", "when", "while", "yield"},
+	},
+	"bash": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: []string{"case", "do", "done",
+			"echo", "elif", "else", "esac", "exit", "export", "fi", "for",
+			"function", "if", "in", "local", "return", "then", "until", "while"},
+	},
+	"lua": {
+		lineComments: []string{"--"},
+		blockComment: [2]string{"--[[", "]]"},
+		quotes:       `"'`,
+		keywords: []string{"and",
+			"break", "do", "else", "elseif", "end", "false", "for", "function",
+			"if", "in", "local", "nil", "not", "or", "repeat", "return", "then",
+			"true", "until", "while"},
+	},
+	"sql": {
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `'"`,
+		keywords: []string{"and", "as",
+			"by", "create", "delete", "desc", "distinct", "drop", "from", "group",
+			"having", "in", "index", "insert", "into", "is", "join", "left",
+			"like", "limit", "not", "null", "on", "or", "order", "primary",
+			"select", "set", "table", "update", "values", "where"},
+		ignoreCase: true,
+	},
+	"css": {
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	},
+	"json": {
+		quotes:   `"`,
+		keywords: []string{"false", "null", "true"},
+	},
+	"yaml": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords:     []string{"false", "null", "true"},
+	},
+	"markdown": {}, // the source is plain text, RenderMarkdown renders it
+}
+
+// htmlWriter collects highlighted tokens and splits them into lines, each
+// line wrapped in a span with an id that can be used as a URL fragment.
+type htmlWriter struct {
+	sb   strings.Builder
+	line int
+}
+
+func (w *htmlWriter) startLine() {
+	w.line++
+	fmt.Fprintf(&w.sb, `<span id="L%d" class="line"><a class="line-number" href="#L%d">%d</a>`, w.line, w.line, w.line)
+}
+
+// token writes text escaped and wrapped into a span with the Prism token
+// class so that the existing prism.css styles apply. Tokens spanning
+// several lines are closed and reopened on each line.
+func (w *htmlWriter) token(class, text string) {
+	for i, part := range strings.Split(text, "\n") {
+		if i > 0 {
+			w.sb.WriteString("</span>\n")
+			w.startLine()
+		}
+		if part == "" {
+			continue
+		}
+		if class == "" {
+			w.sb.WriteString(template.HTMLEscapeString(part))
+			continue
+		}
+		fmt.Fprintf(&w.sb, `<span class="token %s">%s</span>`, class, template.HTMLEscapeString(part))
+	}
+}
+
+func (w *htmlWriter) html() template.HTML {
+	return template.HTML(w.sb.String() + "</span>") //nolint:gosec // all the text is escaped
+}
+
+// HighlightThemes are the names of the highlight themes, the first one is
+// the default. Each theme has a stylesheet in the assets/themes folder that
+// colours the tokens RenderHTML generates.
+var HighlightThemes = []string{"coy", "okaidia", "solarizedlight", "tomorrow"}
+
+// ValidHighlightTheme reports whether name is one of the HighlightThemes.
+func ValidHighlightTheme(name string) bool {
+	for _, t := range HighlightThemes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Syntaxes returns the names of the syntaxes RenderHTML highlights, sorted.
+func Syntaxes() []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KnownSyntax reports whether name is one of the Syntaxes.
+func KnownSyntax(name string) bool {
+	_, ok := languages[name]
+	return ok
+}
+
+// RenderHTML returns paste body as HTML with syntax highlighting. Every line
+// is wrapped in a span with id="L{n}" so that lines can be linked to. If the
+// paste syntax is unknown the body is rendered as escaped plain text.
+// The body is always escaped and never parsed as a template, so markup or
+// template actions in it are shown as they are.
+func RenderHTML(paste store.Paste) (template.HTML, error) {
+	body := strings.ReplaceAll(paste.Body, "\r\n", "\n")
+	body = strings.TrimSuffix(body, "\n")
+	if !utf8.ValidString(body) {
+		return renderPlain(strings.ToValidUTF8(body, "�")), fmt.Errorf("RenderHTML: paste body is not valid UTF-8")
+	}
+	lang, ok := languages[paste.Syntax]
+	if !ok {
+		return renderPlain(body), nil
+	}
+	return highlight(lang, body), nil
+}
+
+// renderPlain returns the body as escaped text split into numbered lines.
+func renderPlain(body string) template.HTML {
+	w := &htmlWriter{}
+	w.startLine()
+	w.token("", body)
+	return w.html()
+}
+
+// highlight scans the body once and wraps comments, strings, numbers and
+// keywords into token spans.
+func highlight(lang language, body string) template.HTML {
+	keywords := make(map[string]bool, len(lang.keywords))
+	for _, k := range lang.keywords {
+		keywords[k] = true
+	}
+
+	w := &htmlWriter{}
+	w.startLine()
+	plain := 0 // start of the pending plain text
+	for i := 0; i < len(body); {
+		end, class := scanToken(lang, keywords, body, i)
+		if end == i {
+			_, size := utf8.DecodeRuneInString(body[i:])
+			i += size
+			continue
+		}
+		if class == "" {
+			i = end
+			continue
+		}
+		w.token("", body[plain:i])
+		w.token(class, body[i:end])
+		i, plain = end, end
+	}
+	w.token("", body[plain:])
+	return w.html()
+}
+
+// scanToken tries to recognise a token at position i. It returns the end of
+// the token and its class, or i if there is no token there.
+func scanToken(lang language, keywords map[string]bool, body string, i int) (int, string) {
+	rest := body[i:]
+	if bc := lang.blockComment; bc[0] != "" && strings.HasPrefix(rest, bc[0]) {
+		if n := strings.Index(rest[len(bc[0]):], bc[1]); n >= 0 {
+			return i + len(bc[0]) + n + len(bc[1]), "comment"
+		}
+		return len(body), "comment"
+	}
+	for _, lc := range lang.lineComments {
+		if strings.HasPrefix(rest, lc) {
+			if n := strings.IndexByte(rest, '\n'); n >= 0 {
+				return i + n, "comment"
+			}
+			return len(body), "comment"
+		}
+	}
+
+	r, size := utf8.DecodeRuneInString(rest)
+	switch {
+	case strings.ContainsRune(lang.quotes, r):
+		for j := size; j < len(rest); j++ {
+			switch rest[j] {
+			case '\\':
+				j++
+			case byte(r):
+				return i + j + 1, "string"
+			case '\n':
+				if r != '`' {
+					return i + j, "string"
+				}
+			}
+		}
+		return len(body), "string"
+	case unicode.IsDigit(r):
+		if i > 0 && isWordByte(body[i-1]) {
+			return i, ""
+		}
+		j := 0
+		for j < len(rest) && (isWordByte(rest[j]) || rest[j] == '.') {
+			j++
+		}
+		return i + j, "number"
+	case r == '_' || unicode.IsLetter(r):
+		j := 0
+		for j < len(rest) && isWordByte(rest[j]) {
+			j++
+		}
+		if j == 0 {
+			return i + size, ""
+		}
+		word := rest[:j]
+		if lang.ignoreCase {
+			word = strings.ToLower(word)
+		}
+		if keywords[word] {
+			return i + j, "keyword"
+		}
+		return i + j, ""
+	}
+	return i, ""
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
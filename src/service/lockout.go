@@ -0,0 +1,108 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// ErrLockedOut is returned by Authenticate after too many failed logins.
+const ErrLockedOut = Error("too many failed logins")
+
+// lockoutPruneAt is the number of tracked logins after which the expired
+// ones are forgotten.
+const lockoutPruneAt = 1000
+
+// loginLockout counts failed logins to an account from an IP address and
+// locks the pair out for a while after too many of them. Other addresses
+// can still login, so that nobody can lock a user out of their account.
+type loginLockout struct {
+	sync.Mutex
+	maxFailures int           // failures within the window that lock the login out, 0 means never
+	window      time.Duration // failures older than that are forgotten
+	cooldown    time.Duration // how long the login stays locked out
+	now         func() time.Time
+	logins      map[string]*failedLogin
+}
+
+// failedLogin is the failures of a single login.
+type failedLogin struct {
+	failures    int
+	first       time.Time // first failure within the window
+	lockedUntil time.Time
+}
+
+// WithLoginLockout locks a login out for cooldown after maxFailures wrong
+// passwords for the same account from the same IP address within window.
+// Zero maxFailures means logins are never locked out.
+func WithLoginLockout(maxFailures int, window, cooldown time.Duration) Option {
+	return func(s *Service) {
+		s.lockout = nil
+		if maxFailures > 0 {
+			s.lockout = newLoginLockout(maxFailures, window, cooldown)
+		}
+	}
+}
+
+func newLoginLockout(maxFailures int, window, cooldown time.Duration) *loginLockout {
+	return &loginLockout{
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+		now:         time.Now,
+		logins:      map[string]*failedLogin{},
+	}
+}
+
+// locked reports whether the login is locked out.
+func (l *loginLockout) locked(key string) bool {
+	if l == nil || l.maxFailures <= 0 {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	f, ok := l.logins[key]
+	return ok && l.now().Before(f.lockedUntil)
+}
+
+// fail records a failed login and locks it out if there were too many.
+func (l *loginLockout) fail(key string) {
+	if l == nil || l.maxFailures <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	if len(l.logins) >= lockoutPruneAt {
+		for k, f := range l.logins {
+			if now.Sub(f.first) > l.window && now.After(f.lockedUntil) {
+				delete(l.logins, k)
+			}
+		}
+	}
+	f, ok := l.logins[key]
+	if !ok || now.Sub(f.first) > l.window {
+		f = &failedLogin{first: now}
+		l.logins[key] = f
+	}
+	f.failures++
+	if f.failures >= l.maxFailures {
+		f.lockedUntil = now.Add(l.cooldown)
+		f.failures = 0
+		f.first = now
+	}
+}
+
+// reset forgets the failures of a successful login.
+func (l *loginLockout) reset(key string) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	delete(l.logins, key)
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package mail
+
+import (
+	"sync"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// Async sends emails in the background with another mailer so that the
+// requests don't wait for the mail server. Failures are logged, the sender
+// never sees them.
+type Async struct {
+	mailer  service.Mailer
+	log     lgr.L
+	pending sync.WaitGroup
+}
+
+// NewAsync returns a mailer that sends emails with m in the background and
+// logs the failures to l.
+func NewAsync(m service.Mailer, l lgr.L) *Async {
+	return &Async{mailer: m, log: l}
+}
+
+// Send starts sending the email and returns right away.
+func (a *Async) Send(to, subject, body string) error {
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		if err := a.mailer.Send(to, subject, body); err != nil {
+			a.log.Logf("ERROR failed to send email %q: %v", subject, err)
+		}
+	}()
+	return nil
+}
+
+// Wait blocks until all the emails in flight are sent or failed.
+func (a *Async) Wait() {
+	a.pending.Wait()
+}
@@ -0,0 +1,29 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package mail
+
+import (
+	"github.com/go-pkgz/lgr"
+)
+
+// Nop is a mailer that doesn't send anything, useful for tests.
+type Nop struct{}
+
+// Send does nothing and never fails.
+func (Nop) Send(to, subject, body string) error {
+	return nil
+}
+
+// Log is a mailer that writes the emails to the log instead of sending
+// them, useful for development when there is no SMTP server.
+type Log struct {
+	L lgr.L
+}
+
+// Send logs the email and never fails.
+func (m Log) Send(to, subject, body string) error {
+	m.L.Logf("INFO email to %s: %s\n%s", to, subject, body)
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+// Package mail implements mailers that send go-pb emails, such as account
+// verification links, to the users.
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server. If the user is set it
+// authenticates with PLAIN auth, which net/smtp only allows over TLS or to
+// localhost.
+type SMTP struct {
+	addr string // host:port of the server
+	host string
+	user string
+	pass string
+	from string
+}
+
+// NewSMTP returns an SMTP mailer for the server at host:port. Emails are
+// sent from the from address.
+func NewSMTP(host string, port int, user, pass, from string) *SMTP {
+	return &SMTP{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		host: host,
+		user: user,
+		pass: pass,
+		from: from,
+	}
+}
+
+// Send sends a plain text email.
+func (m *SMTP) Send(to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("SMTP.Send: invalid header value")
+	}
+	var auth smtp.Auth
+	if m.user != "" {
+		auth = smtp.PlainAuth("", m.user, m.pass, m.host)
+	}
+	if err := smtp.SendMail(m.addr, auth, m.from, []string{to}, m.message(to, subject, body)); err != nil {
+		return fmt.Errorf("SMTP.Send: %w", err)
+	}
+	return nil
+}
+
+// message returns the email with the headers.
+func (m *SMTP) message(to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + m.from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// String returns the server address, used in logs.
+func (m *SMTP) String() string {
+	return "smtp://" + m.addr
+}
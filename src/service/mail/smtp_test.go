@@ -0,0 +1,157 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP accepts a single connection on a random port and records the
+// commands and the message it receives.
+func fakeSMTP(t *testing.T) (host string, port int, done <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	res := make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			res <- ""
+			return
+		}
+		defer conn.Close()
+		var got strings.Builder
+		rd := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		data := false
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				break
+			}
+			got.WriteString(line)
+			switch {
+			case data:
+				if line == ".\r\n" {
+					data = false
+					reply("250 OK")
+				}
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(line, "DATA"):
+				data = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				res <- got.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+		res <- got.String()
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, res
+}
+
+func TestSMTPSend(t *testing.T) {
+	t.Parallel()
+
+	host, port, done := fakeSMTP(t)
+	m := NewSMTP(host, port, "", "", "go-pb@example.com")
+	if err := m.Send("bob@example.com", "Hello", "line one\nline two"); err != nil {
+		t.Fatalf("failed to send email: %v", err)
+	}
+
+	got := <-done
+	for _, want := range []string{
+		"MAIL FROM:<go-pb@example.com>",
+		"RCPT TO:<bob@example.com>",
+		"Subject: Hello\r\n",
+		"To: bob@example.com\r\n",
+		"line one\r\nline two",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the server to receive [%s], got [%s]", want, got)
+		}
+	}
+}
+
+func TestSMTPSendInvalidHeader(t *testing.T) {
+	t.Parallel()
+
+	m := NewSMTP("127.0.0.1", 1, "", "", "go-pb@example.com")
+	if err := m.Send("bob@example.com\r\nBcc: eve@example.com", "Hello", "body"); err == nil {
+		t.Error("expected header injection to fail")
+	}
+	if m.String() != "smtp://127.0.0.1:1" {
+		t.Errorf("unexpected address %s", m)
+	}
+}
+
+// failingMailer counts the attempts and always fails.
+type failingMailer struct {
+	sync.Mutex
+	calls int
+}
+
+func (m *failingMailer) Send(to, subject, body string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.calls++
+	return errors.New("mail server is down")
+}
+
+// logBuffer collects the log lines.
+type logBuffer struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *logBuffer) Logf(format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, format)
+}
+
+func TestAsyncLogsFailures(t *testing.T) {
+	t.Parallel()
+
+	fm := &failingMailer{}
+	log := &logBuffer{}
+	a := NewAsync(fm, log)
+	if err := a.Send("bob@example.com", "Hello", "body"); err != nil {
+		t.Errorf("expected async send to never fail, got %v", err)
+	}
+	a.Wait()
+
+	if fm.calls != 1 {
+		t.Errorf("expected one attempt, got %d", fm.calls)
+	}
+	if len(log.lines) != 1 || !strings.HasPrefix(log.lines[0], "ERROR") {
+		t.Errorf("expected the failure to be logged, got %v", log.lines)
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	t.Parallel()
+
+	log := &logBuffer{}
+	if err := (Log{L: log}).Send("bob@example.com", "Hello", "body"); err != nil {
+		t.Errorf("expected log mailer to never fail, got %v", err)
+	}
+	if len(log.lines) != 1 {
+		t.Errorf("expected the email to be logged, got %v", log.lines)
+	}
+}
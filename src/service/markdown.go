@@ -0,0 +1,158 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// markdown patterns, the inline ones are matched against escaped text
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	mdRule     = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumbered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong   = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdEm       = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+)
+
+// RenderMarkdown converts markdown to HTML that is safe to show on a page.
+// Only the common subset is supported: headings, paragraphs, lists, quotes,
+// code blocks, rules, links, code spans and emphasis. Raw HTML, including
+// scripts, is escaped instead of being rendered, and links that could run
+// scripts are shown as plain text.
+func RenderMarkdown(src string) template.HTML {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	return template.HTML(markdownBlocks(lines)) //nolint:gosec // the text is escaped
+}
+
+// markdownBlocks renders the block level elements of the lines.
+func markdownBlocks(lines []string) string {
+	var (
+		b     strings.Builder
+		para  []string // lines of the current paragraph
+		list  string   // tag of the current list, empty if there is none
+		quote []string // lines of the current block quote
+	)
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + markdownInline(strings.Join(para, "\n")) + "</p>\n")
+			para = nil
+		}
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+		if len(quote) > 0 {
+			b.WriteString("<blockquote>\n" + markdownBlocks(quote) + "</blockquote>\n")
+			quote = nil
+		}
+	}
+	item := func(tag, text string) {
+		if list != tag {
+			flush()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+		b.WriteString("<li>" + markdownInline(text) + "</li>\n")
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, ">"):
+			if len(quote) == 0 {
+				flush()
+			}
+			quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " "))
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + markdownInline(m[2]) + "</" + tag + ">\n")
+		case mdRule.MatchString(trimmed):
+			flush()
+			b.WriteString("<hr>\n")
+		case mdBullet.MatchString(line):
+			item("ul", mdBullet.FindStringSubmatch(line)[1])
+		case mdNumbered.MatchString(line):
+			item("ol", mdNumbered.FindStringSubmatch(line)[1])
+		default:
+			if list != "" || len(quote) > 0 {
+				flush()
+			}
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// markdownInline renders the code spans, links and emphasis in the text.
+// The text between backticks is shown as is.
+func markdownInline(text string) string {
+	var b strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`") // no closing backtick
+		}
+		// the link targets are left out of the emphasis, URLs often have
+		// underscores and asterisks in them
+		s := html.EscapeString(part)
+		last := 0
+		for _, m := range mdLink.FindAllStringSubmatchIndex(s, -1) {
+			b.WriteString(markdownEmphasis(s[last:m[0]]))
+			text, href := markdownEmphasis(s[m[2]:m[3]]), s[m[4]:m[5]]
+			if safeLink(html.UnescapeString(href)) {
+				b.WriteString(`<a href="` + href + `" rel="nofollow noopener">` + text + `</a>`)
+			} else {
+				b.WriteString(text)
+			}
+			last = m[1]
+		}
+		b.WriteString(markdownEmphasis(s[last:]))
+	}
+	return b.String()
+}
+
+// markdownEmphasis renders the strong and emphasised text in the escaped
+// text.
+func markdownEmphasis(s string) string {
+	s = mdStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return mdEm.ReplaceAllString(s, "<em>$1</em>")
+}
+
+// safeLink reports whether the link is relative or uses one of the schemes
+// that can't run scripts.
+func safeLink(link string) bool {
+	scheme, _, found := strings.Cut(link, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidPattern means that a secret pattern can't be parsed.
+const ErrInvalidPattern = Error("invalid secret pattern")
+
+// SecretPattern is a named regular expression that matches a kind of
+// credential that shouldn't be pasted by accident.
+type SecretPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// DefaultSecretPatterns are the secret patterns used unless
+// WithSecretPatterns sets others.
+var DefaultSecretPatterns = []SecretPattern{
+	{"AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+}
+
+// ParseSecretPatterns parses secret patterns in the "name=regexp" form.
+func ParseSecretPatterns(specs []string) ([]SecretPattern, error) {
+	patterns := make([]SecretPattern, 0, len(specs))
+	for _, spec := range specs {
+		name, expr, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || expr == "" {
+			return nil, fmt.Errorf("%w: %q, must be name=regexp", ErrInvalidPattern, spec)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q (%v)", ErrInvalidPattern, spec, err)
+		}
+		patterns = append(patterns, SecretPattern{Name: name, Regexp: re})
+	}
+	return patterns, nil
+}
+
+// WithSecretPatterns sets the patterns ScanForSecrets looks for, none means
+// that nothing is reported.
+func WithSecretPatterns(patterns []SecretPattern) Option {
+	return func(s *Service) {
+		s.secretPatterns = patterns
+	}
+}
+
+// ScanForSecrets returns the names of the secret patterns found in the
+// body, in the order of the patterns.
+func (s Service) ScanForSecrets(body string) []string {
+	var found []string
+	for _, p := range s.secretPatterns {
+		if p.Regexp.MatchString(body) {
+			found = append(found, p.Name)
+		}
+	}
+	return found
+}
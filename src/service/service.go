@@ -9,10 +9,15 @@
 package service
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/iliafrenkel/go-pb/src/store"
 	"golang.org/x/crypto/bcrypt"
@@ -20,7 +25,165 @@ import (
 
 // Service type provides method to work with pastes and users.
 type Service struct {
-	store store.Interface
+	store         store.Interface
+	minExpiration time.Duration // shortest allowed paste expiration, 0 means no limit
+	maxExpiration time.Duration // longest allowed paste expiration, 0 means no limit
+	maxUser       int64         // maximum number of pastes per user, 0 means no limit
+	maxAnonymous  int64         // maximum number of anonymous pastes per IP, 0 means no limit
+	ownerViews    bool          // count views of the paste owner
+	maxReports    int64         // maximum number of abuse reports per IP per hour, 0 means no limit
+	mailer        Mailer        // sends emails, nil means emails are not sent
+	verifyURL     string        // email verification page, empty means local accounts are verified right away
+	resetURL      string        // password reset page the reset links point to
+	bcryptCost    int           // bcrypt cost of the paste and user password hashes
+	trashKeep     time.Duration // how long deleted pastes are kept in the trash, 0 means deletes are permanent
+	firstAdmin    bool          // the first user created becomes an admin
+	lockout       *loginLockout // failed logins, nil means logins are never locked out
+	recentLimit   int           // number of pastes UserPastes returns
+
+	secretPatterns []SecretPattern // credentials ScanForSecrets looks for
+	detectEncoding bool            // convert the bodies that are not UTF-8
+	lossyEncoding  bool            // replace the bytes that can't be converted instead of rejecting the paste
+	stats          *statsCache     // instance stats collected last
+	anonExpiration time.Duration   // longest expiration of anonymous pastes, 0 means no limit
+	trustedNets    []*net.IPNet    // addresses that are not limited by maxAnonymous and maxReports
+	maxTitle       int             // longest paste title in characters, 0 means no limit
+	truncateTitle  bool            // cut the titles longer than maxTitle instead of rejecting the paste
+}
+
+// Option is a function that configures optional Service parameters.
+type Option func(s *Service)
+
+// WithExpirationBounds sets the minimum and maximum allowed paste expiration.
+// Zero value means no limit. Note that when max is set pastes that never
+// expire are not allowed.
+func WithExpirationBounds(minExp, maxExp time.Duration) Option {
+	return func(s *Service) {
+		s.minExpiration = minExp
+		s.maxExpiration = maxExp
+	}
+}
+
+// WithAnonExpiration caps the expiration of anonymous pastes to maxExp,
+// even if the request asks for a later one or for never. Pastes of logged
+// in users are not affected. Zero means no cap.
+func WithAnonExpiration(maxExp time.Duration) Option {
+	return func(s *Service) {
+		s.anonExpiration = maxExp
+	}
+}
+
+// WithTitleLimit sets the longest paste title in characters, 0 means no
+// limit. Longer titles are cut if truncate is true, otherwise the paste is
+// rejected with ErrTitleTooLong.
+func WithTitleLimit(maxLen int, truncate bool) Option {
+	return func(s *Service) {
+		s.maxTitle = maxLen
+		s.truncateTitle = truncate
+	}
+}
+
+// WithPasteLimits sets the maximum number of pastes a user can have and the
+// maximum number of anonymous pastes per IP address. Zero means no limit.
+func WithPasteLimits(maxUser, maxAnonymous int) Option {
+	return func(s *Service) {
+		s.maxUser = int64(maxUser)
+		s.maxAnonymous = int64(maxAnonymous)
+	}
+}
+
+// WithCountOwnerViews sets whether the owner's views of their own pastes are
+// counted. They are counted by default.
+func WithCountOwnerViews(count bool) Option {
+	return func(s *Service) {
+		s.ownerViews = count
+	}
+}
+
+// WithReportLimit sets the maximum number of abuse reports per IP address
+// per hour. Zero means no limit, the default is 5.
+func WithReportLimit(maxReports int) Option {
+	return func(s *Service) {
+		s.maxReports = int64(maxReports)
+	}
+}
+
+// WithTrustedNetworks exempts the addresses in the networks from the
+// anonymous paste limit and the report limit, e.g. for internal tools.
+func WithTrustedNetworks(nets []*net.IPNet) Option {
+	return func(s *Service) {
+		s.trustedNets = nets
+	}
+}
+
+// WithBcryptCost sets the bcrypt cost used to hash paste and user
+// passwords, the default is bcrypt.DefaultCost. Use ValidateBcryptCost to
+// check the value first. Existing hashes are not affected, bcrypt keeps the
+// cost in the hash.
+func WithBcryptCost(cost int) Option {
+	return func(s *Service) {
+		s.bcryptCost = cost
+	}
+}
+
+// WithFirstUserAdmin makes the first user, either registered or logged in
+// with a provider, an admin. It is meant to bootstrap a new installation.
+func WithFirstUserAdmin(first bool) Option {
+	return func(s *Service) {
+		s.firstAdmin = first
+	}
+}
+
+// WithRecentPastesLimit sets the number of pastes UserPastes returns, the
+// default is 10. Zero or negative limit keeps the default.
+func WithRecentPastesLimit(limit int) Option {
+	return func(s *Service) {
+		if limit > 0 {
+			s.recentLimit = limit
+		}
+	}
+}
+
+// ValidateBcryptCost checks that the cost is within the bounds bcrypt
+// supports.
+func ValidateBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("%w: %d, must be between %d and %d", ErrInvalidCost, cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// WithMailer sets the mailer used to send emails to the users.
+func WithMailer(m Mailer) Option {
+	return func(s *Service) {
+		s.mailer = m
+	}
+}
+
+// WithEmailVerification requires new local accounts to confirm their email
+// address before they can login. The verification token is appended to the
+// verifyURL and sent with the mailer.
+func WithEmailVerification(verifyURL string) Option {
+	return func(s *Service) {
+		s.verifyURL = verifyURL
+	}
+}
+
+// WithPasswordReset sets the page the password reset links point to. The
+// reset token is appended to the resetURL.
+func WithPasswordReset(resetURL string) Option {
+	return func(s *Service) {
+		s.resetURL = resetURL
+	}
+}
+
+// WithTrash makes the users' deletes move the pastes to the trash, where
+// they are kept for the given time and can be restored. Zero means that
+// deletes are permanent.
+func WithTrash(keep time.Duration) Option {
+	return func(s *Service) {
+		s.trashKeep = keep
+	}
 }
 
 // Error is a base type for all other service errors.
@@ -32,76 +195,132 @@ func (e Error) Error() string {
 
 // ErrPasteNotFound and other common errors.
 const (
-	ErrPasteNotFound    = Error("paste not found")
-	ErrUserNotFound     = Error("user not found")
-	ErrPasteIsPrivate   = Error("paste is private")
-	ErrPasteHasPassword = Error("paste has password")
-	ErrWrongPassword    = Error("paste password is incorrect")
-	ErrStoreFailure     = Error("store opertation failed")
-	ErrEmptyBody        = Error("body is empty")
-	ErrWrongPrivacy     = Error("privacy is wrong")
-	ErrWrongDuration    = Error("wrong duration format")
+	ErrPasteNotFound     = Error("paste not found")
+	ErrUserNotFound      = Error("user not found")
+	ErrPasteIsPrivate    = Error("paste is private")
+	ErrPasteHasPassword  = Error("paste has password")
+	ErrWrongPassword     = Error("paste password is incorrect")
+	ErrStoreFailure      = Error("store opertation failed")
+	ErrEmptyBody         = Error("body is empty")
+	ErrWrongPrivacy      = Error("privacy is wrong")
+	ErrWrongDuration     = Error("wrong duration format")
+	ErrPasteLimitReached = Error("paste limit reached")
+	ErrDuplicateFile     = Error("duplicate file name")
+	ErrEmptyReason       = Error("report reason is empty")
+	ErrReportLimit       = Error("report limit reached")
+	ErrWrongEmail        = Error("email is wrong")
+	ErrWeakPassword      = Error("password is too short")
+	ErrUserExists        = Error("user already exists")
+	ErrWrongCredentials  = Error("email or password is incorrect")
+	ErrNotVerified       = Error("email is not verified")
+	ErrInvalidToken      = Error("token is invalid or expired")
+	ErrSendFailure       = Error("failed to send email")
+	ErrInvalidCost       = Error("invalid bcrypt cost")
+	ErrStoreUnavailable  = Error("store is unavailable")
+	ErrConflict          = Error("already exists")
+	ErrLastAdmin         = Error("the last admin can't be demoted")
+	ErrCommentNotFound   = Error("comment not found")
+	ErrNotCommentAuthor  = Error("comment belongs to another user")
+	ErrTitleTooLong      = Error("title is too long")
+	ErrPasteExpired      = Error("paste has expired")
+	ErrUserAmbiguous     = Error("several users have this name")
 )
 
+// storeError wraps an error returned by the store. Unreachable store and
+// conflicts get their own errors so that the callers can tell them apart
+// from other failures.
+func storeError(op string, err error) error {
+	switch {
+	case errors.Is(err, store.ErrConnection), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s: %w: (%v)", op, ErrStoreUnavailable, err)
+	case errors.Is(err, store.ErrConflict):
+		return fmt.Errorf("%s: %w: (%v)", op, ErrConflict, err)
+	}
+	return fmt.Errorf("%s: %w: (%v)", op, ErrStoreFailure, err)
+}
+
 // PasteRequest is an input to Create method, normally comes from a web form.
 type PasteRequest struct {
 	Title           string `json:"title" form:"title"`
 	Body            string `json:"body" form:"body" binding:"required"`
 	Expires         string `json:"expires" form:"expires" binding:"required"`
-	DeleteAfterRead bool   `json:"delete_after_read" form:"delete_after_read" binding:"-"`
+	DeleteAfterRead bool   `json:"delete_after_read" form:"delete_after_read" binding:"-"` // deleted on the first read even if it hasn't expired yet
+	BlurUntilClick  bool   `json:"blur_until_click" form:"blur_until_click" binding:"-"`
 	Privacy         string `json:"privacy" form:"privacy" binding:"required"`
 	Password        string `json:"password" form:"password"`
 	Syntax          string `json:"syntax" form:"syntax" binding:"required"`
+	Theme           string `json:"theme" form:"theme"`             // highlight theme, unknown themes are ignored
+	AllowEmbed      bool   `json:"allow_embed" form:"allow_embed"` // the paste can be embedded in other sites
 	UserID          string `json:"user_id"`
+	IP              string `json:"-"`     // creator IP address, used to limit anonymous pastes
+	Files           []File `json:"files"` // files of a multi-file paste, Body and Syntax are ignored if set
+}
+
+// File is a single file of a multi-file PasteRequest.
+type File struct {
+	Name   string `json:"name"`
+	Body   string `json:"body"`
+	Syntax string `json:"syntax"`
 }
 
 // New returns new Service with provided store as a back-end storage.
-func New(store store.Interface) *Service {
+func New(store store.Interface, opts ...Option) *Service {
 	var s *Service = new(Service)
 	s.store = store
-	rand.Seed(time.Now().UnixNano())
+	s.ownerViews = true
+	s.maxReports = 5
+	s.recentLimit = 10
+	s.maxTitle = 255
+	s.bcryptCost = bcrypt.DefaultCost
+	s.secretPatterns = DefaultSecretPatterns
+	s.stats = newStatsCache(time.Minute, false)
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	return s
 }
 
 // NewWithDiskDB returns new Service with a disk file system as a store.
-func NewWithDiskDB(config *store.DiskConfig) (*Service, error) {
+func NewWithDiskDB(config *store.DiskConfig, opts ...Option) (*Service, error) {
 	s, err := store.NewDiskStorage(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return New(s), nil
+	return New(s, opts...), nil
 }
 
 // NewWithMemDB returns new Service with memory as a store.
-func NewWithMemDB() *Service {
-	return New(store.NewMemDB())
+func NewWithMemDB(opts ...Option) *Service {
+	return New(store.NewMemDB(), opts...)
 }
 
 // NewWithPostgres returns new Service with postgres db as a store.
-func NewWithPostgres(conn string) (*Service, error) {
-	s, err := store.NewPostgresDB(conn, true)
+func NewWithPostgres(conn string, pool store.PostgresPool, opts ...Option) (*Service, error) {
+	s, err := store.NewPostgresDB(conn, true, pool)
 	if err != nil {
 		return nil, err
 	}
-	return New(s), nil
+	return New(s, opts...), nil
 }
 
 // parseExpiration tries to parse PasteRequest.Expires string and return
-// corresponding time.Time.
-// We expect the expiration to be in the form of "nx" where "n" is a number
-// and "x" is a time unit character: m for minute, h for hour, d for day,
-// w for week, M for month and y for year.
-func (s Service) parseExpiration(exp string) (time.Time, error) {
+// corresponding time.Time relative to now.
+// We expect the expiration to be in the form of "nx" where "n" is a positive
+// number and "x" is a time unit character: m for minute, h for hour, d for
+// day, w for week, M for month and y for year.
+func (s Service) parseExpiration(exp string, now time.Time) (time.Time, error) {
 	res := time.Time{}
-	now := time.Now()
 
 	if exp != "never" && len(exp) > 1 {
 		dur, err := strconv.Atoi(exp[:len(exp)-1])
 		if err != nil {
 			return time.Time{}, fmt.Errorf("Service.parseExpiration: %w: %s (%v)", ErrWrongDuration, exp, err)
 		}
+		if dur < 1 {
+			return time.Time{}, fmt.Errorf("Service.parseExpiration: %w: %s (must be positive)", ErrWrongDuration, exp)
+		}
 		switch exp[len(exp)-1] {
 		case 'm': //minutes
 			res = now.Add(time.Duration(dur) * time.Minute)
@@ -122,23 +341,141 @@ func (s Service) parseExpiration(exp string) (time.Time, error) {
 	return res, nil
 }
 
+// ValidateExpiration checks that exp can be used as PasteRequest.Expires,
+// i.e. that it can be parsed and is within the configured bounds.
+func (s Service) ValidateExpiration(exp string) error {
+	now := time.Now()
+	expires, err := s.parseExpiration(exp, now)
+	if err != nil {
+		return err
+	}
+	return s.checkExpiration(expires, now)
+}
+
+// expirationSkew is how far in the past an expiration date may be before
+// it is rejected, to allow for small clock differences.
+const expirationSkew = time.Minute
+
+// checkExpiration verifies that the expiration date is not in the past and
+// is within the configured bounds. A zero date means the paste never
+// expires.
+func (s Service) checkExpiration(expires time.Time, now time.Time) error {
+	if expires.IsZero() {
+		if s.maxExpiration > 0 {
+			return fmt.Errorf("Service.checkExpiration: %w: never (maximum is %v)", ErrWrongDuration, s.maxExpiration)
+		}
+		return nil
+	}
+	// a huge duration overflows into the past
+	if expires.Before(now.Add(-expirationSkew)) {
+		return fmt.Errorf("Service.checkExpiration: %w: %v is in the past", ErrWrongDuration, expires)
+	}
+	dur := expires.Sub(now)
+	if s.minExpiration > 0 && dur < s.minExpiration {
+		return fmt.Errorf("Service.checkExpiration: %w: %v (minimum is %v)", ErrWrongDuration, dur, s.minExpiration)
+	}
+	if s.maxExpiration > 0 && dur > s.maxExpiration {
+		return fmt.Errorf("Service.checkExpiration: %w: %v (maximum is %v)", ErrWrongDuration, dur, s.maxExpiration)
+	}
+	return nil
+}
+
+// checkPasteLimit verifies that the user has not reached the maximum number
+// of pastes. Anonymous users are limited by IP address.
+func (s Service) checkPasteLimit(uid string, ip string) error {
+	if uid != "anonymous" {
+		if s.maxUser > 0 && s.store.Count(store.FindRequest{UserID: uid}) >= s.maxUser {
+			return fmt.Errorf("Service.checkPasteLimit: %w: user [%s] has %d pastes", ErrPasteLimitReached, uid, s.maxUser)
+		}
+		return nil
+	}
+	if s.maxAnonymous > 0 && ip != "" && !InNetworks(ip, s.trustedNets) && s.store.Count(store.FindRequest{UserID: uid, IP: ip}) >= s.maxAnonymous {
+		return fmt.Errorf("Service.checkPasteLimit: %w: ip [%s] has %d pastes", ErrPasteLimitReached, ip, s.maxAnonymous)
+	}
+	return nil
+}
+
+// InNetworks reports whether the IP address is in one of the networks.
+func InNetworks(ip string, nets []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewPaste creates new Paste from the request and saves it in the store.
 // Paste.Body is mandatory, Paste.Expires is default to never, Paste.Privacy
 // must be on of ["private","public","unlisted"]. If password is provided it
 // is stored as a hash.
+// Anonymous pastes are returned with the token to claim them in
+// Paste.ClaimToken, it can't be retrieved later.
 func (s Service) NewPaste(pr PasteRequest) (store.Paste, error) {
+	return s.NewPasteCtx(context.Background(), pr)
+}
+
+// NewPasteCtx is NewPaste that passes ctx to the store, so that it gives up
+// once ctx is done.
+func (s Service) NewPasteCtx(ctx context.Context, pr PasteRequest) (store.Paste, error) {
 	var err error
-	created := time.Now()
-	expires, err := s.parseExpiration(pr.Expires)
+	// all the times are stored in UTC, the pages show them in the reader's
+	// timezone
+	created := time.Now().UTC()
+	expires, err := s.parseExpiration(pr.Expires, created)
 	if err != nil {
 		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
 	}
+	if err = s.checkExpiration(expires, created); err != nil {
+		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
+	}
+
+	// Check the files, the first one becomes the paste body
+	files, err := pasteFiles(pr.Files)
+	if err != nil {
+		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
+	}
+	// Convert the bodies to UTF-8, the first converted one gives the
+	// encoding of the paste
+	var encoding string
+	for i := range files {
+		body, enc, err := s.toUTF8(files[i].Body)
+		if err != nil {
+			return store.Paste{}, fmt.Errorf("Service.NewPaste: %w: file [%s]", err, files[i].Name)
+		}
+		files[i].Body = body
+		if encoding == "" {
+			encoding = enc
+		}
+	}
+	if len(files) > 0 {
+		pr.Body = files[0].Body
+		pr.Syntax = files[0].Syntax
+	} else {
+		pr.Body, encoding, err = s.toUTF8(pr.Body)
+		if err != nil {
+			return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
+		}
+	}
 
 	// Check that body is not empty
 	if pr.Body == "" {
 		return store.Paste{}, ErrEmptyBody
 	}
 
+	// Titles are trimmed, the long ones are cut or rejected
+	pr.Title = strings.TrimSpace(pr.Title)
+	if n := utf8.RuneCountInString(pr.Title); s.maxTitle > 0 && n > s.maxTitle {
+		if !s.truncateTitle {
+			return store.Paste{}, fmt.Errorf("Service.NewPaste: %w: %d characters, at most %d", ErrTitleTooLong, n, s.maxTitle)
+		}
+		pr.Title = strings.TrimSpace(string([]rune(pr.Title)[:s.maxTitle]))
+	}
+
 	// Privacy can only be "private", "public" or "unlisted"
 	if pr.Privacy != "private" && pr.Privacy != "public" && pr.Privacy != "unlisted" {
 		return store.Paste{}, ErrWrongPrivacy
@@ -146,7 +483,7 @@ func (s Service) NewPaste(pr PasteRequest) (store.Paste, error) {
 
 	// If password is not empty, hash it before storing
 	if pr.Password != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(pr.Password), bcrypt.DefaultCost)
+		hash, err := bcrypt.GenerateFromPassword([]byte(pr.Password), s.bcryptCost)
 		if err != nil {
 			return store.Paste{}, err
 		}
@@ -156,6 +493,9 @@ func (s Service) NewPaste(pr PasteRequest) (store.Paste, error) {
 	var usr store.User
 	if pr.UserID != "" {
 		usr, err = s.store.User(pr.UserID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return store.Paste{}, storeError("Service.NewPaste", err)
+		}
 		if err != nil || usr == (store.User{}) {
 			return store.Paste{}, fmt.Errorf("Service.NewPaste: %w: user id [%s] (%v)", ErrUserNotFound, pr.UserID, err)
 		}
@@ -163,6 +503,21 @@ func (s Service) NewPaste(pr PasteRequest) (store.Paste, error) {
 		usr.ID = "anonymous"
 		usr.Name = "Anonymous"
 	}
+	// Check the paste limits
+	if err = s.checkPasteLimit(usr.ID, pr.IP); err != nil {
+		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
+	}
+	// Only keep the IP address for anonymous pastes
+	if usr.ID != "anonymous" {
+		pr.IP = ""
+	}
+	// Anonymous pastes expire no later than the cap, even the never
+	// expiring ones
+	if usr.ID == "anonymous" && s.anonExpiration > 0 {
+		if capped := created.Add(s.anonExpiration); expires.IsZero() || expires.After(capped) {
+			expires = capped
+		}
+	}
 	// Do not allow privacy to be be private for anonymous users.
 	if usr.ID == "anonymous" && pr.Privacy == "private" {
 		pr.Privacy = "public"
@@ -171,52 +526,157 @@ func (s Service) NewPaste(pr PasteRequest) (store.Paste, error) {
 	if pr.Syntax == "" {
 		pr.Syntax = "text"
 	}
+	// Unknown highlight themes fall back to the default
+	if !ValidHighlightTheme(pr.Theme) {
+		pr.Theme = ""
+	}
 	// Create a new paste and store it
 	paste := store.Paste{
 		Title:           pr.Title,
 		Body:            pr.Body,
 		Expires:         expires,
 		DeleteAfterRead: pr.DeleteAfterRead,
+		BlurUntilClick:  pr.BlurUntilClick,
+		AllowEmbed:      pr.AllowEmbed,
 		Privacy:         pr.Privacy,
 		Password:        pr.Password,
 		CreatedAt:       created,
 		Syntax:          pr.Syntax,
+		Theme:           pr.Theme,
 		User:            usr,
+		IP:              pr.IP,
+		Files:           files,
+		Encoding:        encoding,
 	}
-	id, err := s.store.Create(paste)
+	paste.Size, paste.Lines = paste.CountSize()
+	// Anonymous pastes get a token, so that their creator can claim them
+	// after logging in. Only the hash is stored.
+	var token string
+	if usr.ID == "anonymous" {
+		if token, err = randomToken(); err != nil {
+			return store.Paste{}, fmt.Errorf("Service.NewPaste: %w", err)
+		}
+		paste.ClaimToken = hashToken(token)
+	}
+	id, err := s.store.CreateCtx(ctx, paste)
 	if err != nil {
-		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w: (%v)", ErrStoreFailure, err)
+		return store.Paste{}, storeError("Service.NewPaste", err)
 	}
 	// Get the paste back and return it
-	paste, err = s.store.Get(id)
+	paste, err = s.store.GetCtx(ctx, id)
 	if err != nil {
-		return store.Paste{}, fmt.Errorf("Service.NewPaste: %w: (%v)", ErrStoreFailure, err)
+		return store.Paste{}, storeError("Service.NewPaste", err)
 	}
+	paste.ClaimToken = token
 	return paste, nil
 }
 
+// pasteFiles validates the files of a multi-file paste request. Every file
+// must have a body, names must be unique and default to "fileN". Syntax
+// defaults to "text".
+func pasteFiles(req []File) ([]store.PasteFile, error) {
+	if len(req) == 0 {
+		return nil, nil
+	}
+	files := make([]store.PasteFile, 0, len(req))
+	names := make(map[string]bool, len(req))
+	for i, f := range req {
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("file%d", i+1)
+		}
+		if f.Body == "" {
+			return nil, fmt.Errorf("%w: file [%s]", ErrEmptyBody, f.Name)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("%w: [%s]", ErrDuplicateFile, f.Name)
+		}
+		names[f.Name] = true
+		if f.Syntax == "" {
+			f.Syntax = "text"
+		}
+		files = append(files, store.PasteFile{Name: f.Name, Body: f.Body, Syntax: f.Syntax})
+	}
+	return files, nil
+}
+
 // GetPaste returns a paste given encoded URL.
 // If the paste is private GetPaste will check that it belongs to the user with
 // provided uid. If password is given and the paste has password GetPaste will
 // check that the password is correct.
 func (s Service) GetPaste(url string, uid string, pwd string) (store.Paste, error) {
+	return s.getPaste(context.Background(), url, uid, pwd, readView)
+}
+
+// GetPasteCtx is GetPaste that passes ctx to the store, so that it gives up
+// once ctx is done.
+func (s Service) GetPasteCtx(ctx context.Context, url string, uid string, pwd string) (store.Paste, error) {
+	return s.getPaste(ctx, url, uid, pwd, readView)
+}
+
+// GetPasteNoCount is the same as GetPaste but it doesn't count the view. It
+// is used for secondary endpoints, such as raw text and the API. It still
+// deletes "burner" pastes, because their body is read.
+func (s Service) GetPasteNoCount(url string, uid string, pwd string) (store.Paste, error) {
+	return s.getPaste(context.Background(), url, uid, pwd, readNoCount)
+}
+
+// GetPasteNoCountCtx is GetPasteNoCount that passes ctx to the store.
+func (s Service) GetPasteNoCountCtx(ctx context.Context, url string, uid string, pwd string) (store.Paste, error) {
+	return s.getPaste(ctx, url, uid, pwd, readNoCount)
+}
+
+// PeekPasteCtx checks access to a paste the same way as GetPaste, but it
+// neither counts the view nor deletes "burner" pastes. It is used for HEAD
+// requests and bots, e.g. link checkers and chat unfurls, so the body of a
+// burner paste is removed: it is only for the one who burns it.
+func (s Service) PeekPasteCtx(ctx context.Context, url string, uid string, pwd string) (store.Paste, error) {
+	return s.getPaste(ctx, url, uid, pwd, readPeek)
+}
+
+// CreatedPasteCtx returns a paste to the client that has created it, e.g. to
+// replay the response to a retried request. It doesn't check the password,
+// which the client knows, and it neither counts the view nor burns the
+// paste. Private pastes are only returned to the owner.
+func (s Service) CreatedPasteCtx(ctx context.Context, url string, uid string) (store.Paste, error) {
+	return s.getPaste(ctx, url, uid, "", readCreated)
+}
+
+// readMode tells readPaste what reading a paste does to it.
+type readMode int
+
+const (
+	readView    readMode = iota // counts the view and burns the paste
+	readNoCount                 // burns the paste without counting the view
+	readPeek                    // leaves the paste as it is
+	readCreated                 // leaves the paste as it is and skips the password
+)
+
+func (s Service) getPaste(ctx context.Context, url string, uid string, pwd string, mode readMode) (store.Paste, error) {
 	p := store.Paste{}
 	id, err := p.URL2ID(url)
 	if err != nil {
 		return store.Paste{}, err
 	}
-	p, err = s.store.Get(id)
-	if err != nil {
-		return p, fmt.Errorf("Service.GetPaste: %w: (%v)", ErrStoreFailure, err)
+	p, err = s.store.GetCtx(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Paste{}, fmt.Errorf("Service.GetPaste: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
 	}
-	// Check if paste was not found
-	if p == (store.Paste{}) {
-		return p, fmt.Errorf("Service.GetPaste: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
+	if err != nil {
+		return store.Paste{}, storeError("Service.GetPaste", err)
 	}
+	return s.readPaste(p, uid, pwd, mode)
+}
+
+// readPaste checks that the user can read the paste, counts the view and
+// deletes "burner" pastes, depending on mode.
+func (s Service) readPaste(p store.Paste, uid string, pwd string, mode readMode) (store.Paste, error) {
 	// Check privacy
 	if p.Privacy == "private" && p.User.ID != uid {
 		return store.Paste{}, ErrPasteIsPrivate
 	}
+	if mode == readCreated {
+		return p, nil
+	}
 	// Check if password protected
 	if p.Password != "" && pwd == "" {
 		return store.Paste{}, ErrPasteHasPassword
@@ -225,31 +685,514 @@ func (s Service) GetPaste(url string, uid string, pwd string) (store.Paste, erro
 	if p.Password != "" && bcrypt.CompareHashAndPassword([]byte(p.Password), []byte(pwd)) != nil {
 		return store.Paste{}, ErrWrongPassword
 	}
+	if mode == readPeek {
+		if p.DeleteAfterRead {
+			p = withoutBody(p)
+		}
+		return p, nil
+	}
 	// Update the view count
-	p.Views++
-	p, _ = s.store.Update(p) // we ignore the error here because we only update the view count
+	if mode == readView && (s.ownerViews || p.User.ID == "" || p.User.ID != uid) {
+		p.Views++
+		p, _ = s.store.Update(p) // we ignore the error here because we only update the view count
+	}
 	// Check if paste is a "burner" and delete it if yes
 	if p.DeleteAfterRead {
-		err = s.store.Delete(p.ID)
-		if err != nil {
-			return p, fmt.Errorf("Service.GetPaste: %w: (%v)", ErrStoreFailure, err)
+		if err := s.store.Delete(p.ID); err != nil {
+			return p, storeError("Service.GetPaste", err)
 		}
 	}
 	return p, nil
 }
 
-// GetOrUpdateUser saves the user in the store and returns it.
+// withoutBody returns a copy of p with the bodies of the paste and its files
+// removed.
+func withoutBody(p store.Paste) store.Paste {
+	p.Body = ""
+	if len(p.Files) > 0 {
+		// copy the files, the store may share them with the stored paste
+		files := make([]store.PasteFile, len(p.Files))
+		for i, f := range p.Files {
+			f.Body = ""
+			files[i] = f
+		}
+		p.Files = files
+	}
+	return p
+}
+
+// GetPasteMeta returns a paste without its body. Unlike
+// GetPaste it doesn't check the password, doesn't count a view and doesn't
+// delete "burner" pastes. Private pastes are only returned to the owner.
+func (s Service) GetPasteMeta(url string, uid string) (store.Paste, error) {
+	p := store.Paste{}
+	id, err := p.URL2ID(url)
+	if err != nil {
+		return store.Paste{}, err
+	}
+	p, err = s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Paste{}, fmt.Errorf("Service.GetPasteMeta: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
+	}
+	if err != nil {
+		return store.Paste{}, storeError("Service.GetPasteMeta", err)
+	}
+	// Check privacy
+	if p.Privacy == "private" && p.User.ID != uid {
+		return store.Paste{}, ErrPasteIsPrivate
+	}
+	return withoutBody(p), nil
+}
+
+// GetOrUpdateUser creates the user or, if it exists, updates the profile
+// from the JWT token and returns the stored user. The rest, e.g. whether the
+// user is an admin and the credentials of local accounts, is kept.
 func (s Service) GetOrUpdateUser(usr store.User) (store.User, error) {
-	_, err := s.store.SaveUser(usr)
+	err := s.store.UpdateUser(usr)
+	if errors.Is(err, store.ErrNotFound) {
+		usr.Admin = usr.Admin || s.firstUser()
+		_, err = s.store.SaveUser(usr)
+	}
 	if err != nil {
-		return store.User{}, fmt.Errorf("Service.GetOrUpdateUser: %w: (%v)", ErrStoreFailure, err)
+		return store.User{}, storeError("Service.GetOrUpdateUser", err)
+	}
+	usr, err = s.store.User(usr.ID)
+	if err != nil {
+		return store.User{}, storeError("Service.GetOrUpdateUser", err)
 	}
 	return usr, nil
 }
 
+// DeleteUser deletes the user and all the user's pastes.
+func (s Service) DeleteUser(uid string) error {
+	if uid == "" {
+		return fmt.Errorf("Service.DeleteUser: %w: empty user id", ErrUserNotFound)
+	}
+	// the pastes in the trash are deleted as well
+	for _, deleted := range []bool{false, true} {
+		req := store.FindRequest{UserID: uid, Deleted: deleted}
+		count := s.store.Count(req)
+		if count == 0 {
+			continue
+		}
+		req.Limit = int(count)
+		pastes, err := s.store.Find(req)
+		if err != nil {
+			return storeError("Service.DeleteUser", err)
+		}
+		for _, p := range pastes {
+			if err = s.store.Delete(p.ID); err != nil {
+				return storeError("Service.DeleteUser", err)
+			}
+		}
+	}
+	if err := s.store.DeleteUser(uid); err != nil {
+		return storeError("Service.DeleteUser", err)
+	}
+	return nil
+}
+
+// DeletePastes deletes the user's pastes with the given IDs, or moves them
+// to the trash if it is enabled. IDs of pastes that don't exist or belong to
+// someone else are skipped.
+func (s Service) DeletePastes(ids []int64, uid string) (deleted int, err error) {
+	if uid == "" {
+		return 0, fmt.Errorf("Service.DeletePastes: %w: empty user id", ErrUserNotFound)
+	}
+	pastes, err := s.store.GetMany(ids)
+	if err != nil {
+		return 0, storeError("Service.DeletePastes", err)
+	}
+	for _, p := range pastes {
+		if p.User.ID != uid {
+			continue
+		}
+		if s.trashKeep > 0 {
+			err = s.store.SoftDelete(p.ID)
+		} else {
+			err = s.store.Delete(p.ID)
+		}
+		if err != nil {
+			return deleted, storeError("Service.DeletePastes", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Trash returns the user's pastes in the trash, most recently created
+// first.
+func (s Service) Trash(uid string) ([]store.Paste, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("Service.Trash: %w: empty user id", ErrUserNotFound)
+	}
+	req := store.FindRequest{UserID: uid, Deleted: true, Sort: "-created"}
+	count := s.store.Count(req)
+	if count == 0 {
+		return []store.Paste{}, nil
+	}
+	req.Limit = int(count)
+	pastes, err := s.store.Find(req)
+	if err != nil {
+		return nil, storeError("Service.Trash", err)
+	}
+	return pastes, nil
+}
+
+// RestorePaste takes the user's paste out of the trash.
+func (s Service) RestorePaste(url string, uid string) error {
+	if uid == "" {
+		return fmt.Errorf("Service.RestorePaste: %w: empty user id", ErrUserNotFound)
+	}
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		return fmt.Errorf("Service.RestorePaste: %w: url [%s] (%v)", ErrPasteNotFound, url, err)
+	}
+	err = s.store.Restore(id, uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.RestorePaste: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
+	}
+	if err != nil {
+		return storeError("Service.RestorePaste", err)
+	}
+	return nil
+}
+
+// TransferPaste makes another user the owner of the paste. Only the owner
+// can give the paste away, to anyone else it doesn't exist.
+func (s Service) TransferPaste(id int64, fromUID, toUID string) error {
+	if fromUID == "" {
+		return fmt.Errorf("Service.TransferPaste: %w: empty user id", ErrUserNotFound)
+	}
+	p, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (p.User.ID != fromUID || p.Deleted())) {
+		return fmt.Errorf("Service.TransferPaste: %w: id [%d]", ErrPasteNotFound, id)
+	}
+	if err != nil {
+		return storeError("Service.TransferPaste", err)
+	}
+	usr, err := s.store.User(toUID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && toUID == "") {
+		return fmt.Errorf("Service.TransferPaste: %w: id [%s]", ErrUserNotFound, toUID)
+	}
+	if err != nil {
+		return storeError("Service.TransferPaste", err)
+	}
+	if usr.ID == fromUID {
+		return nil
+	}
+	p.User, p.UserID = usr, usr.ID
+	if _, err = s.store.Update(p); err != nil {
+		return storeError("Service.TransferPaste", err)
+	}
+	return nil
+}
+
+// ClaimPaste makes the user the owner of an anonymous paste. The user must
+// present the token given out when the paste was created, it can only be
+// used once.
+func (s Service) ClaimPaste(id int64, uid, token string) error {
+	if uid == "" {
+		return fmt.Errorf("Service.ClaimPaste: %w: empty user id", ErrUserNotFound)
+	}
+	p, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (p.User.ID != "anonymous" || p.Deleted())) {
+		return fmt.Errorf("Service.ClaimPaste: %w: id [%d]", ErrPasteNotFound, id)
+	}
+	if err != nil {
+		return storeError("Service.ClaimPaste", err)
+	}
+	if p.ClaimToken == "" || token == "" ||
+		subtle.ConstantTimeCompare([]byte(p.ClaimToken), []byte(hashToken(token))) != 1 {
+		return fmt.Errorf("Service.ClaimPaste: %w: id [%d]", ErrInvalidToken, id)
+	}
+	usr, err := s.store.User(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.ClaimPaste: %w: id [%s]", ErrUserNotFound, uid)
+	}
+	if err != nil {
+		return storeError("Service.ClaimPaste", err)
+	}
+	// the IP address is only kept for anonymous pastes
+	p.User, p.UserID = usr, usr.ID
+	p.ClaimToken, p.IP = "", ""
+	if _, err = s.store.Update(p); err != nil {
+		return storeError("Service.ClaimPaste", err)
+	}
+	return nil
+}
+
+// UserByName returns the only user with the given name, the case is
+// ignored. Names are not unique, so ErrUserAmbiguous is returned if there
+// are several users with this name.
+func (s Service) UserByName(name string) (store.User, error) {
+	if name == "" {
+		return store.User{}, fmt.Errorf("Service.UserByName: %w: empty name", ErrUserNotFound)
+	}
+	users, err := s.store.Users(0, 0)
+	if err != nil {
+		return store.User{}, storeError("Service.UserByName", err)
+	}
+	var found []store.User
+	for _, u := range users {
+		if strings.EqualFold(u.Name, name) {
+			found = append(found, u)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return store.User{}, fmt.Errorf("Service.UserByName: %w: name [%s]", ErrUserNotFound, name)
+	case 1:
+		return found[0], nil
+	default:
+		return store.User{}, fmt.Errorf("Service.UserByName: %w: name [%s]", ErrUserAmbiguous, name)
+	}
+}
+
+// ExtendExpiry sets a new expiration of the user's paste, newExpires is
+// parsed relative to now like PasteRequest.Expires. Only the owner can
+// extend a paste, so anonymous pastes can't be extended. Expired pastes
+// must be created again.
+func (s Service) ExtendExpiry(id int64, uid string, newExpires string) error {
+	if uid == "" {
+		return fmt.Errorf("Service.ExtendExpiry: %w: empty user id", ErrUserNotFound)
+	}
+	p, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && (p.User.ID != uid || p.User.ID == "anonymous")) {
+		return fmt.Errorf("Service.ExtendExpiry: %w: id [%d]", ErrPasteNotFound, id)
+	}
+	if err != nil {
+		return storeError("Service.ExtendExpiry", err)
+	}
+	if p.Expired() {
+		return fmt.Errorf("Service.ExtendExpiry: %w: id [%d]", ErrPasteExpired, id)
+	}
+	if newExpires == "" {
+		return fmt.Errorf("Service.ExtendExpiry: %w: empty expiration", ErrWrongDuration)
+	}
+	now := time.Now().UTC()
+	expires, err := s.parseExpiration(newExpires, now)
+	if err != nil {
+		return fmt.Errorf("Service.ExtendExpiry: %w", err)
+	}
+	if err = s.checkExpiration(expires, now); err != nil {
+		return fmt.Errorf("Service.ExtendExpiry: %w", err)
+	}
+	p.Expires = expires
+	if _, err = s.store.Update(p); err != nil {
+		return storeError("Service.ExtendExpiry", err)
+	}
+	return nil
+}
+
+// PurgeTrash deletes the pastes that have been in the trash for longer
+// than it keeps them and returns their number.
+func (s Service) PurgeTrash() (int64, error) {
+	if s.trashKeep <= 0 {
+		return 0, nil
+	}
+	purged, err := s.store.Purge(time.Now().Add(-s.trashKeep))
+	if err != nil {
+		return purged, storeError("Service.PurgeTrash", err)
+	}
+	return purged, nil
+}
+
+// DeleteExpiredPastes deletes all the user's pastes that have already
+// expired.
+func (s Service) DeleteExpiredPastes(uid string) (deleted int, err error) {
+	now := time.Now()
+	deleted, err = s.deleteUserPastes(uid, func(p store.Paste) bool {
+		return !p.Expires.IsZero() && p.Expires.Before(now)
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("Service.DeleteExpiredPastes: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteUserPastes deletes the user's pastes for which match returns true
+// and returns the number of deleted pastes.
+func (s Service) deleteUserPastes(uid string, match func(p store.Paste) bool) (deleted int, err error) {
+	if uid == "" {
+		return 0, fmt.Errorf("%w: empty user id", ErrUserNotFound)
+	}
+	count := s.store.Count(store.FindRequest{UserID: uid})
+	if count == 0 {
+		return 0, nil
+	}
+	pastes, err := s.store.Find(store.FindRequest{
+		UserID: uid,
+		Limit:  int(count),
+	})
+	if err != nil {
+		return 0, storeError("Service.deleteUserPastes", err)
+	}
+	for _, p := range pastes {
+		if !match(p) {
+			continue
+		}
+		if err = s.store.Delete(p.ID); err != nil {
+			return deleted, storeError("Service.deleteUserPastes", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// AllPastes returns a list of all the pastes, newest first, regardless of
+// their owner and privacy. It is meant for admins only.
+func (s Service) AllPastes(limit int, skip int) ([]store.Paste, error) {
+	pastes, err := s.store.Find(store.FindRequest{
+		Sort:  "-created",
+		Limit: limit,
+		Skip:  skip,
+		All:   true,
+	})
+	if err != nil {
+		return nil, storeError("Service.AllPastes", err)
+	}
+	return pastes, nil
+}
+
+// AllUsers returns a list of all the users sorted by ID. It is meant for
+// admins only.
+func (s Service) AllUsers(limit int, skip int) ([]store.User, error) {
+	users, err := s.store.Users(limit, skip)
+	if err != nil {
+		return nil, storeError("Service.AllUsers", err)
+	}
+	return users, nil
+}
+
+// firstUser reports whether the user about to be created should become an
+// admin because it is the first one, see WithFirstUserAdmin.
+func (s Service) firstUser() bool {
+	if !s.firstAdmin {
+		return false
+	}
+	_, users := s.store.Totals()
+	return users == 0
+}
+
+// IsAdmin reports whether the stored user is an admin.
+func (s Service) IsAdmin(uid string) bool {
+	usr, err := s.store.User(uid)
+	return err == nil && usr.Admin
+}
+
+// SetAdmin promotes the user to an admin or demotes them. The last admin in
+// the store can't be demoted, so that there is always someone to manage the
+// users. Admins from the configuration don't count, they are not stored.
+func (s Service) SetAdmin(uid string, admin bool) error {
+	usr, err := s.store.User(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.SetAdmin: %w: [%s]", ErrUserNotFound, uid)
+	}
+	if err != nil {
+		return storeError("Service.SetAdmin", err)
+	}
+	if usr.Admin == admin {
+		return nil
+	}
+	if !admin {
+		users, err := s.store.Users(0, 0)
+		if err != nil {
+			return storeError("Service.SetAdmin", err)
+		}
+		admins := 0
+		for _, u := range users {
+			if u.Admin {
+				admins++
+			}
+		}
+		if admins <= 1 {
+			return fmt.Errorf("Service.SetAdmin: %w: [%s]", ErrLastAdmin, uid)
+		}
+	}
+	// only the flag is updated, so that a concurrent login doesn't lose
+	// its profile changes
+	usr.Admin = admin
+	if err = s.store.UpdateUser(usr, "admin"); err != nil {
+		return storeError("Service.SetAdmin", err)
+	}
+	return nil
+}
+
+// DeletePaste deletes a paste by its URL without checking who owns it,
+// callers must make sure that the user is allowed to do so.
+func (s Service) DeletePaste(url string) error {
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		return fmt.Errorf("Service.DeletePaste: %w: url [%s] (%v)", ErrPasteNotFound, url, err)
+	}
+	err = s.store.Delete(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.DeletePaste: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
+	}
+	if err != nil {
+		return storeError("Service.DeletePaste", err)
+	}
+	return nil
+}
+
+// ReportPaste records an abuse report for the paste and flags it. Reports
+// are limited per IP address to avoid spam.
+func (s Service) ReportPaste(url string, reason string, ip string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrEmptyReason
+	}
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		return fmt.Errorf("Service.ReportPaste: %w: url [%s] (%v)", ErrPasteNotFound, url, err)
+	}
+	p, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("Service.ReportPaste: %w: url [%s], id [%v]", ErrPasteNotFound, url, id)
+	}
+	if err != nil {
+		return storeError("Service.ReportPaste", err)
+	}
+	now := time.Now().UTC()
+	if s.maxReports > 0 && ip != "" && !InNetworks(ip, s.trustedNets) && s.store.CountReports(ip, now.Add(-time.Hour)) >= s.maxReports {
+		return fmt.Errorf("Service.ReportPaste: %w: ip [%s] has %d reports", ErrReportLimit, ip, s.maxReports)
+	}
+	_, err = s.store.SaveReport(store.Report{
+		PasteID:   p.ID,
+		Reason:    reason,
+		IP:        ip,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return storeError("Service.ReportPaste", err)
+	}
+	p.Reports++
+	if _, err = s.store.Update(p); err != nil {
+		return storeError("Service.ReportPaste", err)
+	}
+	return nil
+}
+
+// Reports returns a list of abuse reports, newest first, and the total
+// number of reports. It is meant for admins only.
+func (s Service) Reports(limit int, skip int) ([]store.Report, int64, error) {
+	reports, err := s.store.Reports(limit, skip)
+	if err != nil {
+		return nil, 0, storeError("Service.Reports", err)
+	}
+	return reports, s.store.CountReports("", time.Time{}), nil
+}
+
 // GetPastes returns a list of pastes for a particular user.
 func (s Service) GetPastes(uid string, sort string, limit int, skip int, privacy string) ([]store.Paste, error) {
-	pastes, err := s.store.Find(store.FindRequest{
+	return s.GetPastesCtx(context.Background(), uid, sort, limit, skip, privacy)
+}
+
+// GetPastesCtx is GetPastes that passes ctx to the store, so that it gives
+// up once ctx is done.
+func (s Service) GetPastesCtx(ctx context.Context, uid string, sort string, limit int, skip int, privacy string) ([]store.Paste, error) {
+	pastes, err := s.store.FindCtx(ctx, store.FindRequest{
 		UserID:  uid,
 		Sort:    sort,
 		Since:   time.Time{},
@@ -258,11 +1201,102 @@ func (s Service) GetPastes(uid string, sort string, limit int, skip int, privacy
 		Privacy: privacy,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("Service.GetPastes: %w: (%v)", ErrStoreFailure, err)
+		return nil, storeError("Service.GetPastes", err)
 	}
 	return pastes, nil
 }
 
+// UserPastes returns the user's most recent pastes, as many as the recent
+// pastes limit. Without a user the most recent public pastes are returned.
+func (s Service) UserPastes(uid string) ([]store.Paste, error) {
+	privacy := ""
+	if uid == "" {
+		privacy = "public"
+	}
+	return s.GetPastes(uid, "-created", s.recentLimit, 0, privacy)
+}
+
+// ExpiringPastes returns the user's pastes that expire within the given
+// duration, the soonest first and as many as the recent pastes limit.
+func (s Service) ExpiringPastes(uid string, within time.Duration) ([]store.Paste, error) {
+	return s.ExpiringPastesCtx(context.Background(), uid, within)
+}
+
+// ExpiringPastesCtx is ExpiringPastes that passes ctx to the store, so that
+// it gives up once ctx is done.
+func (s Service) ExpiringPastesCtx(ctx context.Context, uid string, within time.Duration) ([]store.Paste, error) {
+	if uid == "" || within <= 0 {
+		return []store.Paste{}, nil
+	}
+	pastes, err := s.store.FindCtx(ctx, store.FindRequest{
+		UserID:        uid,
+		Sort:          "+expires",
+		Limit:         s.recentLimit,
+		ExpiresBefore: time.Now().Add(within),
+	})
+	if err != nil {
+		return nil, storeError("Service.ExpiringPastes", err)
+	}
+	return pastes, nil
+}
+
+// MaxTrending is the maximum number of pastes TrendingPastes returns.
+const MaxTrending = 100
+
+// TrendingPastes returns the most viewed public pastes. The limit is capped
+// at MaxTrending, zero or negative limit means MaxTrending.
+func (s Service) TrendingPastes(limit int) ([]store.Paste, error) {
+	return s.TrendingPastesCtx(context.Background(), limit)
+}
+
+// TrendingPastesCtx is TrendingPastes that passes ctx to the store, so that
+// it gives up once ctx is done.
+func (s Service) TrendingPastesCtx(ctx context.Context, limit int) ([]store.Paste, error) {
+	if limit <= 0 || limit > MaxTrending {
+		limit = MaxTrending
+	}
+	pastes, err := s.store.FindCtx(ctx, store.FindRequest{
+		Sort:    "-views",
+		Limit:   limit,
+		Privacy: "public",
+	})
+	if err != nil {
+		return nil, storeError("Service.TrendingPastes", err)
+	}
+	return pastes, nil
+}
+
+// ArchivePastes returns a list of public pastes, only the ones with the
+// given syntax unless it is empty.
+func (s Service) ArchivePastes(syntax string, sort string, limit int, skip int) ([]store.Paste, error) {
+	return s.ArchivePastesCtx(context.Background(), syntax, sort, limit, skip)
+}
+
+// ArchivePastesCtx is ArchivePastes that passes ctx to the store, so that
+// it gives up once ctx is done.
+func (s Service) ArchivePastesCtx(ctx context.Context, syntax string, sort string, limit int, skip int) ([]store.Paste, error) {
+	pastes, err := s.store.FindCtx(ctx, store.FindRequest{
+		Sort:    sort,
+		Limit:   limit,
+		Skip:    skip,
+		Privacy: "public",
+		Syntax:  syntax,
+	})
+	if err != nil {
+		return nil, storeError("Service.ArchivePastes", err)
+	}
+	return pastes, nil
+}
+
+// ArchiveCount returns a number of public pastes, only the ones with the
+// given syntax unless it is empty.
+func (s Service) ArchiveCount(syntax string) int64 {
+	return s.store.Count(store.FindRequest{
+		Privacy: "public",
+		Syntax:  syntax,
+	})
+}
+
 // PastesCount return a number of pastes for a user.
 func (s Service) PastesCount(uid string, privacy string) int64 {
 	return s.store.Count(store.FindRequest{
@@ -271,6 +1305,22 @@ func (s Service) PastesCount(uid string, privacy string) int64 {
 	})
 }
 
+// Ping checks that the store is reachable.
+func (s Service) Ping() error {
+	if err := s.store.Ping(); err != nil {
+		return storeError("Service.Ping", err)
+	}
+	return nil
+}
+
+// Close closes the underlying store, the service can't be used afterwards.
+func (s Service) Close() error {
+	if err := s.store.Close(); err != nil {
+		return storeError("Service.Close", err)
+	}
+	return nil
+}
+
 // GetTotals returns total count of pastes and users.
 func (s Service) GetTotals() (pastes, users int64) {
 	return s.store.Totals()
@@ -0,0 +1,78 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// InstanceStats is the instance-wide paste and user counts.
+type InstanceStats struct {
+	Pastes   int64            `json:"pastes"`   // pastes not in the trash, of any privacy
+	Users    int64            `json:"users"`    // registered users
+	Syntaxes map[string]int64 `json:"syntaxes"` // pastes per syntax, only the public ones unless configured otherwise
+	Last24h  int64            `json:"last_24h"` // pastes created in the last 24 hours
+	Last7d   int64            `json:"last_7d"`  // pastes created in the last 7 days
+}
+
+// statsCache keeps the instance stats for a while, so that every request
+// doesn't go over all the pastes in the store.
+type statsCache struct {
+	sync.Mutex
+	ttl         time.Duration // how long the stats are kept, 0 means they are not
+	allSyntaxes bool          // the syntax breakdown includes private and unlisted pastes
+	now         func() time.Time
+	at          time.Time // when the stats were collected
+	stats       *InstanceStats
+}
+
+// WithInstanceStats sets how long InstanceStats keeps the collected stats,
+// the default is a minute and zero means they are collected every time.
+// The syntax breakdown includes private and unlisted pastes if allSyntaxes
+// is true, otherwise only the public ones.
+func WithInstanceStats(ttl time.Duration, allSyntaxes bool) Option {
+	return func(s *Service) {
+		s.stats = newStatsCache(ttl, allSyntaxes)
+	}
+}
+
+func newStatsCache(ttl time.Duration, allSyntaxes bool) *statsCache {
+	return &statsCache{
+		ttl:         ttl,
+		allSyntaxes: allSyntaxes,
+		now:         time.Now,
+	}
+}
+
+// InstanceStats returns the instance-wide paste and user counts. They are
+// collected at most once per the configured TTL, in between the same stats
+// are returned.
+func (s Service) InstanceStats() (InstanceStats, error) {
+	c := s.stats
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	if c.stats != nil && now.Sub(c.at) < c.ttl {
+		return *c.stats, nil
+	}
+
+	syntaxes, err := s.store.SyntaxCounts(!c.allSyntaxes)
+	if err != nil {
+		return InstanceStats{}, storeError("Service.InstanceStats", err)
+	}
+	stats := InstanceStats{
+		Syntaxes: syntaxes,
+		Last24h:  s.store.Count(store.FindRequest{All: true, Since: now.Add(-24 * time.Hour)}),
+		Last7d:   s.store.Count(store.FindRequest{All: true, Since: now.Add(-7 * 24 * time.Hour)}),
+	}
+	stats.Pastes, stats.Users = s.store.Totals()
+
+	c.stats, c.at = &stats, now
+	return stats, nil
+}
@@ -2,8 +2,11 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,6 +21,19 @@ var ErrNoUserID = fmt.Errorf("user must have a unique ID or Name")
 
 const defaultDirMode = 0o755
 
+// countersKey is the key of the persisted counters in the meta store.
+const countersKey = "counters"
+
+// publicIndexKey marks in the meta store that the index of public pastes
+// has been built.
+const publicIndexKey = "public_index"
+
+// diskCounters are the counters kept on disk, so that they don't have to be
+// counted on startup.
+type diskCounters struct {
+	Pastes int64 // pastes not in the trash
+}
+
 // DiskConfig is the input configuration for disk storage of pastes.
 type DiskConfig struct {
 	// DataDir must be a writsable director for storing pastes and users.
@@ -26,6 +42,10 @@ type DiskConfig struct {
 	CacheSize uint64 `long:"cache-size" env:"CACHE_SIZE" description:"file system storage cache size"`
 	// The file mode given to new folders. Uses a sane default it omitted.
 	DirMode os.FileMode `long:"dir-mode" env:"DIR_MODE" description:"file mode for new directories"`
+	// Create DataDir if it doesn't exist, otherwise it must exist already.
+	CreateDir bool `no-flag:"true"`
+	// Command line counterpart of CreateDir, go-flags booleans always default to false.
+	NoCreateDir bool `long:"no-create-dir" env:"NO_CREATE_DIR" description:"fail if the data directory doesn't exist instead of creating it"`
 }
 
 // DiskStore satisfies the main paste Interface.
@@ -33,9 +53,22 @@ type DiskStore struct {
 	users      *diskv.Diskv
 	pastes     *diskv.Diskv
 	userPastes *diskv.Diskv
+	userTrash  *diskv.Diskv // same as userPastes for the pastes in the trash
+	public     *diskv.Diskv // empty entries keyed by the IDs of public pastes not in the trash
+	reports    *diskv.Diskv
+	comments   *diskv.Diskv
+	resets     *diskv.Diskv
+	meta       *diskv.Diskv // persisted counters
 	pasteCount int64
+	changes    int64               // number of pasteCount changes, to tell if it changed during reconcileCount
+	bg         sync.WaitGroup      // background goroutines other than cleanExpired
 	userList   map[string]struct{} // we only use this for counts, but it could be expanded.
 	expiring   chan Paste
+	stop       chan struct{} // closed by Close to stop cleanExpired, writes fail afterwards
+	create     sync.Mutex    // makes checking a new paste ID and writing the paste atomic
+	newID      func() int64
+	done       chan struct{} // closed when cleanExpired exits
+	closeOnce  sync.Once
 	sync.RWMutex
 }
 
@@ -51,6 +84,9 @@ func NewDiskStorage(config *DiskConfig) (*DiskStore, error) {
 	store := &DiskStore{
 		userList: make(map[string]struct{}),
 		expiring: make(chan Paste),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		newID:    pasteID,
 		users: diskv.New(diskv.Options{
 			BasePath:     filepath.Join(config.DataDir, "users"),
 			CacheSizeMax: config.CacheSize,
@@ -63,6 +99,28 @@ func NewDiskStorage(config *DiskConfig) (*DiskStore, error) {
 			BasePath:     filepath.Join(config.DataDir, "user_pastes"),
 			CacheSizeMax: config.CacheSize,
 		}),
+		userTrash: diskv.New(diskv.Options{
+			BasePath:     filepath.Join(config.DataDir, "user_trash"),
+			CacheSizeMax: config.CacheSize,
+		}),
+		public: diskv.New(diskv.Options{
+			BasePath: filepath.Join(config.DataDir, "public_pastes"),
+		}),
+		reports: diskv.New(diskv.Options{
+			BasePath:     filepath.Join(config.DataDir, "reports"),
+			CacheSizeMax: config.CacheSize,
+		}),
+		comments: diskv.New(diskv.Options{
+			BasePath:     filepath.Join(config.DataDir, "comments"),
+			CacheSizeMax: config.CacheSize,
+		}),
+		resets: diskv.New(diskv.Options{
+			BasePath:     filepath.Join(config.DataDir, "reset_tokens"),
+			CacheSizeMax: config.CacheSize,
+		}),
+		meta: diskv.New(diskv.Options{
+			BasePath: filepath.Join(config.DataDir, "meta"),
+		}),
 	}
 
 	go store.cleanExpired()
@@ -72,6 +130,7 @@ func NewDiskStorage(config *DiskConfig) (*DiskStore, error) {
 }
 
 func (f *DiskStore) cleanExpired() {
+	defer close(f.done)
 	expiring := make(map[string]time.Time)
 
 	// Store all expiring paste IDs in memory on startup.
@@ -99,11 +158,13 @@ func (f *DiskStore) cleanExpired() {
 					delete(expiring, pasteID)
 				}
 			}
-		case paste, ok := <-f.expiring:
-			if !ok {
-				return // f.expiring can be closed to exit this loop.
-			} else if !paste.Expires.IsZero() {
+		case <-f.stop:
+			return
+		case paste := <-f.expiring:
+			if !paste.Expires.IsZero() {
 				expiring[f.intStr(paste.ID)] = paste.Expires
+			} else {
+				delete(expiring, f.intStr(paste.ID)) // the paste doesn't expire any more
 			}
 		}
 	}
@@ -114,6 +175,12 @@ func makeDiskStorageFolders(config *DiskConfig) error {
 		config.DirMode = defaultDirMode
 	}
 
+	if config.CreateDir {
+		if err := os.MkdirAll(config.DataDir, config.DirMode); err != nil {
+			return fmt.Errorf("creating data dir: %w", err)
+		}
+	}
+
 	dirStat, err := os.Stat(config.DataDir)
 	if err != nil {
 		return fmt.Errorf("data dir missing? %w", err)
@@ -153,19 +220,79 @@ func (f *DiskStore) Totals() (int64, int64) {
 	return f.pasteCount, int64(len(f.userList))
 }
 
+// Ping checks that the pastes data directory is still there.
+func (f *DiskStore) Ping() error {
+	if _, err := os.Stat(f.pastes.BasePath); err != nil {
+		return fmt.Errorf("disk.Ping: %w: (%v)", ErrConnection, err)
+	}
+	return nil
+}
+
+// Close stops the background goroutine that deletes expired pastes and waits
+// for it to exit. The diskv caches are read caches and all the writes are on
+// disk already, so there is nothing to flush.
+func (f *DiskStore) Close() error {
+	f.closeOnce.Do(func() { close(f.stop) })
+	<-f.done
+	f.bg.Wait()
+	return nil
+}
+
+// closed reports whether Close has been called.
+func (f *DiskStore) closed() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchExpiry tells cleanExpired about a new or updated paste. It gives up
+// once the store is closed, the paste is on disk already and its expiration
+// is picked up on the next start.
+func (f *DiskStore) watchExpiry(paste Paste) {
+	select {
+	case f.expiring <- paste:
+	case <-f.stop:
+	}
+}
+
 // Create new paste and return its id.
 func (f *DiskStore) Create(paste Paste) (int64, error) {
-	paste.ID = paste.CreatedAt.UnixNano()
+	return f.CreateCtx(context.Background(), paste)
+}
 
-	if err := f.writePaste(paste); err != nil {
-		return 0, err
+// CreateCtx is Create that gives up if ctx is done before the paste is
+// written.
+func (f *DiskStore) CreateCtx(ctx context.Context, paste Paste) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("disk.Create: %w", err)
+	}
+	if f.closed() {
+		return 0, fmt.Errorf("disk.Create: %w", ErrClosed)
 	}
 
-	f.expiring <- paste
+	f.create.Lock()
+	var i int
+	for i = 0; i < idRetries; i++ {
+		paste.ID = f.newID()
+		if !f.pastes.Has(f.intStr(paste.ID)) {
+			break
+		}
+	}
+	if i == idRetries {
+		f.create.Unlock()
+		return 0, fmt.Errorf("disk.Create: %w: id [%d]", ErrConflict, paste.ID)
+	}
+	err := f.writePaste(paste)
+	f.create.Unlock()
+	if err != nil {
+		return 0, err
+	}
 
-	f.Lock()
-	defer f.Unlock()
-	f.pasteCount++
+	f.watchExpiry(paste)
+	f.addPastes(1)
 
 	return paste.ID, nil
 }
@@ -179,24 +306,48 @@ func (f *DiskStore) writePaste(paste Paste) error {
 		return fmt.Errorf("writing user-paste: %w", err)
 	}
 
+	if err := f.indexPublic(paste); err != nil {
+		return fmt.Errorf("writing public paste: %w", err)
+	}
+
+	return nil
+}
+
+// indexPublic adds the paste to the index of public pastes if it is public
+// and not in the trash, and removes it from there otherwise.
+func (f *DiskStore) indexPublic(paste Paste) error {
+	key := f.intStr(paste.ID)
+	if paste.Privacy == "public" && !paste.Deleted() {
+		return f.public.Write(key, []byte{})
+	}
+	if f.public.Has(key) {
+		return f.public.Erase(key)
+	}
 	return nil
 }
 
 func (f *DiskStore) writeUsersPaste(paste Paste) error {
+	return f.addToIndex(f.userPastes, paste)
+}
+
+// addToIndex adds the paste to the list of the user's pastes in the index,
+// either userPastes or userTrash.
+func (f *DiskStore) addToIndex(index *diskv.Diskv, paste Paste) error {
 	if paste.User.ID == "" {
 		return nil
 	}
 
 	pasteList := make(map[int64]struct{})
-	_ = f.getFromDisk(f.userPastes, paste.User.ID, &pasteList)
+	_ = f.getFromDisk(index, paste.User.ID, &pasteList)
 	pasteList[paste.ID] = struct{}{}
 
-	return f.saveToDisk(f.userPastes, paste.User.ID, &pasteList)
+	return f.saveToDisk(index, paste.User.ID, &pasteList)
 }
 
-// Delete paste by id.
+// Delete paste by id, pastes in the trash are deleted too.
 func (f *DiskStore) Delete(pasteID int64) error {
-	paste, _ := f.Get(pasteID)
+	var paste Paste
+	_ = f.getFromDisk(f.pastes, f.intStr(pasteID), &paste)
 	return f.delete(paste)
 }
 
@@ -210,28 +361,47 @@ func (f *DiskStore) delete(paste Paste) error {
 		return fmt.Errorf("disk.Delete: %w", err)
 	}
 
-	f.Lock()
-	f.pasteCount--
-	f.Unlock()
+	// pastes in the trash are not counted
+	index := f.userTrash
+	if !paste.Deleted() {
+		index = f.userPastes
+		f.addPastes(-1)
+	}
+
+	if err := f.deletePasteReports(paste.ID); err != nil {
+		return fmt.Errorf("disk.Delete: %w", err)
+	}
+
+	if err := f.deletePasteComments(paste.ID); err != nil {
+		return fmt.Errorf("disk.Delete: %w", err)
+	}
+
+	if f.public.Has(f.intStr(paste.ID)) {
+		if err := f.public.Erase(f.intStr(paste.ID)); err != nil {
+			return fmt.Errorf("disk.Delete (public paste): %w", err)
+		}
+	}
 
 	if paste.User.ID != "" {
-		return f.deleteUserPaste(paste)
+		return f.deleteFromIndex(index, paste)
 	}
 
 	return nil
 }
 
-func (f *DiskStore) deleteUserPaste(paste Paste) error {
+// deleteFromIndex removes the paste from the list of the user's pastes in
+// the index, either userPastes or userTrash.
+func (f *DiskStore) deleteFromIndex(index *diskv.Diskv, paste Paste) error {
 	ikeys := make(map[int64]struct{})
 
-	err := f.getFromDisk(f.userPastes, paste.User.ID, &ikeys)
+	err := f.getFromDisk(index, paste.User.ID, &ikeys)
 	if err != nil {
 		return fmt.Errorf("disk.Delete (user-paste): %w", err)
 	}
 
 	delete(ikeys, paste.ID)
 
-	err = f.saveToDisk(f.userPastes, paste.User.ID, &ikeys)
+	err = f.saveToDisk(index, paste.User.ID, &ikeys)
 	if err != nil {
 		return fmt.Errorf("disk.Delete (save user-paste): %w", err)
 	}
@@ -239,17 +409,108 @@ func (f *DiskStore) deleteUserPaste(paste Paste) error {
 	return nil
 }
 
+// SoftDelete moves a paste to the trash. The paste stays where it is, only
+// it is moved from the user's pastes to the user's trash.
+func (f *DiskStore) SoftDelete(pasteID int64) error {
+	paste, err := f.Get(pasteID)
+	if err != nil {
+		return fmt.Errorf("disk.SoftDelete: %w", err)
+	}
+	now := time.Now().UTC()
+	paste.DeletedAt = &now
+	if err = f.saveToDisk(f.pastes, f.intStr(paste.ID), &paste); err != nil {
+		return fmt.Errorf("disk.SoftDelete: %w", err)
+	}
+	if err = f.indexPublic(paste); err != nil {
+		return fmt.Errorf("disk.SoftDelete: %w", err)
+	}
+
+	f.addPastes(-1)
+
+	if paste.User.ID == "" {
+		return nil
+	}
+	if err = f.deleteFromIndex(f.userPastes, paste); err != nil {
+		return fmt.Errorf("disk.SoftDelete: %w", err)
+	}
+	if err = f.addToIndex(f.userTrash, paste); err != nil {
+		return fmt.Errorf("disk.SoftDelete: %w", err)
+	}
+	return nil
+}
+
+// Restore takes the user's paste out of the trash.
+func (f *DiskStore) Restore(pasteID int64, userID string) error {
+	var paste Paste
+	if err := f.getFromDisk(f.pastes, f.intStr(pasteID), &paste); err != nil {
+		return fmt.Errorf("disk.Restore: %w", err)
+	}
+	if !paste.Deleted() || paste.User.ID != userID {
+		return fmt.Errorf("disk.Restore: %w: id [%d]", ErrNotFound, pasteID)
+	}
+	trashed := paste
+	paste.DeletedAt = nil
+	if err := f.saveToDisk(f.pastes, f.intStr(paste.ID), &paste); err != nil {
+		return fmt.Errorf("disk.Restore: %w", err)
+	}
+	if err := f.indexPublic(paste); err != nil {
+		return fmt.Errorf("disk.Restore: %w", err)
+	}
+
+	f.addPastes(1)
+
+	if paste.User.ID == "" {
+		return nil
+	}
+	if err := f.deleteFromIndex(f.userTrash, trashed); err != nil {
+		return fmt.Errorf("disk.Restore: %w", err)
+	}
+	if err := f.addToIndex(f.userPastes, paste); err != nil {
+		return fmt.Errorf("disk.Restore: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the pastes moved to the trash before a time. It reads all
+// the pastes, so it is meant to run in the background once in a while.
+func (f *DiskStore) Purge(before time.Time) (int64, error) {
+	var purged int64
+	for key := range f.pastes.Keys(nil) {
+		var paste Paste
+		if err := f.getFromDisk(f.pastes, key, &paste); err != nil {
+			continue // deleted in the meantime
+		}
+		if !paste.Deleted() || !paste.DeletedAt.Before(before) {
+			continue
+		}
+		if err := f.delete(paste); err != nil {
+			return purged, fmt.Errorf("disk.Purge: %w", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
 // Find pastes.
 func (f *DiskStore) Find(req FindRequest) ([]Paste, error) {
+	return f.FindCtx(context.Background(), req)
+}
+
+// FindCtx is Find that stops reading the pastes once ctx is done.
+func (f *DiskStore) FindCtx(ctx context.Context, req FindRequest) ([]Paste, error) {
 	var (
 		keys   = []string{}
 		pastes = []Paste{}
 	)
 
 	if req.UserID != "" {
+		index := f.userPastes
+		if req.Deleted {
+			index = f.userTrash
+		}
 		ikeys := make(map[int64]struct{})
 
-		err := f.getFromDisk(f.userPastes, req.UserID, &ikeys)
+		err := f.getFromDisk(index, req.UserID, &ikeys)
 		if err != nil {
 			return pastes, nil //nolint:nilerr // user has no pastes, do not return an error
 		}
@@ -257,50 +518,74 @@ func (f *DiskStore) Find(req FindRequest) ([]Paste, error) {
 		for ikey := range ikeys {
 			keys = append(keys, f.intStr(ikey))
 		}
+	} else if f.publicOnly(req) {
+		for key := range f.public.Keys(nil) {
+			keys = append(keys, key)
+		}
 	} else {
 		for key := range f.pastes.Keys(nil) {
 			keys = append(keys, key)
 		}
 	}
 
-	var sortCreated bool
-	if req.Sort == "+created" {
-		sortCreated = true
-		sort.Strings(keys)
-	} else if req.Sort == "-created" {
-		sortCreated = true
-		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
-	}
-
+	// the IDs are random, so the pastes are sorted by their fields and only
+	// the ones up to the requested page are kept
+	top := newTopPastes(req, len(keys))
 	for _, key := range keys {
-		if sortCreated && req.Limit != 0 && len(pastes) >= req.Limit {
-			break
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("disk.Find: %w", err)
 		}
 
 		var paste Paste
-		if err := f.getFromDisk(f.pastes, key, &paste); err != nil {
+		err := f.getFromDisk(f.pastes, key, &paste)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted in the meantime
+		}
+		if err != nil {
 			return nil, fmt.Errorf("disk.Find: %w", err)
 		}
 
-		if filterPaste(req, paste) {
-			pastes = append(pastes, paste)
+		if filterPaste(req, &paste) {
+			top.add(&paste)
 		}
 	}
 
-	if !sortCreated {
-		sortPastes(req, pastes)
-	}
-
-	if sortCreated && req.Limit > 0 {
-		return pastes, nil
-	}
+	return top.page(req.Skip), nil
+}
 
-	// Slice with skip and limit
-	return limitPastes(req, pastes), nil
+// publicOnly reports whether only public pastes not in the trash can match
+// the request, so that the index of public pastes can be used instead of
+// going over all of them.
+func (f *DiskStore) publicOnly(req FindRequest) bool {
+	return req.UserID == "" && req.Privacy == "public" && !req.All && !req.Deleted
 }
 
 // Count return pastes count for a user.
 func (f *DiskStore) Count(req FindRequest) int64 {
+	if req.UserID == "" && req.Deleted {
+		pastes, err := f.Find(FindRequest{All: true, Deleted: true, IP: req.IP, Limit: math.MaxInt32})
+		if err != nil {
+			return 0
+		}
+		return int64(len(pastes))
+	}
+
+	if req.Syntax != "" || !req.Since.IsZero() || !req.ExpiresBefore.IsZero() {
+		pastes, err := f.Find(FindRequest{UserID: req.UserID, Privacy: req.Privacy, Syntax: req.Syntax, IP: req.IP, Since: req.Since, ExpiresBefore: req.ExpiresBefore, All: req.All, Limit: math.MaxInt32})
+		if err != nil {
+			return 0
+		}
+		return int64(len(pastes))
+	}
+
+	if f.publicOnly(req) && req.IP == "" {
+		var count int64
+		for range f.public.Keys(nil) {
+			count++
+		}
+		return count
+	}
+
 	if req.UserID == "" {
 		f.RLock()
 		defer f.RUnlock()
@@ -308,40 +593,111 @@ func (f *DiskStore) Count(req FindRequest) int64 {
 		return f.pasteCount
 	}
 
+	if req.IP != "" {
+		pastes, err := f.Find(FindRequest{UserID: req.UserID, IP: req.IP, Limit: math.MaxInt32})
+		if err != nil {
+			return 0
+		}
+		return int64(len(pastes))
+	}
+
+	index := f.userPastes
+	if req.Deleted {
+		index = f.userTrash
+	}
 	pasteList := make(map[int64]struct{})
-	if err := f.getFromDisk(f.userPastes, req.UserID, &pasteList); err != nil {
+	if err := f.getFromDisk(index, req.UserID, &pasteList); err != nil {
 		return 0
 	}
 
 	return int64(len(pasteList))
 }
 
+// SyntaxCounts returns the number of pastes per syntax.
+func (f *DiskStore) SyntaxCounts(publicOnly bool) (map[string]int64, error) {
+	req := FindRequest{All: true, Limit: math.MaxInt32}
+	if publicOnly {
+		req = FindRequest{Privacy: "public", Limit: math.MaxInt32}
+	}
+	pastes, err := f.Find(req)
+	if err != nil {
+		return nil, fmt.Errorf("DiskStore.SyntaxCounts: %w", err)
+	}
+	counts := make(map[string]int64)
+	for _, p := range pastes {
+		counts[p.Syntax]++
+	}
+	return counts, nil
+}
+
 // Get paste by id.
 func (f *DiskStore) Get(pasteID int64) (Paste, error) {
+	return f.GetCtx(context.Background(), pasteID)
+}
+
+// GetCtx is Get that gives up if ctx is already done.
+func (f *DiskStore) GetCtx(ctx context.Context, pasteID int64) (Paste, error) {
+	if err := ctx.Err(); err != nil {
+		return Paste{}, fmt.Errorf("disk.Get: %w", err)
+	}
+
 	var paste Paste
 	if err := f.getFromDisk(f.pastes, f.intStr(pasteID), &paste); err != nil {
 		return paste, fmt.Errorf("disk.Get: %w", err)
 	}
 
+	if paste.Deleted() {
+		return Paste{}, fmt.Errorf("disk.Get: %w: id [%d] is in the trash", ErrNotFound, pasteID)
+	}
+
+	// expired pastes are gone as far as the caller is concerned
 	if !paste.Expires.IsZero() && paste.Expires.Before(time.Now()) {
-		_ = f.Delete(pasteID)
-		return Paste{}, fmt.Errorf("paste expired")
+		_ = f.delete(paste)
+		return Paste{}, fmt.Errorf("disk.Get: %w: id [%d] expired", ErrNotFound, pasteID)
 	}
 
 	return paste, nil
 }
 
+// GetMany returns the pastes with the given IDs in the same order. Missing
+// and expired pastes are omitted.
+func (f *DiskStore) GetMany(ids []int64) ([]Paste, error) {
+	pastes := make([]Paste, 0, len(ids))
+	for _, id := range ids {
+		p, err := f.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("disk.GetMany: %w", err)
+		}
+		pastes = append(pastes, p)
+	}
+	return pastesInOrder(pastes, ids), nil
+}
+
 // Update paste information and return updated paste.
 func (f *DiskStore) Update(paste Paste) (Paste, error) {
-	if existing, err := f.Get(paste.ID); err != nil {
+	if f.closed() {
+		return paste, fmt.Errorf("disk.Update: %w", ErrClosed)
+	}
+	existing, err := f.Get(paste.ID)
+	if err != nil {
 		return existing, err
 	}
 
-	if err := f.writePaste(paste); err != nil {
+	if err = f.writePaste(paste); err != nil {
 		return paste, err
 	}
 
-	f.expiring <- paste
+	// the paste has a new owner, take it out of the old owner's pastes
+	if existing.User.ID != "" && existing.User.ID != paste.User.ID {
+		if err = f.deleteFromIndex(f.userPastes, existing); err != nil {
+			return paste, err
+		}
+	}
+
+	f.watchExpiry(paste)
 
 	return paste, nil
 }
@@ -359,6 +715,26 @@ func (f *DiskStore) SaveUser(user User) (string, error) {
 	return user.ID, nil
 }
 
+// UpdateUser updates the given fields of an existing user, the profile
+// fields by default.
+func (f *DiskStore) UpdateUser(user User, fields ...string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	var old User
+	if err := f.getFromDisk(f.users, user.ID, &old); err != nil {
+		return fmt.Errorf("disk.UpdateUser: %w", err)
+	}
+	if _, err := updateUserFields(&old, user, fields); err != nil {
+		return fmt.Errorf("disk.UpdateUser: %w", err)
+	}
+	if err := f.saveToDisk(f.users, user.ID, &old); err != nil {
+		return fmt.Errorf("disk.UpdateUser: %w", err)
+	}
+
+	return nil
+}
+
 // User returns a user by id.
 func (f *DiskStore) User(userID string) (User, error) {
 	var user User
@@ -369,6 +745,210 @@ func (f *DiskStore) User(userID string) (User, error) {
 	return user, nil
 }
 
+// Users returns a list of users sorted by ID.
+func (f *DiskStore) Users(limit, skip int) ([]User, error) {
+	f.RLock()
+	ids := make([]string, 0, len(f.userList))
+	for id := range f.userList {
+		ids = append(ids, id)
+	}
+	f.RUnlock()
+	sort.Strings(ids)
+	if skip > len(ids) {
+		skip = len(ids)
+	}
+	ids = ids[skip:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+
+	users := []User{}
+	for _, id := range ids {
+		usr, err := f.User(id)
+		if err != nil {
+			return nil, fmt.Errorf("disk.Users: %w", err)
+		}
+		users = append(users, usr)
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user by id together with the user's paste list.
+func (f *DiskStore) DeleteUser(userID string) error {
+	if f.users.Has(userID) {
+		if err := f.users.Erase(userID); err != nil {
+			return fmt.Errorf("disk.DeleteUser: %w", err)
+		}
+	}
+	if f.userPastes.Has(userID) {
+		if err := f.userPastes.Erase(userID); err != nil {
+			return fmt.Errorf("disk.DeleteUser (user-paste): %w", err)
+		}
+	}
+	if f.userTrash.Has(userID) {
+		if err := f.userTrash.Erase(userID); err != nil {
+			return fmt.Errorf("disk.DeleteUser (user-trash): %w", err)
+		}
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	delete(f.userList, userID)
+
+	return nil
+}
+
+// SaveReport stores a new abuse report and returns its ID.
+func (f *DiskStore) SaveReport(r Report) (int64, error) {
+	r.ID = RandomID()
+	if err := f.saveToDisk(f.reports, f.intStr(r.ID), &r); err != nil {
+		return 0, fmt.Errorf("disk.SaveReport: %w", err)
+	}
+	return r.ID, nil
+}
+
+// allReports reads all the reports from disk.
+func (f *DiskStore) allReports() ([]Report, error) {
+	reports := []Report{}
+	for key := range f.reports.Keys(nil) {
+		var r Report
+		if err := f.getFromDisk(f.reports, key, &r); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// Reports returns a list of abuse reports, newest first.
+func (f *DiskStore) Reports(limit, skip int) ([]Report, error) {
+	reports, err := f.allReports()
+	if err != nil {
+		return nil, fmt.Errorf("disk.Reports: %w", err)
+	}
+	return limitReports(reports, limit, skip), nil
+}
+
+// CountReports returns the number of reports since a time, only from the IP
+// address if it is not empty.
+func (f *DiskStore) CountReports(ip string, since time.Time) int64 {
+	reports, _ := f.allReports()
+	return countReports(reports, ip, since)
+}
+
+// deletePasteReports deletes all the reports of the paste.
+func (f *DiskStore) deletePasteReports(pasteID int64) error {
+	reports, err := f.allReports()
+	if err != nil {
+		return err
+	}
+	for _, r := range reports {
+		if r.PasteID != pasteID {
+			continue
+		}
+		if err := f.reports.Erase(f.intStr(r.ID)); err != nil {
+			return fmt.Errorf("deleting report: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddComment stores a new comment and returns its ID.
+func (f *DiskStore) AddComment(c Comment) (int64, error) {
+	c.ID = RandomID()
+	if err := f.saveToDisk(f.comments, f.intStr(c.ID), &c); err != nil {
+		return 0, fmt.Errorf("disk.AddComment: %w", err)
+	}
+	return c.ID, nil
+}
+
+// allComments reads all the comments from disk.
+func (f *DiskStore) allComments() ([]Comment, error) {
+	comments := []Comment{}
+	for key := range f.comments.Keys(nil) {
+		var c Comment
+		if err := f.getFromDisk(f.comments, key, &c); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// Comments returns the comments of a paste, oldest first.
+func (f *DiskStore) Comments(pasteID int64) ([]Comment, error) {
+	all, err := f.allComments()
+	if err != nil {
+		return nil, fmt.Errorf("disk.Comments: %w", err)
+	}
+	comments := []Comment{}
+	for _, c := range all {
+		if c.PasteID == pasteID {
+			comments = append(comments, c)
+		}
+	}
+	return sortComments(comments), nil
+}
+
+// DeleteComment deletes a comment by ID.
+func (f *DiskStore) DeleteComment(id int64) error {
+	if !f.comments.Has(f.intStr(id)) {
+		return fmt.Errorf("disk.DeleteComment: %w: id [%d]", ErrNotFound, id)
+	}
+	if err := f.comments.Erase(f.intStr(id)); err != nil {
+		return fmt.Errorf("disk.DeleteComment: %w", err)
+	}
+	return nil
+}
+
+// deletePasteComments deletes all the comments of the paste.
+func (f *DiskStore) deletePasteComments(pasteID int64) error {
+	comments, err := f.allComments()
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if c.PasteID != pasteID {
+			continue
+		}
+		if err := f.comments.Erase(f.intStr(c.ID)); err != nil {
+			return fmt.Errorf("deleting comment: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveResetToken stores a password reset token.
+func (f *DiskStore) SaveResetToken(t ResetToken) error {
+	if f.resets.Has(t.Hash) {
+		return fmt.Errorf("disk.SaveResetToken: %w", ErrConflict)
+	}
+	if err := f.saveToDisk(f.resets, t.Hash, &t); err != nil {
+		return fmt.Errorf("disk.SaveResetToken: %w", err)
+	}
+	return nil
+}
+
+// ResetToken returns a password reset token by its hash.
+func (f *DiskStore) ResetToken(hash string) (ResetToken, error) {
+	var t ResetToken
+	if err := f.getFromDisk(f.resets, hash, &t); err != nil {
+		return t, fmt.Errorf("disk.ResetToken: %w", err)
+	}
+	return t, nil
+}
+
+// DeleteResetToken deletes a password reset token by its hash.
+func (f *DiskStore) DeleteResetToken(hash string) error {
+	if !f.resets.Has(hash) {
+		return nil
+	}
+	if err := f.resets.Erase(hash); err != nil {
+		return fmt.Errorf("disk.DeleteResetToken: %w", err)
+	}
+	return nil
+}
+
 // fillCaches stores the user list and paste count in memory.
 // This should only run once on startup.
 // The data is appended-to and updated as the app runs.
@@ -380,8 +960,88 @@ func (f *DiskStore) fillCaches() {
 		f.userList[username] = struct{}{}
 	}
 
+	// The paste count is persisted, so that the pastes don't have to be
+	// counted on every start. It is only counted here the first time and
+	// checked in the background otherwise.
+	if !f.meta.Has(publicIndexKey) {
+		f.indexPublicPastes()
+	}
+
+	var counters diskCounters
+	if err := f.getFromDisk(f.meta, countersKey, &counters); err == nil {
+		f.pasteCount = counters.Pastes
+		f.bg.Add(1)
+		go f.reconcileCount()
+		return
+	}
+	f.pasteCount = f.countPastes()
+	f.saveCounters()
+}
+
+// indexPublicPastes builds the index of public pastes by going over all of
+// them, for the data written before there was an index. Pastes that can't
+// be read are left out of it.
+func (f *DiskStore) indexPublicPastes() {
+	for key := range f.pastes.Keys(nil) {
+		var paste Paste
+		if err := f.getFromDisk(f.pastes, key, &paste); err == nil {
+			_ = f.indexPublic(paste)
+		}
+	}
+	_ = f.meta.Write(publicIndexKey, []byte{})
+}
+
+// countPastes counts the pastes not in the trash by going over all of them.
+func (f *DiskStore) countPastes() int64 {
+	var count int64
 	for range f.pastes.Keys(nil) {
-		f.pasteCount++
+		count++
+	}
+
+	// pastes in the trash are not counted
+	for uid := range f.userTrash.Keys(nil) {
+		trash := make(map[int64]struct{})
+		if err := f.getFromDisk(f.userTrash, uid, &trash); err == nil {
+			count -= int64(len(trash))
+		}
+	}
+	return count
+}
+
+// addPastes changes the paste count by n and persists it.
+func (f *DiskStore) addPastes(n int64) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.pasteCount += n
+	f.changes++
+	f.saveCounters()
+}
+
+// saveCounters persists the counters, it must be called with the lock
+// held. The error is ignored because the count is reconciled with the
+// pastes on the next start anyway.
+func (f *DiskStore) saveCounters() {
+	_ = f.saveToDisk(f.meta, countersKey, &diskCounters{Pastes: f.pasteCount})
+}
+
+// reconcileCount counts the pastes and fixes the persisted count if it is
+// wrong, for example after a crash. The count is left alone if pastes were
+// created or deleted while counting.
+func (f *DiskStore) reconcileCount() {
+	defer f.bg.Done()
+
+	f.RLock()
+	changes := f.changes
+	f.RUnlock()
+
+	count := f.countPastes()
+
+	f.Lock()
+	defer f.Unlock()
+	if f.changes == changes && f.pasteCount != count {
+		f.pasteCount = count
+		f.saveCounters()
 	}
 }
 
@@ -404,6 +1064,9 @@ func (f *DiskStore) saveToDisk(disk *diskv.Diskv, storeID string, data interface
 
 func (f *DiskStore) getFromDisk(disk *diskv.Diskv, storeID string, data interface{}) error {
 	buf, err := disk.ReadStream(storeID, true)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("reading storage (id:%s): %w", storeID, ErrNotFound)
+	}
 	if err != nil {
 		return fmt.Errorf("reading storage (id:%s): %w", storeID, err)
 	}
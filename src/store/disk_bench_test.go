@@ -0,0 +1,30 @@
+package store
+
+import (
+	"testing"
+)
+
+// newBenchDiskStorage returns an empty disk store that is closed when the
+// benchmark is over.
+func newBenchDiskStorage(b *testing.B) *DiskStore {
+	b.Helper()
+
+	s, err := NewDiskStorage(&DiskConfig{DataDir: b.TempDir()})
+	if err != nil {
+		b.Fatalf("got error making disk store: %s", err)
+	}
+	b.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func BenchmarkDiskCreate(b *testing.B) {
+	benchmarkCreate(b, newBenchDiskStorage(b))
+}
+
+func BenchmarkDiskGet(b *testing.B) {
+	benchmarkGet(b, newBenchDiskStorage(b))
+}
+
+func BenchmarkDiskFind(b *testing.B) {
+	benchmarkFind(b, newBenchDiskStorage(b))
+}
@@ -1,9 +1,13 @@
 package store
 
 import (
+	"errors"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -122,10 +126,10 @@ func TestDiskDelete(t *testing.T) {
 		t.Fatalf("failed to delete paste: %v", err)
 	}
 	p, err := ddb.Get(paste.ID)
-	if err == nil {
-		t.Fatalf("expected an error, but did not get one")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be deleted but found %+v", p)
 	}
 }
@@ -195,6 +199,42 @@ func TestDiskFind(t *testing.T) {
 	}
 }
 
+// TestDiskFindPublicSkip tests that skip is honored when public pastes are
+// sorted by creation time.
+func TestDiskFindPublicSkip(t *testing.T) {
+	t.Parallel()
+
+	// Make dedicated storage so the results are not changed by other tests.
+	dir, ddb := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 15; i++ {
+		p := randomPaste(User{})
+		p.CreatedAt = time.Now().AddDate(0, 0, -1*i)
+		ddb.Create(p)
+	}
+
+	for _, srt := range []string{"-created", "+created"} {
+		first, err := ddb.Find(FindRequest{Sort: srt, Limit: 10, Privacy: "public"})
+		if err != nil {
+			t.Fatalf("failed to find pastes: %v", err)
+		}
+		second, err := ddb.Find(FindRequest{Sort: srt, Limit: 10, Skip: 10, Privacy: "public"})
+		if err != nil {
+			t.Fatalf("failed to find pastes: %v", err)
+		}
+		if len(first) != 10 || len(second) != 5 {
+			t.Fatalf("expected to find 10 and 5 pastes for %s, got %d and %d", srt, len(first), len(second))
+		}
+		if first[0].ID == second[0].ID {
+			t.Errorf("expected second page to be different from the first for %s", srt)
+		}
+		if (srt == "-created") != first[9].CreatedAt.After(second[0].CreatedAt) {
+			t.Errorf("expected pages to be sorted by %s", srt)
+		}
+	}
+}
+
 func TestDiskUpdate(t *testing.T) {
 	t.Parallel()
 
@@ -225,7 +265,7 @@ func TestDiskUpdateNonExisting(t *testing.T) {
 	paste := randomPaste(User{})
 	p, _ := ddb.Update(paste)
 
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be empty, got [%+v]", p)
 	}
 }
@@ -257,3 +297,367 @@ func TestDiskGetUserNotExisting(t *testing.T) {
 		t.Errorf("expected user to be empty, got %+v", u)
 	}
 }
+
+func TestDiskPing(t *testing.T) {
+	t.Parallel()
+
+	dir, ddb := makeTestDiskStorage(t)
+	if err := ddb.Ping(); err != nil {
+		t.Errorf("expected ping to succeed, got %v", err)
+	}
+	os.RemoveAll(dir)
+	if err := ddb.Ping(); !errors.Is(err, ErrConnection) {
+		t.Errorf("expected ping to fail with ErrConnection after the data dir is removed, got %v", err)
+	}
+}
+
+func TestDiskCreateConflict(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	// pastes created at the same time get different IDs
+	paste := randomPaste(User{})
+	taken, err := m.Create(paste)
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	if id, err := m.Create(paste); err != nil || id == taken {
+		t.Errorf("expected another ID for the same paste, got %d (%v)", id, err)
+	}
+
+	// the first ID collides, the second is free
+	ids := []int64{taken, taken + 1}
+	m.newID = func() int64 {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	if id, err := m.Create(paste); err != nil || id != taken+1 {
+		t.Errorf("expected the collision to be retried with ID %d, got %d (%v)", taken+1, id, err)
+	}
+
+	// every ID collides
+	m.newID = func() int64 { return taken }
+	if _, err := m.Create(paste); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict after %d collisions, got %v", idRetries, err)
+	}
+}
+
+// TestDiskFindCreatedOrder tests that the pastes are sorted by the creation
+// time, not by their random IDs.
+func TestDiskFindCreatedOrder(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	usr := randomUser()
+	created := time.Now().UTC()
+	var want []int64
+	for i := 0; i < 10; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		id, err := m.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		want = append(want, id)
+	}
+
+	pastes, err := m.Find(FindRequest{UserID: usr.ID, Sort: "-created", Skip: 2, Limit: 3})
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	var got []int64
+	for _, p := range pastes {
+		got = append(got, p.ID)
+	}
+	if want := []int64{want[7], want[6], want[5]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected pastes %v, got %v", want, got)
+	}
+}
+
+func TestDiskDeleteUser(t *testing.T) {
+	t.Parallel()
+
+	usr := randomUser()
+	if _, err := ddb.SaveUser(usr); err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	if _, err := ddb.Create(randomPaste(usr)); err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	if err := ddb.DeleteUser(usr.ID); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if _, err := ddb.User(usr.ID); err == nil {
+		t.Errorf("expected user to be deleted")
+	}
+	if got := ddb.Count(FindRequest{UserID: usr.ID}); got != 0 {
+		t.Errorf("expected user paste list to be deleted, got %d pastes", got)
+	}
+	// deleting a user that doesn't exist is not an error
+	if err := ddb.DeleteUser(usr.ID); err != nil {
+		t.Errorf("expected no error deleting non-existing user, got %v", err)
+	}
+}
+
+// TestDiskCreateDir tests that the data directory is created only when
+// CreateDir is set.
+func TestDiskCreateDir(t *testing.T) {
+	t.Parallel()
+
+	base, err := os.MkdirTemp("", "go-pb-tests")
+	if err != nil {
+		t.Fatalf("got error making disk store folder: %s", err)
+	}
+	defer os.RemoveAll(base)
+
+	tests := []struct {
+		name      string
+		dir       string
+		createDir bool
+		wantErr   bool
+	}{
+		{"existing strict", base, false, false},
+		{"existing create", base, true, false},
+		{"missing strict", filepath.Join(base, "missing"), false, true},
+		{"missing create", filepath.Join(base, "new", "data"), true, false},
+	}
+	for _, tc := range tests {
+		_, err := NewDiskStorage(&DiskConfig{DataDir: tc.dir, CreateDir: tc.createDir})
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error making disk store: %s", tc.name, err)
+			continue
+		}
+		for _, sub := range []string{"users", "pastes", "user_pastes"} {
+			if st, err := os.Stat(filepath.Join(tc.dir, sub)); err != nil || !st.IsDir() {
+				t.Errorf("%s: expected %s folder to be created, got %v", tc.name, sub, err)
+			}
+		}
+	}
+}
+
+// TestDiskClose tests that the background goroutine exits after Close.
+func TestDiskClose(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("got error closing disk store: %s", err)
+	}
+	select {
+	case <-m.done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the background goroutine to exit")
+	}
+	// closing twice is fine
+	if err := m.Close(); err != nil {
+		t.Errorf("got error closing disk store twice: %s", err)
+	}
+}
+
+// TestDiskWriteAfterClose tests that writes racing with Close don't panic
+// and the ones after it fail.
+func TestDiskWriteAfterClose(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	usr := randomUser()
+	p := randomPaste(usr)
+	id, err := m.Create(p)
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	p.ID = id
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = m.Update(p)
+		}()
+	}
+	if err = m.Close(); err != nil {
+		t.Fatalf("got error closing disk store: %s", err)
+	}
+	wg.Wait()
+
+	if _, err = m.Create(randomPaste(usr)); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed creating a paste, got %v", err)
+	}
+	if _, err = m.Update(p); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed updating a paste, got %v", err)
+	}
+}
+
+// TestDiskPersistedCount tests that the paste count survives a restart and
+// that a wrong persisted count is fixed in the background.
+func TestDiskPersistedCount(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	usr := randomUser()
+	var ids []int64
+	for i := 0; i < 10; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = p.CreatedAt.Add(time.Duration(i) * time.Microsecond)
+		id, err := m.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids[:3] {
+		if err := m.Delete(id); err != nil {
+			t.Fatalf("failed to delete paste: %v", err)
+		}
+	}
+	if err := m.SoftDelete(ids[3]); err != nil {
+		t.Fatalf("failed to move paste to the trash: %v", err)
+	}
+	if pastes, _ := m.Totals(); pastes != 6 {
+		t.Fatalf("expected 6 pastes, got %d", pastes)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("got error closing disk store: %s", err)
+	}
+
+	reopen := func() *DiskStore {
+		t.Helper()
+		m, err := NewDiskStorage(&DiskConfig{DataDir: dir})
+		if err != nil {
+			t.Fatalf("got error making disk store: %s", err)
+		}
+		// Close waits for the count to be reconciled
+		if err := m.Close(); err != nil {
+			t.Fatalf("got error closing disk store: %s", err)
+		}
+		return m
+	}
+
+	if pastes, _ := reopen().Totals(); pastes != 6 {
+		t.Errorf("expected 6 pastes after restart, got %d", pastes)
+	}
+
+	// a wrong count, e.g. after a crash, is fixed
+	if err := m.saveToDisk(m.meta, countersKey, &diskCounters{Pastes: 42}); err != nil {
+		t.Fatalf("failed to save counters: %v", err)
+	}
+	if pastes, _ := reopen().Totals(); pastes != 6 {
+		t.Errorf("expected the count to be reconciled to 6, got %d", pastes)
+	}
+	m = reopen()
+	var counters diskCounters
+	if err := m.getFromDisk(m.meta, countersKey, &counters); err != nil || counters.Pastes != 6 {
+		t.Errorf("expected the persisted count to be 6, got %d (%v)", counters.Pastes, err)
+	}
+}
+
+// TestDiskArchivePages checks that the public pastes are listed page by page
+// from the index after a mix of changes, and that the index is rebuilt for
+// a data directory without one.
+func TestDiskArchivePages(t *testing.T) {
+	t.Parallel()
+
+	dir, m := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+
+	usr := randomUser()
+	now := time.Now()
+	var public []int64 // newest first
+	for i := 0; i < 30; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = now.Add(-time.Duration(i) * time.Minute)
+		if i%3 == 0 {
+			p.Privacy = "private"
+		}
+		id, err := m.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		if p.Privacy == "public" {
+			public = append(public, id)
+		}
+	}
+	// delete, trash and hide some of the public pastes
+	if err := m.Delete(public[0]); err != nil {
+		t.Fatalf("failed to delete paste: %v", err)
+	}
+	if err := m.SoftDelete(public[5]); err != nil {
+		t.Fatalf("failed to trash paste: %v", err)
+	}
+	p, err := m.Get(public[10])
+	if err != nil {
+		t.Fatalf("failed to get paste: %v", err)
+	}
+	p.Privacy = "unlisted"
+	if _, err = m.Update(p); err != nil {
+		t.Fatalf("failed to update paste: %v", err)
+	}
+	want := []int64{}
+	for i, id := range public {
+		if i != 0 && i != 5 && i != 10 {
+			want = append(want, id)
+		}
+	}
+
+	check := func(s *DiskStore) {
+		t.Helper()
+		if c := s.Count(FindRequest{Privacy: "public"}); c != int64(len(want)) {
+			t.Errorf("expected %d public pastes, got %d", len(want), c)
+		}
+		var got []int64
+		for skip := 0; skip < len(want)+5; skip += 5 {
+			pastes, err := s.Find(FindRequest{Privacy: "public", Sort: "-created", Limit: 5, Skip: skip})
+			if err != nil {
+				t.Fatalf("failed to find pastes: %v", err)
+			}
+			for _, p := range pastes {
+				got = append(got, p.ID)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d public pastes in pages, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("expected paste %d at %d, got %d", want[i], i, got[i])
+			}
+		}
+	}
+	check(m)
+
+	// the index is rebuilt when it's not there
+	if err = m.Close(); err != nil {
+		t.Fatalf("got error closing disk store: %s", err)
+	}
+	if err = os.RemoveAll(filepath.Join(dir, "public_pastes")); err != nil {
+		t.Fatalf("failed to remove the index: %v", err)
+	}
+	if err = m.meta.Erase(publicIndexKey); err != nil {
+		t.Fatalf("failed to remove the index marker: %v", err)
+	}
+	m, err = NewDiskStorage(&DiskConfig{DataDir: dir})
+	if err != nil {
+		t.Fatalf("got error making disk store: %s", err)
+	}
+	defer m.Close()
+	check(m)
+}
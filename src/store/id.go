@@ -0,0 +1,61 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package store
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"sync/atomic"
+)
+
+// idRetries is how many random IDs the stores try when creating a paste
+// before giving up with ErrConflict.
+const idRetries = 5
+
+// Bounds of the paste ID bit width, see SetIDBits.
+const (
+	MinIDBits = 16
+	MaxIDBits = 63
+)
+
+// idBits is the bit width of the random paste IDs, 0 means MaxIDBits.
+var idBits atomic.Int64
+
+// SetIDBits limits the random paste IDs generated by the stores to the
+// given number of bits, so that the URLs are shorter.
+// Fewer bits mean more collisions, which are retried. Zero means MaxIDBits.
+// It is meant to be called once on startup.
+func SetIDBits(bits int) {
+	idBits.Store(int64(bits))
+}
+
+// RandomID returns a random positive int64 read from crypto/rand, so that
+// the IDs can't be predicted from each other or from the process start time.
+func RandomID() int64 {
+	return 1 + randomInt63n(math.MaxInt64)
+}
+
+// pasteID returns a random positive paste ID within the configured bit
+// width.
+func pasteID() int64 {
+	n := int64(math.MaxInt64)
+	if bits := idBits.Load(); bits > 0 && bits < MaxIDBits {
+		n = 1<<bits - 1
+	}
+	return 1 + randomInt63n(n)
+}
+
+// randomInt63n returns a uniformly random number in [0, n).
+func randomInt63n(n int64) int64 {
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		// the system random source is broken, nothing sensible to fall
+		// back to
+		panic(fmt.Sprintf("store: reading random ID: %v", err))
+	}
+	return v.Int64()
+}
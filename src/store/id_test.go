@@ -0,0 +1,100 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestRandomID(t *testing.T) {
+	t.Parallel()
+
+	seen := make(map[int64]bool)
+	for i := 0; i < 10000; i++ {
+		id := RandomID()
+		if id <= 0 {
+			t.Fatalf("expected a positive ID, got %d", id)
+		}
+		if seen[id] {
+			t.Fatalf("expected unique IDs, got %d twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+// The IDs must not depend on the process start, so two runs of the test
+// binary must give different IDs
+func TestRandomIDProcesses(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("GOPB_PRINT_RANDOM_ID") == "1" {
+		fmt.Print(RandomID())
+		os.Exit(0)
+	}
+	run := func() string {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRandomIDProcesses$") // #nosec
+		cmd.Env = append(os.Environ(), "GOPB_PRINT_RANDOM_ID=1")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("failed to run the test binary: %v", err)
+		}
+		return string(out)
+	}
+	if a, b := run(), run(); a == "" || a == b {
+		t.Errorf("expected different IDs from two processes, got %q and %q", a, b)
+	}
+}
+
+// TestIDBits changes the global bit width, so it doesn't run in parallel
+func TestIDBits(t *testing.T) {
+	defer SetIDBits(0)
+
+	for _, bits := range []int{MinIDBits, 32, MaxIDBits, 0} {
+		SetIDBits(bits)
+		limit := int64(1<<MaxIDBits - 1)
+		if bits != 0 && bits < MaxIDBits {
+			limit = 1<<bits - 1
+		}
+		for i := 0; i < 1000; i++ {
+			if id := pasteID(); id <= 0 || id > limit {
+				t.Fatalf("expected %d bit ID within (0, %d], got %d", bits, limit, id)
+			}
+		}
+	}
+}
+
+func TestCreateIDCollision(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemDB()
+	taken, err := m.Create(Paste{Title: "taken"})
+	if err != nil {
+		t.Fatalf("failed to create a paste: %v", err)
+	}
+
+	// the first ID collides, the second is free
+	ids := []int64{taken, taken + 1}
+	m.newID = func() int64 {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	id, err := m.Create(Paste{Title: "retried"})
+	if err != nil {
+		t.Fatalf("expected the collision to be retried, got %v", err)
+	}
+	if id != taken+1 {
+		t.Errorf("expected ID %d, got %d", taken+1, id)
+	}
+	if p, _ := m.Get(taken); p.Title != "taken" {
+		t.Errorf("expected the existing paste to stay intact, got %q", p.Title)
+	}
+
+	// every ID collides
+	m.newID = func() int64 { return taken }
+	if _, err := m.Create(Paste{Title: "conflict"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict after %d collisions, got %v", idRetries, err)
+	}
+}
@@ -5,11 +5,11 @@
 package store
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"sort"
-	"strings"
 	"sync"
+	"time"
 )
 
 // MemDB is a memory storage that implements the store.Interface.
@@ -17,8 +17,12 @@ import (
 // process exits. It's not completely useless though. You can use it when a
 // temporary sharing is needed or as a cache for another storage.
 type MemDB struct {
-	pastes map[int64]Paste
-	users  map[string]User
+	pastes   map[int64]Paste
+	users    map[string]User
+	reports  map[int64]Report
+	comments map[int64]Comment
+	resets   map[string]ResetToken
+	newID    func() int64
 	sync.RWMutex
 }
 
@@ -27,6 +31,10 @@ func NewMemDB() *MemDB {
 	var s MemDB
 	s.pastes = make(map[int64]Paste)
 	s.users = make(map[string]User)
+	s.reports = make(map[int64]Report)
+	s.comments = make(map[int64]Comment)
+	s.resets = make(map[string]ResetToken)
+	s.newID = pasteID
 
 	return &s
 }
@@ -36,18 +44,48 @@ func (m *MemDB) Totals() (pastes, users int64) {
 	m.RLock()
 	defer m.RUnlock()
 
-	return int64(len(m.pastes)), int64(len(m.users))
+	for _, p := range m.pastes {
+		if !p.Deleted() {
+			pastes++
+		}
+	}
+	return pastes, int64(len(m.users))
+}
+
+// Ping always succeeds for the memory store.
+func (m *MemDB) Ping() error {
+	return nil
+}
+
+// Close does nothing for MemDB.
+func (m *MemDB) Close() error {
+	return nil
 }
 
 // Create creates and stores a new paste returning its ID.
 func (m *MemDB) Create(p Paste) (id int64, err error) {
+	return m.CreateCtx(context.Background(), p)
+}
+
+// CreateCtx is Create that gives up if ctx is done before the paste is
+// stored.
+func (m *MemDB) CreateCtx(ctx context.Context, p Paste) (id int64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("MemDB.Create: %w", err)
+	}
+
 	m.Lock()
 	defer m.Unlock()
 
-	p.ID = rand.Int63() // #nosec
-	m.pastes[p.ID] = p
+	for i := 0; i < idRetries; i++ {
+		p.ID = m.newID()
+		if _, ok := m.pastes[p.ID]; !ok {
+			m.pastes[p.ID] = p
+			return p.ID, nil
+		}
+	}
 
-	return p.ID, nil
+	return 0, fmt.Errorf("MemDB.Create: %w: id [%d]", ErrConflict, p.ID)
 }
 
 // Delete deletes a paste by ID.
@@ -56,29 +94,65 @@ func (m *MemDB) Delete(id int64) error {
 	defer m.Unlock()
 
 	delete(m.pastes, id)
+	for rid, r := range m.reports {
+		if r.PasteID == id {
+			delete(m.reports, rid)
+		}
+	}
+	m.deletePasteComments(id)
 
 	return nil
 }
 
-// Find return a sorted list of pastes for a given request.
+// Find return a sorted list of pastes for a given request. Only the pastes
+// up to the requested page are kept and sorted, not all that match.
 func (m *MemDB) Find(req FindRequest) (pastes []Paste, err error) {
-	pastes = []Paste{}
+	return m.FindCtx(context.Background(), req)
+}
+
+// ctxCheckInterval is how many pastes FindCtx goes over between the checks
+// of the context, checking it on every paste would slow the search down.
+const ctxCheckInterval = 1000
 
+// FindCtx is Find that stops going over the pastes once ctx is done.
+func (m *MemDB) FindCtx(ctx context.Context, req FindRequest) (pastes []Paste, err error) {
 	m.RLock()
-	// Find all the pastes for a user.
+	top := newTopPastes(req, len(m.pastes))
+	var n int
 	for _, p := range m.pastes {
-		if filterPaste(req, p) {
-			pastes = append(pastes, p)
+		n++
+		if n%ctxCheckInterval == 0 && ctx.Err() != nil {
+			m.RUnlock()
+			return nil, fmt.Errorf("MemDB.Find: %w", ctx.Err())
+		}
+		if filterPaste(req, &p) {
+			top.add(&p)
 		}
 	}
 	m.RUnlock()
-	// Sort
-	sortPastes(req, pastes)
-	// Slice with skip and limit
-	return limitPastes(req, pastes), nil
+
+	return top.page(req.Skip), nil
 }
 
-func filterPaste(req FindRequest, paste Paste) bool {
+func filterPaste(req FindRequest, paste *Paste) bool {
+	if paste.Deleted() != req.Deleted {
+		return false
+	}
+	if req.IP != "" && paste.IP != req.IP {
+		return false
+	}
+	if req.Syntax != "" && paste.Syntax != req.Syntax {
+		return false
+	}
+	if !req.Since.IsZero() && paste.CreatedAt.Before(req.Since) {
+		return false
+	}
+	if !req.ExpiresBefore.IsZero() && (paste.Expires.IsZero() || paste.Expired() || !paste.Expires.Before(req.ExpiresBefore)) {
+		return false
+	}
+	if req.All {
+		return true
+	}
 	if req.UserID == "" {
 		if req.Privacy != "" && paste.Privacy == req.Privacy {
 			return true
@@ -94,28 +168,116 @@ func filterPaste(req FindRequest, paste Paste) bool {
 	return false
 }
 
+// pasteOrder returns the order of the pastes for the sort of the request.
+// Pastes that are equal otherwise are ordered by ID, so that the pages of
+// a list don't overlap.
+func pasteOrder(sortBy string) func(a, b *Paste) bool {
+	var less func(a, b *Paste) bool
+	switch sortBy {
+	case "-created":
+		less = func(a, b *Paste) bool { return a.CreatedAt.After(b.CreatedAt) }
+	case "+expires":
+		less = func(a, b *Paste) bool { return a.Expires.Before(b.Expires) }
+	case "-expires":
+		less = func(a, b *Paste) bool { return a.Expires.After(b.Expires) }
+	case "+views":
+		less = func(a, b *Paste) bool { return a.Views < b.Views }
+	case "-views":
+		less = func(a, b *Paste) bool { return a.Views > b.Views }
+	default:
+		less = func(a, b *Paste) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	}
+	return func(a, b *Paste) bool {
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	}
+}
+
 func sortPastes(req FindRequest, pastes []Paste) {
+	less := pasteOrder(req.Sort)
 	sort.Slice(pastes, func(i, j int) bool {
-		switch req.Sort {
-		case "+created", "-created":
-			if strings.HasPrefix(req.Sort, "-") {
-				return pastes[i].CreatedAt.After(pastes[j].CreatedAt)
-			}
-			return pastes[i].CreatedAt.Before(pastes[j].CreatedAt)
-		case "+expires", "-expires":
-			if strings.HasPrefix(req.Sort, "-") {
-				return pastes[i].Expires.After(pastes[j].Expires)
-			}
-			return pastes[i].Expires.Before(pastes[j].Expires)
-		case "+views", "-views":
-			if strings.HasPrefix(req.Sort, "-") {
-				return pastes[i].Views > pastes[j].Views
+		return less(&pastes[i], &pastes[j])
+	})
+}
+
+// topPastes keeps the first n pastes in the order of a request, where n is
+// enough for the requested page. Once it is full the last of them is at the
+// root of a heap, so a new paste is only compared to it to be let in.
+type topPastes struct {
+	n      int
+	less   func(a, b *Paste) bool
+	pastes []Paste
+}
+
+// newTopPastes returns an empty topPastes for the request, total is the
+// number of pastes that will be added at most.
+func newTopPastes(req FindRequest, total int) *topPastes {
+	n := req.Skip + req.Limit
+	if n < 0 {
+		n = 0
+	}
+	size := n
+	if size > total {
+		size = total
+	}
+	return &topPastes{n: n, less: pasteOrder(req.Sort), pastes: make([]Paste, 0, size)}
+}
+
+// add keeps the paste if it is among the first n so far.
+func (t *topPastes) add(p *Paste) {
+	if len(t.pastes) < t.n {
+		t.pastes = append(t.pastes, *p)
+		if len(t.pastes) == t.n {
+			for i := t.n/2 - 1; i >= 0; i-- {
+				t.down(i)
 			}
-			return pastes[i].Views <= pastes[j].Views
-		default:
-			return pastes[i].CreatedAt.Before(pastes[j].CreatedAt)
 		}
+		return
+	}
+	if t.n == 0 || !t.less(p, &t.pastes[0]) {
+		return
+	}
+	t.pastes[0] = *p
+	t.down(0)
+}
+
+// down moves the paste at i down the heap until both its children come
+// before it.
+func (t *topPastes) down(i int) {
+	for {
+		c := 2*i + 1
+		if c >= len(t.pastes) {
+			return
+		}
+		if c+1 < len(t.pastes) && t.less(&t.pastes[c], &t.pastes[c+1]) {
+			c++
+		}
+		if !t.less(&t.pastes[i], &t.pastes[c]) {
+			return
+		}
+		t.pastes[i], t.pastes[c] = t.pastes[c], t.pastes[i]
+		i = c
+	}
+}
+
+// page returns the kept pastes in order, without the first skip of them.
+func (t *topPastes) page(skip int) []Paste {
+	less := t.less
+	sort.Slice(t.pastes, func(i, j int) bool {
+		return less(&t.pastes[i], &t.pastes[j])
 	})
+	if skip > len(t.pastes) {
+		skip = len(t.pastes)
+	}
+	if skip < 0 {
+		skip = 0
+	}
+	return t.pastes[skip:]
 }
 
 func limitPastes(req FindRequest, pastes []Paste) []Paste {
@@ -139,27 +301,62 @@ func (m *MemDB) Count(req FindRequest) int64 {
 	// Count all the pastes for a user
 	var cnt int64
 	for _, p := range m.pastes {
-		if req.UserID == "" {
-			if req.Privacy != "" && p.Privacy == req.Privacy {
-				cnt++
-			}
-		} else if p.User.ID == req.UserID {
-			if req.Privacy == "" {
-				cnt++
-			} else if p.Privacy == req.Privacy {
-				cnt++
-			}
+		if filterPaste(req, &p) {
+			cnt++
 		}
 	}
 	return cnt
 }
 
+// SyntaxCounts returns the number of pastes per syntax.
+func (m *MemDB) SyntaxCounts(publicOnly bool) (map[string]int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, p := range m.pastes {
+		if p.Deleted() || (publicOnly && p.Privacy != "public") {
+			continue
+		}
+		counts[p.Syntax]++
+	}
+	return counts, nil
+}
+
 // Get returns a paste by ID.
 func (m *MemDB) Get(id int64) (Paste, error) {
+	return m.GetCtx(context.Background(), id)
+}
+
+// GetCtx is Get that gives up if ctx is already done.
+func (m *MemDB) GetCtx(ctx context.Context, id int64) (Paste, error) {
+	if err := ctx.Err(); err != nil {
+		return Paste{}, fmt.Errorf("MemDB.Get: %w", err)
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	p, ok := m.pastes[id]
+	if !ok || p.Deleted() {
+		return Paste{}, fmt.Errorf("MemDB.Get: %w: id [%d]", ErrNotFound, id)
+	}
+	return p, nil
+}
+
+// GetMany returns the pastes with the given IDs in the same order. Missing
+// and expired pastes are omitted.
+func (m *MemDB) GetMany(ids []int64) ([]Paste, error) {
 	m.RLock()
 	defer m.RUnlock()
 
-	return m.pastes[id], nil
+	pastes := make([]Paste, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := m.pastes[id]; ok {
+			pastes = append(pastes, p)
+		}
+	}
+	return pastesInOrder(pastes, ids), nil
 }
 
 // SaveUser creates a new or updates an existing user.
@@ -172,6 +369,24 @@ func (m *MemDB) SaveUser(usr User) (id string, err error) {
 	return usr.ID, nil
 }
 
+// UpdateUser updates the given fields of an existing user, the profile
+// fields by default.
+func (m *MemDB) UpdateUser(usr User, fields ...string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	old, ok := m.users[usr.ID]
+	if !ok {
+		return fmt.Errorf("MemDB.UpdateUser: %w: id [%s]", ErrNotFound, usr.ID)
+	}
+	if _, err := updateUserFields(&old, usr, fields); err != nil {
+		return fmt.Errorf("MemDB.UpdateUser: %w", err)
+	}
+	m.users[usr.ID] = old
+
+	return nil
+}
+
 // User returns a user by ID.
 func (m *MemDB) User(id string) (User, error) {
 	m.RLock()
@@ -181,21 +396,210 @@ func (m *MemDB) User(id string) (User, error) {
 	var ok bool
 
 	if usr, ok = m.users[id]; !ok {
-		return User{}, fmt.Errorf("MemDB.User: user not found")
+		return User{}, fmt.Errorf("MemDB.User: %w: id [%s]", ErrNotFound, id)
 	}
 	return usr, nil
 }
 
-// Update updates existing paste.
-func (m *MemDB) Update(p Paste) (Paste, error) {
+// Users returns a list of users sorted by ID.
+func (m *MemDB) Users(limit, skip int) ([]User, error) {
 	m.RLock()
-	if _, ok := m.pastes[p.ID]; !ok {
-		m.RUnlock()
-		return Paste{}, nil
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
 	}
 	m.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return limitUsers(users, limit, skip), nil
+}
+
+// DeleteUser deletes a user by ID.
+func (m *MemDB) DeleteUser(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.users, id)
+
+	return nil
+}
+
+// Update updates existing paste.
+func (m *MemDB) Update(p Paste) (Paste, error) {
+	// the check and the write are under the same lock, so that a paste
+	// deleted in between is not put back
 	m.Lock()
 	defer m.Unlock()
+	if old, ok := m.pastes[p.ID]; !ok || old.Deleted() {
+		return Paste{}, fmt.Errorf("MemDB.Update: %w: id [%d]", ErrNotFound, p.ID)
+	}
 	m.pastes[p.ID] = p
 	return p, nil
 }
+
+// SaveReport stores a new abuse report and returns its ID.
+func (m *MemDB) SaveReport(r Report) (id int64, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	r.ID = RandomID()
+	m.reports[r.ID] = r
+
+	return r.ID, nil
+}
+
+// Reports returns a list of abuse reports, newest first.
+func (m *MemDB) Reports(limit, skip int) ([]Report, error) {
+	m.RLock()
+	reports := make([]Report, 0, len(m.reports))
+	for _, r := range m.reports {
+		reports = append(reports, r)
+	}
+	m.RUnlock()
+
+	return limitReports(reports, limit, skip), nil
+}
+
+// CountReports returns the number of reports since a time, only from the IP
+// address if it is not empty.
+func (m *MemDB) CountReports(ip string, since time.Time) int64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	reports := make([]Report, 0, len(m.reports))
+	for _, r := range m.reports {
+		reports = append(reports, r)
+	}
+	return countReports(reports, ip, since)
+}
+
+// SaveResetToken stores a password reset token.
+func (m *MemDB) SaveResetToken(t ResetToken) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.resets[t.Hash]; ok {
+		return fmt.Errorf("MemDB.SaveResetToken: %w", ErrConflict)
+	}
+	m.resets[t.Hash] = t
+	return nil
+}
+
+// ResetToken returns a password reset token by its hash.
+func (m *MemDB) ResetToken(hash string) (ResetToken, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	t, ok := m.resets[hash]
+	if !ok {
+		return ResetToken{}, fmt.Errorf("MemDB.ResetToken: %w", ErrNotFound)
+	}
+	return t, nil
+}
+
+// DeleteResetToken deletes a password reset token by its hash.
+func (m *MemDB) DeleteResetToken(hash string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.resets, hash)
+	return nil
+}
+
+// SoftDelete moves a paste to the trash.
+func (m *MemDB) SoftDelete(id int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pastes[id]
+	if !ok || p.Deleted() {
+		return fmt.Errorf("MemDB.SoftDelete: %w: id [%d]", ErrNotFound, id)
+	}
+	now := time.Now().UTC()
+	p.DeletedAt = &now
+	m.pastes[id] = p
+	return nil
+}
+
+// Restore takes the user's paste out of the trash.
+func (m *MemDB) Restore(id int64, userID string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pastes[id]
+	if !ok || !p.Deleted() || p.User.ID != userID {
+		return fmt.Errorf("MemDB.Restore: %w: id [%d]", ErrNotFound, id)
+	}
+	p.DeletedAt = nil
+	m.pastes[id] = p
+	return nil
+}
+
+// Purge deletes the pastes moved to the trash before a time.
+func (m *MemDB) Purge(before time.Time) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var purged int64
+	for id, p := range m.pastes {
+		if p.Deleted() && p.DeletedAt.Before(before) {
+			delete(m.pastes, id)
+			for rid, r := range m.reports {
+				if r.PasteID == id {
+					delete(m.reports, rid)
+				}
+			}
+			m.deletePasteComments(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AddComment stores a new comment and returns its ID.
+func (m *MemDB) AddComment(c Comment) (id int64, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	c.ID = RandomID()
+	m.comments[c.ID] = c
+
+	return c.ID, nil
+}
+
+// Comments returns the comments of a paste, oldest first.
+func (m *MemDB) Comments(pasteID int64) ([]Comment, error) {
+	m.RLock()
+	comments := []Comment{}
+	for _, c := range m.comments {
+		if c.PasteID == pasteID {
+			comments = append(comments, c)
+		}
+	}
+	m.RUnlock()
+
+	return sortComments(comments), nil
+}
+
+// DeleteComment deletes a comment by ID.
+func (m *MemDB) DeleteComment(id int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.comments[id]; !ok {
+		return fmt.Errorf("MemDB.DeleteComment: %w: id [%d]", ErrNotFound, id)
+	}
+	delete(m.comments, id)
+
+	return nil
+}
+
+// deletePasteComments deletes all the comments of the paste, the caller
+// must hold the lock.
+func (m *MemDB) deletePasteComments(pasteID int64) {
+	for cid, c := range m.comments {
+		if c.PasteID == pasteID {
+			delete(m.comments, cid)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// benchPastes is the number of pastes in the store for the Get and Find
+// benchmarks, spread over benchUsers users.
+const (
+	benchPastes = 10000
+	benchUsers  = 100
+)
+
+// fillStore creates benchPastes pastes, a second apart, and returns their
+// IDs and the first user.
+func fillStore(b *testing.B, s Interface) ([]int64, User) {
+	b.Helper()
+
+	users := make([]User, benchUsers)
+	for i := range users {
+		users[i] = randomUser()
+		if _, err := s.SaveUser(users[i]); err != nil {
+			b.Fatalf("failed to save user: %v", err)
+		}
+	}
+	ids := make([]int64, 0, benchPastes)
+	now := time.Now()
+	for i := 0; i < benchPastes; i++ {
+		p := randomPaste(users[i%benchUsers])
+		p.CreatedAt = now.Add(-time.Duration(i) * time.Second)
+		id, err := s.Create(p)
+		if err != nil {
+			b.Fatalf("failed to create paste: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, users[0]
+}
+
+func benchmarkCreate(b *testing.B, s Interface) {
+	users := make([]User, benchUsers)
+	for i := range users {
+		users[i] = randomUser()
+	}
+	pastes := make([]Paste, b.N)
+	for i := range pastes {
+		pastes[i] = randomPaste(users[i%benchUsers])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Create(pastes[i]); err != nil {
+			b.Fatalf("failed to create paste: %v", err)
+		}
+	}
+}
+
+func benchmarkGet(b *testing.B, s Interface) {
+	ids, _ := fillStore(b, s)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Get(ids[i%len(ids)]); err != nil {
+			b.Fatalf("failed to get paste: %v", err)
+		}
+	}
+}
+
+// benchmarkFind runs the list queries of the pages: the user's and the
+// public pastes, newest first, ten at a time.
+func benchmarkFind(b *testing.B, s Interface) {
+	_, usr := fillStore(b, s)
+
+	for _, bc := range []struct {
+		name string
+		req  FindRequest
+	}{
+		{"user", FindRequest{UserID: usr.ID, Sort: "-created", Limit: 10}},
+		{"user-skip", FindRequest{UserID: usr.ID, Sort: "-created", Limit: 10, Skip: 50}},
+		{"public", FindRequest{Privacy: "public", Sort: "-created", Limit: 10}},
+		{"public-views", FindRequest{Privacy: "public", Sort: "-views", Limit: 10}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				pastes, err := s.Find(bc.req)
+				if err != nil {
+					b.Fatalf("failed to find pastes: %v", err)
+				}
+				if len(pastes) != 10 {
+					b.Fatalf("expected 10 pastes, got %d", len(pastes))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemDBCreate(b *testing.B) {
+	benchmarkCreate(b, NewMemDB())
+}
+
+func BenchmarkMemDBGet(b *testing.B) {
+	benchmarkGet(b, NewMemDB())
+}
+
+func BenchmarkMemDBFind(b *testing.B) {
+	benchmarkFind(b, NewMemDB())
+}
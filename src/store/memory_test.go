@@ -1,8 +1,10 @@
 package store
 
 import (
+	"errors"
 	"math/rand"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -96,10 +98,10 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("failed to delete paste: %v", err)
 	}
 	p, err := mdb.Get(paste.ID)
-	if err != nil {
-		t.Fatalf("failed to get paste: %v", err)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be deleted but found %+v", p)
 	}
 }
@@ -169,6 +171,56 @@ func TestFind(t *testing.T) {
 	}
 }
 
+// TestFindPages checks that the pages Find returns are the same as of the
+// list of all the matching pastes sorted.
+func TestFindPages(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemDB()
+	usr := randomUser()
+	now := time.Now()
+	var all []Paste
+	for i := 0; i < 50; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = now.Add(-time.Duration(rand.Intn(20)) * time.Minute)
+		p.Expires = now.Add(time.Duration(1+rand.Intn(20)) * time.Hour)
+		p.Views = rand.Int63n(10)
+		id, err := m.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		p.ID = id
+		all = append(all, p)
+	}
+
+	for _, order := range []string{"", "+created", "-created", "+expires", "-expires", "+views", "-views"} {
+		want := append([]Paste{}, all...)
+		sortPastes(FindRequest{Sort: order}, want)
+		for _, page := range []struct{ skip, limit int }{{0, 10}, {10, 10}, {45, 10}, {0, 50}, {60, 10}, {0, 0}} {
+			got, err := m.Find(FindRequest{UserID: usr.ID, Sort: order, Skip: page.skip, Limit: page.limit})
+			if err != nil {
+				t.Fatalf("failed to find pastes: %v", err)
+			}
+			end, skip := page.skip+page.limit, page.skip
+			if end > len(want) {
+				end = len(want)
+			}
+			if skip > len(want) {
+				skip = len(want)
+			}
+			if len(got) != end-skip {
+				t.Fatalf("sort %q skip %d limit %d: expected %d pastes, got %d", order, page.skip, page.limit, end-skip, len(got))
+			}
+			for i := range got {
+				if got[i].ID != want[skip+i].ID {
+					t.Errorf("sort %q skip %d limit %d: expected paste %d at %d, got %d", order, page.skip, page.limit, want[skip+i].ID, i, got[i].ID)
+					break
+				}
+			}
+		}
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	t.Parallel()
 
@@ -192,6 +244,35 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+// TestUpdateDeleteRace tests that an update racing with a delete doesn't
+// put the deleted paste back.
+func TestUpdateDeleteRace(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < 100; i++ {
+		id, err := mdb.Create(randomPaste(User{}))
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		paste, _ := mdb.Get(id)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			paste.Views++
+			_, _ = mdb.Update(paste)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = mdb.Delete(id)
+		}()
+		wg.Wait()
+		if _, err = mdb.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the deleted paste to stay deleted, got %v", err)
+		}
+	}
+}
+
 func TestUpdateNonExisting(t *testing.T) {
 	t.Parallel()
 
@@ -199,7 +280,7 @@ func TestUpdateNonExisting(t *testing.T) {
 	paste := randomPaste(User{})
 	p, _ := mdb.Update(paste)
 
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be empty, got [%+v]", p)
 	}
 }
@@ -5,9 +5,14 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net"
 	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -17,26 +22,44 @@ import (
 // PostgresDB is a Postgres SQL databasse storage that implements the
 // store.Interface.
 type PostgresDB struct {
-	db *gorm.DB
+	db    *gorm.DB
+	newID func() int64
+}
+
+// Default connection pool settings of PostgresDB.
+const (
+	defaultMaxOpenConns    = 25
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+)
+
+// PostgresPool is the connection pool configuration of PostgresDB. Zero
+// values are replaced with the defaults.
+type PostgresPool struct {
+	MaxOpenConns    int           // maximum number of open connections
+	MaxIdleConns    int           // maximum number of idle connections
+	ConnMaxLifetime time.Duration // maximum time a connection may be reused
 }
 
 // NewPostgresDB initialises a new instance of PostgresDB and returns.
 // It tries to establish a database connection specified by conn and if
-// autoMigrate is true it will try and create/alter all the tables.
-func NewPostgresDB(conn string, autoMigrate bool) (*PostgresDB, error) {
+// autoMigrate is true it will try and create/alter all the tables. The
+// connection pool is configured with pool.
+func NewPostgresDB(conn string, autoMigrate bool, pool PostgresPool) (*PostgresDB, error) {
 	var pg PostgresDB
-	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresDB: failed to establish database connection: %w: (%v)", ErrConnection, err)
+	}
+	sqlDB, err := db.DB()
 	if err != nil {
-		return nil, fmt.Errorf("NewPostgresDB: failed to establish database connection: %w", err)
+		return nil, fmt.Errorf("NewPostgresDB: %w", err)
 	}
+	pool.apply(sqlDB)
 	if autoMigrate {
-		err = db.AutoMigrate(&Paste{})
+		err = db.AutoMigrate(&Paste{}, &PasteFile{}, &Report{}, &Comment{}, &ResetToken{})
 	} else {
-		if d, e := db.DB(); e == nil {
-			err = d.Ping()
-		} else {
-			err = e
-		}
+		err = sqlDB.Ping()
 	}
 
 	if err != nil {
@@ -44,24 +67,110 @@ func NewPostgresDB(conn string, autoMigrate bool) (*PostgresDB, error) {
 	}
 
 	pg.db = db
+	pg.newID = pasteID
 
 	return &pg, nil
 }
 
+// apply sets the pool configuration on the database handle.
+func (p PostgresPool) apply(db *sql.DB) {
+	if p.MaxOpenConns == 0 {
+		p.MaxOpenConns = defaultMaxOpenConns
+	}
+	if p.MaxIdleConns == 0 {
+		p.MaxIdleConns = defaultMaxIdleConns
+	}
+	if p.ConnMaxLifetime == 0 {
+		p.ConnMaxLifetime = defaultConnMaxLifetime
+	}
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+}
+
+// pgError wraps the gorm and driver errors into the store errors, other
+// errors, including the ones of a done context, are returned as they are.
+func pgError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("%w: (%v)", ErrNotFound, err)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return fmt.Errorf("%w: (%v)", ErrConflict, err)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return fmt.Errorf("%w: (%v)", ErrConnection, err)
+	}
+	return err
+}
+
+// AfterFind is a gorm hook that converts the paste times to UTC, the driver
+// returns them in the server's timezone.
+func (p *Paste) AfterFind(tx *gorm.DB) error {
+	p.CreatedAt = p.CreatedAt.UTC()
+	if !p.Expires.IsZero() {
+		p.Expires = p.Expires.UTC()
+	}
+	if p.DeletedAt != nil {
+		deleted := p.DeletedAt.UTC()
+		p.DeletedAt = &deleted
+	}
+	return nil
+}
+
 // Totals returns total count of pastes and users.
 func (pg *PostgresDB) Totals() (pastes, users int64) {
-	pg.db.Model(&Paste{}).Count(&pastes)
+	pg.db.Model(&Paste{}).Where("deleted_at IS NULL").Count(&pastes)
 	pg.db.Model(&User{}).Count(&users)
 	return
 }
 
+// Ping checks that the database is reachable.
+func (pg *PostgresDB) Ping() error {
+	db, err := pg.db.DB()
+	if err != nil {
+		return fmt.Errorf("PostgresDB.Ping: %w", pgError(err))
+	}
+	if err = db.Ping(); err != nil {
+		return fmt.Errorf("PostgresDB.Ping: %w: (%v)", ErrConnection, err)
+	}
+	return nil
+}
+
+// Close closes the database connections.
+func (pg *PostgresDB) Close() error {
+	db, err := pg.db.DB()
+	if err != nil {
+		return fmt.Errorf("PostgresDB.Close: %w", pgError(err))
+	}
+	if err = db.Close(); err != nil {
+		return fmt.Errorf("PostgresDB.Close: %w", pgError(err))
+	}
+	return nil
+}
+
 // Create creates and stores a new paste returning its ID.
 func (pg *PostgresDB) Create(p Paste) (id int64, err error) {
-	p.ID = rand.Int63() // #nosec
-	if p.User.ID == "" {
-		err = pg.db.Omit("user_id").Create(&p).Error
-	} else {
-		err = pg.db.Create(&p).Error
+	return pg.CreateCtx(context.Background(), p)
+}
+
+// CreateCtx is Create that runs the query with ctx, it is cancelled once
+// ctx is done.
+func (pg *PostgresDB) CreateCtx(ctx context.Context, p Paste) (id int64, err error) {
+	db := pg.db.WithContext(ctx)
+	// The ID is random, so a unique violation is most likely a collision
+	// and worth retrying with another one.
+	for i := 0; i < idRetries; i++ {
+		p.ID = pg.newID()
+		if p.User.ID == "" {
+			err = db.Omit("user_id").Create(&p).Error
+		} else {
+			err = db.Create(&p).Error
+		}
+		if err = pgError(err); !errors.Is(err, ErrConflict) {
+			break
+		}
 	}
 	if err != nil {
 		return 0, fmt.Errorf("PostgresDB.Create: %w", err)
@@ -74,13 +183,19 @@ func (pg *PostgresDB) Delete(id int64) error {
 	if id == 0 {
 		return fmt.Errorf("PostgresDB.Delete: id cannot be null")
 	}
+	if err := pg.db.Where("paste_id = ?", id).Delete(&Report{}).Error; err != nil {
+		return fmt.Errorf("PostgresDB.Delete: %w", pgError(err))
+	}
+	if err := pg.db.Where("paste_id = ?", id).Delete(&Comment{}).Error; err != nil {
+		return fmt.Errorf("PostgresDB.Delete: %w", pgError(err))
+	}
 	tx := pg.db.Delete(&Paste{}, id)
 	err := tx.Error
 	if err != nil {
-		return fmt.Errorf("PostgresDB.Delete: %w", err)
+		return fmt.Errorf("PostgresDB.Delete: %w", pgError(err))
 	}
 	if tx.RowsAffected == 0 {
-		return fmt.Errorf("PostgresDB.Delete: no rows deleted")
+		return fmt.Errorf("PostgresDB.Delete: %w: id [%d]", ErrNotFound, id)
 	}
 
 	return nil
@@ -88,6 +203,12 @@ func (pg *PostgresDB) Delete(id int64) error {
 
 // Find return a sorted list of pastes for a given request.
 func (pg *PostgresDB) Find(req FindRequest) (pastes []Paste, err error) {
+	return pg.FindCtx(context.Background(), req)
+}
+
+// FindCtx is Find that runs the query with ctx, it is cancelled once ctx
+// is done.
+func (pg *PostgresDB) FindCtx(ctx context.Context, req FindRequest) (pastes []Paste, err error) {
 	sort := "created_at desc"
 	switch req.Sort {
 	case "+created", "-created":
@@ -107,87 +228,352 @@ func (pg *PostgresDB) Find(req FindRequest) (pastes []Paste, err error) {
 		}
 	}
 
-	cond := pg.db
-	if req.UserID != "" {
+	// Same as the other stores, pastes without an owner are only listed
+	// when filtered by privacy, so unlisted and private never leak.
+	if req.UserID == "" && req.Privacy == "" && !req.All {
+		return []Paste{}, nil
+	}
+
+	db := pg.db.WithContext(ctx)
+	cond := db.Where("deleted_at IS NULL")
+	if req.Deleted {
+		cond = db.Where("deleted_at IS NOT NULL")
+	}
+	if req.UserID != "" && !req.All {
 		cond = cond.Where("user_id = ?", req.UserID)
 	}
-	if req.Privacy != "" {
+	if req.Privacy != "" && !req.All {
 		cond = cond.Where("privacy = ?", req.Privacy)
 	}
+	if req.IP != "" {
+		cond = cond.Where("ip = ?", req.IP)
+	}
+	if req.Syntax != "" {
+		cond = cond.Where("syntax = ?", req.Syntax)
+	}
+	if !req.Since.IsZero() {
+		cond = cond.Where("created_at >= ?", req.Since)
+	}
+	if !req.ExpiresBefore.IsZero() {
+		cond = cond.Where("expires > ? AND expires < ?", time.Now(), req.ExpiresBefore)
+	}
 
 	err = cond.
 		Limit(req.Limit).
 		Offset(req.Skip).
 		Order(sort).
-		Select("id", "title", "expires", "delete_after_read", "privacy", "password", "created_at", "syntax", "views").
+		Order("id").
+		Select("id", "title", "expires", "delete_after_read", "blur_until_click", "privacy", "password", "created_at", "syntax", "views", "reports", "deleted_at", "size", "lines").
 		Find(&pastes).Error
 	if err != nil {
-		return pastes, fmt.Errorf("PostgresDB.Find: %w", err)
+		return pastes, fmt.Errorf("PostgresDB.Find: %w", pgError(err))
 	}
 	return pastes, nil
 }
 
 // Count returns a number of pastes for a user.
 func (pg *PostgresDB) Count(req FindRequest) (pastes int64) {
-	cond := pg.db
+	cond := pg.db.Where("deleted_at IS NULL")
+	if req.Deleted {
+		cond = pg.db.Where("deleted_at IS NOT NULL")
+	}
 	if req.UserID != "" {
 		cond = cond.Where("user_id = ?", req.UserID)
 	}
 	if req.Privacy != "" {
 		cond = cond.Where("privacy = ?", req.Privacy)
 	}
+	if req.IP != "" {
+		cond = cond.Where("ip = ?", req.IP)
+	}
+	if req.Syntax != "" {
+		cond = cond.Where("syntax = ?", req.Syntax)
+	}
+	if !req.Since.IsZero() {
+		cond = cond.Where("created_at >= ?", req.Since)
+	}
+	if !req.ExpiresBefore.IsZero() {
+		cond = cond.Where("expires > ? AND expires < ?", time.Now(), req.ExpiresBefore)
+	}
 	cond.Model(&Paste{}).Count(&pastes)
 	return pastes
 }
 
+// SyntaxCounts returns the number of pastes per syntax.
+func (pg *PostgresDB) SyntaxCounts(publicOnly bool) (map[string]int64, error) {
+	var rows []struct {
+		Syntax string
+		Count  int64
+	}
+	cond := pg.db.Model(&Paste{}).Where("deleted_at IS NULL")
+	if publicOnly {
+		cond = cond.Where("privacy = ?", "public")
+	}
+	err := cond.Select("syntax, count(*) AS count").Group("syntax").Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("PostgresDB.SyntaxCounts: %w", pgError(err))
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Syntax] = r.Count
+	}
+	return counts, nil
+}
+
 // Get returns a paste by ID.
 func (pg *PostgresDB) Get(id int64) (Paste, error) {
+	return pg.GetCtx(context.Background(), id)
+}
+
+// GetCtx is Get that runs the query with ctx, it is cancelled once ctx is
+// done.
+func (pg *PostgresDB) GetCtx(ctx context.Context, id int64) (Paste, error) {
 	var paste Paste
-	err := pg.db.Preload("User").Limit(1).Find(&paste, id).Error
-	if err != nil {
-		return paste, fmt.Errorf("PostgresDB.Get: %w", err)
+	tx := pg.db.WithContext(ctx).Preload("User").Preload("Files", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("deleted_at IS NULL").Limit(1).Find(&paste, id)
+	if tx.Error != nil {
+		return paste, fmt.Errorf("PostgresDB.Get: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return paste, fmt.Errorf("PostgresDB.Get: %w: id [%d]", ErrNotFound, id)
 	}
 
 	return paste, nil
 }
 
+// GetMany returns the pastes with the given IDs in the same order. Missing
+// and expired pastes are omitted.
+func (pg *PostgresDB) GetMany(ids []int64) ([]Paste, error) {
+	pastes := []Paste{}
+	if len(ids) == 0 {
+		return pastes, nil
+	}
+	err := pg.db.Preload("User").Preload("Files", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("id IN ?", ids).Where("deleted_at IS NULL").Find(&pastes).Error
+	if err != nil {
+		return nil, fmt.Errorf("PostgresDB.GetMany: %w", pgError(err))
+	}
+	return pastesInOrder(pastes, ids), nil
+}
+
 // SaveUser creates a new or updates an existing user.
 func (pg *PostgresDB) SaveUser(usr User) (id string, err error) {
 	err = pg.db.Clauses(clause.OnConflict{
 		UpdateAll: true,
 	}).Save(&usr).Error
 	if err != nil {
-		return "", fmt.Errorf("PostgresDB.SaveUser: %w", err)
+		return "", fmt.Errorf("PostgresDB.SaveUser: %w", pgError(err))
 	}
 	id = usr.ID
 	return id, nil
 }
 
+// UpdateUser updates the given fields of an existing user, the profile
+// fields by default.
+func (pg *PostgresDB) UpdateUser(usr User, fields ...string) error {
+	var upd User
+	fields, err := updateUserFields(&upd, usr, fields)
+	if err != nil {
+		return fmt.Errorf("PostgresDB.UpdateUser: %w", err)
+	}
+	tx := pg.db.Model(&User{ID: usr.ID}).
+		Select(fields).
+		Updates(upd)
+	if tx.Error != nil {
+		return fmt.Errorf("PostgresDB.UpdateUser: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("PostgresDB.UpdateUser: %w: id [%s]", ErrNotFound, usr.ID)
+	}
+	return nil
+}
+
 // User returns a user by ID.
 func (pg *PostgresDB) User(id string) (User, error) {
 	var usr User
 	tx := pg.db.Limit(1).Find(&usr, User{ID: id})
 	err := tx.Error
 	if err != nil {
-		return usr, fmt.Errorf("PostgresDB.User: %w", err)
+		return usr, fmt.Errorf("PostgresDB.User: %w", pgError(err))
 	}
 	if tx.RowsAffected == 0 {
-		return usr, fmt.Errorf("PostgresDB.User: user not found")
+		return usr, fmt.Errorf("PostgresDB.User: %w: id [%s]", ErrNotFound, id)
 	}
 
 	return usr, err
 }
 
+// Users returns a list of users sorted by ID.
+func (pg *PostgresDB) Users(limit, skip int) ([]User, error) {
+	users := []User{}
+	cond := pg.db.Order("id").Offset(skip)
+	if limit > 0 {
+		cond = cond.Limit(limit)
+	}
+	if err := cond.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("PostgresDB.Users: %w", pgError(err))
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user by ID.
+func (pg *PostgresDB) DeleteUser(id string) error {
+	if id == "" {
+		return fmt.Errorf("PostgresDB.DeleteUser: id cannot be empty")
+	}
+	err := pg.db.Delete(&User{}, "id = ?", id).Error
+	if err != nil {
+		return fmt.Errorf("PostgresDB.DeleteUser: %w", pgError(err))
+	}
+
+	return nil
+}
+
 // Update saves the paste into database and returns it
 func (pg *PostgresDB) Update(p Paste) (Paste, error) {
-	err := pg.db.First(&Paste{}, p.ID).Error
+	err := pg.db.Where("deleted_at IS NULL").First(&Paste{}, p.ID).Error
 	if err != nil {
-		return Paste{}, fmt.Errorf("PostgresDB.Update: %w", err)
+		return Paste{}, fmt.Errorf("PostgresDB.Update: %w", pgError(err))
 	}
 	err = pg.db.Save(&p).Error
 	if err != nil {
-		return Paste{}, fmt.Errorf("PostgresDB.Update: %w", err)
+		return Paste{}, fmt.Errorf("PostgresDB.Update: %w", pgError(err))
 	}
 
 	return p, nil
 }
+
+// SaveReport stores a new abuse report and returns its ID.
+func (pg *PostgresDB) SaveReport(r Report) (id int64, err error) {
+	r.ID = RandomID()
+	if err = pg.db.Create(&r).Error; err != nil {
+		return 0, fmt.Errorf("PostgresDB.SaveReport: %w", pgError(err))
+	}
+	return r.ID, nil
+}
+
+// Reports returns a list of abuse reports, newest first.
+func (pg *PostgresDB) Reports(limit, skip int) ([]Report, error) {
+	reports := []Report{}
+	cond := pg.db.Order("created_at desc").Order("id desc").Offset(skip)
+	if limit > 0 {
+		cond = cond.Limit(limit)
+	}
+	if err := cond.Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("PostgresDB.Reports: %w", pgError(err))
+	}
+	return reports, nil
+}
+
+// CountReports returns the number of reports since a time, only from the IP
+// address if it is not empty.
+func (pg *PostgresDB) CountReports(ip string, since time.Time) (reports int64) {
+	cond := pg.db.Where("created_at >= ?", since)
+	if ip != "" {
+		cond = cond.Where("ip = ?", ip)
+	}
+	cond.Model(&Report{}).Count(&reports)
+	return reports
+}
+
+// SaveResetToken stores a password reset token.
+func (pg *PostgresDB) SaveResetToken(t ResetToken) error {
+	if err := pg.db.Create(&t).Error; err != nil {
+		return fmt.Errorf("PostgresDB.SaveResetToken: %w", pgError(err))
+	}
+	return nil
+}
+
+// ResetToken returns a password reset token by its hash.
+func (pg *PostgresDB) ResetToken(hash string) (ResetToken, error) {
+	var t ResetToken
+	tx := pg.db.Limit(1).Find(&t, ResetToken{Hash: hash})
+	if tx.Error != nil {
+		return t, fmt.Errorf("PostgresDB.ResetToken: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return t, fmt.Errorf("PostgresDB.ResetToken: %w", ErrNotFound)
+	}
+	return t, nil
+}
+
+// DeleteResetToken deletes a password reset token by its hash.
+func (pg *PostgresDB) DeleteResetToken(hash string) error {
+	if err := pg.db.Where("hash = ?", hash).Delete(&ResetToken{}).Error; err != nil {
+		return fmt.Errorf("PostgresDB.DeleteResetToken: %w", pgError(err))
+	}
+	return nil
+}
+
+// SoftDelete moves a paste to the trash.
+func (pg *PostgresDB) SoftDelete(id int64) error {
+	tx := pg.db.Model(&Paste{}).Where("id = ? AND deleted_at IS NULL", id).Update("deleted_at", time.Now().UTC())
+	if tx.Error != nil {
+		return fmt.Errorf("PostgresDB.SoftDelete: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("PostgresDB.SoftDelete: %w: id [%d]", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Restore takes the user's paste out of the trash.
+func (pg *PostgresDB) Restore(id int64, userID string) error {
+	tx := pg.db.Model(&Paste{}).Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID).Update("deleted_at", nil)
+	if tx.Error != nil {
+		return fmt.Errorf("PostgresDB.Restore: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("PostgresDB.Restore: %w: id [%d]", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Purge deletes the pastes moved to the trash before a time.
+func (pg *PostgresDB) Purge(before time.Time) (int64, error) {
+	trashed := pg.db.Model(&Paste{}).Select("id").Where("deleted_at < ?", before)
+	if err := pg.db.Where("paste_id IN (?)", trashed).Delete(&Report{}).Error; err != nil {
+		return 0, fmt.Errorf("PostgresDB.Purge: %w", pgError(err))
+	}
+	if err := pg.db.Where("paste_id IN (?)", trashed).Delete(&Comment{}).Error; err != nil {
+		return 0, fmt.Errorf("PostgresDB.Purge: %w", pgError(err))
+	}
+	tx := pg.db.Where("deleted_at < ?", before).Delete(&Paste{})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("PostgresDB.Purge: %w", pgError(tx.Error))
+	}
+	return tx.RowsAffected, nil
+}
+
+// AddComment stores a new comment and returns its ID.
+func (pg *PostgresDB) AddComment(c Comment) (id int64, err error) {
+	c.ID = RandomID()
+	if err = pg.db.Create(&c).Error; err != nil {
+		return 0, fmt.Errorf("PostgresDB.AddComment: %w", pgError(err))
+	}
+	return c.ID, nil
+}
+
+// Comments returns the comments of a paste, oldest first.
+func (pg *PostgresDB) Comments(pasteID int64) ([]Comment, error) {
+	comments := []Comment{}
+	err := pg.db.Where("paste_id = ?", pasteID).Order("created_at").Order("id").Find(&comments).Error
+	if err != nil {
+		return nil, fmt.Errorf("PostgresDB.Comments: %w", pgError(err))
+	}
+	return comments, nil
+}
+
+// DeleteComment deletes a comment by ID.
+func (pg *PostgresDB) DeleteComment(id int64) error {
+	tx := pg.db.Delete(&Comment{}, id)
+	if tx.Error != nil {
+		return fmt.Errorf("PostgresDB.DeleteComment: %w", pgError(tx.Error))
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("PostgresDB.DeleteComment: %w: id [%d]", ErrNotFound, id)
+	}
+	return nil
+}
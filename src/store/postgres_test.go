@@ -1,6 +1,9 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"math/rand"
 	"sort"
 	"testing"
@@ -47,10 +50,10 @@ func TestDeletePDB(t *testing.T) {
 		t.Fatalf("failed to delete paste: %v", err)
 	}
 	p, err := pdb.Get(id)
-	if err != nil {
-		t.Fatalf("failed to get paste: %v", err)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be deleted but found %+v", p)
 	}
 }
@@ -164,7 +167,7 @@ func TestUpdateNonExistingPDB(t *testing.T) {
 	if err == nil {
 		t.Error("expected paste update to fail")
 	}
-	if p != (Paste{}) {
+	if p.ID != 0 {
 		t.Errorf("expected paste to be empty, got [%+v]", p)
 	}
 }
@@ -198,3 +201,139 @@ func TestGetUserNotExistingPDB(t *testing.T) {
 }
 
 /**/
+
+func TestFindPrivacyPDB(t *testing.T) {
+	t.Parallel()
+
+	testFindPrivacy(t, pdb)
+}
+
+func TestFindSyntaxPDB(t *testing.T) {
+	t.Parallel()
+
+	testFindSyntax(t, pdb)
+}
+
+func TestBlurUntilClickPDB(t *testing.T) {
+	t.Parallel()
+
+	testBlurUntilClick(t, pdb)
+}
+
+func TestPasteFilesPDB(t *testing.T) {
+	t.Parallel()
+
+	testPasteFiles(t, pdb)
+}
+
+func TestReportsPDB(t *testing.T) {
+	t.Parallel()
+
+	testReports(t, pdb)
+}
+
+func TestCommentsPDB(t *testing.T) {
+	t.Parallel()
+
+	testComments(t, pdb)
+}
+
+func TestResetTokensPDB(t *testing.T) {
+	t.Parallel()
+
+	testResetTokens(t, pdb)
+}
+
+func TestUpdateUserPDB(t *testing.T) {
+	t.Parallel()
+
+	testUpdateUser(t, pdb)
+}
+
+func TestUpdateOwnerPDB(t *testing.T) {
+	t.Parallel()
+
+	testUpdateOwner(t, pdb)
+}
+
+func TestUsersPDB(t *testing.T) {
+	t.Parallel()
+
+	testUsers(t, pdb)
+}
+
+func TestGetNotFoundPDB(t *testing.T) {
+	t.Parallel()
+
+	testGetNotFound(t, pdb)
+}
+
+func TestErrorsPDB(t *testing.T) {
+	t.Parallel()
+
+	testErrors(t, pdb)
+}
+
+func TestGetManyPDB(t *testing.T) {
+	t.Parallel()
+
+	testGetMany(t, pdb)
+}
+
+func TestTrashPDB(t *testing.T) {
+	t.Parallel()
+
+	testTrash(t, pdb)
+}
+
+func TestFindCancelPDB(t *testing.T) {
+	t.Parallel()
+
+	testFindCancel(t, pdb)
+}
+
+func TestSyntaxCountsPDB(t *testing.T) {
+	t.Parallel()
+
+	testSyntaxCounts(t, pdb)
+}
+
+func TestFindExpiringPDB(t *testing.T) {
+	t.Parallel()
+
+	testFindExpiring(t, pdb)
+}
+
+func TestPoolPDB(t *testing.T) {
+	t.Parallel()
+
+	pg, err := NewPostgresDB(pgTestConn, false, PostgresPool{MaxOpenConns: 3, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("failed to create a PostgresDB store: %v", err)
+	}
+	db, err := pg.db.DB()
+	if err != nil {
+		t.Fatalf("failed to get the database handle: %v", err)
+	}
+	defer db.Close()
+
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("expected 3 max open connections, got %d", got)
+	}
+
+	// Take all the connections and give them back, only one is kept idle.
+	conns := make([]*sql.Conn, 0, 3)
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get a connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if got := db.Stats().Idle; got != 1 {
+		t.Errorf("expected 1 idle connection, got %d", got)
+	}
+}
@@ -8,35 +8,187 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"time"
 )
 
+// Errors returned by all the store implementations, so that the callers can
+// tell what went wrong regardless of the backend. The native errors are
+// wrapped into these.
+var (
+	ErrNotFound   = errors.New("not found")            // there is no item with the given ID
+	ErrConflict   = errors.New("already exists")       // an item with the same ID or key already exists
+	ErrConnection = errors.New("store is unreachable") // the database or the disk can't be accessed
+	ErrClosed     = errors.New("store is closed")      // the store was closed and can't be used any more
+)
+
 // Interface defines methods that an implementation of a concrete storage
 // must provide.
 type Interface interface {
-	Totals() (pastes, users int64)            // return total counts for pastes and users
-	Create(paste Paste) (id int64, err error) // create new paste and return its id
-	Delete(id int64) error                    // delete paste by id
-	Find(req FindRequest) ([]Paste, error)    // find pastes
-	Count(req FindRequest) int64              // return pastes count for a user
-	Get(id int64) (Paste, error)              // get paste by id
-	Update(paste Paste) (Paste, error)        // update paste information and return updated paste
-	SaveUser(usr User) (id string, err error) // creates or updates a user
-	User(id string) (User, error)             // get user by id
+	Totals() (pastes, users int64)                 // return total counts for pastes and users
+	Create(paste Paste) (id int64, err error)      // create new paste and return its id
+	Delete(id int64) error                         // delete paste by id
+	Find(req FindRequest) ([]Paste, error)         // find pastes
+	Count(req FindRequest) int64                   // return pastes count for a user
+	Get(id int64) (Paste, error)                   // get paste by id
+	GetMany(ids []int64) ([]Paste, error)          // get pastes by ids, missing and expired ones are omitted
+	Update(paste Paste) (Paste, error)             // update paste information and return updated paste
+	SaveUser(usr User) (id string, err error)      // creates or updates a user
+	UpdateUser(usr User, fields ...string) error   // update the given fields of an existing user, name, email and IP by default, the rest is kept
+	User(id string) (User, error)                  // get user by id
+	Users(limit, skip int) ([]User, error)         // list users sorted by id
+	DeleteUser(id string) error                    // delete user by id, user pastes are not deleted
+	Ping() error                                   // check that the store is reachable
+	Close() error                                  // release the resources, the store can't be used afterwards
+	SaveReport(r Report) (id int64, err error)     // record an abuse report
+	Reports(limit, skip int) ([]Report, error)     // list abuse reports, newest first
+	CountReports(ip string, since time.Time) int64 // count reports since a time, from an IP if not empty
+	SaveResetToken(t ResetToken) error             // store a password reset token
+	ResetToken(hash string) (ResetToken, error)    // get a password reset token by its hash
+	DeleteResetToken(hash string) error            // delete a password reset token
+	SoftDelete(id int64) error                     // move paste to the trash, it is hidden but can be restored
+	Restore(id int64, userID string) error         // restore user's paste from the trash
+	Purge(before time.Time) (int64, error)         // delete pastes moved to the trash before a time for good
+
+	// Context-aware variants of the methods above, they give up once ctx
+	// is done and return its error.
+	CreateCtx(ctx context.Context, paste Paste) (id int64, err error)
+	FindCtx(ctx context.Context, req FindRequest) ([]Paste, error)
+	GetCtx(ctx context.Context, id int64) (Paste, error)
+
+	// SyntaxCounts returns the number of pastes not in the trash per
+	// syntax, only of the public ones if publicOnly is true.
+	SyntaxCounts(publicOnly bool) (map[string]int64, error)
+
+	// Comments on pastes, they are deleted together with the paste.
+	AddComment(c Comment) (id int64, err error) // add a comment to a paste
+	Comments(pasteID int64) ([]Comment, error)  // list comments of a paste, oldest first
+	DeleteComment(id int64) error               // delete a comment by id
 }
 
 // FindRequest is an input to the Find method
 type FindRequest struct {
-	UserID  string
+	UserID  string // only the user's pastes, of any privacy unless Privacy is set
 	Sort    string
-	Since   time.Time
+	Since   time.Time // only pastes created at or after this time, zero means any
 	Limit   int
 	Skip    int
-	Privacy string
+	Privacy string // only pastes with this privacy, without UserID only these are listed
+	IP      string // only pastes created from this IP address
+	All     bool   // ignore user and privacy, used by admins to list all pastes
+	Deleted bool   // only pastes in the trash instead of the ones not in it
+	Syntax  string // only pastes with this syntax, empty means any
+
+	ExpiresBefore time.Time // only pastes that haven't expired yet but will before this time, zero means any
+}
+
+// pastesInOrder returns the pastes in the order of ids, without duplicates
+// and expired pastes.
+func pastesInOrder(pastes []Paste, ids []int64) []Paste {
+	byID := make(map[int64]Paste, len(pastes))
+	for _, p := range pastes {
+		if !p.Expired() && !p.Deleted() {
+			byID[p.ID] = p
+		}
+	}
+	res := make([]Paste, 0, len(byID))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			res = append(res, p)
+			delete(byID, id)
+		}
+	}
+	return res
+}
+
+// profileFields are the user fields UpdateUser updates if none are given.
+var profileFields = []string{"name", "email", "ip"}
+
+// updateUserFields copies the fields with the given column names from src
+// to dst. It returns the fields, the profile ones if none are given.
+func updateUserFields(dst *User, src User, fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		fields = profileFields
+	}
+	for _, f := range fields {
+		switch f {
+		case "name":
+			dst.Name = src.Name
+		case "email":
+			dst.Email = src.Email
+		case "ip":
+			dst.IP = src.IP
+		case "admin":
+			dst.Admin = src.Admin
+		case "password":
+			dst.Password = src.Password
+		case "verified":
+			dst.Verified = src.Verified
+		case "verify_token":
+			dst.VerifyToken = src.VerifyToken
+		default:
+			return nil, fmt.Errorf("unknown user field [%s]", f)
+		}
+	}
+	return fields, nil
+}
+
+// limitUsers returns a page of users, zero limit means all of them.
+func limitUsers(users []User, limit, skip int) []User {
+	if skip >= len(users) {
+		return []User{}
+	}
+	users = users[skip:]
+	if limit > 0 && limit < len(users) {
+		users = users[:limit]
+	}
+	return users
+}
+
+// limitReports sorts reports newest first and returns a page of them, zero
+// limit means all of them.
+func limitReports(reports []Report, limit, skip int) []Report {
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].CreatedAt.Equal(reports[j].CreatedAt) {
+			return reports[i].ID > reports[j].ID
+		}
+		return reports[i].CreatedAt.After(reports[j].CreatedAt)
+	})
+	if skip >= len(reports) {
+		return []Report{}
+	}
+	reports = reports[skip:]
+	if limit > 0 && limit < len(reports) {
+		reports = reports[:limit]
+	}
+	return reports
+}
+
+// countReports returns the number of reports created since a time, only
+// the reports from the IP address are counted if it is not empty.
+func countReports(reports []Report, ip string, since time.Time) (count int64) {
+	for _, r := range reports {
+		if (ip == "" || r.IP == ip) && !r.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// sortComments sorts comments oldest first.
+func sortComments(comments []Comment) []Comment {
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+			return comments[i].ID < comments[j].ID
+		}
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	return comments
 }
 
 // User represents a single user.
@@ -46,29 +198,110 @@ type User struct {
 	Email string `json:"email" gorm:"index"`
 	IP    string `json:"ip,omitempty"`
 	Admin bool   `json:"admin"`
+	// only for local accounts, oauth users don't have a password
+	Password    string `json:"-"`        // bcrypt hash of the password
+	Verified    bool   `json:"verified"` // email address is confirmed
+	VerifyToken string `json:"-"`        // hash of the email verification token
 }
 
 // Paste represents a single paste with an optional reference to its user.
 type Paste struct {
-	ID              int64     `json:"id" gorm:"primaryKey"`
-	Title           string    `json:"title"`
-	Body            string    `json:"body"`
-	Expires         time.Time `json:"expires" gorm:"index"`
-	DeleteAfterRead bool      `json:"delete_after_read"`
-	Privacy         string    `json:"privacy"`
-	Password        string    `json:"password"`
-	CreatedAt       time.Time `json:"created"`
-	Syntax          string    `json:"syntax"`
-	UserID          string    `json:"user_id" gorm:"index default:null"`
-	User            User      `json:"user"`
-	Views           int64     `json:"views"`
+	ID              int64       `json:"id" gorm:"primaryKey"`
+	Title           string      `json:"title"`
+	Body            string      `json:"body"`
+	Expires         time.Time   `json:"expires" gorm:"index"`
+	DeleteAfterRead bool        `json:"delete_after_read"`
+	BlurUntilClick  bool        `json:"blur_until_click"` // hide the body until the reader clicks on it, burners are deleted on read regardless
+	Privacy         string      `json:"privacy"`
+	Password        string      `json:"password"`
+	CreatedAt       time.Time   `json:"created"`
+	Syntax          string      `json:"syntax"`
+	UserID          string      `json:"user_id" gorm:"index default:null"`
+	User            User        `json:"user"`
+	Views           int64       `json:"views"`
+	Reports         int64       `json:"-"`                                                  // number of abuse reports, only shown to admins
+	IP              string      `json:"-" gorm:"index"`                                     // creator IP address, only kept for anonymous pastes
+	Files           []PasteFile `json:"files,omitempty" gorm:"constraint:OnDelete:CASCADE"` // files of a multi-file paste, Body and Syntax are the same as of the first file
+	DeletedAt       *time.Time  `json:"-" gorm:"index"`                                     // when the paste was moved to the trash, nil if it is not there
+	Size            int64       `json:"size"`                                               // size of the body in bytes, of all the files for a multi-file paste
+	Lines           int         `json:"lines"`                                              // number of lines in the body, of all the files for a multi-file paste
+	Theme           string      `json:"theme"`                                              // highlight theme chosen by the author, empty means the default
+	AllowEmbed      bool        `json:"allow_embed"`                                        // the author lets the paste be embedded in other sites
+	Encoding        string      `json:"encoding,omitempty"`                                 // encoding the body was converted to UTF-8 from, empty if it was UTF-8
+	ClaimToken      string      `json:"-"`                                                  // hash of the token given to the creator of an anonymous paste to claim it
+}
+
+// PasteFile is a single file of a multi-file paste.
+type PasteFile struct {
+	ID      int64  `json:"-" gorm:"primaryKey"`
+	PasteID int64  `json:"-" gorm:"index"`
+	Name    string `json:"name"`
+	Body    string `json:"body"`
+	Syntax  string `json:"syntax"`
+}
+
+// Report is an abuse report for a paste. Reports are deleted together with
+// the paste.
+type Report struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	PasteID   int64     `json:"paste_id" gorm:"index"`
+	Reason    string    `json:"reason"`
+	IP        string    `json:"-" gorm:"index"` // reporter IP address, used to limit reports
+	CreatedAt time.Time `json:"created"`
+}
+
+// Comment is a comment on a paste by a logged in user. Comments are deleted
+// together with the paste.
+type Comment struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	PasteID   int64     `json:"paste_id" gorm:"index"`
+	UserID    string    `json:"user_id" gorm:"index"`
+	UserName  string    `json:"user_name"` // name of the author when the comment was added
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created"`
+}
+
+// ResetToken is a password reset token of a local account. Only the hash
+// of the token is kept, the token itself is emailed to the user.
+type ResetToken struct {
+	Hash    string    `json:"-" gorm:"primaryKey"`
+	UserID  string    `json:"user_id" gorm:"index"`
+	Expires time.Time `json:"expires"`
+}
+
+// PasteURL returns the URL of the reported paste.
+func (r Report) PasteURL() string {
+	return Paste{ID: r.PasteID}.URL()
+}
+
+// AllFiles returns the files of the paste. A paste with a single body is
+// returned as one file.
+func (p Paste) AllFiles() []PasteFile {
+	if len(p.Files) > 0 {
+		return p.Files
+	}
+	return []PasteFile{{Name: p.URL() + ".txt", Body: p.Body, Syntax: p.Syntax}}
+}
+
+// File returns the file with the given name.
+func (p Paste) File(name string) (PasteFile, bool) {
+	for _, f := range p.AllFiles() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return PasteFile{}, false
 }
 
 // URL generates a base62 encoded string from the paste ID. This string is
-// used as a unique URL for the paste, hence the name.
+// used as a unique URL for the paste, hence the name. If a key is set with
+// SetURLKey the ID is obfuscated first.
 func (p Paste) URL() string {
+	if c := currentCipher(); c != nil {
+		return obfuscatedPrefix + encode62(c.encrypt(uint64(p.ID)))
+	}
 	const (
-		alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		alphabet = base62
 		length   = int64(len(alphabet))
 	)
 	var encodedBuilder strings.Builder
@@ -83,9 +316,26 @@ func (p Paste) URL() string {
 }
 
 // URL2ID decodes the previously generated URL string into a paste ID.
+// Both plain and obfuscated URLs are decoded.
 func (p Paste) URL2ID(url string) (int64, error) {
+	if strings.HasPrefix(url, obfuscatedPrefix) {
+		c := currentCipher()
+		if c == nil {
+			return -1, errors.New("obfuscated url but no url key is set")
+		}
+		n, err := decode62(strings.TrimPrefix(url, obfuscatedPrefix))
+		if err != nil {
+			return -1, err
+		}
+		id := c.decrypt(n)
+		if id > math.MaxInt64 {
+			return -1, errors.New("invalid url: " + url)
+		}
+		return int64(id), nil
+	}
+
 	const (
-		alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		alphabet = base62
 		length   = int64(len(alphabet))
 	)
 
@@ -103,6 +353,48 @@ func (p Paste) URL2ID(url string) (int64, error) {
 	return number, nil
 }
 
+// CountSize returns the size in bytes and the number of lines of the paste
+// body, or of all the files of a multi-file paste. A trailing newline doesn't
+// start a new line.
+func (p Paste) CountSize() (size int64, lines int) {
+	for _, f := range p.AllFiles() {
+		size += int64(len(f.Body))
+		if f.Body != "" {
+			lines += strings.Count(f.Body, "\n")
+			if !strings.HasSuffix(f.Body, "\n") {
+				lines++
+			}
+		}
+	}
+	return size, lines
+}
+
+// HumanSize returns the paste size in a human readable form, for example
+// "512 B" or "1.5 KB".
+func (p Paste) HumanSize() string {
+	const unit = 1024
+	if p.Size < unit {
+		return fmt.Sprintf("%d B", p.Size)
+	}
+	div, exp := int64(unit), 0
+	for n := p.Size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(p.Size)/float64(div), "KMGT"[exp])
+}
+
+// Deleted reports whether the paste is in the trash.
+func (p Paste) Deleted() bool {
+	return p.DeletedAt != nil
+}
+
+// Expired reports whether the paste is past its expiration time. Expired
+// pastes may still be in the store until they are cleaned up.
+func (p Paste) Expired() bool {
+	return !p.Expires.IsZero() && !time.Now().Before(p.Expires)
+}
+
 // Expiration returns a "humanized" duration between now and the expiry date
 // stored in `Expires`. For example: "25 minutes" or "2 months" or "Never".
 func (p Paste) Expiration() string {
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -109,6 +111,9 @@ var findTestCases = []testCaseForFind{
 	},
 }
 
+// pgTestConn is the connection string of the test Postgres database.
+const pgTestConn = "host=localhost user=test password=test dbname=test port=5432 sslmode=disable"
+
 // TestMain is a setup function for the test suite. It creates a new MemDB
 // instance and seeds random generator.
 func TestMain(m *testing.M) {
@@ -126,7 +131,7 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
-	pdb, err = NewPostgresDB("host=localhost user=test password=test dbname=test port=5432 sslmode=disable", true)
+	pdb, err = NewPostgresDB(pgTestConn, true, PostgresPool{})
 	pdb.db.AllowGlobalUpdate = true
 	pdb.db.Delete(Paste{})
 	pdb.db.Delete(User{})
@@ -212,6 +217,36 @@ func TestPasteURL(t *testing.T) {
 	}
 }
 
+func TestPasteCountSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		paste Paste
+		size  int64
+		lines int
+	}{
+		{"empty", Paste{}, 0, 0},
+		{"single line", Paste{Body: "qwe"}, 3, 1},
+		{"multi line", Paste{Body: "qwe\nasd\nzxc"}, 11, 3},
+		{"trailing newline", Paste{Body: "qwe\nasd\n"}, 8, 2},
+		{"empty lines", Paste{Body: "\n\n"}, 2, 2},
+		{"files", Paste{Body: "qwe", Files: []PasteFile{{Body: "qwe"}, {Body: "asd\nzxc\n"}}}, 11, 3},
+	}
+	for _, tc := range tests {
+		size, lines := tc.paste.CountSize()
+		if size != tc.size || lines != tc.lines {
+			t.Errorf("%s: expected %d bytes and %d lines, got %d and %d", tc.name, tc.size, tc.lines, size, lines)
+		}
+	}
+
+	for size, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 3 << 20: "3.0 MB"} {
+		if got := (Paste{Size: size}).HumanSize(); got != want {
+			t.Errorf("expected size %d to be [%s], got [%s]", size, want, got)
+		}
+	}
+}
+
 func TestPasteExpiration(t *testing.T) {
 	t.Parallel()
 
@@ -267,3 +302,853 @@ func TestPasteExpiration(t *testing.T) {
 		t.Errorf("expected expiration to be [999ms], got [%s]", p.Expiration())
 	}
 }
+
+// testFindPrivacy creates a public, an unlisted and a private paste and
+// checks that only the public one is listed while all of them can still be
+// retrieved directly.
+func testFindPrivacy(t *testing.T, s Interface) {
+	usr := randomUser()
+	created := map[string]Paste{}
+	for _, privacy := range []string{"public", "unlisted", "private"} {
+		p := randomPaste(usr)
+		p.Privacy = privacy
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		p.ID = id
+		created[privacy] = p
+	}
+
+	pastes, err := s.Find(FindRequest{Privacy: "public", Sort: "-created", Limit: 1000})
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	found := map[int64]Paste{}
+	for _, p := range pastes {
+		if p.Privacy != "public" {
+			t.Errorf("expected only public pastes, got %s paste %d", p.Privacy, p.ID)
+		}
+		found[p.ID] = p
+	}
+	if _, ok := found[created["public"].ID]; !ok {
+		t.Errorf("expected public paste %d to be listed", created["public"].ID)
+	}
+
+	pastes, err = s.Find(FindRequest{})
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	if len(pastes) != 0 {
+		t.Errorf("expected no pastes without user or privacy, got %d", len(pastes))
+	}
+
+	// the owner's list has all the pastes, whatever the privacy
+	pastes, err = s.Find(FindRequest{UserID: usr.ID, Sort: "-created", Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to find user pastes: %v", err)
+	}
+	found = map[int64]Paste{}
+	for _, p := range pastes {
+		found[p.ID] = p
+	}
+	for privacy, p := range created {
+		if _, ok := found[p.ID]; !ok {
+			t.Errorf("expected %s paste %d in the user's list", privacy, p.ID)
+		}
+	}
+	if cnt := s.Count(FindRequest{UserID: usr.ID}); cnt != int64(len(created)) {
+		t.Errorf("expected the user to have %d pastes, got %d", len(created), cnt)
+	}
+
+	for privacy, p := range created {
+		got, err := s.Get(p.ID)
+		if err != nil {
+			t.Fatalf("failed to get %s paste: %v", privacy, err)
+		}
+		if got.ID != p.ID {
+			t.Errorf("expected to get %s paste %d, got %d", privacy, p.ID, got.ID)
+		}
+	}
+}
+
+func TestFindPrivacy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testFindPrivacy(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testFindPrivacy(t, ddb) })
+}
+
+// testFindCancel checks that Find gives up once the context is done, with
+// more pastes than MemDB goes over between the checks of the context.
+func testFindCancel(t *testing.T, s Interface) {
+	usr := randomUser()
+	now := time.Now()
+	for i := 0; i <= ctxCheckInterval; i++ {
+		p := randomPaste(usr)
+		p.Privacy = "public"
+		p.CreatedAt = now.Add(-time.Duration(i) * time.Millisecond) // unique IDs in the disk store
+		if _, err := s.Create(p); err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+	}
+
+	req := FindRequest{UserID: usr.ID, Sort: "-views", Limit: 10}
+	pastes, err := s.FindCtx(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	if len(pastes) != 10 {
+		t.Errorf("expected 10 pastes, got %d", len(pastes))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.FindCtx(ctx, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Find to be cancelled, got %v", err)
+	}
+	_, err = s.GetCtx(ctx, pastes[0].ID)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Get to be cancelled, got %v", err)
+	}
+}
+
+func TestFindCancel(t *testing.T) {
+	t.Parallel()
+
+	dir, ddb := makeTestDiskStorage(t)
+	defer os.RemoveAll(dir)
+	t.Run("memory", func(t *testing.T) { testFindCancel(t, NewMemDB()) })
+	t.Run("disk", func(t *testing.T) { testFindCancel(t, ddb) })
+}
+
+// testFindSyntax creates public pastes of several syntaxes and checks that
+// filtering by syntax finds and counts only the ones with that syntax.
+func testFindSyntax(t *testing.T, s Interface) {
+	usr := randomUser()
+	// unique syntaxes so that pastes of the other tests don't match
+	syntaxes := map[string]int{"go-" + randSeq(8): 2, "python-" + randSeq(8): 1}
+	for syntax, n := range syntaxes {
+		for i := 0; i < n; i++ {
+			p := randomPaste(usr)
+			p.Privacy = "public"
+			p.Syntax = syntax
+			if _, err := s.Create(p); err != nil {
+				t.Fatalf("failed to create paste: %v", err)
+			}
+		}
+	}
+
+	for syntax, n := range syntaxes {
+		for _, req := range []FindRequest{
+			{Privacy: "public", Syntax: syntax, Sort: "-created", Limit: 1000},
+			{UserID: usr.ID, Syntax: syntax, Limit: 1000},
+		} {
+			pastes, err := s.Find(req)
+			if err != nil {
+				t.Fatalf("failed to find pastes: %v", err)
+			}
+			if len(pastes) != n {
+				t.Errorf("expected %d %s pastes, got %d", n, syntax, len(pastes))
+			}
+			for _, p := range pastes {
+				if p.Syntax != syntax {
+					t.Errorf("expected only %s pastes, got %s paste %d", syntax, p.Syntax, p.ID)
+				}
+			}
+			if cnt := s.Count(req); cnt != int64(n) {
+				t.Errorf("expected to count %d %s pastes, got %d", n, syntax, cnt)
+			}
+		}
+	}
+}
+
+func TestFindSyntax(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testFindSyntax(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testFindSyntax(t, ddb) })
+}
+
+// testSyntaxCounts creates pastes of several syntaxes and privacies and
+// checks the per syntax counts with and without the private ones, and the
+// count of the recent ones.
+func testSyntaxCounts(t *testing.T, s Interface) {
+	usr := randomUser()
+	// unique syntaxes so that pastes of the other tests don't match
+	goSyntax, pySyntax := "go-"+randSeq(8), "python-"+randSeq(8)
+	pastes := []struct {
+		syntax, privacy string
+		age             time.Duration
+		trash           bool
+	}{
+		{goSyntax, "public", 0, false},
+		{goSyntax, "public", 48 * time.Hour, false},
+		{goSyntax, "private", 0, false},
+		{goSyntax, "public", 0, true},
+		{pySyntax, "unlisted", 0, false},
+	}
+	for i, tc := range pastes {
+		p := randomPaste(usr)
+		p.Syntax = tc.syntax
+		p.Privacy = tc.privacy
+		p.CreatedAt = p.CreatedAt.Add(-tc.age + time.Duration(i)*time.Microsecond)
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		if tc.trash {
+			if err := s.SoftDelete(id); err != nil {
+				t.Fatalf("failed to move paste to the trash: %v", err)
+			}
+		}
+	}
+
+	all, err := s.SyntaxCounts(false)
+	if err != nil {
+		t.Fatalf("failed to count pastes per syntax: %v", err)
+	}
+	if all[goSyntax] != 3 || all[pySyntax] != 1 {
+		t.Errorf("expected 3 %s and 1 %s pastes, got %d and %d", goSyntax, pySyntax, all[goSyntax], all[pySyntax])
+	}
+	public, err := s.SyntaxCounts(true)
+	if err != nil {
+		t.Fatalf("failed to count public pastes per syntax: %v", err)
+	}
+	if public[goSyntax] != 2 {
+		t.Errorf("expected 2 public %s pastes, got %d", goSyntax, public[goSyntax])
+	}
+	if n, ok := public[pySyntax]; ok {
+		t.Errorf("expected no public %s pastes, got %d", pySyntax, n)
+	}
+
+	if cnt := s.Count(FindRequest{All: true, Syntax: goSyntax, Since: time.Now().Add(-24 * time.Hour)}); cnt != 2 {
+		t.Errorf("expected to count 2 %s pastes created in the last day, got %d", goSyntax, cnt)
+	}
+}
+
+func TestSyntaxCounts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testSyntaxCounts(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testSyntaxCounts(t, ddb) })
+}
+
+// testBlurUntilClick checks that the BlurUntilClick flag is saved and
+// defaults to false.
+func testBlurUntilClick(t *testing.T, s Interface) {
+	usr := randomUser()
+	for _, blur := range []bool{true, false} {
+		p := randomPaste(usr)
+		p.BlurUntilClick = blur
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		got, err := s.Get(id)
+		if err != nil {
+			t.Fatalf("failed to get paste: %v", err)
+		}
+		if got.BlurUntilClick != blur {
+			t.Errorf("expected BlurUntilClick to be %v, got %v", blur, got.BlurUntilClick)
+		}
+	}
+}
+
+func TestBlurUntilClick(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testBlurUntilClick(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testBlurUntilClick(t, ddb) })
+}
+
+// testPasteFiles checks that the files of a multi-file paste are saved in
+// order and that a single body paste is returned as one file.
+func testPasteFiles(t *testing.T, s Interface) {
+	usr := randomUser()
+	p := randomPaste(usr)
+	p.Files = []PasteFile{
+		{Name: "main.go", Body: "package main", Syntax: "go"},
+		{Name: "README.md", Body: "# Readme", Syntax: "markdown"},
+	}
+	id, err := s.Create(p)
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	got, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get paste: %v", err)
+	}
+	if len(got.Files) != len(p.Files) {
+		t.Fatalf("expected %d files, got %d", len(p.Files), len(got.Files))
+	}
+	for i, f := range p.Files {
+		if got.Files[i].Name != f.Name || got.Files[i].Body != f.Body || got.Files[i].Syntax != f.Syntax {
+			t.Errorf("expected file %d to be %+v, got %+v", i, f, got.Files[i])
+		}
+	}
+	if f, ok := got.File("README.md"); !ok || f.Body != "# Readme" {
+		t.Errorf("expected to find file README.md, got %+v", f)
+	}
+
+	single := randomPaste(usr)
+	if files := single.AllFiles(); len(files) != 1 || files[0].Body != single.Body {
+		t.Errorf("expected single body paste to have one file, got %+v", files)
+	}
+}
+
+func TestPasteFiles(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testPasteFiles(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testPasteFiles(t, ddb) })
+}
+
+// testReports checks that reports are listed newest first, counted by IP
+// and deleted together with the paste.
+func testReports(t *testing.T, s Interface) {
+	usr := randomUser()
+	id, err := s.Create(randomPaste(usr))
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	ip := fmt.Sprintf("192.0.2.%d", rand.Intn(250)) // #nosec
+	since := time.Now().Add(-time.Minute)
+	created := time.Now()
+	for i := 0; i < 2; i++ {
+		_, err = s.SaveReport(Report{PasteID: id, Reason: fmt.Sprintf("reason %d", i), IP: ip, CreatedAt: created.Add(time.Duration(i) * time.Millisecond)})
+		if err != nil {
+			t.Fatalf("failed to save report: %v", err)
+		}
+	}
+	if got := s.CountReports(ip, since); got != 2 {
+		t.Errorf("expected 2 reports from %s, got %d", ip, got)
+	}
+	if got := s.CountReports(ip, created.Add(time.Hour)); got != 0 {
+		t.Errorf("expected no reports in the future, got %d", got)
+	}
+	reports, err := s.Reports(0, 0)
+	if err != nil {
+		t.Fatalf("failed to list reports: %v", err)
+	}
+	var found []Report
+	for _, r := range reports {
+		if r.PasteID == id {
+			found = append(found, r)
+		}
+	}
+	if len(found) != 2 || found[0].Reason != "reason 1" {
+		t.Errorf("expected 2 reports newest first, got %+v", found)
+	}
+
+	if err = s.Delete(id); err != nil {
+		t.Fatalf("failed to delete paste: %v", err)
+	}
+	if got := s.CountReports(ip, since); got != 0 {
+		t.Errorf("expected reports to be deleted with the paste, got %d", got)
+	}
+}
+
+func TestReports(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testReports(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testReports(t, ddb) })
+}
+
+// testComments checks that comments are listed oldest first, deleted one by
+// one and deleted together with the paste.
+func testComments(t *testing.T, s Interface) {
+	usr := randomUser()
+	id, err := s.Create(randomPaste(usr))
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	created := time.Now()
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		cid, err := s.AddComment(Comment{PasteID: id, UserID: usr.ID, UserName: usr.Name, Body: fmt.Sprintf("comment %d", i), CreatedAt: created.Add(time.Duration(i) * time.Millisecond)})
+		if err != nil {
+			t.Fatalf("failed to add comment: %v", err)
+		}
+		ids = append(ids, cid)
+	}
+	comments, err := s.Comments(id)
+	if err != nil {
+		t.Fatalf("failed to list comments: %v", err)
+	}
+	if len(comments) != 3 || comments[0].Body != "comment 0" || comments[2].Body != "comment 2" {
+		t.Fatalf("expected 3 comments oldest first, got %+v", comments)
+	}
+	if comments[0].UserID != usr.ID || comments[0].UserName != usr.Name {
+		t.Errorf("expected the comment author to be kept, got %+v", comments[0])
+	}
+
+	if err = s.DeleteComment(ids[1]); err != nil {
+		t.Fatalf("failed to delete comment: %v", err)
+	}
+	if err = s.DeleteComment(ids[1]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected deleting a missing comment to fail with %v, got %v", ErrNotFound, err)
+	}
+	if comments, _ = s.Comments(id); len(comments) != 2 || comments[1].Body != "comment 2" {
+		t.Errorf("expected 2 comments after delete, got %+v", comments)
+	}
+
+	if err = s.Delete(id); err != nil {
+		t.Fatalf("failed to delete paste: %v", err)
+	}
+	if comments, _ = s.Comments(id); len(comments) != 0 {
+		t.Errorf("expected comments to be deleted with the paste, got %+v", comments)
+	}
+}
+
+func TestComments(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testComments(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testComments(t, ddb) })
+}
+
+// testResetTokens checks that reset tokens are found by their hash and
+// can be deleted.
+func testResetTokens(t *testing.T, s Interface) {
+	tkn := ResetToken{
+		Hash:    randSeq(64),
+		UserID:  randomUser().ID,
+		Expires: time.Now().Add(time.Hour).Round(time.Second),
+	}
+	if err := s.SaveResetToken(tkn); err != nil {
+		t.Fatalf("failed to save reset token: %v", err)
+	}
+	got, err := s.ResetToken(tkn.Hash)
+	if err != nil {
+		t.Fatalf("failed to get reset token: %v", err)
+	}
+	if got.UserID != tkn.UserID || !got.Expires.Equal(tkn.Expires) {
+		t.Errorf("expected token %+v, got %+v", tkn, got)
+	}
+	if _, err = s.ResetToken(randSeq(64)); err == nil {
+		t.Error("expected an error for a missing token")
+	}
+
+	if err = s.DeleteResetToken(tkn.Hash); err != nil {
+		t.Fatalf("failed to delete reset token: %v", err)
+	}
+	if _, err = s.ResetToken(tkn.Hash); err == nil {
+		t.Error("expected token to be deleted")
+	}
+	if err = s.DeleteResetToken(tkn.Hash); err != nil {
+		t.Errorf("expected deleting a missing token to succeed, got %v", err)
+	}
+}
+
+func TestResetTokens(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testResetTokens(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testResetTokens(t, ddb) })
+}
+
+// testUsers checks that users are listed sorted by ID and can be paged.
+func testUsers(t *testing.T, s Interface) {
+	for i := 0; i < 3; i++ {
+		if _, err := s.SaveUser(randomUser()); err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
+	}
+	all, err := s.Users(0, 0)
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(all) < 3 {
+		t.Fatalf("expected at least 3 users, got %d", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].ID > all[i].ID {
+			t.Errorf("expected users to be sorted by id, got %q before %q", all[i-1].ID, all[i].ID)
+		}
+	}
+
+	page, err := s.Users(2, 1)
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("expected 2 users, got %d", len(page))
+	}
+	page, err = s.Users(10, 1000000)
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(page) != 0 {
+		t.Errorf("expected no users past the end, got %d", len(page))
+	}
+}
+
+// testUpdateUser checks that UpdateUser changes the profile of an existing
+// user and keeps the rest, e.g. an admin stays an admin.
+func testUpdateUser(t *testing.T, s Interface) {
+	usr := randomUser()
+	usr.Admin = true
+	usr.Password = "hash"
+	usr.Verified = true
+	if _, err := s.SaveUser(usr); err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+
+	if err := s.UpdateUser(User{ID: usr.ID, Name: "New Name", Email: "new@example.com", IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	got, err := s.User(usr.ID)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if got.Name != "New Name" || got.Email != "new@example.com" || got.IP != "10.0.0.1" {
+		t.Errorf("expected the profile to be updated, got %+v", got)
+	}
+	if !got.Admin || got.Password != "hash" || !got.Verified {
+		t.Errorf("expected the rest to be kept, got %+v", got)
+	}
+
+	// only the given fields are updated
+	if err := s.UpdateUser(User{ID: usr.ID, Name: "Other Name", Admin: false}, "admin"); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if got, _ = s.User(usr.ID); got.Admin || got.Name != "New Name" {
+		t.Errorf("expected only the admin flag to be updated, got %+v", got)
+	}
+	if err := s.UpdateUser(User{ID: usr.ID, Name: "Other Name", Password: "new hash", VerifyToken: "token"},
+		"password", "verified", "verify_token"); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if got, _ = s.User(usr.ID); got.Password != "new hash" || got.Verified || got.VerifyToken != "token" || got.Name != "New Name" {
+		t.Errorf("expected only the password and the verification to be updated, got %+v", got)
+	}
+	if err := s.UpdateUser(User{ID: usr.ID}, "id"); err == nil {
+		t.Errorf("expected an error for a field that can't be updated")
+	}
+
+	if err := s.UpdateUser(randomUser()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a new user, got %v", err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testUpdateUser(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testUpdateUser(t, ddb) })
+}
+
+// testUpdateOwner checks that a paste given to another user is listed among
+// the new owner's pastes and no longer among the old owner's.
+func testUpdateOwner(t *testing.T, s Interface) {
+	from, to := randomUser(), randomUser()
+	for _, usr := range []User{from, to} {
+		if _, err := s.SaveUser(usr); err != nil {
+			t.Fatalf("failed to save user: %v", err)
+		}
+	}
+	p := randomPaste(from)
+	id, err := s.Create(p)
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	p.ID, p.User, p.UserID = id, to, to.ID
+	if _, err = s.Update(p); err != nil {
+		t.Fatalf("failed to update paste: %v", err)
+	}
+	if c := s.Count(FindRequest{UserID: from.ID}); c != 0 {
+		t.Errorf("expected the old owner to have no pastes, got %d", c)
+	}
+	pastes, err := s.Find(FindRequest{UserID: to.ID, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	if len(pastes) != 1 || pastes[0].ID != p.ID || pastes[0].User.ID != to.ID {
+		t.Errorf("expected the new owner to have the paste, got %+v", pastes)
+	}
+}
+
+func TestUpdateOwner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testUpdateOwner(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testUpdateOwner(t, ddb) })
+}
+
+func TestUsers(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testUsers(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testUsers(t, ddb) })
+}
+
+// testGetNotFound checks that Get returns ErrNotFound for missing pastes.
+func testGetNotFound(t *testing.T, s Interface) {
+	for _, id := range []int64{0, -1, rand.Int63()} {
+		p, err := s.Get(id)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for id %d, got %v", id, err)
+		}
+		if p.ID != 0 {
+			t.Errorf("expected an empty paste for id %d, got %+v", id, p)
+		}
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testGetNotFound(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testGetNotFound(t, ddb) })
+}
+
+// testErrors checks that missing items and duplicates are reported with
+// ErrNotFound and ErrConflict.
+func testErrors(t *testing.T, s Interface) {
+	if _, err := s.User(randomUser().ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing user, got %v", err)
+	}
+	if _, err := s.ResetToken(fmt.Sprintf("missing-%d", rand.Int63())); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing reset token, got %v", err)
+	}
+	if _, err := s.Update(Paste{ID: rand.Int63(), CreatedAt: time.Now()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound when updating a missing paste, got %v", err)
+	}
+
+	rt := ResetToken{
+		Hash:    fmt.Sprintf("duplicate-%d", rand.Int63()),
+		UserID:  randomUser().ID,
+		Expires: time.Now().Add(time.Hour),
+	}
+	if err := s.SaveResetToken(rt); err != nil {
+		t.Fatalf("failed to save reset token: %v", err)
+	}
+	if err := s.SaveResetToken(rt); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate reset token, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testErrors(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testErrors(t, ddb) })
+}
+
+// testGetMany checks that pastes are returned in the order of the IDs and
+// that missing and expired pastes are omitted.
+func testGetMany(t *testing.T, s Interface) {
+	usr := randomUser()
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = p.CreatedAt.Add(time.Duration(i) * time.Microsecond)
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	expired := randomPaste(usr)
+	expired.CreatedAt = time.Now().Add(-time.Hour)
+	expired.Expires = time.Now().Add(-time.Minute)
+	expiredID, err := s.Create(expired)
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	check := func(req, want []int64) {
+		t.Helper()
+		got, err := s.GetMany(req)
+		if err != nil {
+			t.Fatalf("failed to get pastes: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d pastes, got %d", len(want), len(got))
+		}
+		for i, p := range got {
+			if p.ID != want[i] {
+				t.Errorf("expected paste %d to be %d, got %d", i, want[i], p.ID)
+			}
+			if p.User.ID != usr.ID {
+				t.Errorf("expected paste %d to belong to %s, got %q", p.ID, usr.ID, p.User.ID)
+			}
+		}
+	}
+	check([]int64{ids[2], ids[0], rand.Int63(), ids[1], ids[0], expiredID}, []int64{ids[2], ids[0], ids[1]})
+	check([]int64{expiredID, ids[1], ids[0], ids[2]}, []int64{ids[1], ids[0], ids[2]})
+	check(nil, nil)
+}
+
+// testFindExpiring creates pastes with various expiries and checks that
+// only the ones expiring before the given time, but not expired yet, are
+// found, the soonest first.
+func testFindExpiring(t *testing.T, s Interface) {
+	usr := randomUser()
+	now := time.Now()
+	var want []int64
+	for i, exp := range []time.Duration{10 * time.Hour, 48 * time.Hour, 0, -time.Minute, time.Hour} {
+		p := randomPaste(usr)
+		p.CreatedAt = now.Add(-2*time.Hour + time.Duration(i)*time.Microsecond)
+		if exp != 0 {
+			p.Expires = now.Add(exp)
+		}
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		if exp > 0 && exp < 24*time.Hour {
+			want = append([]int64{id}, want...) // the later one is created first
+		}
+	}
+
+	req := FindRequest{UserID: usr.ID, Sort: "+expires", Limit: 10, ExpiresBefore: now.Add(24 * time.Hour)}
+	pastes, err := s.Find(req)
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	if len(pastes) != len(want) {
+		t.Fatalf("expected %d expiring pastes, got %d", len(want), len(pastes))
+	}
+	for i, p := range pastes {
+		if p.ID != want[i] {
+			t.Errorf("expected expiring paste %d to be %d, got %d", i, want[i], p.ID)
+		}
+	}
+	if cnt := s.Count(req); cnt != int64(len(want)) {
+		t.Errorf("expected to count %d expiring pastes, got %d", len(want), cnt)
+	}
+}
+
+func TestFindExpiring(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testFindExpiring(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testFindExpiring(t, ddb) })
+}
+
+func TestGetMany(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testGetMany(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testGetMany(t, ddb) })
+}
+
+func testTrash(t *testing.T, s Interface) {
+	usr := randomUser()
+	if _, err := s.SaveUser(usr); err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+	// a unique IP address to count the trash of all users
+	ip := fmt.Sprintf("10.%d.%d.%d", rand.Intn(256), rand.Intn(256), rand.Intn(256))
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		p := randomPaste(usr)
+		p.CreatedAt = p.CreatedAt.Add(time.Duration(i) * time.Microsecond)
+		p.IP = ip
+		id, err := s.Create(p)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := s.SoftDelete(ids[0]); err != nil {
+		t.Fatalf("failed to move paste to the trash: %v", err)
+	}
+	if err := s.SoftDelete(ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a paste already in the trash, got %v", err)
+	}
+
+	// Trashed pastes are hidden
+	if _, err := s.Get(ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a paste in the trash, got %v", err)
+	}
+	if got, _ := s.GetMany(ids); len(got) != 1 || got[0].ID != ids[1] {
+		t.Errorf("expected GetMany to return only paste %d, got %+v", ids[1], got)
+	}
+	live, err := s.Find(FindRequest{UserID: usr.ID, Sort: "-created", Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to find pastes: %v", err)
+	}
+	if len(live) != 1 || live[0].ID != ids[1] {
+		t.Errorf("expected Find to return only paste %d, got %+v", ids[1], live)
+	}
+	if c := s.Count(FindRequest{UserID: usr.ID}); c != 1 {
+		t.Errorf("expected Count to be 1, got %d", c)
+	}
+
+	// and listed in the trash
+	trash, err := s.Find(FindRequest{UserID: usr.ID, Sort: "-created", Limit: 10, Deleted: true})
+	if err != nil {
+		t.Fatalf("failed to find pastes in the trash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != ids[0] || !trash[0].Deleted() {
+		t.Errorf("expected the trash to contain paste %d, got %+v", ids[0], trash)
+	}
+	if c := s.Count(FindRequest{UserID: usr.ID, Deleted: true}); c != 1 {
+		t.Errorf("expected trash Count to be 1, got %d", c)
+	}
+	if c := s.Count(FindRequest{All: true, Deleted: true, IP: ip}); c != 1 {
+		t.Errorf("expected trash Count of all users to be 1, got %d", c)
+	}
+
+	// Only the owner can restore
+	if err := s.Restore(ids[0], randomUser().ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring someone else's paste, got %v", err)
+	}
+	if err := s.Restore(ids[1], usr.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring a paste not in the trash, got %v", err)
+	}
+	if err := s.Restore(ids[0], usr.ID); err != nil {
+		t.Fatalf("failed to restore paste: %v", err)
+	}
+	p, err := s.Get(ids[0])
+	if err != nil {
+		t.Fatalf("failed to get restored paste: %v", err)
+	}
+	if p.Deleted() {
+		t.Errorf("expected restored paste not to be in the trash")
+	}
+	if c := s.Count(FindRequest{UserID: usr.ID}); c != 2 {
+		t.Errorf("expected Count to be 2 after restore, got %d", c)
+	}
+
+	// Purge only deletes pastes trashed before the time
+	if err := s.SoftDelete(ids[1]); err != nil {
+		t.Fatalf("failed to move paste to the trash: %v", err)
+	}
+	if _, err := s.Purge(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to purge the trash: %v", err)
+	}
+	if c := s.Count(FindRequest{UserID: usr.ID, Deleted: true}); c != 1 {
+		t.Errorf("expected the paste to stay in the trash, got %d pastes", c)
+	}
+	purged, err := s.Purge(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to purge the trash: %v", err)
+	}
+	if purged < 1 {
+		t.Errorf("expected at least 1 paste to be purged, got %d", purged)
+	}
+	if c := s.Count(FindRequest{UserID: usr.ID, Deleted: true}); c != 0 {
+		t.Errorf("expected the trash to be empty, got %d pastes", c)
+	}
+	if err := s.Restore(ids[1], usr.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring a purged paste, got %v", err)
+	}
+	if _, err := s.Get(ids[0]); err != nil {
+		t.Errorf("expected the restored paste to survive the purge, got %v", err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("memory", func(t *testing.T) { testTrash(t, mdb) })
+	t.Run("disk", func(t *testing.T) { testTrash(t, ddb) })
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+// obfuscatedPrefix marks the URLs with obfuscated IDs. It is not in the
+// base62 alphabet, so the URLs generated before obfuscation was enabled
+// are still decoded as they are.
+const obfuscatedPrefix = "_"
+
+const base62 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// urlCipher holds the *feistel used to obfuscate paste IDs, nil if IDs are
+// not obfuscated.
+var urlCipher atomic.Value
+
+// SetURLKey enables obfuscation of paste IDs in the URLs with the given
+// secret key, so that the URLs don't reveal the order in which pastes were
+// created or their creation time. Empty key disables obfuscation. URLs
+// generated with a key can only be decoded with the same key, while plain
+// URLs can always be decoded. It is meant to be called once on startup.
+func SetURLKey(key string) {
+	if key == "" {
+		urlCipher.Store((*feistel)(nil))
+		return
+	}
+	urlCipher.Store(newFeistel(key))
+}
+
+// currentCipher returns the cipher set by SetURLKey or nil.
+func currentCipher() *feistel {
+	c, _ := urlCipher.Load().(*feistel)
+	return c
+}
+
+// feistel is a balanced Feistel network over 64-bit numbers. It is a
+// permutation, so every ID maps to a unique number and back.
+type feistel [8]uint32
+
+// newFeistel derives the round keys from the secret key.
+func newFeistel(key string) *feistel {
+	var f feistel
+	sum := sha256.Sum256([]byte(key))
+	for i := range f {
+		f[i] = binary.BigEndian.Uint32(sum[i*4:])
+	}
+	return &f
+}
+
+// round is the round function, it mixes the half block with the round key.
+func round(x, k uint32) uint32 {
+	x ^= k
+	x *= 0x9e3779b1
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	return x
+}
+
+func (f *feistel) encrypt(n uint64) uint64 {
+	l, r := uint32(n>>32), uint32(n)
+	for _, k := range f {
+		l, r = r, l^round(r, k)
+	}
+	return uint64(l)<<32 | uint64(r)
+}
+
+func (f *feistel) decrypt(n uint64) uint64 {
+	l, r := uint32(n>>32), uint32(n)
+	for i := len(f) - 1; i >= 0; i-- {
+		l, r = r^round(l, f[i]), l
+	}
+	return uint64(l)<<32 | uint64(r)
+}
+
+// encode62 returns the base62 encoding of the number, least significant
+// digit first, the same way plain URLs are encoded.
+func encode62(n uint64) string {
+	var b strings.Builder
+	b.Grow(11)
+	for ; n > 0; n /= uint64(len(base62)) {
+		b.WriteByte(base62[n%uint64(len(base62))])
+	}
+	return b.String()
+}
+
+// decode62 decodes a string generated by encode62.
+func decode62(s string) (uint64, error) {
+	var n, pow uint64 = 0, 1
+	for i, symbol := range s {
+		d := strings.IndexRune(base62, symbol)
+		if d == -1 {
+			return 0, errors.New("invalid character: " + string(symbol))
+		}
+		if i > 0 {
+			if pow > math.MaxUint64/uint64(len(base62)) {
+				return 0, errors.New("url is too long")
+			}
+			pow *= uint64(len(base62))
+		}
+		if uint64(d) > (math.MaxUint64-n)/pow {
+			return 0, errors.New("url is too long")
+		}
+		n += uint64(d) * pow
+	}
+	return n, nil
+}
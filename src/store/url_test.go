@@ -0,0 +1,87 @@
+package store
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestFeistel(t *testing.T) {
+	t.Parallel()
+
+	f := newFeistel("secret")
+	for _, n := range []uint64{0, 1, 2, 1<<63 - 1, 1 << 63, 1<<64 - 1, rand.Uint64()} {
+		if got := f.decrypt(f.encrypt(n)); got != n {
+			t.Errorf("expected %d to round-trip, got %d", n, got)
+		}
+	}
+	if newFeistel("other").encrypt(12345) == f.encrypt(12345) {
+		t.Errorf("expected different keys to give different results")
+	}
+}
+
+func TestBase62(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []uint64{0, 1, 61, 62, 1<<64 - 1, rand.Uint64()} {
+		got, err := decode62(encode62(n))
+		if err != nil || got != n {
+			t.Errorf("expected %d to round-trip, got %d (%v)", n, got, err)
+		}
+	}
+	if _, err := decode62(strings.Repeat("9", 12)); err == nil {
+		t.Errorf("expected decoding to fail on overflow")
+	}
+	if _, err := decode62("a-b"); err == nil {
+		t.Errorf("expected decoding to fail on invalid character")
+	}
+}
+
+// TestObfuscatedURL changes the global key, so it doesn't run in parallel
+// with the other tests.
+func TestObfuscatedURL(t *testing.T) {
+	plain := Paste{ID: 123456789}.URL()
+
+	SetURLKey("secret")
+	defer SetURLKey("")
+
+	ids := []int64{0, 1, 2, 1<<63 - 1}
+	for i := 0; i < 100; i++ {
+		ids = append(ids, rand.Int63())
+	}
+	for _, id := range ids {
+		url := Paste{ID: id}.URL()
+		if !strings.HasPrefix(url, obfuscatedPrefix) {
+			t.Fatalf("expected url %q to have the %q prefix", url, obfuscatedPrefix)
+		}
+		got, err := Paste{}.URL2ID(url)
+		if err != nil || got != id {
+			t.Errorf("expected url %q to decode to %d, got %d (%v)", url, id, got, err)
+		}
+	}
+
+	// URLs generated before the key was set still work
+	if got, err := (Paste{}).URL2ID(plain); err != nil || got != 123456789 {
+		t.Errorf("expected plain url %q to decode to %d, got %d (%v)", plain, 123456789, got, err)
+	}
+
+	// consecutive IDs, like the ones the disk store makes from timestamps,
+	// must not give ordered URLs
+	var increasing int
+	prev, _ := decode62(strings.TrimPrefix(Paste{ID: 1000}.URL(), obfuscatedPrefix))
+	for id := int64(1001); id < 1100; id++ {
+		n, _ := decode62(strings.TrimPrefix(Paste{ID: id}.URL(), obfuscatedPrefix))
+		if n > prev {
+			increasing++
+		}
+		prev = n
+	}
+	if increasing < 20 || increasing > 80 {
+		t.Errorf("expected obfuscated ids to be unordered, %d of 99 are increasing", increasing)
+	}
+
+	SetURLKey("")
+	if _, err := (Paste{}).URL2ID(obfuscatedPrefix + "abc"); err == nil {
+		t.Errorf("expected obfuscated url to fail without a key")
+	}
+}
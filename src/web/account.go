@@ -0,0 +1,250 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/service/mail"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+)
+
+// mailer returns the mailer for the service. Emails go to the configured
+// mailer, the SMTP server or, if there is none, to the log. Either way they
+// are sent in the background.
+func (h *Server) mailer() service.Mailer {
+	var m service.Mailer
+	switch {
+	case h.options.Mailer != nil:
+		m = h.options.Mailer
+	case h.options.SMTPHost != "":
+		smtp := mail.NewSMTP(h.options.SMTPHost, h.options.SMTPPort, h.options.SMTPUser, h.options.SMTPPass, h.options.SMTPFrom)
+		h.log.Logf("INFO sending emails via %s", smtp)
+		m = smtp
+	default:
+		h.log.Logf("INFO no SMTP server configured, emails are written to the log")
+		m = mail.Log{L: h.log}
+	}
+	h.mail = mail.NewAsync(m, h.log)
+	return h.mail
+}
+
+// showLogin shows the login page with an optional message or error.
+func (h *Server) showLogin(w http.ResponseWriter, msg, errMsg string) {
+	h.showPage(w,
+		page.Template("login.html"),
+		page.Title(h.options.BrandName+" - Login"),
+		page.Message(msg),
+		page.ErrorMessage(errMsg),
+	)
+}
+
+// showRegister shows the registration page with an optional error.
+func (h *Server) showRegister(w http.ResponseWriter, errMsg string) {
+	h.showPage(w,
+		page.Template("register.html"),
+		page.Title(h.options.BrandName+" - Register"),
+		page.ErrorMessage(errMsg),
+	)
+}
+
+// handleGetRegister shows the registration form.
+func (h *Server) handleGetRegister(w http.ResponseWriter, r *http.Request) {
+	h.showRegister(w, "")
+}
+
+// handlePostRegister creates a new local account. If email verification is
+// required the user is asked to check their inbox, otherwise they can login
+// right away.
+func (h *Server) handlePostRegister(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	if r.PostFormValue("password") != r.PostFormValue("confirm") {
+		h.showRegister(w, "Passwords don't match.")
+		return
+	}
+
+	usr, err := h.service.Register(service.RegisterRequest{
+		Email:    r.PostFormValue("email"),
+		Name:     r.PostFormValue("name"),
+		Password: r.PostFormValue("password"),
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrWrongEmail):
+		h.showRegister(w, "Please enter a valid email address.")
+		return
+	case errors.Is(err, service.ErrWeakPassword):
+		h.showRegister(w, "The password must be at least 8 characters long.")
+		return
+	case errors.Is(err, service.ErrUserExists):
+		h.showRegister(w, "An account with this email already exists.")
+		return
+	case errors.Is(err, service.ErrSendFailure):
+		h.log.Logf("ERROR verification email to user %s was not sent: %v", usr.ID, err)
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO local user %s registered", usr.ID)
+
+	if !usr.Verified {
+		h.showLogin(w, "We have sent you an email with a link to confirm your address. "+
+			"Please follow it to activate your account.", "")
+		return
+	}
+	http.Redirect(w, r, "/u/login?registered=yes", http.StatusSeeOther)
+}
+
+// handleGetLogin shows the login form for local accounts.
+func (h *Server) handleGetLogin(w http.ResponseWriter, r *http.Request) {
+	var msg string
+	switch {
+	case r.FormValue("registered") == "yes":
+		msg = "Your account has been created, you can login now."
+	case r.FormValue("verified") == "yes":
+		msg = "Your email address has been confirmed, you can login now."
+	case r.FormValue("reset") == "yes":
+		msg = "Your password has been changed, you can login with the new one now."
+	}
+	h.showLogin(w, msg, "")
+}
+
+// handlePostLogin checks the credentials of a local account and sets the
+// JWT cookie the same way the oauth providers do.
+func (h *Server) handlePostLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	usr, err := h.service.Authenticate(r.PostFormValue("email"), r.PostFormValue("password"), clientIP(r))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrLockedOut):
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.options.LoginLockout.Seconds())))
+		h.showError(w, http.StatusTooManyRequests, "Too many failed logins, please try again later.")
+		return
+	case errors.Is(err, service.ErrWrongCredentials):
+		h.showLogin(w, "", "Incorrect email or password.")
+		return
+	case errors.Is(err, service.ErrNotVerified):
+		h.showLogin(w, "", "Your email address is not confirmed yet. "+
+			"Please follow the link in the email we have sent you to activate your account.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+
+	claims := h.updateClaims(token.Claims{User: &token.User{ID: usr.ID, Name: usr.Name, Email: usr.Email}})
+	if _, err = h.auth.TokenService().Set(w, claims); err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO local user %s logged in", usr.ID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleGetVerify confirms the email address of a local account with the
+// token from the verification email.
+func (h *Server) handleGetVerify(w http.ResponseWriter, r *http.Request) {
+	err := h.service.VerifyUser(r.FormValue("token"))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrInvalidToken):
+		h.showError(w, http.StatusBadRequest, "The verification link is invalid or has already been used.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/u/login?verified=yes", http.StatusSeeOther)
+}
+
+// handleGetForgot shows the form to request a password reset.
+func (h *Server) handleGetForgot(w http.ResponseWriter, r *http.Request) {
+	h.showPage(w,
+		page.Template("forgot.html"),
+		page.Title(h.options.BrandName+" - Forgot password"),
+	)
+}
+
+// handlePostForgot emails a password reset link. The response is the same
+// whether there is an account with the email or not, so that it can't be
+// used to find out who is registered.
+func (h *Server) handlePostForgot(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	if err := h.service.RequestPasswordReset(r.PostFormValue("email")); err != nil {
+		h.log.Logf("ERROR password reset request failed: %v", err)
+	}
+	h.showPage(w,
+		page.Template("forgot.html"),
+		page.Title(h.options.BrandName+" - Forgot password"),
+		page.Message("If there is an account with this email, we have sent it a link to reset the password. "+
+			"The link is valid for an hour."),
+	)
+}
+
+// showReset shows the form to choose a new password.
+func (h *Server) showReset(w http.ResponseWriter, tkn, errMsg string) {
+	h.showPage(w,
+		page.Template("reset.html"),
+		page.Title(h.options.BrandName+" - Reset password"),
+		page.ResetToken(tkn),
+		page.ErrorMessage(errMsg),
+	)
+}
+
+// handleGetReset shows the form to choose a new password with the token
+// from the reset email.
+func (h *Server) handleGetReset(w http.ResponseWriter, r *http.Request) {
+	h.showReset(w, r.FormValue("token"), "")
+}
+
+// handlePostReset sets a new password if the reset token is valid.
+func (h *Server) handlePostReset(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	tkn := r.PostFormValue("token")
+	if r.PostFormValue("password") != r.PostFormValue("confirm") {
+		h.showReset(w, tkn, "Passwords don't match.")
+		return
+	}
+
+	err := h.service.ResetPassword(tkn, r.PostFormValue("password"))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrWeakPassword):
+		h.showReset(w, tkn, "The password must be at least 8 characters long.")
+		return
+	case errors.Is(err, service.ErrInvalidToken):
+		h.showError(w, http.StatusBadRequest, "The reset link is invalid, expired or has already been used.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/u/login?reset=yes", http.StatusSeeOther)
+}
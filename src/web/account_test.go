@@ -0,0 +1,213 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// testMailer keeps the emails instead of sending them.
+type testMailer struct {
+	sync.Mutex
+	bodies []string
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+func (m *testMailer) last() string {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.bodies) == 0 {
+		return ""
+	}
+	return m.bodies[len(m.bodies)-1]
+}
+
+func TestLocalAccountVerification(t *testing.T) {
+	t.Parallel()
+
+	mailer := &testMailer{}
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.EnableLocalAuth = true
+	opts.RequireEmailVerification = true
+	opts.Mailer = mailer
+	srv := New(log, opts)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+	creds := url.Values{"email": {"dave@example.com"}, "password": {"secret123"}}
+
+	w := post("/u/register", url.Values{"email": {"dave@example.com"}, "password": {"secret123"}, "confirm": {"secret123"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "We have sent you an email") {
+		t.Fatalf("expected registration to ask for verification, got %d: %s", w.Code, w.Body.String())
+	}
+	srv.mail.Wait()
+	body := mailer.last()
+	i := strings.Index(body, "http://localhost:8080/u/verify?token=")
+	if i < 0 {
+		t.Fatalf("expected verification link in the email, got [%s]", body)
+	}
+	link := strings.Fields(body[i:])[0]
+
+	w = post("/u/login", creds)
+	if !strings.Contains(w.Body.String(), "Your email address is not confirmed yet") {
+		t.Errorf("expected unverified login to be blocked, got %d", w.Code)
+	}
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Error("expected no cookie for unverified user")
+	}
+
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", strings.TrimPrefix(link, "http://localhost:8080"), nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/u/login?verified=yes" {
+		t.Fatalf("expected redirect to login, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", strings.TrimPrefix(link, "http://localhost:8080"), nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d for a used token, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = post("/u/login", creds)
+	if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Set-Cookie"), "JWT=") {
+		t.Errorf("expected login to set the JWT cookie, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestLocalAccountWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.EnableLocalAuth = true
+	srv := New(log, opts)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/u/register", strings.NewReader("email=erin%40example.com&password=secret123&confirm=secret123"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/u/login?registered=yes" {
+		t.Fatalf("expected redirect to login, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/u/login", strings.NewReader("email=erin%40example.com&password=wrong"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	srv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "Incorrect email or password") {
+		t.Error("expected wrong password to be rejected")
+	}
+}
+
+func TestLocalAccountLockout(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.EnableLocalAuth = true
+	opts.LoginMaxFailures = 3
+	opts.LoginWindow = time.Minute
+	opts.LoginLockout = time.Minute
+	srv := New(log, opts)
+
+	post := func(path, form string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", path, strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+	if w := post("/u/register", "email=ivan%40example.com&password=secret123&confirm=secret123"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to login, got %d", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w := post("/u/login", "email=ivan%40example.com&password=wrong"); !strings.Contains(w.Body.String(), "Incorrect email or password") {
+			t.Fatalf("expected wrong password to be rejected, got %d", w.Code)
+		}
+	}
+	w := post("/u/login", "email=ivan%40example.com&password=secret123")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60, got %q", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	mailer := &testMailer{}
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.EnableLocalAuth = true
+	opts.Mailer = mailer
+	srv := New(log, opts)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+	post("/u/register", url.Values{"email": {"heidi@example.com"}, "password": {"secret123"}, "confirm": {"secret123"}})
+
+	// the response doesn't tell whether the account exists
+	unknown := post("/u/forgot", url.Values{"email": {"nobody@example.com"}})
+	known := post("/u/forgot", url.Values{"email": {"heidi@example.com"}})
+	for _, w := range []*httptest.ResponseRecorder{unknown, known} {
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "If there is an account with this email") {
+			t.Errorf("expected the same response for any email, got %d", w.Code)
+		}
+	}
+
+	srv.mail.Wait()
+	body := mailer.last()
+	i := strings.Index(body, "http://localhost:8080/u/reset?token=")
+	if i < 0 {
+		t.Fatalf("expected reset link in the email, got [%s]", body)
+	}
+	tkn := strings.Fields(body[i+len("http://localhost:8080/u/reset?token="):])[0]
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/u/reset?token="+tkn, nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tkn) {
+		t.Errorf("expected reset form with the token, got %d", w.Code)
+	}
+
+	reset := url.Values{"token": {tkn}, "password": {"newsecret123"}, "confirm": {"newsecret123"}}
+	w = post("/u/reset", reset)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/u/login?reset=yes" {
+		t.Fatalf("expected redirect to login, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w = post("/u/reset", reset); w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d for a used token, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = post("/u/login", url.Values{"email": {"heidi@example.com"}, "password": {"newsecret123"}})
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("expected login with the new password, got %d", w.Code)
+	}
+}
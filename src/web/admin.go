@@ -0,0 +1,176 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+)
+
+// adminPageSize is the number of pastes or users on a single admin page.
+const adminPageSize = 20
+
+// isAdminID reports whether the user ID is one of the configured admins or
+// the user was made an admin on the admin page.
+func (h *Server) isAdminID(uid string) bool {
+	for _, id := range h.options.Admins {
+		if id == uid {
+			return true
+		}
+	}
+	return h.service.IsAdmin(uid)
+}
+
+// updateClaims sets the admin flag in the JWT token. It is called whenever
+// the token is issued or refreshed, so promotions and demotions take effect
+// within the token duration.
+func (h *Server) updateClaims(claims token.Claims) token.Claims {
+	if claims.User != nil {
+		claims.User.SetAdmin(h.isAdminID(claims.User.ID))
+	}
+	return claims
+}
+
+// adminUser returns the logged in user if it is an admin, otherwise it
+// shows an error page and returns false.
+func (h *Server) adminUser(w http.ResponseWriter, r *http.Request) (token.User, bool) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to access this page.")
+		return usr, false
+	}
+	if !usr.IsAdmin() {
+		h.showError(w, http.StatusForbidden, "Only administrators can access this page.")
+		return usr, false
+	}
+	return usr, true
+}
+
+// handleGetAdmin generates the admin dashboard with a list of all the
+// pastes or all the users.
+func (h *Server) handleGetAdmin(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	skip, err := strconv.Atoi(r.FormValue("skip"))
+	if err != nil || skip < 0 {
+		skip = 0
+	}
+	pastes, users := h.service.GetTotals()
+
+	data := []page.Data{
+		page.Template("admin.html"),
+		page.Title(h.options.BrandName + " - Admin"),
+		page.User(usr),
+	}
+	switch r.FormValue("tab") {
+	case "users":
+		list, err := h.service.AllUsers(adminPageSize, skip)
+		if err != nil {
+			h.showInternalError(w, err)
+			return
+		}
+		data = append(data, page.Tab("users"), page.Users(list), page.PageLinks(paginate(users, adminPageSize, skip)))
+	case "reports":
+		list, total, err := h.service.Reports(adminPageSize, skip)
+		if err != nil {
+			h.showInternalError(w, err)
+			return
+		}
+		data = append(data, page.Tab("reports"), page.Reports(list), page.PageLinks(paginate(total, adminPageSize, skip)))
+	default:
+		list, err := h.service.AllPastes(adminPageSize, skip)
+		if err != nil {
+			h.showInternalError(w, err)
+			return
+		}
+		data = append(data, page.Tab("pastes"), page.Pastes(list), page.PageLinks(paginate(pastes, adminPageSize, skip)))
+	}
+
+	h.showPage(w, data...)
+}
+
+// handlePostAdminDeletePaste deletes any paste.
+func (h *Server) handlePostAdminDeletePaste(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if err := h.service.DeletePaste(id); err != nil {
+		if errors.Is(err, service.ErrPasteNotFound) {
+			h.showError(w, http.StatusNotFound, "There is no such paste")
+			return
+		}
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO admin %s deleted paste %s", usr.ID, id)
+	// pastes can be deleted from the reports tab as well
+	tab := "pastes"
+	if r.PostFormValue("tab") == "reports" {
+		tab = "reports"
+	}
+	http.Redirect(w, r, "/admin?tab="+tab, http.StatusSeeOther)
+}
+
+// handlePostAdminDeleteUser deletes any user with all the user's pastes.
+func (h *Server) handlePostAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if id == usr.ID {
+		h.showError(w, http.StatusBadRequest, "You cannot delete yourself from the admin page.")
+		return
+	}
+	if err := h.service.DeleteUser(id); err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO admin %s deleted user %s", usr.ID, id)
+	http.Redirect(w, r, "/admin?tab=users", http.StatusSeeOther)
+}
+
+// handlePostAdminUserAdmin promotes a user to an admin or demotes them,
+// admin=on|off sets the flag and anything else toggles it.
+func (h *Server) handlePostAdminUserAdmin(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	admin := !h.service.IsAdmin(id)
+	switch r.PostFormValue("admin") {
+	case "on":
+		admin = true
+	case "off":
+		admin = false
+	}
+
+	err := h.service.SetAdmin(id, admin)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUserNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such user.")
+		return
+	case errors.Is(err, service.ErrLastAdmin):
+		h.showError(w, http.StatusBadRequest, "The last admin can't be demoted, promote someone else first.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO admin %s set admin of user %s to %v", usr.ID, id, admin)
+	http.Redirect(w, r, "/admin?tab=users", http.StatusSeeOther)
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Admin dashboard is only available to admins
+func TestGetAdmin(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.Admins = []string{"test_admin"}
+	srv := New(log, opts)
+
+	usr, _ := srv.service.GetOrUpdateUser(store.User{ID: "test_admin_user", Name: "Test Admin User"})
+	p, _ := srv.service.NewPaste(service.PasteRequest{
+		Title:   "Admin private paste",
+		Body:    "Test paste",
+		Privacy: "private",
+		UserID:  usr.ID,
+	})
+
+	admin := token.User{ID: "test_admin", Name: "Test Admin"}
+	admin.SetAdmin(true)
+	regular := token.User{ID: usr.ID, Name: usr.Name}
+
+	// anonymous user
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/admin", nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// regular user
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/admin", nil)
+	r = token.SetUserInfo(r, regular)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status should be %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// admin sees private pastes of other users
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/admin", nil)
+	r = token.SetUserInfo(r, admin)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "/admin/pastes/"+p.URL()+"/delete") {
+		t.Errorf("Response should contain the private paste %s", p.URL())
+	}
+
+	// and all the users
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/admin?tab=users", nil)
+	r = token.SetUserInfo(r, admin)
+	srv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "/admin/users/"+usr.ID+"/delete") {
+		t.Errorf("Response should contain the user %s", usr.ID)
+	}
+
+	// regular user cannot delete
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/admin/pastes/"+p.URL()+"/delete", nil)
+	r = token.SetUserInfo(r, regular)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status should be %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// admin deletes the paste
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/admin/pastes/"+p.URL()+"/delete", nil)
+	r = token.SetUserInfo(r, admin)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	if _, err := srv.service.GetPaste(p.URL(), usr.ID, ""); !errors.Is(err, service.ErrPasteNotFound) {
+		t.Errorf("Expected paste to be deleted, got %v", err)
+	}
+
+	// admin cannot delete itself
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/admin/users/"+admin.ID+"/delete", nil)
+	r = token.SetUserInfo(r, admin)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Configured admins get the admin flag in their token
+func TestUpdateClaims(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{options: ServerOptions{Admins: []string{"test_admin"}}, service: service.NewWithMemDB()}
+
+	claims := srv.updateClaims(token.Claims{User: &token.User{ID: "test_admin"}})
+	if !claims.User.IsAdmin() {
+		t.Errorf("User %s should be an admin", claims.User.ID)
+	}
+	claims = srv.updateClaims(token.Claims{User: &token.User{ID: "test_user"}})
+	if claims.User.IsAdmin() {
+		t.Errorf("User %s should not be an admin", claims.User.ID)
+	}
+	srv.updateClaims(token.Claims{})
+}
+
+// Reported pastes are listed on the admin page
+func TestPostReport(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.Admins = []string{"test_admin"}
+	opts.MaxReports = 2
+	srv := New(log, opts)
+
+	p, _ := srv.service.NewPaste(service.PasteRequest{
+		Title:   "Reported paste",
+		Body:    "Spam",
+		Privacy: "public",
+	})
+	admin := token.User{ID: "test_admin", Name: "Test Admin"}
+	admin.SetAdmin(true)
+
+	report := func(id string) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/"+id+"/report", strings.NewReader("reason=Spam+link"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "192.0.2.10:1234"
+		srv.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := report(p.URL()); code != http.StatusSeeOther {
+		t.Fatalf("Status should be %d, got %d", http.StatusSeeOther, code)
+	}
+	if code := report("nonexistent"); code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, code)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/admin?tab=reports", nil)
+	r = token.SetUserInfo(r, admin)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, want := range []string{"Spam link", "/admin/pastes/" + p.URL() + "/delete"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Response should contain [%s]", want)
+		}
+	}
+
+	// reports are limited per IP
+	if code := report(p.URL()); code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, code)
+	}
+	if code := report(p.URL()); code != http.StatusTooManyRequests {
+		t.Errorf("Status should be %d, got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+// Admins promote and demote users, the last admin in the store stays and
+// the token of a promoted user gets the admin flag
+func TestPostAdminUserAdmin(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.Admins = []string{"test_admin"}
+	srv := New(log, opts)
+	defer srv.Close()
+
+	for _, id := range []string{"alice", "bob"} {
+		if _, err := srv.service.GetOrUpdateUser(store.User{ID: id, Name: id}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	admin := token.User{ID: "test_admin", Name: "Test Admin"}
+	admin.SetAdmin(true)
+
+	post := func(id, body string, u token.User) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/admin/users/"+id+"/admin", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = token.SetUserInfo(r, u)
+		srv.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post("alice", "", token.User{ID: "bob", Name: "bob"}); code != http.StatusForbidden {
+		t.Errorf("regular users should not promote, got %d", code)
+	}
+	if code := post("alice", "", admin); code != http.StatusSeeOther {
+		t.Fatalf("Status should be %d, got %d", http.StatusSeeOther, code)
+	}
+	if !srv.service.IsAdmin("alice") {
+		t.Fatalf("alice should be promoted")
+	}
+	claims := srv.updateClaims(token.Claims{User: &token.User{ID: "alice"}})
+	if !claims.User.IsAdmin() {
+		t.Errorf("alice should get the admin flag in her token")
+	}
+
+	// alice is the only admin in the store
+	if code := post("alice", "admin=off", admin); code != http.StatusBadRequest {
+		t.Errorf("the last admin should not be demoted, got %d", code)
+	}
+	if code := post("bob", "admin=on", admin); code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, code)
+	}
+	if code := post("alice", "admin=off", admin); code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, code)
+	}
+	if srv.service.IsAdmin("alice") || !srv.service.IsAdmin("bob") {
+		t.Errorf("only bob should be an admin")
+	}
+	claims = srv.updateClaims(token.Claims{User: &token.User{ID: "alice", Attributes: map[string]interface{}{"admin": true}}})
+	if claims.User.IsAdmin() {
+		t.Errorf("alice should lose the admin flag when her token is refreshed")
+	}
+
+	if code := post("nobody", "", admin); code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, code)
+	}
+}
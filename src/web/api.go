@@ -0,0 +1,433 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// apiPasteMeta is the public metadata of a paste returned by the API.
+type apiPasteMeta struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Syntax     string    `json:"syntax"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	Expiration string    `json:"expiration"`
+	Views      int64     `json:"views"`
+	Privacy    string    `json:"privacy"`
+	Protected  bool      `json:"protected"`
+	URL        string    `json:"url,omitempty"` // where to get the body, empty for protected pastes
+}
+
+// apiUser is the public part of a paste author returned by the API.
+type apiUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiPaste is a paste with its ready to use URLs. Only the public fields are
+// included, never the password hash or the contacts of the author.
+type apiPaste struct {
+	ID              int64             `json:"id"`
+	Title           string            `json:"title"`
+	Body            string            `json:"body"`
+	Expires         time.Time         `json:"expires"`
+	DeleteAfterRead bool              `json:"delete_after_read"`
+	BlurUntilClick  bool              `json:"blur_until_click"`
+	Privacy         string            `json:"privacy"`
+	Created         time.Time         `json:"created"`
+	Syntax          string            `json:"syntax"`
+	UserID          string            `json:"user_id"`
+	User            apiUser           `json:"user"`
+	Views           int64             `json:"views"`
+	Files           []store.PasteFile `json:"files,omitempty"`
+	Size            int64             `json:"size"`
+	Lines           int               `json:"lines"`
+	Theme           string            `json:"theme"`
+	AllowEmbed      bool              `json:"allow_embed"`
+	Encoding        string            `json:"encoding,omitempty"`
+	ShortURL        string            `json:"short_url"`             // paste ID as used in the URLs
+	FullURL         string            `json:"full_url"`              // shareable URL of the paste
+	ClaimToken      string            `json:"claim_token,omitempty"` // token to claim a new anonymous paste, only in the response that created it
+}
+
+// pasteURL returns the full shareable URL of the paste.
+func (h *Server) pasteURL(r *http.Request, p store.Paste) string {
+	return h.serverURL(r) + "/p/" + p.URL()
+}
+
+// newAPIPaste returns the API representation of the paste.
+func (h *Server) newAPIPaste(r *http.Request, p store.Paste) apiPaste {
+	return apiPaste{
+		ID:              p.ID,
+		Title:           p.Title,
+		Body:            p.Body,
+		Expires:         p.Expires,
+		DeleteAfterRead: p.DeleteAfterRead,
+		BlurUntilClick:  p.BlurUntilClick,
+		Privacy:         p.Privacy,
+		Created:         p.CreatedAt,
+		Syntax:          p.Syntax,
+		UserID:          p.UserID,
+		User:            apiUser{ID: p.User.ID, Name: p.User.Name},
+		Views:           p.Views,
+		Files:           p.Files,
+		Size:            p.Size,
+		Lines:           p.Lines,
+		Theme:           p.Theme,
+		AllowEmbed:      p.AllowEmbed,
+		Encoding:        p.Encoding,
+		ShortURL:        p.URL(),
+		FullURL:         h.pasteURL(r, p),
+	}
+}
+
+// pastePassword returns the paste password from the Basic Auth header, the
+// user name is ignored, or from the password query parameter.
+func pastePassword(r *http.Request) string {
+	if _, pwd, ok := r.BasicAuth(); ok {
+		return pwd
+	}
+	return r.URL.Query().Get("password")
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func (h *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.log.Logf("ERROR writeJSON: failed to write response: %v", err)
+	}
+}
+
+// writeJSONError writes an error message as a JSON response.
+func (h *Server) writeJSONError(w http.ResponseWriter, status int, msg string) {
+	h.writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// writeJSONMetaError writes a JSON error for the errors returned by
+// service.GetPasteMeta.
+func (h *Server) writeJSONMetaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.writeJSONError(w, http.StatusNotFound, "paste not found")
+	case errors.Is(err, service.ErrPasteIsPrivate):
+		h.writeJSONError(w, http.StatusForbidden, "paste is private")
+	case errors.Is(err, service.ErrStoreFailure):
+		h.log.Logf("ERROR request %s: %v", w.Header().Get(requestIDHeader), err)
+		h.writeJSONError(w, http.StatusInternalServerError, "internal error")
+	case errors.Is(err, service.ErrStoreUnavailable):
+		h.log.Logf("ERROR request %s: %v", w.Header().Get(requestIDHeader), err)
+		h.writeJSONError(w, http.StatusServiceUnavailable, "service unavailable")
+	default:
+		h.writeJSONError(w, http.StatusBadRequest, "invalid paste id")
+	}
+}
+
+// handleAPIGetPasteMeta returns paste metadata, without the body, as JSON.
+func (h *Server) handleAPIGetPasteMeta(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	id := mux.Vars(r)["id"]
+
+	paste, err := h.service.GetPasteMeta(id, usr.ID)
+	if err != nil {
+		h.writeJSONMetaError(w, err)
+		return
+	}
+
+	meta := apiPasteMeta{
+		ID:         paste.URL(),
+		Title:      paste.Title,
+		Syntax:     paste.Syntax,
+		Created:    paste.CreatedAt,
+		Expires:    paste.Expires,
+		Expiration: paste.Expiration(),
+		Views:      paste.Views,
+		Privacy:    paste.Privacy,
+		Protected:  paste.Password != "",
+	}
+	if !meta.Protected {
+		meta.URL = h.pasteURL(r, paste)
+	}
+	h.writeJSON(w, http.StatusOK, meta)
+}
+
+// handleAPIGetPaste returns a paste with its body as JSON. The password of
+// protected pastes is given with Basic Auth or the password query parameter.
+func (h *Server) handleAPIGetPaste(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	id := mux.Vars(r)["id"]
+
+	paste, err := h.service.GetPasteNoCountCtx(r.Context(), id, usr.ID, pastePassword(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPasteHasPassword), errors.Is(err, service.ErrWrongPassword):
+			w.Header().Set("WWW-Authenticate", `Basic realm="paste"`)
+			h.writeJSONError(w, http.StatusUnauthorized, "paste is protected by a password")
+		default:
+			h.writeJSONMetaError(w, err)
+		}
+		return
+	}
+	h.writeJSON(w, http.StatusOK, h.newAPIPaste(r, paste))
+}
+
+// apiPasteStats is the view statistics of a paste.
+type apiPasteStats struct {
+	Views   int64     `json:"views"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Syntax  string    `json:"syntax"`
+}
+
+// handleGetPasteStats returns paste statistics as JSON. Statistics of
+// private pastes are only available to their owners.
+func (h *Server) handleGetPasteStats(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	id := mux.Vars(r)["id"]
+
+	paste, err := h.service.GetPasteMeta(id, usr.ID)
+	if err != nil {
+		h.writeJSONMetaError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, apiPasteStats{
+		Views:   paste.Views,
+		Created: paste.CreatedAt,
+		Expires: paste.Expires,
+		Syntax:  paste.Syntax,
+	})
+}
+
+// apiPasteStatus tells whether a paste has expired.
+type apiPasteStatus struct {
+	Expired bool      `json:"expired"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleGetPasteStatus returns the expiration status of a paste as JSON,
+// the view page polls it to show when the paste is gone. Pastes past their
+// expiration time are reported as expired even if they are still in the
+// store. It doesn't count a view.
+func (h *Server) handleGetPasteStatus(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	id := mux.Vars(r)["id"]
+
+	paste, err := h.service.GetPasteMeta(id, usr.ID)
+	if err != nil {
+		h.writeJSONMetaError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, apiPasteStatus{
+		Expired: paste.Expired(),
+		Expires: paste.Expires,
+	})
+}
+
+// handleAPIGetStats returns the instance-wide paste and user counts as
+// JSON. They are cached by the service, so they may be a bit behind.
+func (h *Server) handleAPIGetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.service.InstanceStats()
+	if err != nil {
+		h.log.Logf("ERROR request %s: %v", w.Header().Get(requestIDHeader), err)
+		if errors.Is(err, service.ErrStoreUnavailable) {
+			h.writeJSONError(w, http.StatusServiceUnavailable, "service unavailable")
+			return
+		}
+		h.writeJSONError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	h.writeJSON(w, http.StatusOK, stats)
+}
+
+// handleAPIPostPaste creates a new paste. The request is either a JSON
+// encoded service.PasteRequest or, for curl and friends, a text/plain body
+// with optional syntax, expires and privacy query parameters. The response
+// is in the same format as the request.
+func (h *Server) handleAPIPostPaste(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	if h.options.RequireLoginToPaste && usr.ID == "" {
+		h.writeJSONError(w, http.StatusUnauthorized, "login required")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+
+	plain := false
+	var pr service.PasteRequest
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "text/plain":
+		plain = true
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "cannot read the body, it may be too large", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		pr = service.PasteRequest{
+			Title:   q.Get("title"),
+			Body:    string(body),
+			Expires: q.Get("expires"),
+			Privacy: q.Get("privacy"),
+			Syntax:  q.Get("syntax"),
+			Theme:   q.Get("theme"),
+		}
+		if pr.Syntax == "" {
+			pr.Syntax = "text"
+		}
+	case "application/json", "":
+		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+			h.writeJSONError(w, http.StatusBadRequest, "invalid request, it may be too large")
+			return
+		}
+	default:
+		h.writeJSONError(w, http.StatusUnsupportedMediaType, "content type must be application/json or text/plain")
+		return
+	}
+	pr.UserID = usr.ID
+	pr.IP = clientIP(r)
+	if pr.Expires == "" {
+		pr.Expires = h.options.defaultExpiration()
+	}
+	if pr.Privacy == "" {
+		pr.Privacy = h.options.defaultPrivacy(usr.ID != "")
+	}
+
+	if usr.ID != "" {
+		_, err := h.service.GetOrUpdateUser(store.User{
+			ID:    usr.ID,
+			Name:  usr.Name,
+			Email: usr.Email,
+			IP:    usr.IP,
+			Admin: usr.IsAdmin(),
+		})
+		if err != nil {
+			h.log.Logf("ERROR can't update the user: %v", err)
+		}
+	}
+
+	if err := h.options.checkPastePolicy(pr); err != nil {
+		if plain {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			h.writeJSONError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	if h.blockedContent(pr) {
+		if !h.options.UnlistBlocked {
+			if plain {
+				http.Error(w, "content not allowed", http.StatusBadRequest)
+			} else {
+				h.writeJSONError(w, http.StatusBadRequest, "content not allowed")
+			}
+			return
+		}
+		pr.Privacy = "unlisted"
+	}
+	paste, err := h.service.NewPasteCtx(r.Context(), pr)
+	if err != nil {
+		status, msg := apiPasteError(err)
+		if status >= http.StatusInternalServerError {
+			h.log.Logf("ERROR handleAPIPostPaste: %v", err)
+		}
+		if plain {
+			http.Error(w, msg, status)
+		} else {
+			h.writeJSONError(w, status, msg)
+		}
+		return
+	}
+	h.metrics.pastesCreated.Add(1)
+	h.notifyPasteCreated(r, paste)
+	h.idempotentDone(r, paste)
+	h.writeCreatedPaste(w, r, paste, plain)
+}
+
+// writeCreatedPaste writes the response to a request that has created the
+// paste, its URL for plain text requests or the paste as JSON. The token to
+// claim an anonymous paste is in the X-Claim-Token header for plain text
+// requests.
+func (h *Server) writeCreatedPaste(w http.ResponseWriter, r *http.Request, paste store.Paste, plain bool) {
+	if plain {
+		if paste.ClaimToken != "" {
+			w.Header().Set("X-Claim-Token", paste.ClaimToken)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, h.pasteURL(r, paste))
+		return
+	}
+	resp := h.newAPIPaste(r, paste)
+	resp.ClaimToken = paste.ClaimToken
+	h.writeJSON(w, http.StatusCreated, resp)
+}
+
+// handleAPIDeletePaste deletes one of the current user's pastes, or moves it
+// to the trash if it is enabled.
+func (h *Server) handleAPIDeletePaste(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.writeJSONError(w, http.StatusUnauthorized, "login required")
+		return
+	}
+	id, err := store.Paste{}.URL2ID(mux.Vars(r)["id"])
+	if err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "invalid paste id")
+		return
+	}
+	deleted, err := h.service.DeletePastes([]int64{id}, usr.ID)
+	if err != nil {
+		h.writeJSONMetaError(w, err)
+		return
+	}
+	if deleted == 0 {
+		h.writeJSONError(w, http.StatusNotFound, "paste not found")
+		return
+	}
+	h.log.Logf("INFO paste %s deleted by %s", mux.Vars(r)["id"], usr.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPasteError returns the status code and the message for the errors
+// returned by service.NewPaste.
+func apiPasteError(err error) (int, string) {
+	switch {
+	case errors.Is(err, service.ErrEmptyBody):
+		return http.StatusBadRequest, "body must not be empty"
+	case errors.Is(err, service.ErrWrongPrivacy):
+		return http.StatusBadRequest, "privacy can be one of 'private', 'public' or 'unlisted'"
+	case errors.Is(err, service.ErrWrongDuration):
+		return http.StatusBadRequest, "expiration format is incorrect or out of the allowed range"
+	case errors.Is(err, service.ErrTitleTooLong):
+		return http.StatusBadRequest, "title is too long"
+	case errors.Is(err, service.ErrDuplicateFile):
+		return http.StatusBadRequest, "file names must be unique"
+	case errors.Is(err, service.ErrInvalidEncoding):
+		return http.StatusBadRequest, "body must be UTF-8 or Windows-1252 text"
+	case errors.Is(err, service.ErrUserNotFound):
+		return http.StatusBadRequest, "user not found"
+	case errors.Is(err, service.ErrPasteLimitReached):
+		return http.StatusForbidden, "paste limit reached"
+	case errors.Is(err, service.ErrConflict):
+		return http.StatusConflict, "paste already exists, please try again"
+	case errors.Is(err, service.ErrStoreUnavailable):
+		return http.StatusServiceUnavailable, "service unavailable"
+	}
+	return http.StatusInternalServerError, "internal error"
+}
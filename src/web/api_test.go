@@ -0,0 +1,569 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Get paste metadata from the API
+func TestAPIGetPasteMeta(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Test meta",
+		Body:    "Secret body",
+		Privacy: "public",
+		Syntax:  "go",
+	})
+	prot, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:     "Protected body",
+		Privacy:  "public",
+		Password: "pa$$w0rd",
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/v1/paste/"+p.URL()+"/meta", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if strings.Contains(w.Body.String(), "Secret body") {
+		t.Errorf("Response should not contain the body, got [%s]", w.Body.String())
+	}
+	var meta apiPasteMeta
+	if err := json.NewDecoder(w.Body).Decode(&meta); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if meta.Title != "Test meta" || meta.Syntax != "go" || meta.Expiration != "Never" || meta.Protected || meta.URL == "" {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/v1/paste/"+prot.URL()+"/meta", nil)
+	webSrv.router.ServeHTTP(w, r)
+	meta = apiPasteMeta{}
+	if err := json.NewDecoder(w.Body).Decode(&meta); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !meta.Protected || meta.URL != "" {
+		t.Errorf("Protected paste should have no URL, got %+v", meta)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/api/v1/paste/IYCE8rJj8Qg/meta", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// Create a paste from a plain text body
+func TestAPIPostPastePlainText(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader("package main\n"))
+	r.Header.Set("Content-Type", "text/plain")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status should be %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	prefix := webSrv.options.Proto + "://" + webSrv.options.Addr + "/p/"
+	got := strings.TrimSpace(w.Body.String())
+	if !strings.HasPrefix(got, prefix) {
+		t.Fatalf("Response should be the paste URL, got [%s]", got)
+	}
+	p, err := webSrv.service.GetPaste(strings.TrimPrefix(got, prefix), "", "")
+	if err != nil {
+		t.Fatalf("Failed to get the new paste: %v", err)
+	}
+	if p.Body != "package main\n" || p.Privacy != "public" || p.Syntax != "text" || !p.Expires.IsZero() {
+		t.Errorf("Paste should have the body and the defaults, got %+v", p)
+	}
+	if w.Header().Get("X-Claim-Token") == "" {
+		t.Errorf("Anonymous paste should have the X-Claim-Token header")
+	}
+
+	// query overrides
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste?syntax=go&expires=1d&privacy=unlisted", strings.NewReader("package main\n"))
+	r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status should be %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	p, _ = webSrv.service.GetPaste(strings.TrimPrefix(strings.TrimSpace(w.Body.String()), prefix), "", "")
+	if p.Privacy != "unlisted" || p.Syntax != "go" || p.Expires.IsZero() {
+		t.Errorf("Paste should have the query parameters applied, got %+v", p)
+	}
+
+	// errors are plain text as well
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste?privacy=secret", strings.NewReader("package main\n"))
+	r.Header.Set("Content-Type", "text/plain")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Error should be plain text, got %s", w.Header().Get("Content-Type"))
+	}
+
+	// body is too large
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste", strings.NewReader(strings.Repeat("a", int(webSrv.options.MaxBodySize)+1)))
+	r.Header.Set("Content-Type", "text/plain")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Pastes created with the API without privacy get the configured default
+func TestAPIPostPasteDefaultPrivacy(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.DefaultPrivacyAnon = "unlisted"
+	opts.DefaultPrivacyUser = "private"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	defer srv.Close()
+
+	usr := token.User{ID: "test_user_api_default_privacy", Name: "Test User"}
+	tests := []struct {
+		name, ct, body string
+		usr            *token.User
+		want           string
+	}{
+		{"plain anonymous", "text/plain", "Test body", nil, "unlisted"},
+		{"json anonymous", "application/json", `{"body":"Test body"}`, nil, "unlisted"},
+		{"json logged in", "application/json", `{"body":"Test body"}`, &usr, "private"},
+		{"json explicit", "application/json", `{"body":"Test body","privacy":"public"}`, nil, "public"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.ct)
+		uid := ""
+		if tc.usr != nil {
+			r = token.SetUserInfo(r, *tc.usr)
+			uid = tc.usr.ID
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: status should be %d, got %d: %s", tc.name, http.StatusCreated, w.Code, w.Body.String())
+			continue
+		}
+		url := strings.TrimSpace(w.Body.String())
+		if tc.ct == "application/json" {
+			var resp struct {
+				ShortURL string `json:"short_url"`
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			url = resp.ShortURL
+		}
+		p, err := srv.service.GetPasteMeta(url[strings.LastIndex(url, "/")+1:], uid)
+		if err != nil {
+			t.Errorf("%s: failed to get the new paste: %v", tc.name, err)
+			continue
+		}
+		if p.Privacy != tc.want {
+			t.Errorf("%s: privacy should be %s, got %s", tc.name, tc.want, p.Privacy)
+		}
+	}
+}
+
+// Create a paste from a JSON request
+func TestAPIPostPasteJSON(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	body := `{"title":"Test API","body":"Test body","expires":"never","privacy":"public","syntax":"go","password":"secret"}`
+	r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Status should be %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var p apiPaste
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if p.ID == 0 || p.Title != "Test API" || p.Syntax != "go" {
+		t.Errorf("Response should contain the new paste, got %+v", p)
+	}
+	if id := (store.Paste{ID: p.ID}).URL(); p.ShortURL != id {
+		t.Errorf("Short URL should be %s, got %s", id, p.ShortURL)
+	}
+	if want := webSrv.options.Proto + "://" + webSrv.options.Addr + "/p/" + p.ShortURL; p.FullURL != want {
+		t.Errorf("Full URL should be %s, got %s", want, p.FullURL)
+	}
+
+	// validation errors
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"","privacy":"public"}`))
+	r.Header.Set("Content-Type", "application/json")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// too large
+	w = httptest.NewRecorder()
+	body = `{"body":"` + strings.Repeat("a", int(webSrv.options.MaxBodySize)) + `","privacy":"public"}`
+	r, _ = http.NewRequest("POST", "/api/v1/paste", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// unsupported content type
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste", strings.NewReader("body=test"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Status should be %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
+
+// Users can delete only their own pastes with the API
+func TestAPIDeletePaste(t *testing.T) {
+	t.Parallel()
+
+	usr := token.User{ID: "test_user_api_delete", Name: "Test User API Delete"}
+	if _, err := webSrv.service.GetOrUpdateUser(store.User{ID: usr.ID, Name: usr.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	p, err := webSrv.service.NewPaste(service.PasteRequest{Body: "Test body", Privacy: "public", UserID: usr.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	del := func(id string, u *token.User) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("DELETE", "/api/v1/paste/"+id, nil)
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		webSrv.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := del(p.URL(), nil); code != http.StatusUnauthorized {
+		t.Errorf("Status should be %d for anonymous users, got %d", http.StatusUnauthorized, code)
+	}
+	if code := del(p.URL(), &token.User{ID: "test_user_api_delete_other"}); code != http.StatusNotFound {
+		t.Errorf("Status should be %d for someone else's paste, got %d", http.StatusNotFound, code)
+	}
+	if code := del("not-an-id!", &usr); code != http.StatusBadRequest {
+		t.Errorf("Status should be %d for an invalid id, got %d", http.StatusBadRequest, code)
+	}
+	if code := del(p.URL(), &usr); code != http.StatusNoContent {
+		t.Errorf("Status should be %d, got %d", http.StatusNoContent, code)
+	}
+	if _, err := webSrv.service.GetPaste(p.URL(), usr.ID, ""); !errors.Is(err, service.ErrPasteNotFound) {
+		t.Errorf("expected the paste to be deleted, got %v", err)
+	}
+}
+
+// The API doesn't give away the password hash and the contacts of the author
+func TestAPIGetPastePrivateFields(t *testing.T) {
+	t.Parallel()
+
+	usr, err := webSrv.service.GetOrUpdateUser(store.User{ID: "test_user_api_fields", Name: "Test User API Fields",
+		Email: "fields@example.com", IP: "192.0.2.1", Admin: true})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	p, err := webSrv.service.NewPaste(service.PasteRequest{Body: "Test body", Privacy: "public", UserID: usr.ID, Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/v1/paste/"+p.URL()+"?password=secret", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var got map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if _, ok := got["password"]; ok {
+		t.Errorf("Response should not contain the password, got %v", got)
+	}
+	author, _ := got["user"].(map[string]interface{})
+	if author["id"] != usr.ID || author["name"] != usr.Name {
+		t.Errorf("Response should contain the author id and name, got %v", author)
+	}
+	for _, field := range []string{"email", "ip", "admin", "verified"} {
+		if _, ok := author[field]; ok {
+			t.Errorf("Response should not contain the author %s, got %v", field, author)
+		}
+	}
+}
+
+// X-Forwarded-Host is used for the URLs only when proxy headers are trusted
+func TestAPIPasteURLProxyHeaders(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.TrustProxyHeaders = true
+	trusted := New(log, opts)
+
+	tests := []struct {
+		srv  *Server
+		want string
+	}{
+		{trusted, opts.Proto + "://paste.example.com/p/"},
+		{webSrv, webSrv.options.Proto + "://" + webSrv.options.Addr + "/p/"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader("Test body"))
+		r.Header.Set("Content-Type", "text/plain")
+		r.Header.Set("X-Forwarded-Host", "paste.example.com, proxy.internal")
+		tc.srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Status should be %d, got %d", http.StatusCreated, w.Code)
+		}
+		if got := w.Body.String(); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("URL should start with %s, got %s", tc.want, got)
+		}
+	}
+}
+
+// Get paste stats
+func TestGetPasteStats(t *testing.T) {
+	t.Parallel()
+
+	u, _ := webSrv.service.GetOrUpdateUser(store.User{ID: "test_user_stats", Name: "Test User Stats"})
+	pub, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "Test body", Privacy: "public", Syntax: "go"})
+	priv, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "Test body", Privacy: "private", UserID: u.ID})
+	_, _ = webSrv.service.GetPaste(pub.URL(), "", "")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+pub.URL()+"/stats", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	var stats apiPasteStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if stats.Views != 1 || stats.Syntax != "go" || stats.Created.IsZero() {
+		t.Errorf("Stats should have 1 view and go syntax, got %+v", stats)
+	}
+
+	// private paste of another user
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+priv.URL()+"/stats", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status should be %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// private paste of the owner
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+priv.URL()+"/stats", nil)
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// stats don't count as a view
+	meta, _ := webSrv.service.GetPasteMeta(pub.URL(), "")
+	if meta.Views != 1 {
+		t.Errorf("Paste should have 1 view, got %d", meta.Views)
+	}
+}
+
+// Expiration status of a soon-expiring paste and of a paste that has
+// expired but hasn't been deleted yet
+func TestGetPasteStatus(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+	mdb := store.NewMemDB()
+	srv.service = service.New(mdb)
+
+	status := func(id string) (int, apiPasteStatus) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+id+"/status", nil)
+		srv.router.ServeHTTP(w, r)
+		var st apiPasteStatus
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, st
+	}
+
+	soon, _ := srv.service.NewPaste(service.PasteRequest{Body: "Soon gone", Privacy: "public", Expires: "1m"})
+	code, st := status(soon.URL())
+	if code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, code)
+	}
+	if st.Expired || !st.Expires.Equal(soon.Expires) {
+		t.Errorf("Paste should expire at %s and not be expired yet, got %+v", soon.Expires, st)
+	}
+
+	id, _ := mdb.Create(store.Paste{Body: "Gone", Privacy: "public", CreatedAt: time.Now().Add(-time.Hour), Expires: time.Now().Add(-time.Second)})
+	gone := store.Paste{ID: id}.URL()
+	if code, st = status(gone); code != http.StatusOK || !st.Expired {
+		t.Errorf("Paste past its expiration should be expired, got %d %+v", code, st)
+	}
+
+	if code, _ = status("nonexistent"); code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, code)
+	}
+
+	// status doesn't count as a view
+	if meta, _ := srv.service.GetPasteMeta(soon.URL(), ""); meta.Views != 0 {
+		t.Errorf("Paste should have no views, got %d", meta.Views)
+	}
+}
+
+// Password protected pastes with Basic Auth or the password parameter
+func TestPastePassword(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:     "Protected body",
+		Privacy:  "public",
+		Password: "secret",
+	})
+
+	tests := []struct {
+		name   string
+		query  string
+		basic  string // password for Basic Auth, empty means no header
+		status int
+	}{
+		{"basic auth", "", "secret", http.StatusOK},
+		{"query", "?password=secret", "", http.StatusOK},
+		{"wrong basic auth", "", "wrong", http.StatusUnauthorized},
+		{"wrong query", "?password=wrong", "", http.StatusUnauthorized},
+		{"missing", "", "", http.StatusUnauthorized},
+	}
+	for _, path := range []string{"/p/" + p.URL() + "/raw", "/api/v1/paste/" + p.URL()} {
+		for _, tc := range tests {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", path+tc.query, nil)
+			if tc.basic != "" {
+				r.SetBasicAuth("", tc.basic)
+			}
+			webSrv.router.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Errorf("%s %s: status should be %d, got %d", path, tc.name, tc.status, w.Code)
+				continue
+			}
+			if tc.status == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+				t.Errorf("%s %s: response should ask for Basic Auth", path, tc.name)
+			}
+			if tc.status == http.StatusOK && !strings.Contains(w.Body.String(), "Protected body") {
+				t.Errorf("%s %s: response should contain the body, got [%s]", path, tc.name, w.Body.String())
+			}
+			if tc.status == http.StatusOK && w.Header().Get("ETag") != "" {
+				t.Errorf("%s %s: protected paste should not be cached", path, tc.name)
+			}
+		}
+	}
+}
+
+// downStore is a store that can't be reached.
+type downStore struct {
+	*store.MemDB
+}
+
+func (s downStore) Get(id int64) (store.Paste, error) {
+	return store.Paste{}, fmt.Errorf("downStore.Get: %w", store.ErrConnection)
+}
+
+func (s downStore) GetCtx(ctx context.Context, id int64) (store.Paste, error) {
+	return s.Get(id)
+}
+
+func (s downStore) SyntaxCounts(publicOnly bool) (map[string]int64, error) {
+	return nil, fmt.Errorf("downStore.SyntaxCounts: %w", store.ErrConnection)
+}
+
+// Unreachable store is reported as 503 Service Unavailable
+func TestAPIStoreUnavailable(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+	srv.service = service.New(downStore{store.NewMemDB()})
+
+	for _, path := range []string{"/api/v1/paste/abc/meta", "/p/abc", "/api/v1/stats"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status should be %d, got %d", path, http.StatusServiceUnavailable, w.Code)
+		}
+	}
+}
+
+// Instance stats count all the pastes and break down the public ones per
+// syntax unless the private ones are included
+func TestAPIGetStats(t *testing.T) {
+	t.Parallel()
+
+	for _, allSyntaxes := range []bool{false, true} {
+		opts := testServerOptions()
+		opts.StatsAllSyntaxes = allSyntaxes
+		srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+		for _, req := range []service.PasteRequest{
+			{Body: "package main", Syntax: "go", Privacy: "public", Expires: "never"},
+			{Body: "print(1)", Syntax: "python", Privacy: "unlisted", Expires: "never"},
+		} {
+			if _, err := srv.service.NewPaste(req); err != nil {
+				t.Fatalf("failed to create paste: %v", err)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/v1/stats", nil)
+		srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status should be %d, got %d", http.StatusOK, w.Code)
+		}
+		var stats service.InstanceStats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatalf("failed to decode stats: %v", err)
+		}
+		if stats.Pastes != 2 || stats.Last24h != 2 || stats.Last7d != 2 {
+			t.Errorf("expected 2 pastes in total, last day and last week, got %+v", stats)
+		}
+		want := map[string]int64{"go": 1}
+		if allSyntaxes {
+			want = map[string]int64{"go": 1, "python": 1}
+		}
+		if !reflect.DeepEqual(stats.Syntaxes, want) {
+			t.Errorf("all syntaxes %t: expected breakdown %v, got %v", allSyntaxes, want, stats.Syntaxes)
+		}
+	}
+}
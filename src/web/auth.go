@@ -0,0 +1,102 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"crypto/sha1" //nolint:gosec // used for user id hashing only, same as go-pkgz/auth
+	"strings"
+
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"golang.org/x/oauth2"
+)
+
+// giteaProvider returns oauth2 options for a Gitea instance at url.
+// The callback URL to register with Gitea is {AuthURL}/auth/gitea/callback.
+func giteaProvider(url string) provider.CustomHandlerOpt {
+	url = strings.TrimSuffix(url, "/")
+	return provider.CustomHandlerOpt{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  url + "/login/oauth/authorize",
+			TokenURL: url + "/login/oauth/access_token",
+		},
+		InfoURL: url + "/api/v1/user",
+		Scopes:  []string{"read:user"},
+		MapUserFn: func(data provider.UserData, _ []byte) token.User {
+			usr := token.User{
+				ID:      "gitea_" + token.HashID(sha1.New(), data.Value("login")),
+				Name:    data.Value("full_name"),
+				Picture: data.Value("avatar_url"),
+			}
+			if usr.Name == "" {
+				usr.Name = data.Value("login")
+			}
+			return usr
+		},
+	}
+}
+
+// gitlabProvider returns oauth2 options for a GitLab instance at url.
+// The callback URL to register with GitLab is {AuthURL}/auth/gitlab/callback.
+func gitlabProvider(url string) provider.CustomHandlerOpt {
+	url = strings.TrimSuffix(url, "/")
+	return provider.CustomHandlerOpt{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  url + "/oauth/authorize",
+			TokenURL: url + "/oauth/token",
+		},
+		InfoURL: url + "/api/v4/user",
+		Scopes:  []string{"read_user"},
+		MapUserFn: func(data provider.UserData, _ []byte) token.User {
+			usr := token.User{
+				ID:      "gitlab_" + token.HashID(sha1.New(), data.Value("username")),
+				Name:    data.Value("name"),
+				Picture: data.Value("avatar_url"),
+			}
+			if usr.Name == "" {
+				usr.Name = data.Value("username")
+			}
+			return usr
+		},
+	}
+}
+
+// addAuthProviders registers all the oauth providers that have a client id
+// configured and remembers their names so that only those are shown on the
+// login menu.
+func (h *Server) addAuthProviders(authSvc *auth.Service) {
+	builtin := []struct{ name, cid, csec string }{
+		{"github", h.options.GitHubCID, h.options.GitHubCSEC},
+		{"google", h.options.GoogleCID, h.options.GoogleCSEC},
+		{"twitter", h.options.TwitterCID, h.options.TwitterCSEC},
+	}
+	for _, p := range builtin {
+		if p.cid == "" {
+			continue
+		}
+		authSvc.AddProvider(p.name, p.cid, p.csec)
+		h.providers = append(h.providers, p.name)
+	}
+
+	if h.options.GiteaCID != "" && h.options.GiteaURL != "" {
+		authSvc.AddCustomProvider("gitea",
+			auth.Client{Cid: h.options.GiteaCID, Csecret: h.options.GiteaCSEC},
+			giteaProvider(h.options.GiteaURL),
+		)
+		h.providers = append(h.providers, "gitea")
+	}
+	if h.options.GitLabCID != "" {
+		url := h.options.GitLabURL
+		if url == "" {
+			url = "https://gitlab.com"
+		}
+		authSvc.AddCustomProvider("gitlab",
+			auth.Client{Cid: h.options.GitLabCID, Csecret: h.options.GitLabCSEC},
+			gitlabProvider(url),
+		)
+		h.providers = append(h.providers, "gitlab")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // used for avatar id hashing only, same as go-pkgz/auth
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+)
+
+const avatarSuffix = ".image"
+
+// s3AvatarStore is an avatar.Store that keeps avatars in an S3 bucket.
+// Avatar IDs are derived from the user ID and content fingerprints come
+// from the object ETag, so several instances sharing the same bucket serve
+// the same avatars.
+type s3AvatarStore struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or a MinIO URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // key prefix inside the bucket
+
+	client *http.Client
+	now    func() time.Time
+}
+
+func newS3AvatarStore(endpoint, region, bucket, accessKey, secretKey string) *s3AvatarStore {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return &s3AvatarStore{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Prefix:    "avatars/",
+		client:    &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}
+}
+
+// Put saves avatar data for the user and returns avatar ID (id.image).
+func (s *s3AvatarStore) Put(userID string, reader io.Reader) (string, error) {
+	if reader == nil {
+		return "", fmt.Errorf("s3AvatarStore.Put: empty reader")
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("s3AvatarStore.Put: %w", err)
+	}
+	avatarID := token.HashID(sha1.New(), strings.TrimSuffix(userID, avatarSuffix)) + avatarSuffix
+	resp, err := s.do(http.MethodPut, s.Prefix+avatarID, nil, data)
+	if err != nil {
+		return "", fmt.Errorf("s3AvatarStore.Put: %w", err)
+	}
+	resp.Body.Close()
+	return avatarID, nil
+}
+
+// Get returns avatar data reader and its size.
+func (s *s3AvatarStore) Get(avatarID string) (io.ReadCloser, int, error) {
+	resp, err := s.do(http.MethodGet, s.Prefix+avatarID, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("s3AvatarStore.Get: %w", err)
+	}
+	return resp.Body, int(resp.ContentLength), nil
+}
+
+// ID returns a fingerprint of the avatar content.
+func (s *s3AvatarStore) ID(avatarID string) string {
+	resp, err := s.do(http.MethodHead, s.Prefix+avatarID, nil, nil)
+	if err != nil {
+		return token.HashID(sha1.New(), avatarID)
+	}
+	resp.Body.Close()
+	return token.HashID(sha1.New(), avatarID+strings.Trim(resp.Header.Get("ETag"), `"`))
+}
+
+// Remove deletes avatar data.
+func (s *s3AvatarStore) Remove(avatarID string) error {
+	resp, err := s.do(http.MethodDelete, s.Prefix+avatarID, nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3AvatarStore.Remove: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns all avatar IDs in the bucket.
+func (s *s3AvatarStore) List() ([]string, error) {
+	var ids []string
+	next := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", s.Prefix)
+		if next != "" {
+			q.Set("continuation-token", next)
+		}
+		resp, err := s.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, fmt.Errorf("s3AvatarStore.List: %w", err)
+		}
+		var res struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3AvatarStore.List: %w", err)
+		}
+		for _, c := range res.Contents {
+			if strings.HasSuffix(c.Key, avatarSuffix) {
+				ids = append(ids, strings.TrimPrefix(c.Key, s.Prefix))
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return ids, nil
+		}
+		next = res.NextContinuationToken
+	}
+}
+
+// Close does nothing but satisfies the interface.
+func (s *s3AvatarStore) Close() error {
+	return nil
+}
+
+func (s *s3AvatarStore) String() string {
+	return fmt.Sprintf("s3, endpoint=%s, bucket=%s", s.Endpoint, s.Bucket)
+}
+
+// do sends a signed request for the object key (or the bucket itself if key
+// is empty) and returns the response if its status is 2xx.
+func (s *s3AvatarStore) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint + "/" + s.Bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to the request.
+func (s *s3AvatarStore) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// canonical query string must be sorted by key
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		params = append(params, awsEscape(k)+"="+awsEscape(query.Get(k)))
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data)) //nolint:errcheck // hash.Write never returns an error
+	return h.Sum(nil)
+}
+
+// awsEscape escapes a query component the way AWS expects it.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
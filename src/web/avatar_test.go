@@ -0,0 +1,107 @@
+package web
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal in-memory S3 server that supports the requests used
+// by s3AvatarStore.
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		type content struct {
+			Key string `xml:"Key"`
+		}
+		var res struct {
+			XMLName  xml.Name  `xml:"ListBucketResult"`
+			Contents []content `xml:"Contents"`
+		}
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				res.Contents = append(res.Contents, content{Key: k})
+			}
+		}
+		xml.NewEncoder(w).Encode(res) //nolint:errcheck
+	case r.Method == http.MethodPut:
+		f.objects[key], _ = io.ReadAll(r.Body)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+sha256Hex(data)+`"`)
+		w.Write(data) //nolint:errcheck
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3AvatarStore(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	defer ts.Close()
+
+	s1 := newS3AvatarStore(ts.URL, "", "bucket", "key", "secret")
+	s2 := newS3AvatarStore(ts.URL, "", "bucket", "key", "secret")
+
+	id, err := s1.Put("user1", bytes.NewBufferString("avatar data"))
+	if err != nil {
+		t.Fatalf("failed to put avatar: %v", err)
+	}
+	if !strings.HasSuffix(id, ".image") {
+		t.Errorf("expected avatar id to end with .image, got %s", id)
+	}
+
+	// another instance sharing the bucket must see the same avatar
+	r, size, err := s2.Get(id)
+	if err != nil {
+		t.Fatalf("failed to get avatar: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "avatar data" || size != len(data) {
+		t.Errorf("expected to get [avatar data], got [%s] of size %d", data, size)
+	}
+	if s1.ID(id) != s2.ID(id) {
+		t.Errorf("expected avatar fingerprints to be the same for both instances")
+	}
+
+	ids, err := s2.List()
+	if err != nil {
+		t.Fatalf("failed to list avatars: %v", err)
+	}
+	sort.Strings(ids)
+	if len(ids) != 1 || ids[0] != id {
+		t.Errorf("expected to list [%s], got %v", id, ids)
+	}
+
+	if err = s1.Remove(id); err != nil {
+		t.Fatalf("failed to remove avatar: %v", err)
+	}
+	if _, _, err = s2.Get(id); err == nil {
+		t.Errorf("expected an error getting removed avatar")
+	}
+}
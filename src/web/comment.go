@@ -0,0 +1,115 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// maxComment is the maximum length of a comment.
+const maxComment = 4096
+
+// commentsOn reports whether the paste page shows comments. Burners are gone
+// once read and have none, password-protected pastes have none either.
+func (h *Server) commentsOn(p store.Paste) bool {
+	return h.options.EnableComments && !p.DeleteAfterRead && p.Password == ""
+}
+
+// handlePostComment adds the current user's comment to the paste and
+// redirects back to the paste. The route is only registered when comments
+// are enabled.
+func (h *Server) handlePostComment(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to comment.")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err = r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	body := r.PostFormValue("body")
+	if len(body) > maxComment {
+		h.showError(w, http.StatusBadRequest, fmt.Sprintf("Sorry, comments can't be longer than %d characters.", maxComment))
+		return
+	}
+	// the comment author must be in the store
+	_, err = h.service.GetOrUpdateUser(store.User{
+		ID:    usr.ID,
+		Name:  usr.Name,
+		Email: usr.Email,
+		IP:    usr.IP,
+		Admin: usr.IsAdmin(),
+	})
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+
+	_, err = h.service.AddComment(id, usr.ID, body)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste")
+		return
+	case errors.Is(err, service.ErrPasteIsPrivate):
+		h.showError(w, http.StatusForbidden, "This paste is private")
+		return
+	case errors.Is(err, service.ErrPasteHasPassword):
+		h.showError(w, http.StatusForbidden, "Password-protected pastes can't be commented")
+		return
+	case errors.Is(err, service.ErrEmptyBody):
+		h.showError(w, http.StatusBadRequest, "Comment must not be empty.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/p/"+id+"#comments", http.StatusSeeOther)
+}
+
+// handlePostDeleteComment deletes a comment of the paste and redirects back
+// to the paste. Users can delete their own comments, paste owners and admins
+// can delete any comment of the paste.
+func (h *Server) handlePostDeleteComment(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to delete comments.")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	cid, err := strconv.ParseInt(mux.Vars(r)["cid"], 10, 64)
+	if err != nil {
+		h.showError(w, http.StatusNotFound, "There is no such comment")
+		return
+	}
+
+	err = h.service.DeleteComment(id, cid, usr.ID, usr.IsAdmin())
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound), errors.Is(err, service.ErrCommentNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such comment")
+		return
+	case errors.Is(err, service.ErrPasteIsPrivate), errors.Is(err, service.ErrPasteHasPassword),
+		errors.Is(err, service.ErrNotCommentAuthor):
+		h.showError(w, http.StatusForbidden, "You can't delete this comment")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/p/"+id+"#comments", http.StatusSeeOther)
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Logged in users can comment on the pastes they can see, comments on
+// private pastes are only shown to the owner
+func TestPostComment(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.EnableComments = true
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	defer srv.Close()
+
+	owner := token.User{ID: "test_user_comment_owner", Name: "Owner"}
+	other := token.User{ID: "test_user_comment_other", Name: "Other"}
+	third := token.User{ID: "test_user_comment_third", Name: "Third"}
+	if _, err := srv.service.GetOrUpdateUser(store.User{ID: owner.ID, Name: owner.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	public, err := srv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	private, err := srv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "private", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("POST", "/p/"+public.URL()+"/comment", "body=Anonymous", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for anonymous users, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve("POST", "/p/"+public.URL()+"/comment", "body=+", &other); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an empty comment, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve("POST", "/p/"+public.URL()+"/comment", "body=Nice+paste", &other); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected status %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	w := serve("GET", "/p/"+public.URL(), "", nil)
+	if !strings.Contains(w.Body.String(), "Nice paste") || !strings.Contains(w.Body.String(), "Login to comment.") {
+		t.Errorf("expected anonymous readers to see the comment and a login prompt")
+	}
+
+	if w := serve("POST", "/p/"+private.URL()+"/comment", "body=Secret", &other); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for another user's private paste, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve("POST", "/p/"+private.URL()+"/comment", "body=Note+to+self", &owner); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected status %d for the owner, got %d", http.StatusSeeOther, w.Code)
+	}
+	if w := serve("GET", "/p/"+private.URL(), "", &owner); !strings.Contains(w.Body.String(), "Note to self") {
+		t.Errorf("expected the owner to see the comment on the private paste")
+	}
+
+	// password-protected pastes have no comments
+	protected, err := srv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public", Password: "secret", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	if w := serve("POST", "/p/"+protected.URL()+"/comment", "body=Guess", &other); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a protected paste, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve("POST", "/p/"+protected.URL(), "password=secret", &other); strings.Contains(w.Body.String(), `id="comments"`) {
+		t.Errorf("expected no comments section on a protected paste")
+	}
+
+	// only the author, the paste owner and admins can delete a comment
+	comments, err := srv.service.PasteComments(public.URL(), "")
+	if err != nil || len(comments) != 1 {
+		t.Fatalf("expected one comment, got %+v, %v", comments, err)
+	}
+	del := "/p/" + public.URL() + "/comment/" + strconv.FormatInt(comments[0].ID, 10) + "/delete"
+	if w := serve("POST", del, "", &third); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a third user, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := serve("POST", del, "", &other); w.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d for the author, got %d", http.StatusSeeOther, w.Code)
+	}
+	if w := serve("GET", "/p/"+public.URL(), "", nil); strings.Contains(w.Body.String(), "Nice paste") {
+		t.Errorf("expected the comment to be deleted")
+	}
+}
+
+// Without EnableComments the routes are not there and the pastes have no
+// comments section
+func TestCommentsDisabled(t *testing.T) {
+	t.Parallel()
+
+	p, err := webSrv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public"})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	usr := token.User{ID: "test_user_comments_disabled", Name: "Test User"}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/p/"+p.URL()+"/comment", strings.NewReader("body=Hello"))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	webSrv.router.ServeHTTP(w, token.SetUserInfo(r, usr))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL(), nil)
+	webSrv.router.ServeHTTP(w, token.SetUserInfo(r, usr))
+	if strings.Contains(w.Body.String(), `id="comments"`) {
+		t.Errorf("expected no comments section")
+	}
+}
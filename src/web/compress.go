@@ -0,0 +1,205 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultCompressionMinSize is the smallest response that is compressed
+// when ServerOptions.CompressionMinSize is not set.
+const defaultCompressionMinSize = 1024
+
+// compressibleTypes are the content types worth compressing. Images, such
+// as the QR codes, are compressed already.
+var compressibleTypes = []string{"text/html", "text/plain", "application/json"}
+
+// acceptedEncoding returns the preferred encoding that the client accepts,
+// either "gzip" or "deflate", or an empty string if it accepts neither.
+func acceptedEncoding(r *http.Request) string {
+	var gz, deflate bool
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 {
+			q := strings.TrimSpace(fields[1])
+			if strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					continue
+				}
+			}
+		}
+		switch name {
+		case "gzip":
+			gz = true
+		case "deflate":
+			deflate = true
+		}
+	}
+	switch {
+	case gz:
+		return "gzip"
+	case deflate:
+		return "deflate"
+	}
+	return ""
+}
+
+// compress compresses responses of compressible types that are larger
+// than the configured threshold, if the client accepts it.
+func (h *Server) compress(next http.Handler) http.Handler {
+	minSize := h.options.CompressionMinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := acceptedEncoding(r)
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc, minSize: minSize}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the beginning of the response until it knows
+// whether the response is large enough to be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	zw       io.WriteCloser // compressor, nil until compression starts
+	passThru bool           // response is written as is
+}
+
+// WriteHeader remembers the status code, it is written together with the
+// first part of the body.
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+}
+
+// Write buffers the body until it reaches the threshold and then starts
+// compressing it.
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.zw != nil {
+		return c.zw.Write(b)
+	}
+	if c.passThru {
+		return c.ResponseWriter.Write(b)
+	}
+	if !c.compressible() {
+		c.passThru = true
+		if err := c.flushBuffer(); err != nil {
+			return 0, err
+		}
+		return c.ResponseWriter.Write(b)
+	}
+	c.buf = append(c.buf, b...)
+	if len(c.buf) < c.minSize {
+		return len(b), nil
+	}
+	if err := c.startCompression(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// compressible reports whether the response can be compressed, based on
+// its status and headers.
+func (c *compressWriter) compressible() bool {
+	hdr := c.Header()
+	if c.status < http.StatusOK || c.status == http.StatusNoContent || c.status == http.StatusNotModified {
+		return false
+	}
+	// a range is a part of the uncompressed body
+	if c.status == http.StatusPartialContent {
+		return false
+	}
+	if hdr.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := hdr.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(c.buf)
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// startCompression writes the headers and the buffered body through the
+// compressor.
+func (c *compressWriter) startCompression() error {
+	hdr := c.Header()
+	if hdr.Get("Content-Type") == "" {
+		hdr.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	hdr.Del("Content-Length")
+	hdr.Del("Accept-Ranges") // the ranges would be of the compressed body
+	// The compressed bytes differ from the original ones, so a strong
+	// validator would no longer be true. Weak tags still revalidate.
+	if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		hdr.Set("ETag", "W/"+etag)
+	}
+	hdr.Set("Content-Encoding", c.encoding)
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.encoding == "gzip" {
+		c.zw = gzip.NewWriter(c.ResponseWriter)
+	} else {
+		c.zw = zlib.NewWriter(c.ResponseWriter)
+	}
+	_, err := c.zw.Write(c.buf)
+	c.buf = nil
+	return err
+}
+
+// flushBuffer writes the headers and the buffered body as is.
+func (c *compressWriter) flushBuffer() error {
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.ResponseWriter.Write(c.buf)
+	c.buf = nil
+	return err
+}
+
+// Close finishes the response. Small responses are written uncompressed
+// with the correct Content-Length.
+func (c *compressWriter) Close() error {
+	switch {
+	case c.zw != nil:
+		return c.zw.Close()
+	case c.passThru:
+		return nil
+	case c.status == 0 && len(c.buf) == 0:
+		return nil // nothing was written, net/http will send 200 itself
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.status != http.StatusNotModified && c.status != http.StatusNoContent {
+		c.Header().Set("Content-Length", strconv.Itoa(len(c.buf)))
+	}
+	return c.flushBuffer()
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Large text responses are compressed, small ones and images are not
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("Test paste ", 200)
+	srv := &Server{options: ServerOptions{CompressionMinSize: 1024}}
+	hdlr := srv.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("ETag", `"abc"`)
+			_, _ = io.WriteString(w, large)
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = io.WriteString(w, "Test paste")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, large)
+		case "/notmodified":
+			w.WriteHeader(http.StatusNotModified)
+		case "/range":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(large)-1, len(large)+10))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, large)
+		}
+	}))
+
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+	}{
+		{"/large", "gzip, deflate, br", "gzip"},
+		{"/large", "deflate", "deflate"},
+		{"/large", "gzip;q=0, deflate", "deflate"},
+		{"/large", "", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+		{"/notmodified", "gzip", ""},
+		{"/range", "gzip", ""},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept-Encoding", tc.accept)
+		}
+		hdlr.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != tc.encoding {
+			t.Errorf("%s [%s]: Content-Encoding should be %q, got %q", tc.path, tc.accept, tc.encoding, got)
+			continue
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s [%s]: Vary should be Accept-Encoding, got %q", tc.path, tc.accept, got)
+		}
+		var body io.Reader = w.Body
+		switch tc.encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(w.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(w.Body)
+		default:
+			if cl := w.Header().Get("Content-Length"); tc.path == "/small" && cl != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("%s [%s]: Content-Length should be %d, got %s", tc.path, tc.accept, w.Body.Len(), cl)
+			}
+		}
+		if etag := w.Header().Get("ETag"); tc.path == "/large" && tc.encoding != "" && etag != `W/"abc"` {
+			t.Errorf("%s [%s]: compressed response should have a weak ETag, got %q", tc.path, tc.accept, etag)
+		}
+		if etag := w.Header().Get("ETag"); tc.path == "/large" && tc.encoding == "" && etag != `"abc"` {
+			t.Errorf("%s [%s]: uncompressed response should keep the strong ETag, got %q", tc.path, tc.accept, etag)
+		}
+		if tc.encoding != "" && w.Header().Get("Content-Length") != "" {
+			t.Errorf("%s [%s]: compressed response should not have Content-Length", tc.path, tc.accept)
+		}
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("%s [%s]: failed to read body: %v", tc.path, tc.accept, err)
+			continue
+		}
+		if (tc.path == "/large" || tc.path == "/range") && string(got) != large {
+			t.Errorf("%s [%s]: body doesn't match", tc.path, tc.accept)
+		}
+	}
+}
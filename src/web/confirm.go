@@ -0,0 +1,47 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/iliafrenkel/go-pb/src/store"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+)
+
+// pasteWarnings returns the reasons to ask the user to confirm a new paste
+// before it is created: a large body or something that looks like a
+// credential.
+func (h *Server) pasteWarnings(body string) []string {
+	var warnings []string
+	if h.options.ConfirmSize > 0 && int64(len(body)) > h.options.ConfirmSize {
+		size := store.Paste{Size: int64(len(body))}.HumanSize()
+		warnings = append(warnings, fmt.Sprintf("The paste is large (%s).", size))
+	}
+	for _, name := range h.service.ScanForSecrets(body) {
+		warnings = append(warnings, fmt.Sprintf("The paste looks like it contains a secret: %s.", name))
+	}
+	return warnings
+}
+
+// showConfirm shows the warnings about the new paste with a form that posts
+// the original fields again, confirmed.
+func (h *Server) showConfirm(w http.ResponseWriter, r *http.Request, usr token.User, warnings []string) {
+	pastes, err := h.getUserPastes(usr.ID)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	h.showPage(w,
+		page.Template("confirm.html"),
+		page.Title(h.options.BrandName+" - Confirm"),
+		page.UserPastes(pastes),
+		page.User(usr),
+		page.Warnings(warnings),
+		page.FormValues(r.PostForm),
+	)
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+)
+
+// embeddable reports whether the paste can be shown in an iframe on other
+// sites. The author has to allow it, and private, password protected and
+// burner pastes are never embedded.
+func embeddable(p store.Paste) bool {
+	return p.AllowEmbed && p.Privacy != "private" && p.Password == "" && !p.DeleteAfterRead
+}
+
+// allowFraming lets any site put the response in a frame, undoing what
+// securityHeaders sets for all the other pages.
+func allowFraming(w http.ResponseWriter) {
+	w.Header().Del("X-Frame-Options")
+	directives := []string{}
+	for _, d := range strings.Split(w.Header().Get("Content-Security-Policy"), ";") {
+		d = strings.TrimSpace(d)
+		if d != "" && !strings.HasPrefix(d, "frame-ancestors") {
+			directives = append(directives, d)
+		}
+	}
+	directives = append(directives, "frame-ancestors *")
+	w.Header().Set("Content-Security-Policy", strings.Join(directives, "; "))
+}
+
+// handleGetPasteEmbed shows just the highlighted paste body, without the
+// rest of the site, to be put in an iframe on other sites. It is anonymous,
+// so only the pastes anyone can read are shown, and doesn't count a view.
+func (h *Server) handleGetPasteEmbed(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// check before reading the body, which deletes burner pastes
+	meta, err := h.service.GetPasteMeta(id, "")
+	if err == nil && !embeddable(meta) {
+		err = service.ErrPasteIsPrivate
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrPasteIsPrivate) {
+			h.showError(w, http.StatusForbidden, "This paste can't be embedded")
+			return
+		}
+		h.showPasteBodyError(w, err)
+		return
+	}
+	paste, err := h.service.GetPasteNoCountCtx(r.Context(), id, "", "")
+	if err != nil {
+		h.showPasteBodyError(w, err)
+		return
+	}
+
+	allowFraming(w)
+	h.showPage(w,
+		page.Template("embed.html"),
+		page.Title(h.options.BrandName+" - Paste"),
+		page.Paste(paste),
+		page.Code(h.renderPaste(paste)),
+		page.Files(h.renderFiles(paste)),
+		page.HighlightTheme(h.highlightTheme(w, r, paste)),
+		page.Server(h.serverURL(r)),
+	)
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Only the pastes the author allowed to embed, and that anyone can read, are
+// shown on the embed page, and only the embed page can be framed
+func TestGetPasteEmbed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		pr   service.PasteRequest
+		want int
+	}{
+		{"allowed", service.PasteRequest{Privacy: "public", AllowEmbed: true}, http.StatusOK},
+		{"unlisted", service.PasteRequest{Privacy: "unlisted", AllowEmbed: true}, http.StatusOK},
+		{"not allowed", service.PasteRequest{Privacy: "public"}, http.StatusForbidden},
+		{"private", service.PasteRequest{Privacy: "private", AllowEmbed: true, UserID: "test_user_embed"}, http.StatusForbidden},
+		{"password", service.PasteRequest{Privacy: "public", AllowEmbed: true, Password: "secret"}, http.StatusForbidden},
+		{"burner", service.PasteRequest{Privacy: "public", AllowEmbed: true, DeleteAfterRead: true}, http.StatusForbidden},
+	}
+	if _, err := webSrv.service.GetOrUpdateUser(store.User{ID: "test_user_embed", Name: "Test User Embed"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	for _, tc := range tests {
+		tc.pr.Title = "Embedded " + tc.name
+		tc.pr.Body = "Test embed " + tc.name
+		tc.pr.Syntax = "text"
+		p, err := webSrv.service.NewPaste(tc.pr)
+		if err != nil {
+			t.Fatalf("%s: failed to create paste: %v", tc.name, err)
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/embed", nil)
+		webSrv.router.ServeHTTP(w, r)
+
+		if w.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, w.Code)
+		}
+		body := w.Body.String()
+		xfo, csp := w.Header().Get("X-Frame-Options"), w.Header().Get("Content-Security-Policy")
+		if tc.want == http.StatusOK {
+			if !strings.Contains(body, "Test embed "+tc.name) || strings.Contains(body, "<nav") {
+				t.Errorf("%s: expected just the paste body, got %s", tc.name, body)
+			}
+			if xfo != "" || !strings.Contains(csp, "frame-ancestors *") || strings.Contains(csp, "frame-ancestors 'none'") {
+				t.Errorf("%s: expected the page to be framed anywhere, got %q and %q", tc.name, xfo, csp)
+			}
+			continue
+		}
+		if strings.Contains(body, "Test embed "+tc.name) {
+			t.Errorf("%s: the paste body should not be shown", tc.name)
+		}
+		if xfo != "DENY" || !strings.Contains(csp, "frame-ancestors 'none'") {
+			t.Errorf("%s: expected framing to be denied, got %q and %q", tc.name, xfo, csp)
+		}
+	}
+
+	// the burner paste is still there, the embed page didn't read it
+	pastes, err := webSrv.service.GetPastes("", "-created", 1000, 0, "public")
+	if err != nil {
+		t.Fatalf("failed to get pastes: %v", err)
+	}
+	found := false
+	for _, p := range pastes {
+		found = found || p.Title == "Embedded burner"
+	}
+	if !found {
+		t.Errorf("the burner paste should not be deleted")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// handlePostExtend sets a new expiration of the current user's paste from
+// the form and redirects back to the paste.
+func (h *Server) handlePostExtend(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to extend pastes.")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err = r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	url := mux.Vars(r)["id"]
+
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		h.showError(w, http.StatusNotFound, "There is no such paste among your pastes")
+		return
+	}
+	err = h.service.ExtendExpiry(id, usr.ID, r.PostFormValue("expires"))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste among your pastes")
+		return
+	case errors.Is(err, service.ErrPasteExpired):
+		h.showError(w, http.StatusBadRequest, "This paste has already expired, please create it again.")
+		return
+	case errors.Is(err, service.ErrWrongDuration):
+		h.showError(w, http.StatusBadRequest, "Expiration is incorrect or out of the allowed range.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/p/"+url, http.StatusSeeOther)
+}
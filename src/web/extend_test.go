@@ -0,0 +1,75 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// The owner can extend a paste, nobody else can
+func TestPostExtend(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+	defer srv.Close()
+
+	owner := token.User{ID: "test_user_extend_owner", Name: "Owner"}
+	other := token.User{ID: "test_user_extend_other", Name: "Other"}
+	if _, err := srv.service.GetOrUpdateUser(store.User{ID: owner.ID, Name: owner.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	p, err := srv.service.NewPaste(service.PasteRequest{Body: "Test paste", Expires: "10m", Privacy: "public", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("GET", "/p/"+p.URL(), "", &owner); !strings.Contains(w.Body.String(), `action="/p/`+p.URL()+`/extend"`) {
+		t.Errorf("expected the owner to see the extend form")
+	}
+	if w := serve("GET", "/p/"+p.URL(), "", &other); strings.Contains(w.Body.String(), `action="/p/`+p.URL()+`/extend"`) {
+		t.Errorf("expected other users not to see the extend form")
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/extend", "expires=1d", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for anonymous users, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/extend", "expires=1d", &other); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for someone else's paste, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/extend", "expires=1x", &owner); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid expiration, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/extend", "expires=1d", &owner); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected status %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	got, err := srv.service.GetPasteMeta(p.URL(), owner.ID)
+	if err != nil {
+		t.Fatalf("failed to get paste: %v", err)
+	}
+	day := time.Now().AddDate(0, 0, 1)
+	if got.Expires.Before(day.Add(-time.Minute)) || got.Expires.After(day.Add(time.Minute)) {
+		t.Errorf("expected the paste to expire in a day, got %v", got.Expires)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+const (
+	// idempotencyTTL is how long a created paste is returned for a repeated
+	// Idempotency-Key.
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKey is the maximum length of an Idempotency-Key.
+	maxIdempotencyKey = 255
+	// idempotencyPruneAt is the number of remembered keys after which the
+	// expired ones are forgotten.
+	idempotencyPruneAt = 1000
+	// maxIdempotencyKeys is the maximum number of remembered keys, the
+	// oldest ones are forgotten first.
+	maxIdempotencyKeys = 100000
+)
+
+// idempotencyKeys remembers the pastes created by the requests with an
+// Idempotency-Key, so that a client retrying a request gets the original
+// paste instead of creating another one.
+type idempotencyKeys struct {
+	sync.Mutex
+	ttl   time.Duration
+	limit int
+	now   func() time.Time
+	keys  map[string]*idempotentPaste
+}
+
+// idempotentPaste is the paste created for a key, it is nil while the first
+// request with the key is being served.
+type idempotentPaste struct {
+	id      int64
+	created time.Time
+}
+
+func newIdempotencyKeys(ttl time.Duration, limit int) *idempotencyKeys {
+	return &idempotencyKeys{
+		ttl:   ttl,
+		limit: limit,
+		now:   time.Now,
+		keys:  map[string]*idempotentPaste{},
+	}
+}
+
+// claim returns the paste remembered for the key. If there is none, the
+// key is reserved for the caller, who must then either remember the paste
+// with done or give the key up with release. busy is true if another
+// request with the key is still being served.
+func (k *idempotencyKeys) claim(key string) (p *idempotentPaste, busy bool) {
+	k.Lock()
+	defer k.Unlock()
+
+	now := k.now()
+	if len(k.keys) >= idempotencyPruneAt {
+		for old, v := range k.keys {
+			if v != nil && now.Sub(v.created) >= k.ttl {
+				delete(k.keys, old)
+			}
+		}
+	}
+	p, ok := k.keys[key]
+	switch {
+	case !ok, p != nil && now.Sub(p.created) >= k.ttl:
+		if !ok && len(k.keys) >= k.limit {
+			k.forgetOldest()
+		}
+		k.keys[key] = nil
+		return nil, false
+	case p == nil:
+		return nil, true
+	}
+	return p, false
+}
+
+// forgetOldest forgets the oldest remembered paste, the keys that are being
+// served are kept. The caller must hold the lock.
+func (k *idempotencyKeys) forgetOldest() {
+	var oldest string
+	var created time.Time
+	for key, p := range k.keys {
+		if p != nil && (oldest == "" || p.created.Before(created)) {
+			oldest, created = key, p.created
+		}
+	}
+	if oldest != "" {
+		delete(k.keys, oldest)
+	}
+}
+
+// done remembers the paste created for a claimed key.
+func (k *idempotencyKeys) done(key string, id int64) {
+	k.Lock()
+	defer k.Unlock()
+	k.keys[key] = &idempotentPaste{id: id, created: k.now()}
+}
+
+// release forgets a claimed key that has no paste, so that the request can
+// be retried.
+func (k *idempotencyKeys) release(key string) {
+	k.Lock()
+	defer k.Unlock()
+	if p, ok := k.keys[key]; ok && p == nil {
+		delete(k.keys, key)
+	}
+}
+
+// idempotentDone remembers the paste created by the request for its
+// Idempotency-Key, if it has one.
+func (h *Server) idempotentDone(r *http.Request, p store.Paste) {
+	if key, ok := r.Context().Value(idempotencyKey).(string); ok {
+		h.idempotency.done(key, p.ID)
+	}
+}
+
+// idempotent wraps a handler that creates pastes. When a request has an
+// Idempotency-Key header, a repeated request with the same key from the
+// same user, or the same IP address for anonymous users, gets the paste
+// created by the first one and no new paste is created. The handler must
+// call idempotentDone once the paste is created, failed requests can be
+// retried with the same key.
+func (h *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			h.writeJSONError(w, http.StatusBadRequest, "idempotency key is too long")
+			return
+		}
+		usr, _ := token.GetUserInfo(r)
+		if usr.ID != "" {
+			key = "user:" + usr.ID + ":" + key
+		} else {
+			key = "ip:" + clientIP(r) + ":" + key
+		}
+
+		p, busy := h.idempotency.claim(key)
+		if busy {
+			h.writeJSONError(w, http.StatusConflict, "a request with this idempotency key is in progress")
+			return
+		}
+		if p != nil {
+			h.replayCreatedPaste(w, r, usr.ID, p.id)
+			return
+		}
+
+		defer h.idempotency.release(key)
+		next(w, r.WithContext(context.WithValue(r.Context(), idempotencyKey, key)))
+	}
+}
+
+// replayCreatedPaste writes the response to a retried request that has
+// created the paste with the given id. The paste is read again, so a paste
+// that is gone since is not found.
+func (h *Server) replayCreatedPaste(w http.ResponseWriter, r *http.Request, uid string, id int64) {
+	plain := false
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "text/plain" {
+		plain = true
+	}
+	paste, err := h.service.CreatedPasteCtx(r.Context(), store.Paste{ID: id}.URL(), uid)
+	if err != nil {
+		status, msg := http.StatusInternalServerError, "internal error"
+		if errors.Is(err, service.ErrPasteNotFound) || errors.Is(err, service.ErrPasteIsPrivate) {
+			status, msg = http.StatusNotFound, "paste not found"
+		} else {
+			h.log.Logf("ERROR replayCreatedPaste: %v", err)
+		}
+		if plain {
+			http.Error(w, msg, status)
+		} else {
+			h.writeJSONError(w, status, msg)
+		}
+		return
+	}
+	// only the hash of the token is stored, the token itself was in the
+	// first response
+	paste.ClaimToken = ""
+	w.Header().Set("Idempotent-Replayed", "true")
+	h.writeCreatedPaste(w, r, paste, plain)
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+)
+
+// Repeated creates with the same Idempotency-Key return the first paste,
+// other keys and other users create new ones
+func TestAPIPostPasteIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	usr := token.User{ID: "test_user_idempotency", Name: "Test User"}
+	other := token.User{ID: "test_user_idempotency_other", Name: "Other User"}
+	serve := func(key string, u token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"Idempotent paste","expires":"never","privacy":"public"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		webSrv.router.ServeHTTP(w, token.SetUserInfo(r, u))
+		return w
+	}
+
+	first := serve("retry-me", usr)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, first.Code, first.Body.String())
+	}
+	second := serve("retry-me", usr)
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("expected the same response, got %d %q and %d %q", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if got := second.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON response, got %q", got)
+	}
+	pastes, err := webSrv.service.UserPastes(usr.ID)
+	if err != nil {
+		t.Fatalf("failed to get user pastes: %v", err)
+	}
+	if len(pastes) != 1 {
+		t.Errorf("expected one paste, got %d", len(pastes))
+	}
+
+	if w := serve("another-key", usr); w.Code != http.StatusCreated || w.Body.String() == first.Body.String() {
+		t.Errorf("expected a new paste for another key, got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("retry-me", other); w.Code != http.StatusCreated || w.Body.String() == first.Body.String() {
+		t.Errorf("expected a new paste for another user, got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(strings.Repeat("k", maxIdempotencyKey+1), usr); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a long key, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// A paste that is gone since it was created is not replayed
+func TestAPIPostPasteIdempotencyKeyDeleted(t *testing.T) {
+	t.Parallel()
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader("Idempotent paste"))
+		r.Header.Set("Content-Type", "text/plain")
+		r.Header.Set("Idempotency-Key", "deleted")
+		r.RemoteAddr = "192.0.2.75:1234"
+		webSrv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	first := serve()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, first.Code, first.Body.String())
+	}
+	url := strings.TrimSpace(first.Body.String())
+	if err := webSrv.service.DeletePaste(url[strings.LastIndex(url, "/")+1:]); err != nil {
+		t.Fatalf("failed to delete paste: %v", err)
+	}
+	if w := serve(); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a deleted paste, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+}
+
+// The token to claim an anonymous paste is only in the first response, the
+// store has nothing but its hash
+func TestAPIPostPasteIdempotencyKeyClaimToken(t *testing.T) {
+	t.Parallel()
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"Idempotent paste","privacy":"public"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "claim-token")
+		r.RemoteAddr = "192.0.2.76:1234"
+		webSrv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"claim_token":`) {
+		t.Errorf("expected the new paste with its claim token, got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(); w.Code != http.StatusCreated || strings.Contains(w.Body.String(), `"claim_token":`) {
+		t.Errorf("expected the replayed paste without a claim token, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// Keys are forgotten after the TTL and the oldest ones when there are too
+// many, a request that is still being served makes the others wait
+func TestIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	k := newIdempotencyKeys(time.Hour, 2)
+	k.now = func() time.Time { return now }
+
+	if p, busy := k.claim("key"); p != nil || busy {
+		t.Fatalf("expected to claim a new key, got %+v, %v", p, busy)
+	}
+	if _, busy := k.claim("key"); !busy {
+		t.Errorf("expected a claimed key to be busy")
+	}
+	k.done("key", 42)
+	k.release("key")
+	if p, busy := k.claim("key"); p == nil || busy || p.id != 42 {
+		t.Errorf("expected the remembered paste, got %+v, %v", p, busy)
+	}
+
+	now = now.Add(time.Hour)
+	if p, busy := k.claim("key"); p != nil || busy {
+		t.Errorf("expected an expired key to be claimed again, got %+v, %v", p, busy)
+	}
+	k.release("key")
+	if p, busy := k.claim("key"); p != nil || busy {
+		t.Errorf("expected a released key to be claimed again, got %+v, %v", p, busy)
+	}
+
+	k.done("key", 1)
+	now = now.Add(time.Minute)
+	k.claim("second")
+	k.done("second", 2)
+	k.claim("third")
+	k.done("third", 3)
+	if len(k.keys) != 2 {
+		t.Errorf("expected 2 remembered keys, got %d", len(k.keys))
+	}
+	if p, _ := k.claim("second"); p == nil || p.id != 2 {
+		t.Errorf("expected the newer key to be remembered, got %+v", p)
+	}
+	if _, ok := k.keys["key"]; ok {
+		t.Errorf("expected the oldest key to be forgotten")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+)
+
+// Anonymous visitors see the landing page at / while logged in users and
+// /p/ still get the new paste form
+func TestGetHomePageLanding(t *testing.T) {
+	t.Parallel()
+
+	landing := filepath.Join(t.TempDir(), "landing.md")
+	if err := os.WriteFile(landing, []byte("# Team pastebin\n\nPlease **don't** paste secrets."), 0o600); err != nil {
+		t.Fatalf("failed to write landing page: %v", err)
+	}
+	opts := testServerOptions()
+	opts.LandingMarkdownFile = landing
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	defer srv.Close()
+
+	get := func(path string, u *token.User) string {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, path, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	body := get("/", nil)
+	if !strings.Contains(body, "<h1>Team pastebin</h1>") || !strings.Contains(body, "Please <strong>don&#39;t</strong> paste secrets.") {
+		t.Errorf("expected the landing page for anonymous visitors, got %s", body)
+	}
+	if strings.Contains(body, "New Paste</h5>") {
+		t.Errorf("expected no new paste form on the landing page")
+	}
+	if body := get("/p/", nil); !strings.Contains(body, "New Paste</h5>") {
+		t.Errorf("expected the new paste form at /p/")
+	}
+	if body := get("/", &token.User{ID: "test_user_landing", Name: "Test User"}); !strings.Contains(body, "New Paste</h5>") || strings.Contains(body, "Team pastebin") {
+		t.Errorf("expected the new paste form for logged in users")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// logoURL returns the URL of the logo image used in the templates. External
+// URLs are used as is, absolute paths are served by the server at /logo and
+// anything else is a file in the assets folder. A warning is logged if a
+// local logo file doesn't exist.
+func (h *Server) logoURL() string {
+	logo := h.options.Logo
+	if strings.HasPrefix(logo, "http://") || strings.HasPrefix(logo, "https://") {
+		return logo
+	}
+	if filepath.IsAbs(logo) {
+		if _, err := os.Stat(logo); err != nil {
+			h.log.Logf("WARN logo image is not available: %v", err)
+		}
+		return "/logo"
+	}
+	if _, err := fs.Stat(h.assets, logo); err != nil {
+		h.log.Logf("WARN logo image is not available: %v", err)
+	}
+	return "/assets/" + logo
+}
+
+// faviconHandler returns the handler that serves the favicon file. The
+// default is the favicon in the assets folder. A warning is logged if it
+// doesn't exist.
+func (h *Server) faviconHandler() http.HandlerFunc {
+	if path := h.options.Favicon; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			h.log.Logf("WARN favicon is not available: %v", err)
+		}
+		return serveFile(path)
+	}
+	const name = "favicon/favicon.ico"
+	if _, err := fs.Stat(h.assets, name); err != nil {
+		h.log.Logf("WARN favicon is not available: %v", err)
+	}
+	return serveFSFile(h.assets, name)
+}
+
+// serveFile returns a handler that serves a single file.
+func serveFile(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, path)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// Logo is resolved to an external URL, an asset or a file served at /logo
+func TestLogoURL(t *testing.T) {
+	t.Parallel()
+
+	logoFile := filepath.Join(t.TempDir(), "logo.svg")
+	if err := os.WriteFile(logoFile, []byte("<svg></svg>"), 0o600); err != nil {
+		t.Fatalf("Failed to write logo file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		logo string
+		want string
+	}{
+		{"external", "https://example.com/logo.png", "https://example.com/logo.png"},
+		{"asset", "bighead.svg", "/assets/bighead.svg"},
+		{"absolute path", logoFile, "/logo"},
+	}
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	for _, tc := range tests {
+		opts := testServerOptions()
+		opts.Logo = tc.logo
+		srv := New(log, opts)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		srv.router.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), `src="`+tc.want+`"`) {
+			t.Errorf("%s: home page should show the logo from %s", tc.name, tc.want)
+		}
+	}
+
+	opts := testServerOptions()
+	opts.Logo = logoFile
+	srv := New(log, opts)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/logo", nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Body.String(); got != "<svg></svg>" {
+		t.Errorf("Response should be the logo file, got [%s]", got)
+	}
+}
+
+// Favicon is served at /favicon.ico
+func TestFavicon(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/favicon.ico", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Body.Len() == 0 {
+		t.Errorf("Favicon should not be empty")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// latencyBuckets are upper bounds (in seconds) of the request latency
+// histogram buckets, same as the Prometheus client defaults.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative histogram of observed values.
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// metrics keeps application metrics and writes them in the Prometheus text
+// exposition format.
+type metrics struct {
+	pastesCreated atomic.Int64
+	pastesViewed  atomic.Int64
+	logins        atomic.Int64
+
+	sync.Mutex
+	latency map[string]*histogram // keyed by "method route"
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		latency: make(map[string]*histogram),
+	}
+}
+
+// observe records a single request duration for a route.
+func (m *metrics) observe(method, route string, d time.Duration) {
+	key := method + " " + route
+	sec := d.Seconds()
+
+	m.Lock()
+	defer m.Unlock()
+	h, ok := m.latency[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[key] = h
+	}
+	for i, b := range latencyBuckets {
+		if sec <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += sec
+}
+
+// middleware measures the latency of every routed request except for the
+// metrics endpoint itself.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		if route == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		m.observe(r.Method, route, time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// countLogins wraps auth handlers and counts successful oauth callbacks.
+func (m *metrics) countLogins(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/callback") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < http.StatusBadRequest {
+			m.logins.Add(1)
+		}
+	})
+}
+
+// write writes all the metrics to w. Pastes and users gauges are passed in
+// because they come from the store.
+func (m *metrics) write(w io.Writer, pastes, users int64) {
+	fmt.Fprintf(w, "# HELP gopb_pastes Number of pastes in the store.\n# TYPE gopb_pastes gauge\ngopb_pastes %d\n", pastes)
+	fmt.Fprintf(w, "# HELP gopb_users Number of users in the store.\n# TYPE gopb_users gauge\ngopb_users %d\n", users)
+	fmt.Fprintf(w, "# HELP gopb_pastes_created_total Number of pastes created.\n# TYPE gopb_pastes_created_total counter\ngopb_pastes_created_total %d\n", m.pastesCreated.Load())
+	fmt.Fprintf(w, "# HELP gopb_pastes_viewed_total Number of pastes viewed.\n# TYPE gopb_pastes_viewed_total counter\ngopb_pastes_viewed_total %d\n", m.pastesViewed.Load())
+	fmt.Fprintf(w, "# HELP gopb_logins_total Number of successful logins.\n# TYPE gopb_logins_total counter\ngopb_logins_total %d\n", m.logins.Load())
+
+	m.Lock()
+	defer m.Unlock()
+	keys := make([]string, 0, len(m.latency))
+	for k := range m.latency {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "# HELP gopb_http_request_duration_seconds HTTP handler latency.\n# TYPE gopb_http_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		h := m.latency[k]
+		parts := strings.SplitN(k, " ", 2)
+		labels := fmt.Sprintf(`method="%s",route="%s"`, labelValue(parts[0]), labelValue(parts[1]))
+		var cumulative uint64
+		for i, b := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "gopb_http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, b, cumulative)
+		}
+		fmt.Fprintf(w, "gopb_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "gopb_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "gopb_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// labelEscaper escapes the characters the exposition format doesn't allow
+// in label values as is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns v escaped for a label value. Unlike %q it leaves tabs
+// and the rest of the UTF-8 text alone, the format has no other escapes.
+func labelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// handleGetMetrics writes application metrics in the Prometheus text format.
+func (h *Server) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	pastes, users := h.service.GetTotals()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	h.metrics.write(w, pastes, users)
+}
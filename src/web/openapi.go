@@ -0,0 +1,386 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// openAPIDocument is the part of the OpenAPI 3 document the API description
+// uses.
+type openAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// openAPIPathItem maps lower case HTTP methods to the operations.
+type openAPIPathItem map[string]openAPIOperation
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIMedia struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of the JSON encoding of t. Struct fields are
+// named by their json tags, the ones tagged "-" are left out and the fields
+// of embedded structs are merged in.
+func schemaOf(t reflect.Type) *openAPISchema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+		s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if f.Anonymous && name == "" {
+				for n, p := range schemaOf(f.Type).Properties {
+					s.Properties[n] = p
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaOf(f.Type)
+		}
+		return s
+	default:
+		return &openAPISchema{} // any value
+	}
+}
+
+// schemaRef returns a reference to one of the component schemas.
+func schemaRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// jsonContent returns the content of a JSON request or response with the
+// given component schema.
+func jsonContent(schema string) map[string]openAPIMedia {
+	return map[string]openAPIMedia{"application/json": {Schema: schemaRef(schema)}}
+}
+
+// jsonError is a response with an error message.
+func jsonError(description string) openAPIResponse {
+	return openAPIResponse{Description: description, Content: jsonContent("Error")}
+}
+
+// openAPI returns the description of the API, the paste endpoints and the
+// ones to get a token for them.
+func (h *Server) openAPI() openAPIDocument {
+	pasteID := openAPIParameter{Name: "id", In: "path", Required: true, Description: "paste ID as used in the paste URL", Schema: schemaOf(reflect.TypeOf(""))}
+	password := openAPIParameter{Name: "password", In: "query", Description: "password of a protected paste, Basic Auth can be used instead", Schema: schemaOf(reflect.TypeOf(""))}
+	jwt := []map[string][]string{{"jwt": {}}}
+
+	paths := map[string]openAPIPathItem{
+		"/api/v1/paste": {
+			"post": {
+				Summary: "Create a paste, anonymous unless a token is given",
+				Parameters: []openAPIParameter{
+					{Name: "title", In: "query", Description: "title of a text/plain paste", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "syntax", In: "query", Description: "syntax of a text/plain paste, text by default", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "expires", In: "query", Description: "expiration of a text/plain paste, e.g. 1h or 1M", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "privacy", In: "query", Description: "privacy of a text/plain paste, public by default", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "theme", In: "query", Description: "highlight theme of a text/plain paste", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "Idempotency-Key", In: "header", Description: "repeated requests with the same key return the paste created by the first one", Schema: schemaOf(reflect.TypeOf(""))},
+				},
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]openAPIMedia{
+						"application/json": {Schema: schemaRef("PasteRequest")},
+						"text/plain":       {Schema: schemaOf(reflect.TypeOf(""))},
+					},
+				},
+				Responses: map[string]openAPIResponse{
+					"201": {Description: "The paste, the URL of the paste for a text/plain request", Content: jsonContent("Paste")},
+					"400": jsonError("Invalid request"),
+					"401": jsonError("Login required"),
+					"403": jsonError("Paste limit reached"),
+					"409": jsonError("A request with the same idempotency key is in progress"),
+					"415": jsonError("Unsupported content type"),
+				},
+				Security: jwt,
+			},
+		},
+		"/api/v1/paste/{id}": {
+			"get": {
+				Summary:    "Get a paste with its body",
+				Parameters: []openAPIParameter{pasteID, password},
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "The paste", Content: jsonContent("Paste")},
+					"401": jsonError("Wrong or missing password"),
+					"403": jsonError("The paste is private"),
+					"404": jsonError("The paste doesn't exist"),
+				},
+				Security: jwt,
+			},
+			"delete": {
+				Summary:    "Delete one of the user's pastes",
+				Parameters: []openAPIParameter{pasteID},
+				Responses: map[string]openAPIResponse{
+					"204": {Description: "The paste is deleted"},
+					"401": jsonError("A token is required"),
+					"404": jsonError("The user has no such paste"),
+				},
+				Security: jwt,
+			},
+		},
+		"/api/v1/paste/{id}/meta": {
+			"get": {
+				Summary:    "Get the paste metadata without the body",
+				Parameters: []openAPIParameter{pasteID},
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "The paste metadata", Content: jsonContent("PasteMeta")},
+					"403": jsonError("The paste is private"),
+					"404": jsonError("The paste doesn't exist"),
+				},
+				Security: jwt,
+			},
+		},
+		"/api/v1/stats": {
+			"get": {
+				Summary: "Get the instance-wide paste and user counts, they are cached for a while",
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "The instance stats", Content: jsonContent("Stats")},
+					"503": jsonError("The store is unavailable"),
+				},
+			},
+		},
+		"/auth/{provider}/login": {
+			"get": {
+				Summary: "Login with one of the providers, the token is set in the JWT cookie",
+				Parameters: []openAPIParameter{
+					{Name: "provider", In: "path", Required: true, Description: "one of the configured providers", Schema: schemaOf(reflect.TypeOf(""))},
+					{Name: "from", In: "query", Description: "where to redirect after the login", Schema: schemaOf(reflect.TypeOf(""))},
+				},
+				Responses: map[string]openAPIResponse{
+					"302": {Description: "Redirect to the provider"},
+				},
+			},
+		},
+		"/auth/user": {
+			"get": {
+				Summary: "Get the user the token belongs to",
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "The user", Content: jsonContent("User")},
+					"401": {Description: "The token is missing or invalid"},
+				},
+				Security: jwt,
+			},
+		},
+		"/auth/logout": {
+			"get": {
+				Summary: "Logout, the JWT cookie is removed",
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "Logged out"},
+				},
+			},
+		},
+	}
+	if h.options.EnableLocalAuth {
+		paths["/u/login"] = openAPIPathItem{
+			"post": {
+				Summary: "Login with a local account, the token is set in the JWT cookie",
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]openAPIMedia{
+						"application/x-www-form-urlencoded": {Schema: &openAPISchema{
+							Type: "object",
+							Properties: map[string]*openAPISchema{
+								"email":    schemaOf(reflect.TypeOf("")),
+								"password": schemaOf(reflect.TypeOf("")),
+							},
+						}},
+					},
+				},
+				Responses: map[string]openAPIResponse{
+					"303": {Description: "Logged in, redirect to the home page"},
+					"401": {Description: "Wrong email or password"},
+				},
+			},
+		}
+	}
+
+	return openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       h.options.BrandName + " API",
+			Version:     h.options.Version,
+			Description: h.options.BrandTagline,
+		},
+		Paths: paths,
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				"PasteRequest": schemaOf(reflect.TypeOf(service.PasteRequest{})),
+				"Paste":        schemaOf(reflect.TypeOf(apiPaste{})),
+				"PasteMeta":    schemaOf(reflect.TypeOf(apiPasteMeta{})),
+				"Stats":        schemaOf(reflect.TypeOf(service.InstanceStats{})),
+				"User":         schemaOf(reflect.TypeOf(token.User{})),
+				"Error":        schemaOf(reflect.TypeOf(map[string]string{})),
+			},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"jwt": {Type: "apiKey", In: "header", Name: "X-JWT", Description: "the token from the JWT cookie"},
+			},
+		},
+	}
+}
+
+// validateOpenAPI parses the API description and checks that it is an
+// OpenAPI 3 document, that every operation has responses and that all the
+// schema references resolve.
+func validateOpenAPI(b []byte) error {
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+	if len(doc.Paths) == 0 {
+		return fmt.Errorf("no paths")
+	}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			if _, ok := op["responses"]; !ok {
+				return fmt.Errorf("%s %s has no responses", method, path)
+			}
+		}
+	}
+
+	var all interface{}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	var check func(v interface{}) error
+	check = func(v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if ref, ok := e.(string); ok && k == "$ref" {
+					name := strings.TrimPrefix(ref, "#/components/schemas/")
+					if _, found := doc.Components.Schemas[name]; !found || name == ref {
+						return fmt.Errorf("unresolved reference %q", ref)
+					}
+				}
+				if err := check(e); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, e := range v {
+				if err := check(e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return check(all)
+}
+
+// handleGetOpenAPI returns the description of the API as an OpenAPI 3
+// document.
+func (h *Server) handleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(h.openAPIDoc); err != nil {
+		h.log.Logf("ERROR handleGetOpenAPI: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The API description is a valid OpenAPI document with the API paths and
+// the schemas of the request and response types
+func TestGetOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/v1/openapi.json", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+	if err := validateOpenAPI(w.Body.Bytes()); err != nil {
+		t.Errorf("expected a valid document, got %v", err)
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode the document: %v", err)
+	}
+	for path, methods := range map[string][]string{
+		"/api/v1/paste":           {"post"},
+		"/api/v1/paste/{id}":      {"get", "delete"},
+		"/api/v1/paste/{id}/meta": {"get"},
+		"/api/v1/stats":           {"get"},
+		"/auth/{provider}/login":  {"get"},
+		"/auth/user":              {"get"},
+	} {
+		for _, m := range methods {
+			if _, ok := doc.Paths[path][m]; !ok {
+				t.Errorf("expected %s %s in the document", m, path)
+			}
+		}
+	}
+
+	req := doc.Components.Schemas["PasteRequest"]
+	if req == nil || req.Properties["body"] == nil || req.Properties["files"].Items.Properties["syntax"] == nil {
+		t.Errorf("expected the PasteRequest schema from the type, got %+v", req)
+	}
+	if _, ok := req.Properties["IP"]; ok {
+		t.Errorf("fields that are not in the JSON should not be in the schema")
+	}
+	paste := doc.Components.Schemas["Paste"]
+	if paste == nil || paste.Properties["full_url"] == nil || paste.Properties["created"].Format != "date-time" {
+		t.Errorf("expected the Paste schema with the embedded fields, got %+v", paste)
+	}
+}
+
+func TestValidateOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not JSON", `{"openapi": `},
+		{"version", `{"openapi": "2.0", "paths": {"/": {"get": {"responses": {}}}}}`},
+		{"no paths", `{"openapi": "3.0.3", "paths": {}}`},
+		{"no responses", `{"openapi": "3.0.3", "paths": {"/": {"get": {"summary": "x"}}}}`},
+		{"bad reference", `{"openapi": "3.0.3", "paths": {"/": {"get": {"responses": {"200": {"description": "x",
+			"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pasta"}}}}}}}},
+			"components": {"schemas": {"Paste": {"type": "object"}}}}`},
+	}
+	for _, tc := range tests {
+		if err := validateOpenAPI([]byte(tc.doc)); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
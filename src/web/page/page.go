@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"net/url"
+	"time"
 
 	"github.com/go-pkgz/auth/token"
 	"github.com/iliafrenkel/go-pb/src/store"
@@ -15,23 +17,58 @@ import (
 // functions defined below to add data to the page.
 type Page struct {
 	// common for all pages
-	Title   string // page title, used a value for the <title> tag
-	Brand   string // text displayed in big letters at the top of each page
-	Tagline string // text displayed below the Brand
-	Logo    string // name of the image file from the assets folder to use as a logo
-	Theme   string // bootstrap theme
-	Server  string // server URL
-	Version string // application version to show at the bottom of every page
-	Totals  Stats  // totals, such as total number of pastes and users
+	Title   string        // page title, used a value for the <title> tag
+	Brand   string        // text displayed in big letters at the top of each page
+	Tagline string        // text displayed below the Brand
+	Logo    string        // name of the image file from the assets folder to use as a logo
+	Theme   string        // bootstrap theme
+	Server  string        // server URL
+	Version string        // application version to show at the bottom of every page
+	Totals  Stats         // totals, such as total number of pastes and users
+	Footer  template.HTML // operator provided HTML shown in the footer, not escaped
+
+	Providers []string      // names of the enabled auth providers
+	RequestID string        // ID of the current request, for bug reports
+	TrashKeep time.Duration // how long deleted pastes are kept in the trash, 0 if there is no trash
+	ReadOnly  bool          // the site is in maintenance mode and doesn't accept changes
 
 	// not common for all pages
-	User       token.User    // user details parsed from the JWT token
-	PasteID    string        // paste ID (URL) for pages that need redirect/post back
-	Pastes     []store.Paste // a list of pastes for the list pages
-	UserPastes []store.Paste // a list of pastes for the sidebar
-	Paste      store.Paste   // a single paste
-	PageLinks  Paginator     // paginator for list pages
-	LastPage   int           // offset for the last paginator link
+	User              token.User     // user details parsed from the JWT token
+	PasteID           string         // paste ID (URL) for pages that need redirect/post back
+	Pastes            []store.Paste  // a list of pastes for the list pages
+	UserPastes        []store.Paste  // a list of pastes for the sidebar
+	ExpiringPastes    []store.Paste  // the user's pastes expiring soon, for the list page
+	Paste             store.Paste    // a single paste
+	Code              template.HTML  // highlighted paste body
+	Landing           template.HTML  // rendered landing page shown to anonymous visitors
+	Markdown          template.HTML  // rendered body of a markdown paste, empty to show the source
+	Files             []File         // highlighted files of a multi-file paste
+	Diff              string         // unified diff between two pastes
+	DiffFrom          string         // ID (URL) of the first paste in the diff
+	DiffTo            string         // ID (URL) of the second paste in the diff
+	PageLinks         Paginator      // paginator for list pages
+	Sort              string         // current sort order for list pages
+	Syntax            string         // current syntax filter of the archive, empty means all
+	Syntaxes          []string       // syntaxes the archive can be filtered by
+	Users             []store.User   // a list of users for the admin page
+	Reports           []store.Report // a list of abuse reports for the admin page
+	Tab               string         // active tab on pages with tabs
+	Message           string         // flash message with the result of the last action
+	Expirations       []Expiration   // expiration presets for the new paste form
+	DefaultPrivacy    string         // preselected privacy of the new paste form
+	DefaultExpiration string         // preselected expiration of the new paste form
+	RequireLogin      bool           // anonymous visitors see a login prompt instead of the new paste form
+	HighlightTheme    string         // highlight theme of the paste page
+	HighlightThemes   []string       // highlight themes to choose from
+	LastPage          int            // offset for the last paginator link
+	ResetToken        string         // password reset token for the reset form
+	ClaimToken        string         // token to claim the anonymous paste, only shown to its creator right after it is created
+	Warnings          []string       // reasons to confirm the new paste
+	FormValues        url.Values     // fields of the new paste form to post again once confirmed
+
+	// only for the paste page with comments enabled
+	Comments   []store.Comment // comments of the paste, oldest first
+	CommentsOn bool            // comments are enabled and shown below the paste
 
 	// only for error pages
 	ErrorCode    int    // error code, to show on the error page (404, 500, etc.)
@@ -43,6 +80,21 @@ type Page struct {
 	template  string             // template name to generate HTML
 }
 
+// Expiration is an option of the expiration dropdown.
+type Expiration struct {
+	Value string // value accepted by the service, e.g. "10m"
+	Label string // text shown to the user, e.g. "10 minutes"
+}
+
+// File is a highlighted file of a multi-file paste.
+type File struct {
+	Name     string        // file name, used in the tabs and raw links
+	Syntax   string        // file syntax
+	Body     string        // file body as is
+	Code     template.HTML // highlighted file body
+	Markdown template.HTML // rendered body of a markdown file, empty to show the source
+}
+
 // Data func type.
 type Data func(p *Page)
 
@@ -61,6 +113,7 @@ type PaginatorLink struct {
 // Paginator struct used to build paginators on list pages.
 type Paginator struct {
 	Current    int             // current page number
+	Offset     int             // offset of the current page
 	Last       int             // last page number
 	LastOffset int             // last page number
 	Pages      []PaginatorLink // a list of links
@@ -115,6 +168,29 @@ func Version(version string) Data {
 	}
 }
 
+// Footer sets page footer. The footer comes from the server configuration
+// and is trusted, it is rendered as is without escaping.
+func Footer(footer string) Data {
+	return func(p *Page) {
+		p.Footer = template.HTML(footer) //nolint:gosec // trusted operator content
+	}
+}
+
+// Markdown sets the rendered body of a markdown paste.
+func Markdown(md template.HTML) Data {
+	return func(p *Page) {
+		p.Markdown = md
+	}
+}
+
+// Landing sets the rendered landing page. It comes from the server
+// configuration and is rendered as is without escaping.
+func Landing(landing template.HTML) Data {
+	return func(p *Page) {
+		p.Landing = landing
+	}
+}
+
 // Totals sets page totals.
 func Totals(totals Stats) Data {
 	return func(p *Page) {
@@ -122,6 +198,13 @@ func Totals(totals Stats) Data {
 	}
 }
 
+// Providers sets the enabled auth providers.
+func Providers(names []string) Data {
+	return func(p *Page) {
+		p.Providers = names
+	}
+}
+
 // User sets page user.
 func User(usr token.User) Data {
 	return func(p *Page) {
@@ -136,6 +219,20 @@ func PasteID(pid string) Data {
 	}
 }
 
+// ResetToken sets the password reset token.
+func ResetToken(tkn string) Data {
+	return func(p *Page) {
+		p.ResetToken = tkn
+	}
+}
+
+// ClaimToken sets the token to claim the new anonymous paste.
+func ClaimToken(tkn string) Data {
+	return func(p *Page) {
+		p.ClaimToken = tkn
+	}
+}
+
 // Pastes sets a list of pastes.
 func Pastes(pastes []store.Paste) Data {
 	return func(p *Page) {
@@ -150,6 +247,29 @@ func UserPastes(pastes []store.Paste) Data {
 	}
 }
 
+// ExpiringPastes sets a list of the user's pastes expiring soon.
+func ExpiringPastes(pastes []store.Paste) Data {
+	return func(p *Page) {
+		p.ExpiringPastes = pastes
+	}
+}
+
+// Comments sets whether comments are enabled and the comments of the paste.
+func Comments(enabled bool, comments []store.Comment) Data {
+	return func(p *Page) {
+		p.CommentsOn = enabled
+		p.Comments = comments
+	}
+}
+
+// RequireLogin sets whether anonymous visitors need to login before they
+// can create pastes.
+func RequireLogin(require bool) Data {
+	return func(p *Page) {
+		p.RequireLogin = require
+	}
+}
+
 // Paste sets a single paste.
 func Paste(paste store.Paste) Data {
 	return func(p *Page) {
@@ -157,6 +277,121 @@ func Paste(paste store.Paste) Data {
 	}
 }
 
+// Code sets the highlighted body of the paste. It is inserted into the page
+// unescaped, so it must come from service.RenderHTML that escapes the body.
+func Code(code template.HTML) Data {
+	return func(p *Page) {
+		p.Code = code
+	}
+}
+
+// Files sets the highlighted files of a multi-file paste.
+func Files(files []File) Data {
+	return func(p *Page) {
+		p.Files = files
+	}
+}
+
+// Diff sets the diff between two pastes and their IDs.
+func Diff(from, to, diff string) Data {
+	return func(p *Page) {
+		p.DiffFrom = from
+		p.DiffTo = to
+		p.Diff = diff
+	}
+}
+
+// Users sets a list of users.
+func Users(users []store.User) Data {
+	return func(p *Page) {
+		p.Users = users
+	}
+}
+
+// Reports sets the list of abuse reports.
+func Reports(reports []store.Report) Data {
+	return func(p *Page) {
+		p.Reports = reports
+	}
+}
+
+// Tab sets the active tab.
+func Tab(tab string) Data {
+	return func(p *Page) {
+		p.Tab = tab
+	}
+}
+
+// TrashKeep sets how long deleted pastes are kept in the trash.
+func TrashKeep(keep time.Duration) Data {
+	return func(p *Page) {
+		p.TrashKeep = keep
+	}
+}
+
+// ReadOnly sets whether the site is in maintenance mode.
+func ReadOnly(on bool) Data {
+	return func(p *Page) {
+		p.ReadOnly = on
+	}
+}
+
+// Warnings sets the reasons to confirm the new paste.
+func Warnings(warnings []string) Data {
+	return func(p *Page) {
+		p.Warnings = warnings
+	}
+}
+
+// FormValues sets the fields of the new paste form to post again.
+func FormValues(values url.Values) Data {
+	return func(p *Page) {
+		p.FormValues = values
+	}
+}
+
+// Message sets a flash message shown on top of the page.
+func Message(msg string) Data {
+	return func(p *Page) {
+		p.Message = msg
+	}
+}
+
+// Expirations sets the expiration presets for the new paste form.
+func Expirations(presets []Expiration) Data {
+	return func(p *Page) {
+		p.Expirations = presets
+	}
+}
+
+// DefaultExpiration sets the preselected expiration of the new paste form.
+func DefaultExpiration(exp string) Data {
+	return func(p *Page) {
+		p.DefaultExpiration = exp
+	}
+}
+
+// DefaultPrivacy sets the preselected privacy of the new paste form.
+func DefaultPrivacy(privacy string) Data {
+	return func(p *Page) {
+		p.DefaultPrivacy = privacy
+	}
+}
+
+// HighlightTheme sets the highlight theme of the paste page.
+func HighlightTheme(theme string) Data {
+	return func(p *Page) {
+		p.HighlightTheme = theme
+	}
+}
+
+// HighlightThemes sets the highlight themes to choose from.
+func HighlightThemes(themes []string) Data {
+	return func(p *Page) {
+		p.HighlightThemes = themes
+	}
+}
+
 // PageLinks sets paginator for the page.
 func PageLinks(paginator Paginator) Data {
 	return func(p *Page) {
@@ -164,6 +399,27 @@ func PageLinks(paginator Paginator) Data {
 	}
 }
 
+// Sort sets the current sort order for list pages.
+func Sort(sort string) Data {
+	return func(p *Page) {
+		p.Sort = sort
+	}
+}
+
+// Syntax sets the current syntax filter of the archive.
+func Syntax(syntax string) Data {
+	return func(p *Page) {
+		p.Syntax = syntax
+	}
+}
+
+// Syntaxes sets the syntaxes the archive can be filtered by.
+func Syntaxes(syntaxes []string) Data {
+	return func(p *Page) {
+		p.Syntaxes = syntaxes
+	}
+}
+
 // ErrorCode sets error code for the error page.
 func ErrorCode(code int) Data {
 	return func(p *Page) {
@@ -171,6 +427,13 @@ func ErrorCode(code int) Data {
 	}
 }
 
+// RequestID sets the ID of the current request.
+func RequestID(id string) Data {
+	return func(p *Page) {
+		p.RequestID = id
+	}
+}
+
 // ErrorText sets error text for the error page.
 func ErrorText(txt string) Data {
 	return func(p *Page) {
@@ -204,6 +467,24 @@ func New(t *template.Template, data ...Data) *Page {
 	return &p
 }
 
+// HasProvider returns true if the auth provider with the given name is enabled.
+func (p *Page) HasProvider(name string) bool {
+	for _, n := range p.Providers {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgeDate returns the time when a paste in the trash is deleted for good.
+func (p *Page) PurgeDate(paste store.Paste) time.Time {
+	if paste.DeletedAt == nil {
+		return time.Time{}
+	}
+	return paste.DeletedAt.Add(p.TrashKeep)
+}
+
 // Show renders the template with the page data and writes resulting HTML.
 func (p *Page) Show(w io.Writer) error {
 	var html bytes.Buffer
@@ -0,0 +1,88 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// allowed reports whether the list is empty, i.e. everything is allowed,
+// or has the value.
+func allowed(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPastePolicy checks a new paste against the operator's restrictions
+// on privacy and syntax, on top of what the service validates. Plain text
+// is always allowed.
+func (opts ServerOptions) checkPastePolicy(pr service.PasteRequest) error {
+	if pr.UserID == "" && !allowed(opts.AllowedPrivacyAnon, pr.Privacy) {
+		return fmt.Errorf("anonymous pastes can only be %s", strings.Join(opts.AllowedPrivacyAnon, " or "))
+	}
+	syntaxes := []string{pr.Syntax}
+	if len(pr.Files) > 0 {
+		syntaxes = syntaxes[:0]
+		for _, f := range pr.Files {
+			syntaxes = append(syntaxes, f.Syntax)
+		}
+	}
+	for _, s := range syntaxes {
+		if s != "" && s != "none" && s != "text" && !allowed(opts.AllowedSyntaxes, s) {
+			return fmt.Errorf("syntax %q is not allowed", s)
+		}
+	}
+	return nil
+}
+
+// parseBlockedPatterns compiles the regexps of the content that public
+// pastes must not have.
+func parseBlockedPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocked pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// blockedContent reports whether the paste is going to be public and its
+// title or body matches one of the blocked patterns. Private and unlisted
+// pastes are not checked, except for the anonymous private ones, the
+// service makes them public.
+func (h *Server) blockedContent(pr service.PasteRequest) bool {
+	if len(h.blocked) == 0 {
+		return false
+	}
+	if pr.Privacy != "public" && (pr.Privacy != "private" || pr.UserID != "") {
+		return false
+	}
+	texts := []string{pr.Title, pr.Body}
+	for _, f := range pr.Files {
+		texts = append(texts, f.Name, f.Body)
+	}
+	for _, re := range h.blocked {
+		for _, t := range texts {
+			if re.MatchString(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Anonymous privacy and syntaxes can be restricted by the operator
+func TestPostPastePolicy(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.AllowedPrivacyAnon = []string{"public", "unlisted"}
+	opts.AllowedSyntaxes = []string{"go", "python"}
+	srv := New(log, opts)
+	defer srv.Close()
+
+	usr := token.User{ID: "test_user_policy", Name: "Test User Policy"}
+	tests := []struct {
+		name string
+		usr  *token.User
+		body string
+		code int
+	}{
+		{"anonymous private", nil, "body=Test&privacy=private", http.StatusBadRequest},
+		{"anonymous unlisted", nil, "body=Test&privacy=unlisted", http.StatusOK},
+		{"anonymous default", nil, "body=Test", http.StatusOK},
+		{"logged in private", &usr, "body=Test&privacy=private", http.StatusOK},
+		{"allowed syntax", nil, "body=Test&syntax=go", http.StatusOK},
+		{"plain text", nil, "body=Test&syntax=none", http.StatusOK},
+		{"disallowed syntax", &usr, "body=Test&syntax=cobol", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader(tc.body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if tc.usr != nil {
+			r = token.SetUserInfo(r, *tc.usr)
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"Test","privacy":"private"}`))
+	r.Header.Add("Content-Type", "application/json")
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "anonymous pastes can only be public or unlisted") {
+		t.Errorf("expected the API to reject an anonymous private paste, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// Public pastes with blocked content are rejected, or made unlisted with
+// UnlistBlocked, private and unlisted ones are not checked
+func TestPostPasteBlockedPatterns(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.BlockedPatterns = []string{`(?i)\bcasino\b`}
+	srv := New(log, opts)
+	defer srv.Close()
+
+	usr := token.User{ID: "test_user_blocked", Name: "Test User Blocked"}
+	if _, err := srv.service.GetOrUpdateUser(store.User{ID: usr.ID, Name: usr.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	tests := []struct {
+		name string
+		usr  *token.User
+		body string
+		code int
+	}{
+		{"allowed body", nil, "body=Hello+world&privacy=public", http.StatusOK},
+		{"blocked body", nil, "body=Best+Casino+online&privacy=public", http.StatusBadRequest},
+		{"blocked title", &usr, "title=casino&body=Hello&privacy=public", http.StatusBadRequest},
+		{"anonymous private", nil, "body=Best+casino&privacy=private", http.StatusBadRequest},
+		{"logged in private", &usr, "body=Best+casino&privacy=private", http.StatusOK},
+		{"unlisted", nil, "body=Best+casino&privacy=unlisted", http.StatusOK},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader(tc.body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if tc.usr != nil {
+			r = token.SetUserInfo(r, *tc.usr)
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"Best casino","privacy":"public"}`))
+	r.Header.Add("Content-Type", "application/json")
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "content not allowed") {
+		t.Errorf("expected the API to reject blocked content, got %d %s", w.Code, w.Body.String())
+	}
+
+	// with UnlistBlocked the paste is created but not listed
+	opts.UnlistBlocked = true
+	srv = New(log, opts)
+	defer srv.Close()
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/p/", strings.NewReader("body=Best+casino&privacy=public"))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	srv.router.ServeHTTP(w, r)
+	url := strings.TrimPrefix(w.Header().Get("Location"), "/p/")
+	if w.Code != http.StatusOK || url == "" {
+		t.Fatalf("expected the paste to be created, got %d", w.Code)
+	}
+	p, err := srv.service.GetPasteMeta(url, "")
+	if err != nil {
+		t.Fatalf("failed to get paste: %v", err)
+	}
+	if p.Privacy != "unlisted" {
+		t.Errorf("expected the paste to be unlisted, got %q", p.Privacy)
+	}
+}
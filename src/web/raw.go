@@ -0,0 +1,166 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// handleGetPasteRaw returns the paste body as plain text.
+func (h *Server) handleGetPasteRaw(w http.ResponseWriter, r *http.Request) {
+	h.servePasteBody(w, r, false)
+}
+
+// handleGetPasteDownload returns the paste body as a file attachment.
+func (h *Server) handleGetPasteDownload(w http.ResponseWriter, r *http.Request) {
+	h.servePasteBody(w, r, true)
+}
+
+// servePasteBody writes the paste body as plain text. Pastes never change
+// once created, so the response can be cached, unless the paste is going
+// to disappear, i.e. it expires or is deleted after read. The password of
+// protected pastes is given with Basic Auth or the password query parameter.
+// A single file of a multi-file paste is selected with the file parameter.
+// Byte ranges of the body can be requested, except for the pastes deleted
+// after read.
+func (h *Server) servePasteBody(w http.ResponseWriter, r *http.Request, download bool) {
+	usr, _ := token.GetUserInfo(r)
+	id := mux.Vars(r)["id"]
+
+	// check the cache validators first, so that 304 doesn't hit the body
+	meta, err := h.service.GetPasteMeta(id, usr.ID)
+	if err != nil {
+		h.showPasteBodyError(w, err)
+		return
+	}
+	if cacheablePaste(meta) {
+		etag := pasteETag(meta)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", meta.CreatedAt.UTC().Format(http.TimeFormat))
+		if meta.Privacy == "private" {
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		if notModified(r, etag, meta.CreatedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	// only the HTML page counts views, see countView, and HEAD requests
+	// don't burn the paste either; bots do, since curl is a bot here
+	getPaste := h.service.GetPasteNoCountCtx
+	if r.Method == http.MethodHead {
+		getPaste = h.service.PeekPasteCtx
+	}
+	paste, err := getPaste(r.Context(), id, usr.ID, pastePassword(r))
+	if err != nil {
+		w.Header().Del("ETag")
+		w.Header().Del("Last-Modified")
+		w.Header().Set("Cache-Control", "no-store")
+		h.showPasteBodyError(w, err)
+		return
+	}
+
+	// files of multi-file pastes are selected by name
+	body, filename := paste.Body, paste.URL()+".txt"
+	if name := r.URL.Query().Get("file"); name != "" {
+		f, ok := paste.File(name)
+		if !ok {
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+			w.Header().Set("Cache-Control", "no-store")
+			h.showError(w, http.StatusNotFound, "There is no such file")
+			return
+		}
+		body, filename = f.Body, f.Name
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if download {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	// a delete after read paste is gone once it is read, so it is always
+	// sent whole, otherwise the client couldn't get the rest
+	if paste.DeleteAfterRead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if _, err := w.Write([]byte(body)); err != nil {
+			h.log.Logf("ERROR servePasteBody: failed to write response: %v", err)
+		}
+		return
+	}
+	// ServeContent handles Range and If-Range, the ETag is already set
+	var modified time.Time
+	if cacheablePaste(meta) {
+		modified = meta.CreatedAt
+	}
+	http.ServeContent(w, r, "", modified, strings.NewReader(body))
+}
+
+// showPasteBodyError shows an error page for the errors returned by the
+// service when getting a paste.
+func (h *Server) showPasteBodyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste")
+	case errors.Is(err, service.ErrPasteIsPrivate):
+		h.showError(w, http.StatusForbidden, "This paste is private")
+	case errors.Is(err, service.ErrPasteHasPassword), errors.Is(err, service.ErrWrongPassword):
+		w.Header().Set("WWW-Authenticate", `Basic realm="paste"`)
+		h.showError(w, http.StatusUnauthorized, "This paste is protected by a password")
+	case errors.Is(err, service.ErrStoreFailure), errors.Is(err, service.ErrStoreUnavailable):
+		h.showInternalError(w, err)
+	default:
+		h.showError(w, http.StatusNotFound, "There is no such paste")
+	}
+}
+
+// cacheablePaste reports whether the paste body can be cached by clients.
+// Password protected pastes are not cached to keep them off shared caches.
+func cacheablePaste(p store.Paste) bool {
+	return !p.DeleteAfterRead && p.Expires.IsZero() && p.Password == ""
+}
+
+// pasteETag returns a strong ETag for the paste body. The paste ID and the
+// creation time identify the body because pastes are never updated.
+func pasteETag(p store.Paste) string {
+	return fmt.Sprintf(`"%s-%x"`, p.URL(), p.CreatedAt.UnixNano())
+}
+
+// notModified reports whether the client already has the current version
+// based on the If-None-Match and If-Modified-Since headers. As per RFC 7232
+// If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Raw paste body is cached with ETag and Last-Modified
+func TestGetPasteRawCaching(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Test",
+		Body:    "Test paste",
+		Privacy: "public",
+		Syntax:  "text",
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Body.String(); got != "Test paste" {
+		t.Errorf("Response should be [%s], got [%s]", "Test paste", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type should be text/plain, got %s", got)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Response should have an ETag")
+	}
+	lastModified := w.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatalf("Response should have Last-Modified")
+	}
+
+	// same ETag
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	r.Header.Set("If-None-Match", etag)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Status should be %d, got %d", http.StatusNotModified, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Response body should be empty, got [%s]", w.Body.String())
+	}
+
+	// different ETag
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	r.Header.Set("If-None-Match", `"something-else"`)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// same modification time
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/download", nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Status should be %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	// raw fetches are not counted as views
+	meta, _ := webSrv.service.GetPasteMeta(p.URL(), "")
+	if meta.Views != 0 {
+		t.Errorf("Paste should have 0 views, got %d", meta.Views)
+	}
+}
+
+// Download is sent as an attachment
+func TestGetPasteDownload(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "Test paste",
+		Privacy: "public",
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/download", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	want := `attachment; filename="` + p.URL() + `.txt"`
+	if got := w.Header().Get("Content-Disposition"); got != want {
+		t.Errorf("Content-Disposition should be [%s], got [%s]", want, got)
+	}
+}
+
+// Pastes that disappear are not cached
+func TestGetPasteRawNoStore(t *testing.T) {
+	t.Parallel()
+
+	tests := []service.PasteRequest{
+		{Body: "Test paste", Privacy: "public", DeleteAfterRead: true},
+		{Body: "Test paste", Privacy: "public", Expires: "1d"},
+	}
+	for _, pr := range tests {
+		p, err := webSrv.service.NewPaste(pr)
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Cache-Control should be no-store, got %s", got)
+		}
+		if got := w.Header().Get("ETag"); got != "" {
+			t.Errorf("Response should not have an ETag, got %s", got)
+		}
+	}
+}
+
+// Protected pastes are not available as raw text
+func TestGetPasteRawProtected(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:     "Test paste",
+		Privacy:  "public",
+		Password: "secret",
+	})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	u, _ := webSrv.service.GetOrUpdateUser(store.User{ID: "test_user_raw", Name: "Test User Raw"})
+	p, _ = webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "Test paste",
+		Privacy: "private",
+		UserID:  u.ID,
+	})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status should be %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+// Files of a multi-file paste are shown as tabs and selected by name
+func TestGetPasteFiles(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Files",
+		Privacy: "public",
+		Files: []service.File{
+			{Name: "main.go", Body: "package main", Syntax: "go"},
+			{Name: "notes.txt", Body: "Some notes"},
+		},
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL(), nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, want := range []string{">main.go</button>", ">notes.txt</button>", "/raw?file=notes.txt"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Response should contain [%s]", want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/download?file=notes.txt", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Body.String(); got != "Some notes" {
+		t.Errorf("Response should be [%s], got [%s]", "Some notes", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="notes.txt"` {
+		t.Errorf("Content-Disposition should name the file, got %s", got)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL()+"/raw?file=missing.txt", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// A byte range of the body is returned as 206 Partial Content, except for
+// the pastes deleted after read that are always returned whole
+func TestGetPasteRawRange(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "0123456789abcdef",
+		Privacy: "public",
+		Syntax:  "text",
+	})
+	for _, path := range []string{"/raw", "/download"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL()+path, nil)
+		r.Header.Set("Range", "bytes=4-9")
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("%s: status should be %d, got %d", path, http.StatusPartialContent, w.Code)
+		}
+		if got := w.Body.String(); got != "456789" {
+			t.Errorf("%s: response should be [456789], got [%s]", path, got)
+		}
+		if got := w.Header().Get("Content-Range"); got != "bytes 4-9/16" {
+			t.Errorf("%s: Content-Range should be [bytes 4-9/16], got [%s]", path, got)
+		}
+	}
+
+	// resuming with a stale ETag gets the whole body
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL()+"/raw", nil)
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("If-Range", `"stale"`)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789abcdef" {
+		t.Errorf("expected the whole body for a stale If-Range, got %d [%s]", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Length"); got != "16" {
+		t.Errorf("Content-Length should be 16, got [%s]", got)
+	}
+
+	dar, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:            "0123456789abcdef",
+		Privacy:         "public",
+		DeleteAfterRead: true,
+	})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+dar.URL()+"/raw", nil)
+	r.Header.Set("Range", "bytes=4-9")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789abcdef" {
+		t.Errorf("expected the whole body of a delete after read paste, got %d [%s]", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// readOnlyRoutes are the routes that accept POST requests but don't change
+// anything, so they keep working in maintenance mode.
+var readOnlyRoutes = map[string]bool{
+	"/p/{id}":         true, // paste password
+	"/diff":           true,
+	"/u/login":        true,
+	"/auth":           true,
+	"/admin/readonly": true, // to turn maintenance mode off
+}
+
+// SetReadOnly turns the maintenance mode on or off.
+func (h *Server) SetReadOnly(on bool) {
+	h.readOnly.Store(on)
+}
+
+// ReadOnly reports whether the server is in maintenance mode.
+func (h *Server) ReadOnly() bool {
+	return h.readOnly.Load()
+}
+
+// readOnlyGuard answers the requests that would change something with 503
+// Service Unavailable while the server is in maintenance mode. Reads keep
+// working.
+func (h *Server) readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.readOnly.Load() || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil && readOnlyRoutes[tpl] {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Retry-After", "300")
+		h.showError(w, http.StatusServiceUnavailable, "The site is in maintenance mode, pastes can be read but not created or changed. Please try again later.")
+	})
+}
+
+// handlePostAdminReadOnly turns the maintenance mode on or off, or flips it
+// if the form doesn't say which.
+func (h *Server) handlePostAdminReadOnly(w http.ResponseWriter, r *http.Request) {
+	usr, ok := h.adminUser(w, r)
+	if !ok {
+		return
+	}
+	on := !h.readOnly.Load()
+	switch r.PostFormValue("readonly") {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	}
+	h.SetReadOnly(on)
+	h.log.Logf("INFO admin %s set maintenance mode to %v", usr.ID, on)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// In maintenance mode writes are refused and reads keep working
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.ReadOnly = true
+	opts.EnableLocalAuth = true
+	srv := New(log, opts)
+	defer srv.Close()
+
+	p, err := srv.service.NewPaste(service.PasteRequest{Title: "Read only", Body: "Test paste", Privacy: "public"})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("POST", "/p/", "title=Blocked&body=Blocked&privacy=public", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new pastes to be refused with %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "maintenance mode") {
+		t.Errorf("expected the maintenance message, got %s", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the Retry-After header")
+	}
+	if w := serve("POST", "/u/register", "username=test&email=test@example.com&password=password", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected registration to be refused with %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w := serve("GET", "/p/"+p.URL(), "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected the paste to be readable, got %d", w.Code)
+	} else if !strings.Contains(w.Body.String(), "The site is in maintenance mode") {
+		t.Errorf("expected the maintenance banner on the paste page")
+	}
+	if w := serve("GET", "/p/"+p.URL()+"/raw", "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected the raw paste to be readable, got %d", w.Code)
+	}
+
+	// only admins can turn the maintenance mode off
+	usr := token.User{ID: "test_user_readonly", Name: "Test User Read Only"}
+	if w := serve("POST", "/admin/readonly", "readonly=off", &usr); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a non-admin, got %d", http.StatusForbidden, w.Code)
+	}
+	admin := token.User{ID: "test_admin_readonly", Name: "Test Admin Read Only"}
+	admin.SetAdmin(true)
+	if w := serve("POST", "/admin/readonly", "readonly=off", &admin); w.Code != http.StatusSeeOther {
+		t.Errorf("expected redirect after turning maintenance mode off, got %d", w.Code)
+	}
+	if srv.ReadOnly() {
+		t.Fatalf("expected maintenance mode to be off")
+	}
+	if w := serve("POST", "/p/", "title=Allowed&body=Allowed&privacy=public", nil); w.Code == http.StatusServiceUnavailable {
+		t.Errorf("expected new pastes to be accepted again, got %d", w.Code)
+	}
+
+	// without a value the mode is flipped
+	serve("POST", "/admin/readonly", "", &admin)
+	if !srv.ReadOnly() {
+		t.Errorf("expected maintenance mode to be flipped on")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listenRedirect opens the plain HTTP listener that redirects to HTTPS, on
+// the host of the main server and RedirectHTTPPort. It returns nil if the
+// server doesn't serve HTTPS or the port is not set.
+func (h *Server) listenRedirect() (net.Listener, error) {
+	if !h.options.servesTLS() || h.options.RedirectHTTPPort == 0 {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(h.options.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(h.options.RedirectHTTPPort)))
+}
+
+// serveRedirect starts redirecting the requests on the listener to the
+// HTTPS server listening on tlsAddr. If acm is not nil, it also answers the
+// ACME HTTP challenges. It is shut down together with the main server.
+func (h *Server) serveRedirect(ln net.Listener, tlsAddr net.Addr, acm *autocert.Manager) {
+	_, port, _ := net.SplitHostPort(tlsAddr.String())
+	hdlr := redirectHandler(port)
+	if acm != nil {
+		hdlr = acm.HTTPHandler(hdlr)
+	}
+	h.redirect = h.httpServer(hdlr)
+	h.log.Logf("INFO redirecting http on %s to https", ln.Addr())
+	go func() {
+		if err := h.redirect.Serve(ln); err != nil && err != http.ErrServerClosed {
+			h.log.Logf("ERROR http redirect listener failed: %v", err)
+		}
+	}()
+}
+
+// redirectHandler permanently redirects every request to the same host,
+// path and query over HTTPS on the given port.
+func redirectHandler(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]") // IPv6 without a port
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawPath:  r.URL.RawPath,
+			RawQuery: r.URL.RawQuery,
+		}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	})
+}
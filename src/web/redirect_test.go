@@ -0,0 +1,97 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// Requests are redirected to the same host, path and query over https
+func TestRedirectHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name, port, target, want string
+	}{
+		{"host with port", "8443", "http://go-pb.example.com:8080/p/abc?x=1&y=2", "https://go-pb.example.com:8443/p/abc?x=1&y=2"},
+		{"default port", "443", "http://go-pb.example.com/p/abc?x=1", "https://go-pb.example.com/p/abc?x=1"},
+		{"default port drops http port", "443", "http://go-pb.example.com:80/", "https://go-pb.example.com/"},
+		{"escaped path", "443", "http://go-pb.example.com/a%2Fb", "https://go-pb.example.com/a%2Fb"},
+		{"ipv6", "8443", "http://[::1]:8080/l/", "https://[::1]:8443/l/"},
+		{"ipv6 default port", "443", "http://[::1]/l/", "https://[::1]/l/"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tc.target, nil)
+		redirectHandler(tc.port).ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: status should be %d, got %d", tc.name, http.StatusMovedPermanently, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != tc.want {
+			t.Errorf("%s: expected Location %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+// The redirect listener runs and shuts down together with the TLS server
+func TestRedirectListener(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, _ := selfSignedCert(t, t.TempDir())
+	opts := testServerOptions()
+	opts.Proto = "https"
+	opts.TLSCert = certFile
+	opts.TLSKey = keyFile
+	opts.RedirectHTTPPort = 8080
+	opts.ReadTimeout, opts.WriteTimeout = 5*time.Second, 5*time.Second
+	if err := opts.validate(); err != nil {
+		t.Fatalf("options with a redirect port should be valid, got %v", err)
+	}
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	redirectLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.serve(ln, redirectLn) }()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	_, tlsPort, _ := net.SplitHostPort(ln.Addr().String())
+	resp, err := client.Get("http://" + redirectLn.Addr().String() + "/p/abc?x=1")
+	if err != nil {
+		t.Fatalf("request to the redirect listener failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("status should be %d, got %d", http.StatusMovedPermanently, resp.StatusCode)
+	}
+	if want := "https://127.0.0.1:" + tlsPort + "/p/abc?x=1"; resp.Header.Get("Location") != want {
+		t.Errorf("expected Location %q, got %q", want, resp.Header.Get("Location"))
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("failed to shut down: %v", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		t.Errorf("expected the server to be closed, got %v", err)
+	}
+	if resp, err := client.Get("http://" + redirectLn.Addr().String() + "/"); err == nil {
+		resp.Body.Close()
+		t.Errorf("redirect listener should be closed with the server")
+	}
+}
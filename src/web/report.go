@@ -0,0 +1,51 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// maxReportReason is the maximum length of the abuse report reason.
+const maxReportReason = 1024
+
+// handlePostReport records an abuse report for a paste and redirects to the
+// home page.
+func (h *Server) handlePostReport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	reason := r.PostFormValue("reason")
+	if len(reason) > maxReportReason {
+		reason = reason[:maxReportReason]
+	}
+
+	err := h.service.ReportPaste(id, reason, clientIP(r))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste")
+		return
+	case errors.Is(err, service.ErrEmptyReason):
+		h.showError(w, http.StatusBadRequest, "Please tell us what is wrong with the paste.")
+		return
+	case errors.Is(err, service.ErrReportLimit):
+		h.showError(w, http.StatusTooManyRequests, "You have sent too many reports, please try again later.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO paste %s reported from %s", id, clientIP(r))
+	http.Redirect(w, r, "/?reported=yes", http.StatusSeeOther)
+}
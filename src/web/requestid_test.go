@@ -0,0 +1,77 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// Request ID is generated or taken from the request and echoed back
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	var fromCtx string
+	hdlr := webSrv.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromCtx = RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"incoming", "abc-123_DEF.4", true},
+		{"invalid", "<script>", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tc.incoming != "" {
+			r.Header.Set("X-Request-ID", tc.incoming)
+		}
+		hdlr.ServeHTTP(w, r)
+
+		got := w.Header().Get("X-Request-ID")
+		if tc.keep && got != tc.incoming {
+			t.Errorf("%s: request ID should be %s, got %s", tc.name, tc.incoming, got)
+		}
+		if !tc.keep && !uuidRe.MatchString(got) {
+			t.Errorf("%s: request ID should be a UUID, got %s", tc.name, got)
+		}
+		if fromCtx != got {
+			t.Errorf("%s: request ID in the context should be %s, got %s", tc.name, got, fromCtx)
+		}
+		if r.Header.Get("X-Request-ID") != got {
+			t.Errorf("%s: request ID should be set on the request", tc.name)
+		}
+	}
+}
+
+// Error pages show the request ID
+func TestRequestIDErrorPage(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"/nonexisting", "/p/nonexisting"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("X-Request-ID", "test-request-id")
+		webSrv.router.ServeHTTP(w, r)
+
+		if got := w.Header().Get("X-Request-ID"); got != "test-request-id" {
+			t.Errorf("%s: request ID should be test-request-id, got %s", path, got)
+		}
+		if !strings.Contains(w.Body.String(), "<code>test-request-id</code>") {
+			t.Errorf("%s: error page should show the request ID", path)
+		}
+	}
+}
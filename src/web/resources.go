@@ -0,0 +1,74 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+
+	gopb "github.com/iliafrenkel/go-pb"
+)
+
+// resourceFS returns the folder at path, or the embedded folder with the
+// same name if the options say so or the path doesn't exist.
+func (h *Server) resourceFS(path, embedded string) fs.FS {
+	if !h.options.UseEmbedded {
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			return os.DirFS(path)
+		}
+		h.log.Logf("WARN %s folder %q is not available, using the embedded one", embedded, path)
+	}
+	sub, err := fs.Sub(gopb.Resources, embedded)
+	if err != nil {
+		h.log.Logf("FATAL embedded %s folder: %v", embedded, err)
+	}
+	return sub
+}
+
+// loadTemplates parses the templates.
+func (h *Server) loadTemplates() (*template.Template, error) {
+	return template.New("").Funcs(h.templateFuncs()).ParseFS(h.templatesFS, "*.html")
+}
+
+// currentTemplates returns the templates to render a page with. With hot
+// reload in debug mode they are parsed again, so that template changes show
+// without a restart; the previous ones are kept if parsing fails.
+func (h *Server) currentTemplates() *template.Template {
+	if h.options.HotReloadTemplates && h.options.LogMode == "debug" {
+		tpl, err := h.loadTemplates()
+		if err != nil {
+			h.log.Logf("ERROR reloading templates: %v", err)
+		} else {
+			h.templatesMu.Lock()
+			h.templates = tpl
+			h.templatesMu.Unlock()
+		}
+	}
+	h.templatesMu.RLock()
+	defer h.templatesMu.RUnlock()
+	return h.templates
+}
+
+// serveFSFile returns a handler that serves a single file from fsys.
+func serveFSFile(fsys fs.FS, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := fsys.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		rs, ok := f.(io.ReadSeeker)
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), rs)
+	}
+}
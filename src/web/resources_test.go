@@ -0,0 +1,117 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// The server runs with the embedded templates and assets, when asked to or
+// when the folders don't exist
+func TestEmbeddedResources(t *testing.T) {
+	t.Parallel()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name     string
+		embedded bool
+		dir      string
+	}{
+		{"embedded", true, ""},
+		{"fallback", false, missing},
+	}
+	for _, tc := range tests {
+		opts := testServerOptions()
+		opts.UseEmbedded = tc.embedded
+		if tc.dir != "" {
+			opts.Templates, opts.Assets = tc.dir, tc.dir
+		}
+		srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+		for _, path := range []string{"/", "/assets/prism.css", "/assets/themes/okaidia.css", "/favicon.ico"} {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", path, nil)
+			srv.router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: %s should be served, got %d", tc.name, path, w.Code)
+			}
+			if w.Body.Len() == 0 {
+				t.Errorf("%s: %s should not be empty", tc.name, path)
+			}
+		}
+		srv.Close()
+	}
+}
+
+// With hot reload in debug mode a template change shows on the next page,
+// without it the templates are parsed only once
+func TestHotReloadTemplates(t *testing.T) {
+	t.Parallel()
+
+	for _, reload := range []bool{true, false} {
+		dir := copyTemplates(t)
+
+		opts := testServerOptions()
+		opts.Templates = dir
+		opts.HotReloadTemplates = reload
+		srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+		get := func() string {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "/", nil)
+			srv.router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+			}
+			return w.Body.String()
+		}
+
+		const marker = "hot-reload-marker"
+		if strings.Contains(get(), marker) {
+			t.Fatalf("the page should not contain the marker before the change")
+		}
+		footer := filepath.Join(dir, "footer.html")
+		b, err := os.ReadFile(footer)
+		if err != nil {
+			t.Fatalf("failed to read the footer: %v", err)
+		}
+		if err := os.WriteFile(footer, append([]byte(marker), b...), 0o600); err != nil {
+			t.Fatalf("failed to change the footer: %v", err)
+		}
+		if got := strings.Contains(get(), marker); got != reload {
+			t.Errorf("reload %v: expected the marker to show %v, got %v", reload, reload, got)
+		}
+		srv.Close()
+	}
+}
+
+// copyTemplates copies the templates to a temporary folder, so that a test
+// can change them, and returns the folder.
+func copyTemplates(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../templates/*.html")
+	if err != nil {
+		t.Fatalf("failed to list the templates: %v", err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(f)), b, 0o600); err != nil {
+			t.Fatalf("failed to copy %s: %v", f, err)
+		}
+	}
+	return dir
+}
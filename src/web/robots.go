@@ -0,0 +1,31 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"io"
+	"net/http"
+)
+
+// defaultRobotsTxt keeps crawlers away from the pages that are not pastes.
+// Public pastes are indexable, the other pastes have a noindex meta tag.
+const defaultRobotsTxt = `User-agent: *
+Disallow: /admin
+Disallow: /api/
+Disallow: /auth/
+Disallow: /diff
+Disallow: /l/
+Disallow: /u/
+`
+
+// handleGetRobots serves /robots.txt from the options or the default one.
+func (h *Server) handleGetRobots(w http.ResponseWriter, r *http.Request) {
+	robots := h.options.RobotsTxt
+	if robots == "" {
+		robots = defaultRobotsTxt
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, robots)
+}
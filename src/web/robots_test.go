@@ -0,0 +1,58 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// Only public pastes can be indexed
+func TestPasteNoindex(t *testing.T) {
+	t.Parallel()
+
+	const noindex = `<meta name="robots" content="noindex">`
+	for privacy, want := range map[string]bool{"public": false, "unlisted": true} {
+		p, err := webSrv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: privacy})
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL(), nil)
+		webSrv.router.ServeHTTP(w, r)
+		if got := strings.Contains(w.Body.String(), noindex); got != want {
+			t.Errorf("%s paste: noindex should be %v, got %v", privacy, want, got)
+		}
+	}
+}
+
+// robots.txt comes from the options or the default
+func TestGetRobots(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/robots.txt", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != defaultRobotsTxt {
+		t.Errorf("Response should be the default robots.txt, got %d [%s]", w.Code, w.Body.String())
+	}
+
+	opts := testServerOptions()
+	opts.RobotsTxt = "User-agent: *\nDisallow: /\n"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, r)
+	if w.Body.String() != opts.RobotsTxt {
+		t.Errorf("Response should be [%s], got [%s]", opts.RobotsTxt, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type should be text/plain, got %s", ct)
+	}
+}
@@ -5,10 +5,17 @@
 package web
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"html"
+	"html/template"
 	"math"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-pkgz/auth/token"
 	"github.com/gorilla/mux"
@@ -17,33 +24,61 @@ import (
 	"github.com/iliafrenkel/go-pb/src/web/page"
 )
 
-// showInternalError writes 500 Internal Server Error page.
+// defaultSupportMessage is shown on internal errors when
+// ServerOptions.SupportMessage is not set.
+const defaultSupportMessage = "Something went wrong on our side and it has been logged. Please try again later."
+
+// fallbackErrorPage is written when the error template itself can't be
+// rendered. It depends on nothing, so the user always gets a readable page.
+const fallbackErrorPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>%[1]d %[2]s</title></head>
+<body>
+<h1>%[1]d %[2]s</h1>
+<p>%[3]s</p>
+<p>Request ID: <code>%[4]s</code></p>
+<p><a href="/">Home</a></p>
+</body>
+</html>
+`
+
+// showInternalError writes 500 Internal Server Error page, or 503 Service
+// Unavailable if the store can't be reached.
 func (h *Server) showInternalError(w http.ResponseWriter, err error) {
-	h.log.Logf("ERROR : %v", err)
+	h.log.Logf("ERROR request %s: %v", w.Header().Get(requestIDHeader), err)
+	status := http.StatusInternalServerError
+	if errors.Is(err, service.ErrStoreUnavailable) {
+		status = http.StatusServiceUnavailable
+	}
 	pastes, users := h.service.GetTotals()
 	totals := page.Stats{
 		Pastes: pastes,
 		Users:  users,
 	}
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-	w.WriteHeader(http.StatusInternalServerError)
-	p := page.New(h.templates,
+	p := page.New(h.currentTemplates(),
 		page.Template("error.html"),
 		page.Brand(h.options.BrandName),
 		page.Tagline(h.options.BrandTagline),
-		page.Logo(h.options.Logo),
+		page.Logo(h.logo),
 		page.Theme(h.options.BootstrapTheme),
 		page.Server(h.options.Proto+"://"+h.options.Addr),
 		page.Version(h.options.Version),
+		page.Footer(h.options.FooterHTML),
 		page.Totals(totals),
+		page.Providers(h.providers),
+		page.RequestID(w.Header().Get(requestIDHeader)),
 		page.Title(h.options.BrandName+" - Error"),
-		page.ErrorCode(http.StatusInternalServerError),
-		page.ErrorText(http.StatusText(http.StatusInternalServerError)),
+		page.ErrorCode(status),
+		page.ErrorText(http.StatusText(status)),
+		page.ErrorMessage(h.options.supportMessage()),
 	)
 
-	e := p.Show(w)
-	if e != nil {
+	if e := h.render(w, status, p); e != nil {
 		h.log.Logf("ERROR showInternalError: failed to generate page: %v", e)
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, fallbackErrorPage, status, http.StatusText(status),
+			html.EscapeString(h.options.supportMessage()), html.EscapeString(w.Header().Get(requestIDHeader)))
 	}
 }
 
@@ -54,66 +89,116 @@ func (h *Server) showError(w http.ResponseWriter, httpError int, msg string) {
 		Pastes: pastes,
 		Users:  users,
 	}
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-	w.WriteHeader(httpError)
-	p := page.New(h.templates,
+	p := page.New(h.currentTemplates(),
 		page.Template("error.html"),
 		page.Brand(h.options.BrandName),
 		page.Tagline(h.options.BrandTagline),
-		page.Logo(h.options.Logo),
+		page.Logo(h.logo),
 		page.Theme(h.options.BootstrapTheme),
 		page.Server(h.options.Proto+"://"+h.options.Addr),
 		page.Version(h.options.Version),
+		page.Footer(h.options.FooterHTML),
 		page.Totals(totals),
+		page.Providers(h.providers),
+		page.RequestID(w.Header().Get(requestIDHeader)),
 		page.Title(h.options.BrandName+" - Error"),
 		page.ErrorCode(httpError),
 		page.ErrorText(http.StatusText(httpError)),
 		page.ErrorMessage(msg),
 	)
 
-	e := p.Show(w)
-	if e != nil {
+	if e := h.render(w, httpError, p); e != nil {
 		h.log.Logf("ERROR showError: failed to generate page: %v", e)
+		http.Error(w, msg, httpError)
 	}
 }
 
-// showPage generates a page and writes to the response
+// showPage generates a page and writes to the response. If the page can't
+// be generated 500 Internal Server Error page is shown instead.
 func (h *Server) showPage(w http.ResponseWriter, data ...page.Data) {
+	h.showPageStatus(w, http.StatusOK, data...)
+}
+
+// showPageStatus is showPage that writes the page with the given status.
+func (h *Server) showPageStatus(w http.ResponseWriter, status int, data ...page.Data) {
 	pastes, users := h.service.GetTotals()
 	totals := page.Stats{
 		Pastes: pastes,
 		Users:  users,
 	}
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-	p := page.New(h.templates,
+	p := page.New(h.currentTemplates(),
 		page.Brand(h.options.BrandName),
 		page.Tagline(h.options.BrandTagline),
-		page.Logo(h.options.Logo),
+		page.Logo(h.logo),
 		page.Theme(h.options.BootstrapTheme),
 		page.Server(h.options.Proto+"://"+h.options.Addr),
 		page.Version(h.options.Version),
+		page.Footer(h.options.FooterHTML),
 		page.Totals(totals),
+		page.Providers(h.providers),
+		page.RequestID(w.Header().Get(requestIDHeader)),
+		page.Expirations(h.expirations),
+		page.TrashKeep(h.options.TrashRetention),
+		page.ReadOnly(h.readOnly.Load()),
+		page.HighlightThemes(service.HighlightThemes),
 	)
 	for _, d := range data {
 		d(p)
 	}
 
-	e := p.Show(w)
-	if e != nil {
-		h.log.Logf("ERROR showPage: failed to generate page: %v", e)
+	if e := h.render(w, status, p); e != nil {
+		h.showInternalError(w, fmt.Errorf("showPage: failed to generate page: %w", e))
+	}
+}
+
+// render generates the page and only writes it, with the status, if that
+// fully succeeded, so that a template error doesn't send a broken page.
+// Nothing is written to the response when an error is returned.
+func (h *Server) render(w http.ResponseWriter, status int, p *page.Page) error {
+	var buf bytes.Buffer
+	if err := p.Show(&buf); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		h.log.Logf("WARN failed to write the page: %v", err)
+	}
+	return nil
+}
+
+// paginate returns a paginator for count items shown limit per page.
+func paginate(count int64, limit, skip int) page.Paginator {
+	pageCount := int(math.Ceil(float64(count) / float64(limit)))
+	paginator := page.Paginator{
+		Current:    skip/limit + 1,
+		Offset:     skip,
+		Last:       pageCount,
+		LastOffset: (pageCount - 1) * limit,
+		Pages:      make([]page.PaginatorLink, pageCount),
+	}
+	for i := 1; i <= pageCount; i++ {
+		paginator.Pages[i-1] = page.PaginatorLink{
+			Number: i,
+			Offset: (i - 1) * limit,
+		}
 	}
+	return paginator
 }
 
-//getUserPastes returns 10 most recent posts for the user. If there is no user
-// anonymous user is assumed and 10 most recent public pastes are retuned.
+//getUserPastes returns the most recent posts for the user. If there is no user
+// anonymous user is assumed and the most recent public pastes are retuned.
 func (h *Server) getUserPastes(uid string) (pastes []store.Paste, err error) {
-	if uid == "" {
-		pastes, err = h.service.GetPastes("", "-created", 10, 0, "public")
-	} else {
-		pastes, err = h.service.GetPastes(uid, "-created", 10, 0, "")
+	return h.service.UserPastes(uid)
+}
+
+// clientIP returns the IP address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return
+	return host
 }
 
 // handleGetHomePage shows the homepage in response to a GET / request.
@@ -126,19 +211,44 @@ func (h *Server) handleGetHomePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var msg string
+	if r.FormValue("reported") == "yes" {
+		msg = "Thank you, the paste has been reported."
+	}
+
+	// the new paste form is still at /p/ for anonymous visitors
+	if h.landing != "" && usr.ID == "" && r.URL.Path == "/" {
+		h.showPage(w,
+			page.Template("landing.html"),
+			page.Title(h.options.BrandName+" - Home"),
+			page.UserPastes(pastes),
+			page.Message(msg),
+			page.Landing(h.landing),
+		)
+		return
+	}
+
 	h.showPage(w,
 		page.Template("index.html"),
 		page.Title(h.options.BrandName+" - Home"),
 		page.UserPastes(pastes),
 		page.User(usr),
+		page.Message(msg),
+		page.DefaultPrivacy(h.options.defaultPrivacy(usr.ID != "")),
+		page.DefaultExpiration(h.options.defaultExpiration()),
+		page.RequireLogin(h.options.RequireLoginToPaste),
 	)
 }
 
 // handlePostPaste creates new paste from the form data
 func (h *Server) handlePostPaste(w http.ResponseWriter, r *http.Request) {
 	usr, _ := token.GetUserInfo(r)
+	if h.options.RequireLoginToPaste && usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to create pastes.")
+		return
+	}
 	// Read the form data
-	r.Body = http.MaxBytesReader(w, r.Body, h.options.MaxBodySize)
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
 	if err := r.ParseForm(); err != nil {
 		h.log.Logf("WARN parsing form failed: %v", err)
 		h.showError(w, http.StatusBadRequest, "")
@@ -161,29 +271,78 @@ func (h *Server) handlePostPaste(w http.ResponseWriter, r *http.Request) {
 		Body:            r.PostFormValue("body"),
 		Expires:         r.PostFormValue("expires"),
 		DeleteAfterRead: r.PostFormValue("delete_after_read") == "yes",
+		BlurUntilClick:  r.PostFormValue("blur_until_click") == "yes",
+		AllowEmbed:      r.PostFormValue("allow_embed") == "yes",
 		Privacy:         r.PostFormValue("privacy"),
 		Password:        r.PostFormValue("password"),
 		Syntax:          r.PostFormValue("syntax"),
+		Theme:           r.PostFormValue("theme"),
 		UserID:          usr.ID,
+		IP:              clientIP(r),
+	}
+	if pr.Expires == "" {
+		pr.Expires = h.options.defaultExpiration()
+	}
+	if pr.Privacy == "" {
+		pr.Privacy = h.options.defaultPrivacy(usr.ID != "")
 	}
-	paste, err := h.service.NewPaste(pr)
+	if err := h.options.checkPastePolicy(pr); err != nil {
+		h.showError(w, http.StatusBadRequest, fmt.Sprintf("Sorry, %v.", err))
+		return
+	}
+	if h.blockedContent(pr) {
+		if !h.options.UnlistBlocked {
+			h.showError(w, http.StatusBadRequest, "Sorry, this content is not allowed in public pastes.")
+			return
+		}
+		pr.Privacy = "unlisted"
+	}
+	if r.PostFormValue("confirm") != "yes" {
+		if warnings := h.pasteWarnings(pr.Body); len(warnings) > 0 {
+			h.showConfirm(w, r, usr, warnings)
+			return
+		}
+	}
+	paste, err := h.service.NewPasteCtx(r.Context(), pr)
 	if err != nil {
 		if errors.Is(err, service.ErrEmptyBody) {
 			h.showError(w, http.StatusBadRequest, "Body must not be empty.")
 			return
 		}
+		if errors.Is(err, service.ErrInvalidEncoding) {
+			h.showError(w, http.StatusBadRequest, "Body must be UTF-8 or Windows-1252 text.")
+			return
+		}
 		if errors.Is(err, service.ErrWrongPrivacy) {
 			h.showError(w, http.StatusBadRequest, "Privacy can be one of 'private', 'public' or 'unlisted'.")
 			return
 		}
+		if errors.Is(err, service.ErrTitleTooLong) {
+			h.showError(w, http.StatusBadRequest, fmt.Sprintf("Title can be at most %d characters long.", h.options.MaxTitleLength))
+			return
+		}
 		if errors.Is(err, service.ErrWrongDuration) {
 			h.showError(w, http.StatusBadRequest, "Duration format is incorrect.")
 			return
 		}
+		if errors.Is(err, service.ErrPasteLimitReached) {
+			h.showError(w, http.StatusForbidden, "You have reached the maximum number of pastes. Please delete some of your pastes or wait for them to expire.")
+			return
+		}
+		if errors.Is(err, service.ErrConflict) {
+			h.showError(w, http.StatusConflict, "The paste couldn't be saved, please try again.")
+			return
+		}
 		// Some bad thing happened and we don't know what to do
 		h.showInternalError(w, err)
 		return
 	}
+	h.metrics.pastesCreated.Add(1)
+	h.notifyPasteCreated(r, paste)
+	// The page is returned right away instead of a redirect, the headers
+	// let scripts find the new paste without parsing the HTML
+	w.Header().Set("Location", "/p/"+paste.URL())
+	w.Header().Set("X-Paste-URL", h.pasteURL(r, paste))
 	// Get a list of user pastes
 	pastes, err := h.getUserPastes(usr.ID)
 	if err != nil {
@@ -191,15 +350,69 @@ func (h *Server) handlePostPaste(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	files := h.renderFiles(paste)
 	h.showPage(w,
 		page.Template("view.html"),
 		page.Title(h.options.BrandName+" - Paste"),
 		page.UserPastes(pastes),
 		page.Paste(paste),
+		page.Code(h.renderPaste(paste)),
+		page.Markdown(h.renderMarkdown(r, paste, files)),
+		page.Files(files),
+		page.Comments(h.commentsOn(paste), nil),
+		page.HighlightTheme(h.highlightTheme(w, r, paste)),
+		page.ClaimToken(paste.ClaimToken),
 		page.User(usr),
+		page.Server(h.serverURL(r)),
 	)
 }
 
+// renderPaste returns highlighted paste body. RenderHTML falls back to
+// plain text on errors so the error is only logged.
+func (h *Server) renderPaste(paste store.Paste) template.HTML {
+	code, err := service.RenderHTML(paste)
+	if err != nil {
+		h.log.Logf("WARN renderPaste: %v", err)
+	}
+	return code
+}
+
+// renderMarkdown renders the body of a markdown paste and its markdown
+// files, unless the reader asked for the source with ?source=yes. It returns
+// the rendered body, empty if the paste is not markdown.
+func (h *Server) renderMarkdown(r *http.Request, paste store.Paste, files []page.File) template.HTML {
+	if r.FormValue("source") == "yes" {
+		return ""
+	}
+	for i := range files {
+		if files[i].Syntax == "markdown" {
+			files[i].Markdown = service.RenderMarkdown(files[i].Body)
+		}
+	}
+	if paste.Syntax != "markdown" {
+		return ""
+	}
+	return service.RenderMarkdown(paste.Body)
+}
+
+// renderFiles returns highlighted files of a multi-file paste or nil if
+// the paste has a single body.
+func (h *Server) renderFiles(paste store.Paste) []page.File {
+	if len(paste.Files) == 0 {
+		return nil
+	}
+	files := make([]page.File, 0, len(paste.Files))
+	for _, f := range paste.Files {
+		files = append(files, page.File{
+			Name:   f.Name,
+			Syntax: f.Syntax,
+			Body:   f.Body,
+			Code:   h.renderPaste(store.Paste{Body: f.Body, Syntax: f.Syntax}),
+		})
+	}
+	return files
+}
+
 // handleGetPastePage generates a page to view a single paste.
 func (h *Server) handleGetPastePage(w http.ResponseWriter, r *http.Request) {
 	usr, _ := token.GetUserInfo(r)
@@ -219,8 +432,14 @@ func (h *Server) handleGetPastePage(w http.ResponseWriter, r *http.Request) {
 	}
 	pwd := r.PostFormValue("password")
 
-	// Get the paste from the storage
-	paste, err := h.service.GetPaste(id, usr.ID, pwd)
+	// Get the paste from the storage, HEAD requests and bots neither count
+	// the view nor burn the paste
+	getPaste := h.service.PeekPasteCtx
+	countView := h.countView(r)
+	if countView {
+		getPaste = h.service.GetPasteCtx
+	}
+	paste, err := getPaste(r.Context(), id, usr.ID, pwd)
 	if err != nil {
 		// Check if paste was not found
 		if errors.Is(err, service.ErrPasteNotFound) {
@@ -234,8 +453,7 @@ func (h *Server) handleGetPastePage(w http.ResponseWriter, r *http.Request) {
 		}
 		// Check if paste is password-protected
 		if errors.Is(err, service.ErrPasteHasPassword) || errors.Is(err, service.ErrWrongPassword) {
-			w.WriteHeader(http.StatusUnauthorized)
-			h.showPage(w,
+			h.showPageStatus(w, http.StatusUnauthorized,
 				page.Template("password.html"),
 				page.Title(h.options.BrandName+" - Password"),
 				page.PasteID(id),
@@ -248,6 +466,9 @@ func (h *Server) handleGetPastePage(w http.ResponseWriter, r *http.Request) {
 		h.showInternalError(w, err)
 		return
 	}
+	if countView {
+		h.metrics.pastesViewed.Add(1)
+	}
 
 	// Get user pastes
 	pastes, err := h.getUserPastes(usr.ID)
@@ -256,16 +477,85 @@ func (h *Server) handleGetPastePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Get the comments
+	var comments []store.Comment
+	if h.commentsOn(paste) {
+		comments, err = h.service.PasteComments(id, usr.ID)
+		if err != nil {
+			h.showInternalError(w, err)
+			return
+		}
+	}
+
+	files := h.renderFiles(paste)
 	h.showPage(w,
 		page.Template("view.html"),
 		page.Title(h.options.BrandName+" - Paste"),
 		page.UserPastes(pastes),
 		page.Paste(paste),
+		page.Code(h.renderPaste(paste)),
+		page.Markdown(h.renderMarkdown(r, paste, files)),
+		page.Files(files),
+		page.Comments(h.commentsOn(paste), comments),
+		page.HighlightTheme(h.highlightTheme(w, r, paste)),
 		page.User(usr),
+		page.Server(h.serverURL(r)),
 	)
 }
 
-// handleGetPastesList generates a page to view a list of pastes.
+// handleGetDiff generates a page with the difference between two pastes.
+func (h *Server) handleGetDiff(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	if err := r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	a, b := r.Form.Get("a"), r.Form.Get("b")
+	if a == "" || b == "" {
+		h.showError(w, http.StatusBadRequest, "Please provide two pastes to compare.")
+		return
+	}
+
+	diff, err := h.service.DiffPastes(a, b, usr.ID, r.PostFormValue("password"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPasteNotFound):
+			h.showError(w, http.StatusNotFound, "There is no such paste")
+		case errors.Is(err, service.ErrPasteIsPrivate):
+			h.showError(w, http.StatusForbidden, "This paste is private")
+		case errors.Is(err, service.ErrPasteHasPassword), errors.Is(err, service.ErrWrongPassword):
+			h.showError(w, http.StatusUnauthorized, "This paste is protected by a password")
+		case errors.Is(err, service.ErrDiffTooLarge):
+			h.showError(w, http.StatusBadRequest, "The pastes are too large to compare.")
+		case errors.Is(err, service.ErrDiffBurner):
+			h.showError(w, http.StatusBadRequest, "Pastes that are deleted after reading can't be compared.")
+		case errors.Is(err, service.ErrStoreFailure), errors.Is(err, service.ErrStoreUnavailable):
+			h.showInternalError(w, err)
+		default:
+			h.showError(w, http.StatusNotFound, "There is no such paste")
+		}
+		return
+	}
+
+	pastes, err := h.getUserPastes(usr.ID)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+
+	h.showPage(w,
+		page.Template("diff.html"),
+		page.Title(h.options.BrandName+" - Diff"),
+		page.UserPastes(pastes),
+		page.Diff(a, b, diff),
+		page.User(usr),
+	)
+}
+
+// handleGetPastesList generates a page to view a list of pastes. Logged in
+// users see all their pastes, whatever the privacy, everyone else sees the
+// public ones.
 func (h *Server) handleGetPastesList(w http.ResponseWriter, r *http.Request) {
 	usr, _ := token.GetUserInfo(r)
 	limit := 10 //TODO: make it configurable as PageSize or MaxPastesPerPage
@@ -277,10 +567,10 @@ func (h *Server) handleGetPastesList(w http.ResponseWriter, r *http.Request) {
 	var pastes []store.Paste
 	var count int64
 	if usr.ID != "" {
-		pastes, err = h.service.GetPastes(usr.ID, "-created", limit, skip, "")
+		pastes, err = h.service.GetPastesCtx(r.Context(), usr.ID, "-created", limit, skip, "")
 		count = h.service.PastesCount(usr.ID, "")
 	} else {
-		pastes, err = h.service.GetPastes("", "-created", limit, skip, "public")
+		pastes, err = h.service.GetPastesCtx(r.Context(), "", "-created", limit, skip, "public")
 		count = h.service.PastesCount("", "public")
 	}
 	if err != nil {
@@ -309,35 +599,156 @@ func (h *Server) handleGetPastesList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expiring, err := h.service.ExpiringPastesCtx(r.Context(), usr.ID, h.options.ExpiringSoon)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+
+	var msg string
+	if deleted, err := strconv.Atoi(r.FormValue("deleted")); err == nil {
+		msg = fmt.Sprintf("Deleted %d paste(s).", deleted)
+	}
+	if trashed, err := strconv.Atoi(r.FormValue("trashed")); err == nil {
+		msg = fmt.Sprintf("Moved %d paste(s) to the trash.", trashed)
+	}
+	if r.FormValue("transferred") == "yes" {
+		msg = "The paste has been transferred."
+	}
+	if r.FormValue("claimed") == "yes" {
+		msg = "The paste is now yours."
+	}
+
 	h.showPage(w,
 		page.Template("list.html"),
 		page.Title(h.options.BrandName+" - Pastes"),
 		page.Pastes(pastes),
 		page.UserPastes(userPastes),
+		page.ExpiringPastes(expiring),
 		page.PageLinks(paginator),
 		page.User(usr),
+		page.Message(msg),
 	)
 }
 
-// handleGetArchive generates an archive page to view a list of public pastes.
+// handlePostDeletePastes deletes the selected or all the expired pastes of
+// the current user and redirects back to the list with a summary.
+func (h *Server) handlePostDeletePastes(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to delete pastes.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.showError(w, http.StatusBadRequest, "Cannot parse the form.")
+		return
+	}
+
+	var deleted int
+	if r.PostForm.Get("expired") == "yes" {
+		deleted, err = h.service.DeleteExpiredPastes(usr.ID)
+	} else {
+		ids := make([]int64, 0, len(r.PostForm["ids"]))
+		for _, v := range r.PostForm["ids"] {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				h.showError(w, http.StatusBadRequest, "Invalid paste ID "+v)
+				return
+			}
+			ids = append(ids, id)
+		}
+		deleted, err = h.service.DeletePastes(ids, usr.ID)
+		if err == nil && h.options.TrashRetention > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/l/?trashed=%d", deleted), http.StatusSeeOther)
+			return
+		}
+	}
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/l/?deleted=%d", deleted), http.StatusSeeOther)
+}
+
+// sortOrders is a list of sort orders supported by the stores.
+var sortOrders = []string{"-created", "+created", "-views", "+views", "-expires", "+expires"}
+
+// parseSort returns a valid sort order from the query value. Unencoded "+"
+// in the query is decoded as a space so we treat leading space as "+".
+// Unknown values fall back to "-created".
+func parseSort(s string) string {
+	if strings.HasPrefix(s, " ") {
+		s = "+" + s[1:]
+	}
+	for _, so := range sortOrders {
+		if s == so {
+			return s
+		}
+	}
+	return "-created"
+}
+
+// handleGetArchive generates an archive page to view a list of public pastes,
+// optionally only the ones with the syntax from the query. An unknown syntax
+// is ignored.
 func (h *Server) handleGetArchive(w http.ResponseWriter, r *http.Request) {
 	usr, _ := token.GetUserInfo(r)
 	limit := 10 //TODO: make it configurable as PageSize or MaxPastesPerPage
 	skip, err := strconv.Atoi(r.FormValue("skip"))
+	if err != nil || skip < 0 {
+		skip = 0
+	}
+	sort := parseSort(r.FormValue("sort"))
+	syntax := r.FormValue("syntax")
+	if !service.KnownSyntax(syntax) {
+		syntax = ""
+	}
+
+	pastes, err := h.service.ArchivePastesCtx(r.Context(), syntax, sort, limit, skip)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	paginator := paginate(h.service.ArchiveCount(syntax), limit, skip)
+
+	userPastes, err := h.getUserPastes(usr.ID)
 	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+
+	h.showPage(w,
+		page.Template("archive.html"),
+		page.Title(h.options.BrandName+" - Archive"),
+		page.Pastes(pastes),
+		page.UserPastes(userPastes),
+		page.PageLinks(paginator),
+		page.Sort(sort),
+		page.Syntax(syntax),
+		page.Syntaxes(service.Syntaxes()),
+		page.User(usr),
+	)
+}
+
+// handleGetTrending generates a page with the most viewed public pastes.
+func (h *Server) handleGetTrending(w http.ResponseWriter, r *http.Request) {
+	usr, _ := token.GetUserInfo(r)
+	limit := 10 //TODO: make it configurable as PageSize or MaxPastesPerPage
+	skip, err := strconv.Atoi(r.FormValue("skip"))
+	if err != nil || skip < 0 {
 		skip = 0
 	}
 
-	pastes, err := h.service.GetPastes("", "-created", limit, skip, "public")
+	trending, err := h.service.TrendingPastesCtx(r.Context(), service.MaxTrending)
 	if err != nil {
 		h.showInternalError(w, err)
 		return
 	}
-	count := h.service.PastesCount("", "public")
-	pageCount := int(math.Ceil(float64(count) / float64(limit))) // number of pages
+	pageCount := int(math.Ceil(float64(len(trending)) / float64(limit))) // number of pages
 
 	paginator := page.Paginator{
 		Current:    skip/limit + 1,
+		Offset:     skip,
 		Last:       pageCount,
 		LastOffset: (pageCount - 1) * limit,
 		Pages:      make([]page.PaginatorLink, pageCount),
@@ -350,6 +761,15 @@ func (h *Server) handleGetArchive(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Slice the current page out of the trending list
+	if skip > len(trending) {
+		skip = len(trending)
+	}
+	end := skip + limit
+	if end > len(trending) {
+		end = len(trending)
+	}
+
 	userPastes, err := h.getUserPastes(usr.ID)
 	if err != nil {
 		h.showInternalError(w, err)
@@ -357,15 +777,82 @@ func (h *Server) handleGetArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.showPage(w,
-		page.Template("archive.html"),
-		page.Title(h.options.BrandName+" - Archive"),
-		page.Pastes(pastes),
+		page.Template("trending.html"),
+		page.Title(h.options.BrandName+" - Trending"),
+		page.Pastes(trending[skip:end]),
 		page.UserPastes(userPastes),
 		page.PageLinks(paginator),
 		page.User(usr),
 	)
 }
 
+// handleGetDeleteUser shows a page to confirm account deletion.
+func (h *Server) handleGetDeleteUser(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to delete your account.")
+		return
+	}
+
+	h.showPage(w,
+		page.Template("delete.html"),
+		page.Title(h.options.BrandName+" - Delete account"),
+		page.User(usr),
+	)
+}
+
+// handlePostDeleteUser deletes the logged in user with all the pastes and
+// logs the user out.
+func (h *Server) handlePostDeleteUser(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to delete your account.")
+		return
+	}
+	if err = r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	if r.PostFormValue("confirm") != "yes" {
+		h.showError(w, http.StatusBadRequest, "Please confirm that you want to delete your account.")
+		return
+	}
+
+	if err = h.service.DeleteUser(usr.ID); err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO user %s deleted", usr.ID)
+
+	h.auth.TokenService().Reset(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleGetHealth reports whether the server and its store are healthy.
+func (h *Server) handleGetHealth(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	resp := map[string]string{
+		"status":  "ok",
+		"version": h.options.Version,
+	}
+	if err := h.service.Ping(); err != nil {
+		h.log.Logf("ERROR health check failed: %v", err)
+		status = http.StatusServiceUnavailable
+		resp["status"] = "error"
+		resp["error"] = err.Error()
+	} else if h.draining.Load() {
+		status = http.StatusServiceUnavailable
+		resp["status"] = "draining"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.log.Logf("ERROR handleGetHealth: failed to write response: %v", err)
+	}
+}
+
 // Show 404 Not Found error page
 func (h *Server) notFound(w http.ResponseWriter, r *http.Request) {
 	h.showError(w, http.StatusNotFound, "Unfortunately the page you are looking for is not there 🙁")
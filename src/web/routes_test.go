@@ -1,19 +1,26 @@
 package web
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-pkgz/auth"
 	"github.com/go-pkgz/auth/token"
 	"github.com/go-pkgz/lgr"
 	"github.com/iliafrenkel/go-pb/src/service"
 	"github.com/iliafrenkel/go-pb/src/store"
+	"github.com/iliafrenkel/go-pb/src/web/page"
 )
 
 var webSrv *Server
@@ -23,7 +30,14 @@ var webSrv *Server
 func TestMain(m *testing.M) {
 	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
 
-	webSrv = New(log, ServerOptions{
+	webSrv = New(log, testServerOptions())
+
+	os.Exit(m.Run())
+}
+
+// testServerOptions returns server options suitable for testing.
+func testServerOptions() ServerOptions {
+	return ServerOptions{
 		Addr:               "localhost:8080",
 		Proto:              "http",
 		ReadTimeout:        2,
@@ -43,9 +57,9 @@ func TestMain(m *testing.M) {
 		AuthIssuer:         "go-pb test",
 		AuthURL:            "http://localhost:8080",
 		DBType:             "memory",
-	})
-
-	os.Exit(m.Run())
+		EnableMetrics:      true,
+		AvatarDir:          filepath.Join(os.TempDir(), "go-pb-test-avatars"),
+	}
 }
 
 // TestGetHomePage verifies the GET / route handler. It checks that the home
@@ -72,7 +86,114 @@ func TestGetHomePage(t *testing.T) {
 	}
 }
 
+// TestGetHomePageFooter verifies that the configured footer HTML is shown
+// on the home page as is.
+func TestGetHomePageFooter(t *testing.T) {
+	t.Parallel()
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.FooterHTML = `<a href="/privacy">Privacy policy</a>`
+	srv := New(log, opts)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	srv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Body.String(); !strings.Contains(got, opts.FooterHTML) {
+		t.Errorf("Response should have footer [%s], got [%s]", opts.FooterHTML, got)
+	}
+}
+
 // TestPostPasteDefaults create a paste with just the required fields.
+// A template that fails to execute shows the error page with 500 rather
+// than a truncated page with 200
+func TestGetHomePageBrokenTemplate(t *testing.T) {
+	t.Parallel()
+
+	dir := copyTemplates(t)
+	index := filepath.Join(dir, "index.html")
+	b, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatalf("failed to read the index template: %v", err)
+	}
+	b = append(b, []byte("{{.NoSuchField}}")...)
+	if err := os.WriteFile(index, b, 0o600); err != nil {
+		t.Fatalf("failed to break the index template: %v", err)
+	}
+
+	opts := testServerOptions()
+	opts.Templates = dir
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	srv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if strings.Contains(w.Body.String(), `action="/p/"`) {
+		t.Errorf("the broken page should not be sent, got:\n%s", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), http.StatusText(http.StatusInternalServerError)) {
+		t.Errorf("expected the error page, got:\n%s", w.Body.String())
+	}
+}
+
+// The internal error page shows the support message, and when the error
+// template can't be rendered either a minimal page is written instead
+func TestInternalErrorFallback(t *testing.T) {
+	t.Parallel()
+
+	for _, missing := range []bool{false, true} {
+		dir := copyTemplates(t)
+		index := filepath.Join(dir, "index.html")
+		b, err := os.ReadFile(index)
+		if err != nil {
+			t.Fatalf("failed to read the index template: %v", err)
+		}
+		if err := os.WriteFile(index, append(b, []byte("{{.NoSuchField}}")...), 0o600); err != nil {
+			t.Fatalf("failed to break the index template: %v", err)
+		}
+		if missing {
+			if err := os.Remove(filepath.Join(dir, "error.html")); err != nil {
+				t.Fatalf("failed to remove the error template: %v", err)
+			}
+		}
+
+		opts := testServerOptions()
+		opts.Templates = dir
+		opts.SupportMessage = "Write to <support@example.com>"
+		srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		srv.router.ServeHTTP(w, r)
+		srv.Close()
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("missing %v: expected %d, got %d", missing, http.StatusInternalServerError, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("missing %v: expected an HTML page, got %q", missing, ct)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "Write to &lt;support@example.com&gt;") {
+			t.Errorf("missing %v: expected the escaped support message, got:\n%s", missing, body)
+		}
+		if !strings.Contains(body, w.Header().Get(requestIDHeader)) {
+			t.Errorf("missing %v: expected the request ID, got:\n%s", missing, body)
+		}
+		if got := strings.Contains(body, "<h1>500 Internal Server Error</h1>"); got != missing {
+			t.Errorf("missing %v: expected the fallback page %v, got %v", missing, missing, got)
+		}
+	}
+}
+
 func TestPostPasteDefaults(t *testing.T) {
 	t.Parallel()
 	w := httptest.NewRecorder()
@@ -93,10 +214,293 @@ func TestPostPasteDefaults(t *testing.T) {
 		t.Errorf("Response should have title [%s], got [%s]", want, got)
 	}
 
-	want = `<code class="py-3 language-text">Test body</code>`
+	want = `<code class="py-3 language-text"><span id="L1" class="line"><a class="line-number" href="#L1">1</a>Test body</span></code>`
 	if !strings.Contains(got, want) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
+
+	loc := w.Header().Get("Location")
+	if !strings.HasPrefix(loc, "/p/") || len(loc) == len("/p/") {
+		t.Errorf("Location should point to the new paste, got [%s]", loc)
+	}
+	want = webSrv.options.Proto + "://" + webSrv.options.Addr + loc
+	if got := w.Header().Get("X-Paste-URL"); got != want {
+		t.Errorf("X-Paste-URL should be [%s], got [%s]", want, got)
+	}
+}
+
+// Windows-1252 bodies are converted to UTF-8 and the ones that can't be are rejected
+func TestPostPasteEncoding(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.BodyEncoding = "convert"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		want string
+	}{
+		{"windows-1252", "Caf\xe9 \x805", http.StatusOK, "Café €5"},
+		{"undefined byte", "abc\x81", http.StatusBadRequest, "Body must be UTF-8 or Windows-1252 text."},
+	}
+	for _, tc := range tests {
+		form := url.Values{}
+		form.Add("body", tc.body)
+		form.Add("privacy", "public")
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader(form.Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: status should be %d, got %d", tc.name, tc.code, w.Code)
+		}
+		if got := w.Body.String(); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: response should contain [%s], got [%s]", tc.name, tc.want, got)
+		}
+		if tc.code == http.StatusOK && !strings.Contains(w.Body.String(), `title="Converted to UTF-8 from windows-1252"`) {
+			t.Errorf("%s: response should show the encoding", tc.name)
+		}
+	}
+}
+
+// Pastes without privacy get the default for anonymous or logged in users
+func TestPostPasteDefaultPrivacy(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.DefaultPrivacyAnon = "unlisted"
+	opts.DefaultPrivacyUser = "private"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	tests := []struct {
+		name    string
+		usr     *token.User
+		privacy string
+		want    string
+	}{
+		{"anonymous", nil, "unlisted", `title="Unlisted"`},
+		{"logged in", &token.User{ID: "test_user_default_privacy", Name: "Test User"}, "private", `title="Private"`},
+	}
+	for _, tc := range tests {
+		// the form preselects the default
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tc.usr != nil {
+			r = token.SetUserInfo(r, *tc.usr)
+		}
+		srv.router.ServeHTTP(w, r)
+		selected := fmt.Sprintf(`<option value="%s" selected>`, tc.privacy)
+		if !strings.Contains(w.Body.String(), selected) {
+			t.Errorf("%s: the form should preselect [%s]", tc.name, selected)
+		}
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", "/p/", strings.NewReader("body=Test+body"))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if tc.usr != nil {
+			r = token.SetUserInfo(r, *tc.usr)
+		}
+		srv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status should be %d, got %d", tc.name, http.StatusOK, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: the paste should be %s", tc.name, tc.want)
+		}
+	}
+
+	// the form value wins over the default
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/p/", strings.NewReader("body=Test+body&privacy=public"))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	srv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `title="Public"`) {
+		t.Errorf("the paste should be public")
+	}
+}
+
+// The default expiration applies only when the request doesn't set one
+func TestPostPasteDefaultExpiration(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.DefaultExpiration = "1d"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	srv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `<option value="1d" selected>`) {
+		t.Errorf("the form should preselect the default expiration")
+	}
+
+	expires := func(body string) time.Time {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+		url := strings.TrimPrefix(w.Header().Get("Location"), "/p/")
+		if url == "" {
+			t.Fatalf("expected the paste to be created, got %d %s", w.Code, w.Body.String())
+		}
+		p, err := srv.service.GetPaste(url, "", "")
+		if err != nil {
+			t.Fatalf("failed to get the paste: %v", err)
+		}
+		return p.Expires
+	}
+
+	day := time.Now().Add(24 * time.Hour)
+	if got := expires("body=Test+body"); got.Before(day.Add(-time.Minute)) || got.After(day.Add(time.Minute)) {
+		t.Errorf("expected the paste to expire in a day, got %v", got)
+	}
+	if got := expires("body=Test+body&expires=never"); !got.IsZero() {
+		t.Errorf("expected an explicit never to win, got %v", got)
+	}
+	if got := expires("body=Test+body&expires=1h"); got.After(time.Now().Add(time.Hour + time.Minute)) {
+		t.Errorf("expected an explicit expiration to win, got %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/api/v1/paste", strings.NewReader(`{"body":"Test body","privacy":"public"}`))
+	r.Header.Add("Content-Type", "application/json")
+	srv.router.ServeHTTP(w, r)
+	var p struct {
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode the API response: %v", err)
+	}
+	if p.Expires.Before(day.Add(-time.Minute)) {
+		t.Errorf("expected the API paste to expire in a day, got %v", p.Expires)
+	}
+}
+
+// Anonymous pastes are capped to the anonymous expiration, even the never
+// expiring ones, while the pastes of logged in users are not
+func TestPostPasteAnonExpiration(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.AnonMaxExpiration = 24 * time.Hour
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	expires := func(usr *token.User) time.Time {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader("body=Test+body&expires=never&privacy=public"))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		uid := ""
+		if usr != nil {
+			r = token.SetUserInfo(r, *usr)
+			uid = usr.ID
+		}
+		srv.router.ServeHTTP(w, r)
+		url := strings.TrimPrefix(w.Header().Get("Location"), "/p/")
+		if url == "" {
+			t.Fatalf("expected the paste to be created, got %d %s", w.Code, w.Body.String())
+		}
+		p, err := srv.service.GetPaste(url, uid, "")
+		if err != nil {
+			t.Fatalf("failed to get the paste: %v", err)
+		}
+		return p.Expires
+	}
+
+	day := time.Now().Add(24 * time.Hour)
+	if got := expires(nil); got.Before(day.Add(-time.Minute)) || got.After(day.Add(time.Minute)) {
+		t.Errorf("expected the anonymous paste to expire in a day, got %v", got)
+	}
+	if got := expires(&token.User{ID: "test_user_anon_expiration", Name: "Test User"}); !got.IsZero() {
+		t.Errorf("expected the logged in user's paste to never expire, got %v", got)
+	}
+}
+
+// Long titles are rejected, or cut with TruncateTitles
+func TestPostPasteTitleLimit(t *testing.T) {
+	t.Parallel()
+
+	post := func(truncate bool) *httptest.ResponseRecorder {
+		t.Helper()
+		opts := testServerOptions()
+		opts.MaxTitleLength = 8
+		opts.TruncateTitles = truncate
+		srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader("title=A+very+long+title&body=Test+body&privacy=public"))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post(false); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at most 8 characters") {
+		t.Errorf("expected status %d and the limit in the message, got %d", http.StatusBadRequest, w.Code)
+	}
+	w := post(true)
+	if w.Code != http.StatusOK || w.Header().Get("Location") == "" {
+		t.Fatalf("expected the paste to be created, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "A very l") || strings.Contains(w.Body.String(), "A very long") {
+		t.Errorf("expected the title to be cut to 8 characters")
+	}
+}
+
+// With RequireLoginToPaste anonymous users can't create pastes, neither with
+// the form nor with the API, and the home page asks them to login
+func TestRequireLoginToPaste(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.RequireLoginToPaste = true
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	usr := token.User{ID: "test_user_require_login", Name: "Test User"}
+
+	serve := func(r *http.Request, u *token.User) *httptest.ResponseRecorder {
+		t.Helper()
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+	form := func() *http.Request {
+		r, _ := http.NewRequest("POST", "/p/", strings.NewReader("body=Test+body&privacy=public"))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	api := func() *http.Request {
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader("Test body"))
+		r.Header.Add("Content-Type", "text/plain")
+		return r
+	}
+
+	if w := serve(form(), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous form status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve(form(), &usr); w.Code != http.StatusOK || w.Header().Get("Location") == "" {
+		t.Errorf("logged in form should create the paste, got %d", w.Code)
+	}
+	if w := serve(api(), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous API status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve(api(), &usr); w.Code != http.StatusCreated {
+		t.Errorf("logged in API status should be %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	home, _ := http.NewRequest("GET", "/p/", nil)
+	if w := serve(home, nil); !strings.Contains(w.Body.String(), `id="login-required"`) || strings.Contains(w.Body.String(), `name="body"`) {
+		t.Errorf("anonymous home page should show the login prompt instead of the form")
+	}
+	home, _ = http.NewRequest("GET", "/p/", nil)
+	if w := serve(home, &usr); strings.Contains(w.Body.String(), `id="login-required"`) || !strings.Contains(w.Body.String(), `name="body"`) {
+		t.Errorf("logged in home page should show the form")
+	}
 }
 
 // TestPostPasteEmptyForm try to POST an empty form
@@ -238,12 +642,88 @@ func TestGetPublicPaste(t *testing.T) {
 		t.Errorf("Response should have title [%s], got [%s]", want, got)
 	}
 
-	want = `<code class="py-3 language-text">Test paste</code>`
+	want = `<code class="py-3 language-text"><span id="L1" class="line"><a class="line-number" href="#L1">1</a>Test paste</span></code>`
 	if !strings.Contains(got, want) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
 }
 
+// Paste bodies and titles are data, markup and template actions in them are
+// shown escaped and never executed
+func TestGetPasteInert(t *testing.T) {
+	t.Parallel()
+
+	body := "{{.Secret}} {{ .Paste.Password }}\n<script>alert('xss')</script>"
+	for _, syntax := range []string{"text", "markup", "go", "markdown"} {
+		p, err := webSrv.service.NewPaste(service.PasteRequest{
+			Title:   "<script>alert('title')</script> {{.Secret}}",
+			Body:    body,
+			Privacy: "public",
+			Syntax:  syntax,
+		})
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL(), nil)
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status should be %d, got %d", syntax, http.StatusOK, w.Code)
+		}
+		got := w.Body.String()
+		if strings.Contains(got, "<script>alert(") {
+			t.Errorf("%s: expected the script tags to be escaped", syntax)
+		}
+		if !strings.Contains(got, "&lt;script&gt;") {
+			t.Errorf("%s: expected the escaped script tag in the page", syntax)
+		}
+		if strings.Count(got, "{{.Secret}}") < 2 {
+			t.Errorf("%s: expected the template action to be shown as is in the title and the body", syntax)
+		}
+	}
+}
+
+// Markdown pastes are rendered unless the source is asked for
+func TestGetMarkdownPaste(t *testing.T) {
+	t.Parallel()
+
+	p, err := webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "# Release notes\n\nSee [the docs](https://example.com/docs).\n\n<script>alert('xss')</script>",
+		Privacy: "public",
+		Syntax:  "markdown",
+	})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status should be %d, got %d", http.StatusOK, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	got := get("/p/" + p.URL())
+	if !strings.Contains(got, "<h1>Release notes</h1>") || !strings.Contains(got, `<a href="https://example.com/docs" rel="nofollow noopener">the docs</a>`) {
+		t.Errorf("expected the rendered markdown, got %s", got)
+	}
+	if strings.Contains(got, "<script>alert(") {
+		t.Errorf("expected the script tag not to be rendered")
+	}
+	if !strings.Contains(got, `href="/p/`+p.URL()+`?source=yes"`) {
+		t.Errorf("expected a link to the source")
+	}
+
+	got = get("/p/" + p.URL() + "?source=yes")
+	if strings.Contains(got, "<h1>Release notes</h1>") || !strings.Contains(got, "# Release notes") {
+		t.Errorf("expected the markdown source")
+	}
+}
+
 // Get non-existing paste
 func TestGetNonExistingPaste(t *testing.T) {
 	t.Parallel()
@@ -267,6 +747,34 @@ func TestGetNonExistingPaste(t *testing.T) {
 	}
 }
 
+// Paste times are rendered in UTC with a timestamp for the browser to
+// localize
+func TestGetPasteTimestamp(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.TimeFormat = "2006-01-02 15:04 MST"
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	p, err := srv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public"})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/p/"+p.URL(), nil)
+	srv.router.ServeHTTP(w, r)
+
+	got := w.Body.String()
+	want := `data-timestamp="` + p.CreatedAt.UTC().Format(time.RFC3339) + `"`
+	if !strings.Contains(got, want) {
+		t.Errorf("Response should contain [%s]", want)
+	}
+	want = p.CreatedAt.UTC().Format(opts.TimeFormat)
+	if !strings.Contains(got, want) {
+		t.Errorf("Response should contain the formatted time [%s]", want)
+	}
+}
+
 // Get private paste of another user
 func TestGetPrivatePasteOfAnotherUser(t *testing.T) {
 	t.Parallel()
@@ -344,12 +852,23 @@ func TestGetPrivatePaste(t *testing.T) {
 		t.Errorf("Response should have title [%s], got [%s]", want, got)
 	}
 
-	want = `<code class="py-3 language-text">Test paste</code>`
+	want = `<code class="py-3 language-text"><span id="L1" class="line"><a class="line-number" href="#L1">1</a>Test paste</span></code>`
 	if !strings.Contains(got, want) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
 }
 
+// headerCounter counts the WriteHeader calls made by a handler.
+type headerCounter struct {
+	http.ResponseWriter
+	calls int
+}
+
+func (h *headerCounter) WriteHeader(code int) {
+	h.calls++
+	h.ResponseWriter.WriteHeader(code)
+}
+
 // Get password protected paste without password
 func TestGetPasswordProtectedPasteNoPassword(t *testing.T) {
 	t.Parallel()
@@ -366,12 +885,16 @@ func TestGetPasswordProtectedPasteNoPassword(t *testing.T) {
 	})
 
 	w := httptest.NewRecorder()
+	hc := &headerCounter{ResponseWriter: w}
 	r, _ := http.NewRequest("GET", "/p/"+p.URL(), nil)
-	webSrv.router.ServeHTTP(w, r)
+	webSrv.router.ServeHTTP(hc, r)
 
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Status should be %d, got %d", http.StatusUnauthorized, w.Code)
 	}
+	if hc.calls != 1 {
+		t.Errorf("WriteHeader should be called once, got %d calls", hc.calls)
+	}
 
 	want := webSrv.options.BrandName + " - Password"
 	got := w.Body.String()
@@ -417,7 +940,7 @@ func TestGetPasswordProtectedPasteWithPassword(t *testing.T) {
 		t.Errorf("Response should have title [%s], got [%s]", want, got)
 	}
 
-	want = `<code class="py-3 language-text">Test paste</code>`
+	want = `<code class="py-3 language-text"><span id="L1" class="line"><a class="line-number" href="#L1">1</a>Test paste</span></code>`
 	if !strings.Contains(got, want) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
@@ -486,6 +1009,112 @@ func TestGetUserPastes(t *testing.T) {
 	}
 }
 
+// The user's pastes expiring within the window are listed above the others,
+// the soonest first
+func TestGetUserPastesExpiringSoon(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.ExpiringSoon = 24 * time.Hour
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	usr, err := srv.service.GetOrUpdateUser(store.User{ID: "test_user_expiring", Name: "Test User Expiring"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	for _, exp := range []string{"2d", "1h", "never", "10m", "1w"} {
+		_, err = srv.service.NewPaste(service.PasteRequest{
+			Title:   "Expiring " + exp,
+			Body:    "Test paste",
+			Expires: exp,
+			Privacy: "public",
+			UserID:  usr.ID,
+		})
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/l/", nil)
+	r = token.SetUserInfo(r, token.User{Name: usr.Name, ID: usr.ID})
+	srv.router.ServeHTTP(w, r)
+	got := w.Body.String()
+	start, end := strings.Index(got, `id="expiring-pastes"`), strings.Index(got, "My Pastes")
+	if start < 0 || end < start {
+		t.Fatalf("expected the expiring soon section above the list, got [%s]", got)
+	}
+	section := got[start:end]
+	for _, exp := range []string{"2d", "never", "1w"} {
+		if strings.Contains(section, "Expiring "+exp) {
+			t.Errorf("expected the %s paste not to be expiring soon", exp)
+		}
+	}
+	soonest, later := strings.Index(section, "Expiring 10m"), strings.Index(section, "Expiring 1h")
+	if soonest < 0 || later < 0 {
+		t.Fatalf("expected the 10m and 1h pastes to be expiring soon, got [%s]", section)
+	}
+	if soonest > later {
+		t.Errorf("expected the soonest expiring paste first")
+	}
+	// the list has a column with the expiration of every paste
+	if list := got[end:]; strings.Count(list, `class="paste-expiration`) != 5 || !strings.Contains(list, `title="Never expires">Never</div>`) {
+		t.Errorf("expected the expiration column in the list, got [%s]", list)
+	}
+
+	// anonymous visitors don't have the section
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/l/", nil)
+	srv.router.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), `id="expiring-pastes"`) {
+		t.Errorf("expected no expiring soon section for anonymous visitors")
+	}
+}
+
+// The owner's list has pastes of every privacy, the archive only public ones
+func TestGetUserPastesPrivacy(t *testing.T) {
+	t.Parallel()
+
+	usr, err := webSrv.service.GetOrUpdateUser(store.User{ID: "test_user_privacy_list", Name: "Test User Privacy List"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	for _, privacy := range []string{"public", "unlisted", "private"} {
+		_, err = webSrv.service.NewPaste(service.PasteRequest{
+			Title:   "Privacy list " + privacy,
+			Body:    "Test paste",
+			Privacy: privacy,
+			UserID:  usr.ID,
+		})
+		if err != nil {
+			t.Fatalf("failed to create paste: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/l/", nil)
+	r = token.SetUserInfo(r, token.User{Name: usr.Name, ID: usr.ID})
+	webSrv.router.ServeHTTP(w, r)
+	got := w.Body.String()
+	for _, privacy := range []string{"public", "unlisted", "private"} {
+		if !strings.Contains(got, "Privacy list "+privacy) {
+			t.Errorf("expected the %s paste in the user's list", privacy)
+		}
+	}
+	for _, badge := range []string{`title="Public"`, `title="Unlisted"`, `title="Private"`} {
+		if !strings.Contains(got, badge) {
+			t.Errorf("expected the %s privacy badge in the user's list", badge)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/a/", nil)
+	webSrv.router.ServeHTTP(w, r)
+	got = w.Body.String()
+	if strings.Contains(got, "Privacy list unlisted") || strings.Contains(got, "Privacy list private") {
+		t.Errorf("expected only public pastes in the archive")
+	}
+}
+
 // Get a list of public pastes
 func TestGetArchive(t *testing.T) {
 	t.Parallel()
@@ -530,8 +1159,789 @@ func TestGetArchive(t *testing.T) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
 	//check paginator - there is a link to the second page
-	want = `<li class="page-item"><a class="page-link" href="/a/?skip=10">2</a></li>`
+	want = `<li class="page-item"><a class="page-link" href="/a/?sort=-created&skip=10">2</a></li>`
 	if !strings.Contains(got, want) {
 		t.Errorf("Response should have body [%s], got [%s]", want, got)
 	}
 }
+
+// Get a list of public pastes with a custom sort order
+func TestGetArchiveSort(t *testing.T) {
+	t.Parallel()
+	// make sure the archive is not empty
+	_, err := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Test sort",
+		Body:    "Test sort",
+		Privacy: "public",
+		Syntax:  "text",
+	})
+	if err != nil {
+		t.Fatalf("failed to create paste: %+v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "sort=-views&skip=10", want: `<a class="nav-link active" href="/a/?sort=-views&skip=10">`},
+		{query: "sort=%2bexpires", want: `<a class="nav-link active" href="/a/?sort=%2bexpires&skip=0">`},
+		{query: "sort=+views", want: `<a class="nav-link active" href="/a/?sort=%2bviews&skip=0">`},
+		{query: "sort=bogus", want: `<a class="nav-link active" href="/a/?sort=-created&skip=0">`},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/a/?"+tc.query, nil)
+		webSrv.router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+		}
+
+		got := w.Body.String()
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("Response for [%s] should have [%s], got [%s]", tc.query, tc.want, got)
+		}
+	}
+}
+
+// Filter the archive by syntax, unknown syntax shows all the pastes
+func TestGetArchiveSyntax(t *testing.T) {
+	t.Parallel()
+
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), testServerOptions())
+	defer srv.Close()
+	for _, syntax := range []string{"go", "go", "python", "text"} {
+		_, err := srv.service.NewPaste(service.PasteRequest{
+			Title:   "Archived " + syntax,
+			Body:    "Test syntax " + syntax,
+			Privacy: "public",
+			Syntax:  syntax,
+		})
+		if err != nil {
+			t.Fatalf("failed to create paste: %+v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  map[string]int
+		chip  string
+	}{
+		{query: "syntax=go", want: map[string]int{"go": 2, "python": 0, "text": 0}, chip: `active" href="/a/?sort=-created&syntax=go">go</a>`},
+		{query: "syntax=python&sort=-views", want: map[string]int{"go": 0, "python": 1, "text": 0}, chip: `active" href="/a/?sort=-views&syntax=python">python</a>`},
+		{query: "syntax=cobol", want: map[string]int{"go": 2, "python": 1, "text": 1}, chip: `active" href="/a/?sort=-created">All</a>`},
+		{query: "", want: map[string]int{"go": 2, "python": 1, "text": 1}, chip: `active" href="/a/?sort=-created">All</a>`},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/a/?"+tc.query, nil)
+		// a user without pastes, so that the sidebar doesn't list any
+		r = token.SetUserInfo(r, token.User{ID: "archive_syntax_user"})
+		srv.router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+		}
+		got := w.Body.String()
+		for syntax, n := range tc.want {
+			if cnt := strings.Count(got, `title="Archived `+syntax+`"`); cnt != n {
+				t.Errorf("[%s] expected %d %s pastes, got %d", tc.query, n, syntax, cnt)
+			}
+		}
+		if !strings.Contains(got, tc.chip) {
+			t.Errorf("[%s] expected the active filter [%s], got [%s]", tc.query, tc.chip, got)
+		}
+	}
+}
+
+// Get a list of trending pastes
+func TestGetTrending(t *testing.T) {
+	t.Parallel()
+	_, err := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Test trending",
+		Body:    "Test trending",
+		Privacy: "public",
+		Syntax:  "text",
+	})
+	if err != nil {
+		t.Fatalf("failed to create paste: %+v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/trending", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+
+	want := webSrv.options.BrandName + " - Trending"
+	got := w.Body.String()
+	if !strings.Contains(got, want) {
+		t.Errorf("Response should have title [%s], got [%s]", want, got)
+	}
+	want = "Trending Pastes"
+	if !strings.Contains(got, want) {
+		t.Errorf("Response should have [%s] in the body, got [%s]", want, got)
+	}
+}
+
+// Get application metrics
+func TestGetMetrics(t *testing.T) {
+	t.Parallel()
+	// make a request so there is some latency to report
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/a/", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/metrics", nil)
+	webSrv.router.ServeHTTP(w, r)
+	w = httptest.NewRecorder()
+	webSrv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+
+	got := w.Body.String()
+	for _, want := range []string{
+		"# TYPE gopb_pastes gauge",
+		"# TYPE gopb_pastes_created_total counter",
+		"# TYPE gopb_pastes_viewed_total counter",
+		"# TYPE gopb_logins_total counter",
+		`gopb_http_request_duration_seconds_count{method="GET",route="/a/"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Response should have [%s], got [%s]", want, got)
+		}
+	}
+	want := `route="/metrics"`
+	if strings.Contains(got, want) {
+		t.Errorf("Response should not have [%s], got [%s]", want, got)
+	}
+}
+
+// Label values only have backslashes, quotes and new lines escaped
+func TestMetricsLabelValues(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics()
+	m.observe("GET", "/a\"b\nc\\d/é\t", time.Millisecond)
+	var buf strings.Builder
+	m.write(&buf, 0, 0)
+
+	want := `gopb_http_request_duration_seconds_count{method="GET",route="/a\"b\nc\\d/é` + "\t" + `"} 1` + "\n"
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("Response should have [%s], got [%s]", want, got)
+	}
+}
+
+// Get the health status
+func TestGetHealth(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/healthz", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+
+	want := `{"status":"ok","version":"test"}`
+	got := strings.TrimSpace(w.Body.String())
+	if got != want {
+		t.Errorf("Response should be [%s], got [%s]", want, got)
+	}
+}
+
+// Only the configured auth providers are shown on the login menu
+func TestLoginProviders(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	got := w.Body.String()
+	for _, id := range []string{"devLogin", "githubLogin", "googleLogin", "twitterLogin", "gitlabLogin", "giteaLogin"} {
+		if strings.Contains(got, `id="`+id+`"`) {
+			t.Errorf("Response should not have [%s] when the provider is not configured", id)
+		}
+	}
+
+	h := &Server{options: ServerOptions{
+		GitLabCID: "gitlab-cid",
+		GiteaCID:  "gitea-cid",
+	}}
+	h.addAuthProviders(auth.NewService(auth.Opts{}))
+	if len(h.providers) != 1 || h.providers[0] != "gitlab" {
+		t.Errorf("Expected only gitlab provider to be added (gitea needs url), got %v", h.providers)
+	}
+}
+
+// Dev auth provider is disabled unless explicitly enabled
+func TestDevAuthDisabled(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range webSrv.providers {
+		if p == "dev" {
+			t.Errorf("Dev provider should not be enabled by default")
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/dev/login", nil)
+	webSrv.router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Delete the logged in user with all the pastes
+func TestPostDeleteUser(t *testing.T) {
+	t.Parallel()
+
+	u, _ := webSrv.service.GetOrUpdateUser(store.User{
+		ID:   "test_user_delete",
+		Name: "Test User Delete",
+	})
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Title:   "Test",
+		Body:    "Test paste",
+		Privacy: "public",
+		Syntax:  "text",
+		UserID:  u.ID,
+	})
+
+	// without confirmation
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/u/delete", nil)
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// anonymous user
+	form := url.Values{}
+	form.Add("confirm", "yes")
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/u/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// confirmed
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/u/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	if len(w.Result().Cookies()) == 0 {
+		t.Errorf("Response should reset the session cookies")
+	}
+
+	if _, err := webSrv.service.GetPaste(p.URL(), "", ""); !errors.Is(err, service.ErrPasteNotFound) {
+		t.Errorf("Expected paste to be deleted, got %v", err)
+	}
+}
+
+// Bulk delete removes only the user's own pastes
+func TestPostDeletePastes(t *testing.T) {
+	t.Parallel()
+
+	u, _ := webSrv.service.GetOrUpdateUser(store.User{
+		ID:   "test_user_bulk_delete",
+		Name: "Test User Bulk Delete",
+	})
+	mine, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "Test paste",
+		Privacy: "public",
+		UserID:  u.ID,
+	})
+	theirs, _ := webSrv.service.NewPaste(service.PasteRequest{
+		Body:    "Test paste",
+		Privacy: "public",
+	})
+
+	form := url.Values{}
+	form.Add("ids", strconv.FormatInt(mine.ID, 10))
+	form.Add("ids", strconv.FormatInt(theirs.ID, 10))
+
+	// anonymous user
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/l/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/l/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Status should be %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/l/?deleted=1" {
+		t.Errorf("Location should be %s, got %s", "/l/?deleted=1", loc)
+	}
+	if _, err := webSrv.service.GetPaste(mine.URL(), u.ID, ""); !errors.Is(err, service.ErrPasteNotFound) {
+		t.Errorf("Expected paste to be deleted, got %v", err)
+	}
+	if _, err := webSrv.service.GetPaste(theirs.URL(), "", ""); err != nil {
+		t.Errorf("Expected paste of another user to stay, got %v", err)
+	}
+
+	// summary message
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/l/?deleted=1", nil)
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "Deleted 1 paste(s).") {
+		t.Errorf("Response should contain the summary message")
+	}
+
+	// invalid id
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/l/delete", strings.NewReader("ids=abc"))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r = token.SetUserInfo(r, token.User{ID: u.ID, Name: u.Name})
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// Cookie secret is required in production mode only and bcrypt cost must
+// be within the bcrypt bounds
+func TestServerOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    ServerOptions
+		wantErr bool
+	}{
+		{"debug without secret", ServerOptions{LogMode: "debug"}, false},
+		{"production without secret", ServerOptions{LogMode: "production"}, true},
+		{"production with cookie secret", ServerOptions{LogMode: "production", CookieSecret: "secret"}, false},
+		{"production with auth secret", ServerOptions{LogMode: "production", AuthSecret: "secret"}, false},
+		{"bcrypt cost too low", ServerOptions{LogMode: "debug", BcryptCost: 3}, true},
+		{"bcrypt cost too high", ServerOptions{LogMode: "debug", BcryptCost: 32}, true},
+		{"bcrypt cost in range", ServerOptions{LogMode: "debug", BcryptCost: 12}, false},
+		{"default privacy", ServerOptions{LogMode: "debug", DefaultPrivacyAnon: "unlisted", DefaultPrivacyUser: "private"}, false},
+		{"wrong anonymous privacy", ServerOptions{LogMode: "debug", DefaultPrivacyAnon: "secret"}, true},
+		{"wrong user privacy", ServerOptions{LogMode: "debug", DefaultPrivacyUser: "hidden"}, true},
+		{"id bits in range", ServerOptions{LogMode: "debug", IDBits: 32}, false},
+		{"id bits too low", ServerOptions{LogMode: "debug", IDBits: 8}, true},
+		{"id bits too high", ServerOptions{LogMode: "debug", IDBits: 64}, true},
+		{"secret patterns", ServerOptions{LogMode: "debug", SecretPatterns: []string{"token=tk_[0-9]+"}}, false},
+		{"allowed privacy", ServerOptions{LogMode: "debug", AllowedPrivacyAnon: []string{"unlisted"}, DefaultPrivacyAnon: "unlisted"}, false},
+		{"wrong allowed privacy", ServerOptions{LogMode: "debug", AllowedPrivacyAnon: []string{"hidden"}}, true},
+		{"default privacy not allowed", ServerOptions{LogMode: "debug", AllowedPrivacyAnon: []string{"unlisted"}}, true},
+		{"wrong secret pattern", ServerOptions{LogMode: "debug", SecretPatterns: []string{"token=tk_["}}, true},
+		{"wrong blocked pattern", ServerOptions{LogMode: "debug", BlockedPatterns: []string{"casino["}}, true},
+		{"https with certificate", ServerOptions{LogMode: "debug", Proto: "https", TLSCert: "cert.pem", TLSKey: "key.pem"}, false},
+		{"https behind proxy", ServerOptions{LogMode: "debug", Proto: "https", TrustProxyHeaders: true}, false},
+		{"https without certificate", ServerOptions{LogMode: "debug", Proto: "https"}, true},
+		{"certificate without key", ServerOptions{LogMode: "debug", Proto: "https", TLSCert: "cert.pem"}, true},
+		{"https with autocert", ServerOptions{LogMode: "debug", Proto: "https", AutocertDomains: []string{"paste.example.com"}, AutocertCacheDir: "certs"}, false},
+		{"autocert without cache dir", ServerOptions{LogMode: "debug", Proto: "https", AutocertDomains: []string{"paste.example.com"}}, true},
+		{"autocert with certificate", ServerOptions{LogMode: "debug", Proto: "https", TLSCert: "cert.pem", TLSKey: "key.pem",
+			AutocertDomains: []string{"paste.example.com"}, AutocertCacheDir: "certs"}, true},
+		{"autocert over http", ServerOptions{LogMode: "debug", Proto: "http", AutocertDomains: []string{"paste.example.com"}, AutocertCacheDir: "certs"}, true},
+		{"redirect with autocert", ServerOptions{LogMode: "debug", Proto: "https", AutocertDomains: []string{"paste.example.com"}, AutocertCacheDir: "certs",
+			RedirectHTTPPort: 80}, false},
+		{"redirect with certificate", ServerOptions{LogMode: "debug", Proto: "https", TLSCert: "cert.pem", TLSKey: "key.pem", RedirectHTTPPort: 80}, false},
+		{"redirect without certificate", ServerOptions{LogMode: "debug", Proto: "https", TrustProxyHeaders: true, RedirectHTTPPort: 80}, true},
+		{"body encoding", ServerOptions{LogMode: "debug", BodyEncoding: "replace"}, false},
+		{"wrong body encoding", ServerOptions{LogMode: "debug", BodyEncoding: "latin1"}, true},
+		{"negative stats ttl", ServerOptions{LogMode: "debug", StatsTTL: -time.Second}, true},
+		{"negative expiring soon", ServerOptions{LogMode: "debug", ExpiringSoon: -time.Hour}, true},
+		{"negative max title length", ServerOptions{LogMode: "debug", MaxTitleLength: -1}, true},
+		{"trusted cidrs", ServerOptions{LogMode: "debug", TrustedCIDRs: []string{"10.0.0.0/8", "::1/128"}}, false},
+		{"invalid trusted cidr", ServerOptions{LogMode: "debug", TrustedCIDRs: []string{"10.0.0.1"}}, true},
+		{"negative trusted body size", ServerOptions{LogMode: "debug", TrustedMaxBodySize: -1}, true},
+		{"certificate over http", ServerOptions{LogMode: "debug", Proto: "http", TLSCert: "cert.pem", TLSKey: "key.pem"}, true},
+	}
+	for _, tc := range tests {
+		err := tc.opts.validate()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	opts := ServerOptions{AuthSecret: "auth", CookieSecret: "cookie"}
+	if s := opts.cookieSecret(); s != "cookie" {
+		t.Errorf("Cookie secret should take precedence over auth secret, got %q", s)
+	}
+}
+
+// Session cookies are secure when the server runs over https
+func TestSecureCookies(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.Proto = "https"
+	opts.TrustProxyHeaders = true // TLS is terminated by a reverse proxy
+	opts.CookieDomain = "go-pb.example.com"
+	srv := New(log, opts)
+
+	form := url.Values{}
+	form.Add("confirm", "yes")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/u/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r = token.SetUserInfo(r, token.User{ID: "test_user_secure", Name: "Test User Secure"})
+	srv.router.ServeHTTP(w, r)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("Response should set the session cookies")
+	}
+	for _, c := range cookies {
+		if !c.Secure {
+			t.Errorf("Cookie %s should be secure", c.Name)
+		}
+		if c.Domain != opts.CookieDomain {
+			t.Errorf("Cookie %s domain should be %s, got %s", c.Name, opts.CookieDomain, c.Domain)
+		}
+	}
+
+	// plain http server
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/u/delete", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r = token.SetUserInfo(r, token.User{ID: "test_user_secure", Name: "Test User Secure"})
+	webSrv.router.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Secure {
+			t.Errorf("Cookie %s should not be secure over http", c.Name)
+		}
+	}
+}
+
+// Zero timeouts fall back to the defaults
+func TestHTTPServerTimeouts(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{options: ServerOptions{ReadTimeout: 3 * time.Second}}
+	hs := srv.httpServer(nil)
+	if hs.ReadTimeout != 3*time.Second {
+		t.Errorf("Read timeout should be %s, got %s", 3*time.Second, hs.ReadTimeout)
+	}
+	if hs.WriteTimeout != defaultWriteTimeout {
+		t.Errorf("Write timeout should be %s, got %s", defaultWriteTimeout, hs.WriteTimeout)
+	}
+	if hs.IdleTimeout != defaultIdleTimeout {
+		t.Errorf("Idle timeout should be %s, got %s", defaultIdleTimeout, hs.IdleTimeout)
+	}
+	if hs.ReadHeaderTimeout != defaultReadHeaderTimeout {
+		t.Errorf("Read header timeout should be %s, got %s", defaultReadHeaderTimeout, hs.ReadHeaderTimeout)
+	}
+}
+
+// New requests get 503 once shutdown begins
+func TestShutdownDraining(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status should be %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/healthz", nil)
+	srv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status should be %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"status":"draining"`) {
+		t.Errorf("Health check should report draining, got [%s]", w.Body.String())
+	}
+}
+
+// Compare two pastes
+func TestGetDiff(t *testing.T) {
+	t.Parallel()
+
+	a, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "first <line>", Privacy: "public"})
+	b, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "second <line>", Privacy: "public"})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/diff?a="+a.URL()+"&b="+b.URL(), nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	want := "-first &lt;line&gt;\n&#43;second &lt;line&gt;"
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Response should have diff [%s], got [%s]", want, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/diff?a="+a.URL(), nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status should be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/diff?a="+a.URL()+"&b=IYCE8rJj8Qg", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status should be %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// Create a paste hidden until click
+func TestPostPasteBlurUntilClick(t *testing.T) {
+	t.Parallel()
+	w := httptest.NewRecorder()
+	form := url.Values{}
+	form.Add("body", "Secret body")
+	form.Add("privacy", "public")
+	form.Add("blur_until_click", "yes")
+	req, _ := http.NewRequest("POST", "/p/", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	webSrv.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	got := w.Body.String()
+	if !strings.Contains(got, `class="position-relative blurred"`) {
+		t.Errorf("Response should have blurred body, got [%s]", got)
+	}
+	if strings.Contains(got, "Raw text") {
+		t.Errorf("Response should not have raw text, got [%s]", got)
+	}
+}
+
+// Expiration presets are validated and shown on the form
+func TestExpirationPresets(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		service: service.NewWithMemDB(service.WithExpirationBounds(0, 24*time.Hour)),
+	}
+	// defaults are filtered by the bounds
+	presets, err := srv.expirationPresets()
+	if err != nil {
+		t.Fatalf("Failed to get default presets: %v", err)
+	}
+	if presets[0].Value != "10m" || presets[len(presets)-1].Value != "1d" {
+		t.Errorf("Default presets should be from 10m to 1d, got %+v", presets)
+	}
+
+	srv.options.ExpirationPresets = []string{"30m", "1h"}
+	presets, err = srv.expirationPresets()
+	if err != nil {
+		t.Fatalf("Failed to get presets: %v", err)
+	}
+	want := []page.Expiration{{Value: "30m", Label: "30 minutes"}, {Value: "1h", Label: "1 hour"}}
+	if fmt.Sprint(presets) != fmt.Sprint(want) {
+		t.Errorf("Presets should be %v, got %v", want, presets)
+	}
+
+	for _, bad := range []string{"never", "2d", "1x", "abc"} {
+		srv.options.ExpirationPresets = []string{"1h", bad}
+		if _, err := srv.expirationPresets(); err == nil {
+			t.Errorf("Preset %q should be invalid", bad)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	webSrv.router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `<option value="never" selected>Never</option>`) {
+		t.Errorf("Home page should have the default expiration presets, got [%s]", w.Body.String())
+	}
+}
+
+// Security headers are set on all responses
+func TestSecurityHeaders(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"/", "/api/v1/paste/nonexisting/meta", "/nonexisting"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		webSrv.router.ServeHTTP(w, r)
+		want := map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"X-Frame-Options":         "DENY",
+			"Referrer-Policy":         "strict-origin-when-cross-origin",
+			"Content-Security-Policy": defaultContentSecurityPolicy,
+		}
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s: header %s should be %q, got %q", path, k, v, got)
+			}
+		}
+	}
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.ContentSecurityPolicy = "default-src 'self' https://cdn.example.com"
+	srv := New(log, opts)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	srv.router.ServeHTTP(w, r)
+	if got := w.Header().Get("Content-Security-Policy"); got != opts.ContentSecurityPolicy {
+		t.Errorf("Content-Security-Policy should be %q, got %q", opts.ContentSecurityPolicy, got)
+	}
+}
+
+// Proxy headers are used only when trusted
+func TestProxyHeaders(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.TrustProxyHeaders = true
+	trusted := New(log, opts)
+
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:34567"
+		r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "paste.example.com")
+		return r
+	}
+
+	// trusted
+	var ip string
+	hdlr := trusted.proxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = clientIP(r)
+	}))
+	r := newRequest()
+	hdlr.ServeHTTP(httptest.NewRecorder(), r)
+	if ip != "203.0.113.7" {
+		t.Errorf("Client IP should be %s, got %s", "203.0.113.7", ip)
+	}
+	if got := trusted.serverURL(r); got != "https://paste.example.com" {
+		t.Errorf("Server URL should be %s, got %s", "https://paste.example.com", got)
+	}
+
+	// invalid address is ignored
+	r = newRequest()
+	r.Header.Set("X-Forwarded-For", "not an ip")
+	hdlr.ServeHTTP(httptest.NewRecorder(), r)
+	if ip != "127.0.0.1" {
+		t.Errorf("Client IP should be %s, got %s", "127.0.0.1", ip)
+	}
+
+	// untrusted
+	r = newRequest()
+	if got, want := webSrv.serverURL(r), webSrv.options.Proto+"://"+webSrv.options.Addr; got != want {
+		t.Errorf("Server URL should be %s, got %s", want, got)
+	}
+	if got := clientIP(r); got != "127.0.0.1" {
+		t.Errorf("Client IP should be %s, got %s", "127.0.0.1", got)
+	}
+
+	// share link on the paste page
+	p, _ := trusted.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public"})
+	w := httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/p/"+p.URL(), nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "paste.example.com")
+	trusted.router.ServeHTTP(w, r)
+	if want := `value="https://paste.example.com/p/` + p.URL() + `"`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Response should contain the share link [%s]", want)
+	}
+}
+
+// HEAD requests and bots don't burn delete after read pastes
+func TestPasteHeadKeepsBurner(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public", DeleteAfterRead: true})
+
+	tests := []struct {
+		method string
+		path   string
+		ua     string
+	}{
+		{"HEAD", "/p/" + p.URL(), "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+		{"HEAD", "/p/" + p.URL() + "/raw", "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+		{"HEAD", "/p/" + p.URL() + "/download", "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+		{"GET", "/p/" + p.URL(), "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("User-Agent", tc.ua)
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: status should be %d, got %d", tc.method, tc.path, http.StatusOK, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "Test paste") {
+			t.Errorf("%s %s: response should not contain the body of the paste", tc.method, tc.path)
+		}
+	}
+
+	if _, err := webSrv.service.GetPasteMeta(p.URL(), ""); err != nil {
+		t.Errorf("Paste should still be in the store, got %v", err)
+	}
+}
+
+// Only the HTML page viewed by people counts views
+func TestPasteViewCount(t *testing.T) {
+	t.Parallel()
+
+	p, _ := webSrv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public"})
+
+	tests := []struct {
+		method string
+		path   string
+		ua     string
+	}{
+		{"GET", "/p/" + p.URL(), "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"}, // counted
+		{"HEAD", "/p/" + p.URL(), "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+		{"GET", "/p/" + p.URL(), "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"},
+		{"GET", "/p/" + p.URL(), "TelegramBot (like TwitterBot)"},
+		{"GET", "/p/" + p.URL() + "/raw", "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+		{"GET", "/p/" + p.URL() + "/download", "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(tc.method, tc.path, nil)
+		r.Header.Set("User-Agent", tc.ua)
+		webSrv.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: status should be %d, got %d", tc.method, tc.path, http.StatusOK, w.Code)
+		}
+	}
+
+	meta, _ := webSrv.service.GetPasteMeta(p.URL(), "")
+	if meta.Views != 1 {
+		t.Errorf("Paste should have 1 view, got %d", meta.Views)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// highlightThemeCookie keeps the highlight theme chosen by the viewer.
+const highlightThemeCookie = "highlight_theme"
+
+// highlightTheme returns the highlight theme to show the paste with. A
+// theme from the ?theme= parameter is remembered in a cookie, the viewer's
+// choice wins over the theme chosen by the paste author. Unknown themes are
+// ignored.
+func (h *Server) highlightTheme(w http.ResponseWriter, r *http.Request, paste store.Paste) string {
+	if theme := r.URL.Query().Get("theme"); service.ValidHighlightTheme(theme) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     highlightThemeCookie,
+			Value:    theme,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			Secure:   h.options.Proto == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		return theme
+	}
+	if c, err := r.Cookie(highlightThemeCookie); err == nil && service.ValidHighlightTheme(c.Value) {
+		return c.Value
+	}
+	if service.ValidHighlightTheme(paste.Theme) {
+		return paste.Theme
+	}
+	return service.HighlightThemes[0]
+}
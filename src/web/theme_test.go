@@ -0,0 +1,75 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// The highlight theme from the query is remembered in a cookie and wins
+// over the paste author's theme
+func TestHighlightThemeCookie(t *testing.T) {
+	t.Parallel()
+
+	p, err := webSrv.service.NewPaste(service.PasteRequest{Body: "Test paste", Privacy: "public", Theme: "okaidia"})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+	get := func(query string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/p/"+p.URL()+query, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		webSrv.router.ServeHTTP(w, r)
+		return w
+	}
+	stylesheet := func(theme string) string {
+		return `href="/assets/themes/` + theme + `.css"`
+	}
+
+	// the author's theme
+	w := get("")
+	if !strings.Contains(w.Body.String(), stylesheet("okaidia")) {
+		t.Errorf("Response should use the paste theme")
+	}
+
+	// the viewer's choice is remembered
+	w = get("?theme=tomorrow")
+	if !strings.Contains(w.Body.String(), stylesheet("tomorrow")) {
+		t.Errorf("Response should use the theme from the query")
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == highlightThemeCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != "tomorrow" {
+		t.Fatalf("Response should set the theme cookie, got %v", cookie)
+	}
+	w = get("", cookie)
+	if !strings.Contains(w.Body.String(), stylesheet("tomorrow")) {
+		t.Errorf("Response should use the theme from the cookie")
+	}
+
+	// unknown themes are ignored
+	w = get("?theme=../../etc/passwd", cookie)
+	if !strings.Contains(w.Body.String(), stylesheet("tomorrow")) {
+		t.Errorf("Response should ignore an unknown theme")
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("Response should not set a cookie for an unknown theme")
+	}
+	w = get("", &http.Cookie{Name: highlightThemeCookie, Value: "neon"})
+	if !strings.Contains(w.Body.String(), stylesheet("okaidia")) {
+		t.Errorf("Response should ignore an unknown theme in the cookie")
+	}
+}
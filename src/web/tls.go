@@ -0,0 +1,20 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertManager returns the manager that gets Let's Encrypt certificates
+// for AutocertDomains and keeps them in AutocertCacheDir. Certificates for
+// other hosts are never requested.
+func (h *Server) autocertManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(h.options.AutocertDomains...),
+		Cache:      autocert.DirCache(h.options.AutocertCacheDir),
+	}
+}
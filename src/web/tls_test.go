@@ -0,0 +1,157 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// selfSignedCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir and returns their paths along with the certificate.
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-pb test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+// The server serves HTTPS with the configured certificate
+func TestServeTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, cert := selfSignedCert(t, t.TempDir())
+	opts := testServerOptions()
+	opts.Proto = "https"
+	opts.TLSCert = certFile
+	opts.TLSKey = keyFile
+	opts.ReadTimeout, opts.WriteTimeout = 5*time.Second, 5*time.Second // the test ones are too short for a handshake
+	if err := opts.validate(); err != nil {
+		t.Fatalf("options with a certificate should be valid, got %v", err)
+	}
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.serve(ln, nil) }()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+	}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("health check over TLS failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("health check should return %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp.TLS == nil {
+		t.Errorf("health check should be served over TLS")
+	}
+
+	// plain HTTP is not served on the TLS port
+	resp, err = http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("plain HTTP should not be served on the TLS port")
+		}
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("failed to shut down: %v", err)
+	}
+	if err := <-errc; err != http.ErrServerClosed {
+		t.Errorf("expected the server to be closed, got %v", err)
+	}
+}
+
+// Autocert only gets certificates for the configured domains and answers
+// the ACME challenges on the redirect listener
+func TestAutocert(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.Proto = "https"
+	opts.AutocertDomains = []string{"paste.example.com"}
+	opts.AutocertCacheDir = t.TempDir()
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+
+	m := srv.autocertManager()
+	if err := m.HostPolicy(context.Background(), "paste.example.com"); err != nil {
+		t.Errorf("configured domain should be allowed, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Errorf("other domains should not be allowed")
+	}
+
+	// the challenge path is answered by autocert, everything else is
+	// redirected
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://paste.example.com/.well-known/acme-challenge/token", nil)
+	m.HTTPHandler(redirectHandler("443")).ServeHTTP(w, r)
+	if w.Code == http.StatusMovedPermanently {
+		t.Errorf("ACME challenge should not be redirected")
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "http://paste.example.com/p/abc", nil)
+	m.HTTPHandler(redirectHandler("443")).ServeHTTP(w, r)
+	if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != "https://paste.example.com/p/abc" {
+		t.Errorf("expected a redirect to https, got %d %q", w.Code, got)
+	}
+}
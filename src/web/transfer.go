@@ -0,0 +1,112 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// handlePostTransfer gives the current user's paste to the user with the name
+// from the form and redirects to the list of the user's pastes, where the
+// paste is no longer shown. User names are not unique, the paste is only
+// given away if there is exactly one user with this name.
+func (h *Server) handlePostTransfer(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to transfer pastes.")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err = r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	url := mux.Vars(r)["id"]
+	name := strings.TrimSpace(r.PostFormValue("user"))
+
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		h.showError(w, http.StatusNotFound, "There is no such paste among your pastes")
+		return
+	}
+	to, err := h.service.UserByName(name)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUserNotFound):
+		h.showError(w, http.StatusBadRequest, "There is no user with this name")
+		return
+	case errors.Is(err, service.ErrUserAmbiguous):
+		h.showError(w, http.StatusBadRequest, "There are several users with this name, the paste can't be transferred")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	err = h.service.TransferPaste(id, usr.ID, to.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUserNotFound):
+		h.showError(w, http.StatusBadRequest, "There is no user with this name")
+		return
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste among your pastes")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO paste %s transferred from %s to %s", url, usr.ID, to.ID)
+	http.Redirect(w, r, "/l/?transferred=yes", http.StatusSeeOther)
+}
+
+// handlePostClaim makes the current user the owner of an anonymous paste
+// given the token shown to its creator and redirects to the list of the
+// user's pastes.
+func (h *Server) handlePostClaim(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to claim pastes.")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBodySize(r))
+	if err = r.ParseForm(); err != nil {
+		h.log.Logf("WARN parsing form failed: %v", err)
+		h.showError(w, http.StatusBadRequest, "")
+		return
+	}
+	url := mux.Vars(r)["id"]
+
+	id, err := store.Paste{}.URL2ID(url)
+	if err != nil {
+		h.showError(w, http.StatusNotFound, "There is no such anonymous paste")
+		return
+	}
+	err = h.service.ClaimPaste(id, usr.ID, strings.TrimSpace(r.PostFormValue("token")))
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such anonymous paste")
+		return
+	case errors.Is(err, service.ErrInvalidToken):
+		h.showError(w, http.StatusForbidden, "The token doesn't match this paste")
+		return
+	case errors.Is(err, service.ErrUserNotFound):
+		h.showError(w, http.StatusUnauthorized, "You need to login to claim pastes.")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO paste %s claimed by %s", url, usr.ID)
+	http.Redirect(w, r, "/l/?claimed=yes", http.StatusSeeOther)
+}
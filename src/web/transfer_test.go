@@ -0,0 +1,145 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// The owner can give a paste to another user, nobody else can
+func TestPostTransfer(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+	defer srv.Close()
+
+	from := token.User{ID: "test_user_transfer_from", Name: "From"}
+	to := token.User{ID: "test_user_transfer_to", Name: "To"}
+	for _, u := range []token.User{from, to, {ID: "test_user_transfer_same_1", Name: "Same"}, {ID: "test_user_transfer_same_2", Name: "Same"}} {
+		if _, err := srv.service.GetOrUpdateUser(store.User{ID: u.ID, Name: u.Name}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	p, err := srv.service.NewPaste(service.PasteRequest{Title: "Transferred paste", Body: "Test paste", Privacy: "private", UserID: from.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve("GET", "/p/"+p.URL(), "", &from); !strings.Contains(w.Body.String(), `action="/p/`+p.URL()+`/transfer"`) {
+		t.Errorf("expected the owner to see the transfer form")
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/transfer", "user="+to.Name, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for anonymous users, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/transfer", "user="+to.Name, &to); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for someone else's paste, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/transfer", "user=no_such_user", &from); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an unknown user, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if w := serve("POST", "/p/"+p.URL()+"/transfer", "user="+to.ID, &from); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a user ID instead of a name, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/transfer", "user=Same", &from); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "There are several users with this name") {
+		t.Errorf("expected status %d for an ambiguous name, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := serve("POST", "/p/"+p.URL()+"/transfer", "user="+to.Name, &from)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/l/?transferred=yes" {
+		t.Errorf("expected redirect to /l/?transferred=yes, got %d %s", w.Code, loc)
+	}
+	if w := serve("GET", "/l/?transferred=yes", "", &from); !strings.Contains(w.Body.String(), "The paste has been transferred.") {
+		t.Errorf("response should contain the summary message")
+	}
+	if w := serve("GET", "/p/"+p.URL(), "", &to); w.Code != http.StatusOK {
+		t.Errorf("expected the new owner to see the paste, got %d", w.Code)
+	}
+	if w := serve("GET", "/p/"+p.URL(), "", &from); w.Code == http.StatusOK {
+		t.Errorf("expected the old owner not to see the private paste")
+	}
+}
+
+// A logged in user can claim an anonymous paste with the token shown to its
+// creator
+func TestPostClaim(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	srv := New(log, testServerOptions())
+	defer srv.Close()
+
+	usr := token.User{ID: "test_user_claim", Name: "Claim"}
+	if _, err := srv.service.GetOrUpdateUser(store.User{ID: usr.ID, Name: usr.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("POST", "/p/", "body=Claimed+paste&privacy=public", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create paste: %d", w.Code)
+	}
+	url := strings.TrimPrefix(w.Header().Get("Location"), "/p/")
+	m := regexp.MustCompile(`<code id="claim-token">([0-9a-f]+)</code>`).FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("expected the creator to see the claim token")
+	}
+	tkn := m[1]
+	if w := serve("GET", "/p/"+url, "", nil); strings.Contains(w.Body.String(), "claim-token") {
+		t.Errorf("expected the token to only be shown once")
+	}
+	if w := serve("GET", "/p/"+url, "", &usr); !strings.Contains(w.Body.String(), `action="/p/`+url+`/claim"`) {
+		t.Errorf("expected a logged in user to see the claim form")
+	}
+
+	if w := serve("POST", "/p/"+url+"/claim", "token="+tkn, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for anonymous users, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve("POST", "/p/"+url+"/claim", "token=wrong", &usr); w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a wrong token, got %d", http.StatusForbidden, w.Code)
+	}
+	w = serve("POST", "/p/"+url+"/claim", "token="+tkn, &usr)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/l/?claimed=yes" {
+		t.Errorf("expected redirect to /l/?claimed=yes, got %d %s", w.Code, loc)
+	}
+	if w := serve("GET", "/l/?claimed=yes", "", &usr); !strings.Contains(w.Body.String(), "The paste is now yours.") {
+		t.Errorf("response should contain the summary message")
+	}
+	if w := serve("POST", "/p/"+url+"/claim", "token="+tkn, &usr); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a claimed paste, got %d", http.StatusNotFound, w.Code)
+	}
+}
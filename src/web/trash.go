@@ -0,0 +1,101 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/gorilla/mux"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+)
+
+// trashPurgeInterval is how often the pastes that have been in the trash
+// for too long are deleted.
+const trashPurgeInterval = time.Hour
+
+// handleGetTrash shows the pastes the current user has deleted and that
+// can still be restored.
+func (h *Server) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to see your trash.")
+		return
+	}
+
+	pastes, err := h.service.Trash(usr.ID)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+	userPastes, err := h.getUserPastes(usr.ID)
+	if err != nil {
+		h.showInternalError(w, err)
+		return
+	}
+
+	var msg string
+	if r.FormValue("restored") == "yes" {
+		msg = "The paste has been restored."
+	}
+
+	h.showPage(w,
+		page.Template("trash.html"),
+		page.Title(h.options.BrandName+" - Trash"),
+		page.Pastes(pastes),
+		page.UserPastes(userPastes),
+		page.User(usr),
+		page.Message(msg),
+	)
+}
+
+// handlePostRestore takes the current user's paste out of the trash and
+// redirects back to the trash.
+func (h *Server) handlePostRestore(w http.ResponseWriter, r *http.Request) {
+	usr, err := token.GetUserInfo(r)
+	if err != nil || usr.ID == "" {
+		h.showError(w, http.StatusUnauthorized, "You need to login to restore pastes.")
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	err = h.service.RestorePaste(id, usr.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrPasteNotFound):
+		h.showError(w, http.StatusNotFound, "There is no such paste in your trash")
+		return
+	default:
+		h.showInternalError(w, err)
+		return
+	}
+	h.log.Logf("INFO paste %s restored by %s", id, usr.ID)
+	http.Redirect(w, r, "/l/trash?restored=yes", http.StatusSeeOther)
+}
+
+// purgeTrash deletes the pastes that have been in the trash for longer than
+// the retention period, on start and then every trashPurgeInterval until
+// the server is closed.
+func (h *Server) purgeTrash() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := h.service.PurgeTrash()
+		if err != nil {
+			h.log.Logf("ERROR purging the trash failed: %v", err)
+		} else if purged > 0 {
+			h.log.Logf("INFO purged %d paste(s) from the trash", purged)
+		}
+
+		select {
+		case <-h.stopPurge:
+			return
+		case <-ticker.C:
+		}
+	}
+}
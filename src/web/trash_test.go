@@ -0,0 +1,93 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/lgr"
+	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+// Deleted pastes go to the trash and can be restored by their owner
+func TestTrash(t *testing.T) {
+	t.Parallel()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.TrashRetention = time.Hour
+	srv := New(log, opts)
+	defer srv.Close()
+
+	usr := token.User{ID: "test_user_trash", Name: "Test User Trash"}
+	if _, err := srv.service.GetOrUpdateUser(store.User{ID: usr.ID, Name: usr.Name}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	p, err := srv.service.NewPaste(service.PasteRequest{Title: "Trashed paste", Body: "Test paste", Privacy: "public", UserID: usr.ID})
+	if err != nil {
+		t.Fatalf("failed to create paste: %v", err)
+	}
+
+	serve := func(method, path, body string, u *token.User) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if u != nil {
+			r = token.SetUserInfo(r, *u)
+		}
+		srv.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("POST", "/l/delete", "ids="+strconv.FormatInt(p.ID, 10), &usr)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/l/?trashed=1" {
+		t.Errorf("expected redirect to /l/?trashed=1, got %d %s", w.Code, loc)
+	}
+	if _, err := srv.service.GetPaste(p.URL(), usr.ID, ""); !errors.Is(err, service.ErrPasteNotFound) {
+		t.Errorf("expected paste to be hidden, got %v", err)
+	}
+	if w := serve("GET", "/l/?trashed=1", "", &usr); !strings.Contains(w.Body.String(), "Moved 1 paste(s) to the trash.") {
+		t.Errorf("response should contain the summary message")
+	}
+
+	// trash page
+	if w := serve("GET", "/l/trash", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	w = serve("GET", "/l/trash", "", &usr)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status should be %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Trashed paste") || !strings.Contains(w.Body.String(), "/p/"+p.URL()+"/restore") {
+		t.Errorf("response should list the deleted paste")
+	}
+
+	// restore
+	other := token.User{ID: "test_user_trash_other", Name: "Other User"}
+	if w := serve("POST", "/p/"+p.URL()+"/restore", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("status should be %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serve("POST", "/p/"+p.URL()+"/restore", "", &other); w.Code != http.StatusNotFound {
+		t.Errorf("status should be %d, got %d", http.StatusNotFound, w.Code)
+	}
+	w = serve("POST", "/p/"+p.URL()+"/restore", "", &usr)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusSeeOther || loc != "/l/trash?restored=yes" {
+		t.Errorf("expected redirect to /l/trash?restored=yes, got %d %s", w.Code, loc)
+	}
+	if _, err := srv.service.GetPaste(p.URL(), usr.ID, ""); err != nil {
+		t.Errorf("expected paste to be restored, got %v", err)
+	}
+	if w := serve("GET", "/l/trash?restored=yes", "", &usr); !strings.Contains(w.Body.String(), "The trash is empty.") {
+		t.Errorf("response should show an empty trash")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+
+	"github.com/iliafrenkel/go-pb/src/service"
+)
+
+// parseCIDRs parses the trusted networks in CIDR notation, such as
+// 10.0.0.0/8 or fd00::/8.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedClient reports whether the client address of the request, the one
+// from the proxy headers if they are trusted, is in a trusted network.
+func (h *Server) trustedClient(r *http.Request) bool {
+	return service.InNetworks(clientIP(r), h.trusted)
+}
+
+// maxBodySize returns the maximum size of the request body, trusted clients
+// get TrustedMaxBodySize instead of MaxBodySize.
+func (h *Server) maxBodySize(r *http.Request) int64 {
+	if !h.trustedClient(r) {
+		return h.options.MaxBodySize
+	}
+	if h.options.TrustedMaxBodySize == 0 {
+		return math.MaxInt64
+	}
+	return h.options.TrustedMaxBodySize
+}
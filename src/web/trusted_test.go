@@ -0,0 +1,80 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// Only valid networks in CIDR notation are accepted
+func TestParseCIDRs(t *testing.T) {
+	t.Parallel()
+
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("expected the networks to be valid, got %v", err)
+	}
+	if len(nets) != 2 || nets[0].String() != "10.0.0.0/8" || nets[1].String() != "fd00::/8" {
+		t.Errorf("expected the parsed networks, got %v", nets)
+	}
+	for _, c := range []string{"10.0.0.1", "10.0.0.0/33", "lan"} {
+		if _, err := parseCIDRs([]string{c}); err == nil {
+			t.Errorf("expected %q to be invalid", c)
+		}
+	}
+}
+
+// Requests from the trusted networks bypass the anonymous paste limit and
+// the body size limit, the others hit them
+func TestTrustedCIDRs(t *testing.T) {
+	t.Parallel()
+
+	opts := testServerOptions()
+	opts.MaxAnonymousPastes = 1
+	opts.MaxBodySize = 64
+	opts.TrustedCIDRs = []string{"10.0.0.0/8"}
+	opts.TrustProxyHeaders = true
+	srv := New(lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces), opts)
+	hdlr := srv.proxyHeaders(srv.router)
+
+	post := func(ip, body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/api/v1/paste", strings.NewReader(body))
+		r.Header.Set("Content-Type", "text/plain")
+		r.RemoteAddr = "192.0.2.10:1234" // the reverse proxy
+		r.Header.Set("X-Forwarded-For", ip)
+		hdlr.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	large := strings.Repeat("x", 128)
+	for _, tc := range []struct {
+		ip      string
+		trusted bool
+	}{
+		{"10.1.2.3", true},
+		{"198.51.100.7", false},
+	} {
+		if code := post(tc.ip, "small"); code != http.StatusCreated {
+			t.Fatalf("%s: first paste should be created, got %d", tc.ip, code)
+		}
+		want := http.StatusForbidden
+		if tc.trusted {
+			want = http.StatusCreated
+		}
+		if code := post(tc.ip, "small again"); code != want {
+			t.Errorf("%s: second paste status should be %d, got %d", tc.ip, want, code)
+		}
+		want = http.StatusBadRequest
+		if tc.trusted {
+			want = http.StatusCreated
+		}
+		if code := post(tc.ip, large); code != want {
+			t.Errorf("%s: large paste status should be %d, got %d", tc.ip, want, code)
+		}
+	}
+}
@@ -8,12 +8,19 @@ package web
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-pkgz/auth"
@@ -23,39 +30,128 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/iliafrenkel/go-pb/src/service"
+	"github.com/iliafrenkel/go-pb/src/service/mail"
 	"github.com/iliafrenkel/go-pb/src/store"
+	"github.com/iliafrenkel/go-pb/src/web/page"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // ServerOptions defines various parameters needed to run the WebServer
 type ServerOptions struct {
-	Addr               string        // address to listen on, see http.Server docs for details
-	Proto              string        // protocol, either "http" or "https"
-	ReadTimeout        time.Duration // maximum duration for reading the entire request.
-	WriteTimeout       time.Duration // maximum duration before timing out writes of the response
-	IdleTimeout        time.Duration // maximum amount of time to wait for the next request
-	LogFile            string        // if not empty, will write logs to the file
-	LogMode            string        // can be either "debug" or "production"
-	BrandName          string        // displayed at the top of each page, default is "Go PB"
-	BrandTagline       string        // displayed below the BrandName
-	Assets             string        // location of the assets folder (css, js, images)
-	Templates          string        // location of the templates folder
-	Logo               string        // name of the logo image within the assets folder
-	MaxBodySize        int64         // maximum size for request's body
-	BootstrapTheme     string        // one of the themes, see css files in the assets folder
-	Version            string        // app version, comes from build
-	AuthSecret         string        // secret for JWT token generation and validation
-	AuthTokenDuration  time.Duration // JWT token expiration duration
-	AuthCookieDuration time.Duration // cookie expiration time
-	AuthIssuer         string        // application name used as an issuer in oauth requests
-	AuthURL            string        // callback URL for oauth requests
-	DBType             string        // type of the store to use
-	DBConn             string        // database connection string
-	GitHubCID          string        // github client id for oauth
-	GitHubCSEC         string        // github client secret for oauth
-	GoogleCID          string        // google client id for oauth
-	GoogleCSEC         string        // google client secret for oauth
-	TwitterCID         string        // twitter client id for oauth
-	TwitterCSEC        string        // twitter client secret for oauth
+	Addr                     string         // address to listen on, see http.Server docs for details
+	Proto                    string         // protocol, either "http" or "https"
+	TLSCert                  string         // path to the TLS certificate, the server serves HTTPS if it is set
+	TLSKey                   string         // path to the TLS private key of TLSCert
+	AutocertDomains          []string       // domains to get Let's Encrypt certificates for, the server serves HTTPS if it is set, instead of TLSCert
+	AutocertCacheDir         string         // directory where the Let's Encrypt certificates are kept
+	RedirectHTTPPort         int            // port of the plain HTTP listener that redirects to HTTPS, 0 means none
+	TrustProxyHeaders        bool           // trust X-Forwarded-* headers set by a reverse proxy
+	ReadTimeout              time.Duration  // maximum duration for reading the entire request.
+	WriteTimeout             time.Duration  // maximum duration before timing out writes of the response
+	IdleTimeout              time.Duration  // maximum amount of time to wait for the next request
+	ReadHeaderTimeout        time.Duration  // maximum duration for reading request headers
+	LogFile                  string         // if not empty, will write logs to the file
+	LogMode                  string         // can be either "debug" or "production"
+	BrandName                string         // displayed at the top of each page, default is "Go PB"
+	BrandTagline             string         // displayed below the BrandName
+	TimeFormat               string         // Go layout of the times shown when the browser can't localize them, default is defaultTimeFormat
+	Assets                   string         // location of the assets folder (css, js, images)
+	Templates                string         // location of the templates folder
+	UseEmbedded              bool           // use the templates and assets embedded in the binary instead of the folders
+	HotReloadTemplates       bool           // parse the templates again for every page, only in debug mode
+	Logo                     string         // logo image within the assets folder, absolute path or URL
+	Favicon                  string         // path to the favicon, default is favicon/favicon.ico in assets
+	FooterHTML               string         // HTML added to the footer of every page, trusted and not escaped
+	LandingMarkdownFile      string         // markdown file shown to anonymous visitors at /, empty means the new paste form
+	RecentPastes             int            // number of pastes in the sidebar, 0 means 10
+	SupportMessage           string         // message shown on internal errors, empty means defaultSupportMessage
+	RobotsTxt                string         // content of /robots.txt, empty means defaultRobotsTxt
+	WebhookURL               string         // if not empty, new pastes are posted to this URL
+	MaxBodySize              int64          // maximum size for request's body
+	TrustedCIDRs             []string       // networks of clients that aren't limited per IP and have TrustedMaxBodySize, e.g. internal tools
+	TrustedMaxBodySize       int64          // maximum size for the body of requests from the trusted networks, 0 means no limit
+	BootstrapTheme           string         // one of the themes, see css files in the assets folder
+	Version                  string         // app version, comes from build
+	AuthSecret               string         // secret for JWT token generation and validation
+	CookieSecret             string         // secret for signing session cookies, falls back to AuthSecret
+	CookieDomain             string         // domain for session cookies, empty means the request host
+	ContentSecurityPolicy    string         // value of the Content-Security-Policy header, empty means the default
+	AuthTokenDuration        time.Duration  // JWT token expiration duration
+	AuthCookieDuration       time.Duration  // cookie expiration time
+	AuthIssuer               string         // application name used as an issuer in oauth requests
+	AuthURL                  string         // callback URL for oauth requests
+	DBType                   string         // type of the store to use
+	DBConn                   string         // database connection string
+	DBMaxOpenConns           int            // maximum number of open postgres connections, 0 for the default, negative for no limit
+	DBMaxIdleConns           int            // maximum number of idle postgres connections, 0 for the default, negative to keep none
+	DBConnMaxLifetime        time.Duration  // maximum time a postgres connection is reused, 0 for the default, negative for no limit
+	GitHubCID                string         // github client id for oauth
+	GitHubCSEC               string         // github client secret for oauth
+	GoogleCID                string         // google client id for oauth
+	GoogleCSEC               string         // google client secret for oauth
+	TwitterCID               string         // twitter client id for oauth
+	TwitterCSEC              string         // twitter client secret for oauth
+	GiteaCID                 string         // gitea client id for oauth
+	GiteaCSEC                string         // gitea client secret for oauth
+	GiteaURL                 string         // gitea instance URL, e.g. https://gitea.example.com
+	GitLabCID                string         // gitlab client id for oauth
+	GitLabCSEC               string         // gitlab client secret for oauth
+	GitLabURL                string         // gitlab instance URL, default is https://gitlab.com
+	EnableDevAuth            bool           // enable dev oauth provider, never use in production
+	EnableLocalAuth          bool           // enable local accounts with email and password
+	RequireEmailVerification bool           // new local accounts must confirm their email before login
+	BcryptCost               int            // bcrypt cost of password hashes, 0 means bcrypt.DefaultCost
+	SMTPHost                 string         // SMTP server for outgoing emails, empty means emails are logged
+	SMTPPort                 int            // SMTP server port
+	SMTPUser                 string         // SMTP user, empty means no authentication
+	SMTPPass                 string         // SMTP password
+	SMTPFrom                 string         // sender address of the outgoing emails
+	Mailer                   service.Mailer // overrides the SMTP mailer, mostly for tests
+	Admins                   []string       // IDs of the users with admin rights
+	FirstUserAdmin           bool           // the first user to register or login becomes an admin
+	LoginMaxFailures         int            // wrong passwords from one IP that lock a local login out, 0 means never
+	LoginWindow              time.Duration  // period in which LoginMaxFailures wrong passwords lock the login out
+	LoginLockout             time.Duration  // how long a local login stays locked out
+	AvatarDir                string         // local directory for user avatars
+	AvatarS3Bucket           string         // if not empty, avatars are stored in this S3 bucket
+	AvatarS3Region           string         // S3 region, default is us-east-1
+	AvatarS3Endpoint         string         // S3 endpoint, default is AWS, set for S3 compatible storage
+	AvatarS3AccessKey        string         // S3 access key
+	AvatarS3SecretKey        string         // S3 secret key
+	MinExpiration            time.Duration  // shortest allowed paste expiration, 0 means no limit
+	MaxExpiration            time.Duration  // longest allowed paste expiration, 0 means no limit
+	AnonMaxExpiration        time.Duration  // anonymous pastes expire no later than that, even if they ask for never, 0 means no cap
+	ExpiringSoon             time.Duration  // the list page shows the user's pastes expiring within that, 0 hides them
+	RequireLoginToPaste      bool           // only logged in users can create pastes, anonymous ones are asked to login
+	EnableComments           bool           // logged in users can comment on pastes, comments are shown below the paste
+	ExpirationPresets        []string       // expiration options for the new paste form, e.g. "10m", "1d", "never"
+	DefaultExpiration        string         // expiration of new pastes that don't set one, e.g. "1M", empty means "never"
+	DefaultPrivacyAnon       string         // privacy of anonymous pastes when the form doesn't set it, default is "public"
+	DefaultPrivacyUser       string         // privacy of logged in users' pastes when the form doesn't set it, default is "public"
+	MaxPastesPerUser         int            // maximum number of pastes per user, 0 means no limit
+	MaxAnonymousPastes       int            // maximum number of anonymous pastes per IP, 0 means no limit
+	MaxReports               int            // maximum number of abuse reports per IP per hour, 0 means no limit
+	MaxTitleLength           int            // longest paste title in characters, 0 means no limit
+	TruncateTitles           bool           // cut the titles longer than MaxTitleLength instead of rejecting the paste
+	URLKey                   string         // secret key to obfuscate paste IDs in the URLs, empty means plain IDs
+	TrashRetention           time.Duration  // how long deleted pastes are kept in the trash, 0 means deletes are permanent
+	ReadOnly                 bool           // start in maintenance mode, where pastes can be read but not changed
+	IDBits                   int            // bit width of the random paste IDs, 0 means the full 63 bits
+	SkipOwnerViews           bool           // don't count views of the paste owner
+	BotUserAgents            []string       // User-Agent substrings of bots whose views are not counted
+	AllowedPrivacyAnon       []string       // privacy values anonymous users may choose, empty means all
+	AllowedSyntaxes          []string       // syntaxes new pastes may use, empty means all
+	ConfirmSize              int64          // body size in bytes above which new pastes must be confirmed, 0 means no limit
+	SecretPatterns           []string       // "name=regexp" secrets that new pastes must be confirmed for, empty means the service defaults
+	SkipSecretScan           bool           // don't ask to confirm pastes that look like they contain secrets
+	BlockedPatterns          []string       // regexps of the content public pastes must not have in the title or the body
+	UnlistBlocked            bool           // make the public pastes with blocked content unlisted instead of rejecting them
+	BodyEncoding             string         // bodies that are not UTF-8: "keep" or empty stores them as they are, "convert" converts or rejects them, "replace" converts them lossily
+	StatsTTL                 time.Duration  // how long the instance stats are cached, 0 means they are collected on every request
+	StatsAllSyntaxes         bool           // include private and unlisted pastes in the per syntax breakdown of the instance stats
+	EnableMetrics            bool           // expose Prometheus metrics on /metrics
+	EnableCompression        bool           // compress large text responses with gzip or deflate
+	CompressionMinSize       int            // smallest response to compress, default is 1024 bytes
 	store.DiskConfig
 }
 
@@ -63,12 +159,33 @@ type ServerOptions struct {
 // Normally, you'd create a new instance by calling New which configures the
 // rotuer and then call ListenAndServe to start serving incoming requests.
 type Server struct {
-	router    *mux.Router
-	server    *http.Server
-	options   ServerOptions
-	templates *template.Template
-	log       *lgr.Logger
-	service   *service.Service
+	router      *mux.Router
+	server      *http.Server
+	redirect    *http.Server // plain HTTP server that redirects to HTTPS, nil if there is none
+	options     ServerOptions
+	templates   *template.Template
+	templatesFS fs.FS        // folder the templates are loaded from
+	templatesMu sync.RWMutex // guards templates when they are reloaded
+	log         *lgr.Logger
+	service     *service.Service
+	metrics     *metrics
+	providers   []string // names of the enabled auth providers
+	auth        *auth.Service
+	expirations []page.Expiration // validated expiration presets
+	logo        string            // URL of the logo image
+	assets      fs.FS             // static files served at /assets/
+	webhooks    sync.WaitGroup    // webhook requests in flight
+	mail        *mail.Async       // emails in flight
+	draining    atomic.Bool       // set when shutdown begins
+	inFlight    atomic.Int64      // number of requests being served
+	readOnly    atomic.Bool       // set in maintenance mode
+	openAPIDoc  []byte            // API description served at /api/v1/openapi.json
+	trusted     []*net.IPNet      // parsed TrustedCIDRs
+	blocked     []*regexp.Regexp  // parsed BlockedPatterns
+	idempotency *idempotencyKeys  // pastes created by API requests with an Idempotency-Key
+	landing     template.HTML     // rendered LandingMarkdownFile, empty if there is none
+	stopPurge   chan struct{}     // closed to stop purging the trash
+	closeOnce   sync.Once
 }
 
 var dbgLogFormatter handlers.LogFormatter = func(writer io.Writer, params handlers.LogFormatterParams) {
@@ -122,19 +239,262 @@ var dbgLogFormatter handlers.LogFormatter = func(writer io.Writer, params handle
 		host = params.Request.RemoteAddr
 	}
 
-	fmt.Fprintf(writer, "|%s %3d %s| %15s |%s %-7s %s| %8d | %s \n",
+	fmt.Fprintf(writer, "|%s %3d %s| %15s |%s %-7s %s| %8d | %s | %s \n",
 		cclr, code, reset,
 		host,
 		mclr, method, reset,
 		params.Size,
+		params.Request.Header.Get(requestIDHeader),
 		params.URL.RequestURI(),
 	)
 }
 
+// cookieSecret returns the secret used to sign session cookies.
+func (opts ServerOptions) cookieSecret() string {
+	if opts.CookieSecret != "" {
+		return opts.CookieSecret
+	}
+	return opts.AuthSecret
+}
+
+// servesTLS reports whether the server serves HTTPS itself, with a
+// certificate or with autocert.
+func (opts ServerOptions) servesTLS() bool {
+	return opts.TLSCert != "" || len(opts.AutocertDomains) > 0
+}
+
+// validate checks that the options are safe to run with.
+func (opts ServerOptions) validate() error {
+	if opts.LogMode != "debug" && opts.cookieSecret() == "" {
+		return fmt.Errorf("cookie secret must be set in production mode, use --web-cookie-secret or GOPB_WEB_COOKIE_SECRET")
+	}
+	if opts.BcryptCost != 0 {
+		if err := service.ValidateBcryptCost(opts.BcryptCost); err != nil {
+			return fmt.Errorf("%v, use --auth-bcrypt-cost or GOPB_AUTH_BCRYPT_COST", err)
+		}
+	}
+	for _, p := range []string{opts.DefaultPrivacyAnon, opts.DefaultPrivacyUser} {
+		if p != "" && p != "private" && p != "public" && p != "unlisted" {
+			return fmt.Errorf("default privacy can be one of 'private', 'public' or 'unlisted', got %q", p)
+		}
+	}
+	for _, p := range opts.AllowedPrivacyAnon {
+		if p != "private" && p != "public" && p != "unlisted" {
+			return fmt.Errorf("allowed privacy can be one of 'private', 'public' or 'unlisted', got %q", p)
+		}
+	}
+	if !allowed(opts.AllowedPrivacyAnon, opts.defaultPrivacy(false)) {
+		return fmt.Errorf("default privacy of anonymous pastes %q is not allowed, use --paste-privacy-anon or GOPB_PASTE_PRIVACY_ANON", opts.defaultPrivacy(false))
+	}
+	if opts.IDBits != 0 && (opts.IDBits < store.MinIDBits || opts.IDBits > store.MaxIDBits) {
+		return fmt.Errorf("paste ID bits must be between %d and %d, got %d, use --paste-id-bits or GOPB_PASTE_ID_BITS", store.MinIDBits, store.MaxIDBits, opts.IDBits)
+	}
+	if _, err := service.ParseSecretPatterns(opts.SecretPatterns); err != nil {
+		return fmt.Errorf("%v, use --paste-secret-pattern or GOPB_PASTE_SECRET_PATTERNS", err)
+	}
+	if _, err := parseBlockedPatterns(opts.BlockedPatterns); err != nil {
+		return fmt.Errorf("%v, use --paste-blocked-pattern or GOPB_PASTE_BLOCKED_PATTERNS", err)
+	}
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return fmt.Errorf("TLS needs both the certificate and the key, use --web-tls-cert and --web-tls-key or GOPB_WEB_TLS_CERT and GOPB_WEB_TLS_KEY")
+	}
+	if opts.TLSCert != "" && len(opts.AutocertDomains) > 0 {
+		return fmt.Errorf("TLS certificate and autocert can't be used together, use either --web-tls-cert or --web-autocert-domain")
+	}
+	if len(opts.AutocertDomains) > 0 && opts.AutocertCacheDir == "" {
+		return fmt.Errorf("autocert needs a directory for the certificates, use --web-autocert-dir or GOPB_WEB_AUTOCERT_DIR")
+	}
+	if opts.servesTLS() && opts.Proto != "https" {
+		return fmt.Errorf("TLS is configured but the protocol is %q, use --web-proto=https or GOPB_WEB_PROTO=https", opts.Proto)
+	}
+	if opts.RedirectHTTPPort != 0 && !opts.servesTLS() {
+		return fmt.Errorf("http redirect needs a TLS certificate, use --web-tls-cert and --web-tls-key or GOPB_WEB_TLS_CERT and GOPB_WEB_TLS_KEY")
+	}
+	if opts.Proto == "https" && !opts.servesTLS() && !opts.TrustProxyHeaders {
+		return fmt.Errorf("https needs a TLS certificate, use --web-tls-cert and --web-tls-key, --web-autocert-domain, or --web-trust-proxy if a reverse proxy terminates TLS")
+	}
+	if opts.BodyEncoding != "" && opts.BodyEncoding != "keep" && opts.BodyEncoding != "convert" && opts.BodyEncoding != "replace" {
+		return fmt.Errorf("body encoding can be one of 'keep', 'convert' or 'replace', got %q", opts.BodyEncoding)
+	}
+	if _, err := parseCIDRs(opts.TrustedCIDRs); err != nil {
+		return fmt.Errorf("%v, use --web-trusted-cidr or GOPB_WEB_TRUSTED_CIDRS", err)
+	}
+	if opts.TrustedMaxBodySize < 0 {
+		return fmt.Errorf("trusted body size can't be negative, got %d, use --web-trusted-max-body-size or GOPB_WEB_TRUSTED_MAX_BODY_SIZE", opts.TrustedMaxBodySize)
+	}
+	if opts.ExpiringSoon < 0 {
+		return fmt.Errorf("expiring soon window can't be negative, got %s, use --paste-expiring-soon or GOPB_PASTE_EXPIRING_SOON", opts.ExpiringSoon)
+	}
+	if opts.StatsTTL < 0 {
+		return fmt.Errorf("stats TTL can't be negative, got %s, use --paste-stats-ttl or GOPB_PASTE_STATS_TTL", opts.StatsTTL)
+	}
+	if opts.MaxTitleLength < 0 {
+		return fmt.Errorf("maximum title length can't be negative, got %d, use --paste-max-title-length or GOPB_PASTE_MAX_TITLE_LENGTH", opts.MaxTitleLength)
+	}
+	if opts.RecentPastes < 0 {
+		return fmt.Errorf("number of recent pastes can't be negative, got %d, use --web-recent-pastes or GOPB_WEB_RECENT_PASTES", opts.RecentPastes)
+	}
+	if opts.LoginMaxFailures > 0 && (opts.LoginWindow <= 0 || opts.LoginLockout <= 0) {
+		return fmt.Errorf("login window and lockout must be positive, use --auth-login-window and --auth-lockout or GOPB_AUTH_LOGIN_WINDOW and GOPB_AUTH_LOCKOUT")
+	}
+	if opts.RequireEmailVerification && opts.SMTPHost == "" && opts.Mailer == nil && opts.LogMode != "debug" {
+		return fmt.Errorf("email verification needs an SMTP server, use --smtp-host or GOPB_SMTP_HOST")
+	}
+	return nil
+}
+
+// defaultTimeFormat is the layout of the times shown on the pages when
+// ServerOptions.TimeFormat is not set. The times are in UTC until the
+// browser converts them to the reader's timezone.
+const defaultTimeFormat = "Jan 2, 2006 15:04 MST"
+
+// templateFuncs returns the functions available in the templates.
+func (h *Server) templateFuncs() template.FuncMap {
+	layout := h.options.TimeFormat
+	if layout == "" {
+		layout = defaultTimeFormat
+	}
+	return template.FuncMap{
+		// timestamp is the machine readable time for the data-timestamp
+		// attributes, the pages convert it to the reader's timezone
+		"timestamp": func(t time.Time) string {
+			return t.UTC().Format(time.RFC3339)
+		},
+		// datetime is the time shown until it is converted or if the
+		// browser can't do it
+		"datetime": func(t time.Time) string {
+			return t.UTC().Format(layout)
+		},
+	}
+}
+
+// defaultExpiration returns the expiration of new pastes that don't set
+// one.
+func (opts ServerOptions) defaultExpiration() string {
+	if opts.DefaultExpiration == "" {
+		return "never"
+	}
+	return opts.DefaultExpiration
+}
+
+// supportMessage returns the message shown to the user on internal errors.
+func (opts ServerOptions) supportMessage() string {
+	if opts.SupportMessage == "" {
+		return defaultSupportMessage
+	}
+	return opts.SupportMessage
+}
+
+// defaultPrivacy returns the privacy of the new pastes that don't set it,
+// depending on whether the user is logged in.
+func (opts ServerOptions) defaultPrivacy(loggedIn bool) string {
+	p := opts.DefaultPrivacyAnon
+	if loggedIn {
+		p = opts.DefaultPrivacyUser
+	}
+	if p == "" {
+		return "public"
+	}
+	return p
+}
+
+// Default HTTP timeouts used when the options don't set them. Zero would
+// mean no timeout at all, which leaves the server open to slow clients.
+const (
+	defaultReadTimeout       = 15 * time.Second
+	defaultWriteTimeout      = 15 * time.Second
+	defaultIdleTimeout       = 60 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+)
+
+// durationOrDefault returns d if it is positive and def otherwise.
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// httpServer returns an http.Server for the handler with the configured
+// timeouts, falling back to the defaults for zero values.
+func (h *Server) httpServer(hdlr http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              h.options.Addr,
+		WriteTimeout:      durationOrDefault(h.options.WriteTimeout, defaultWriteTimeout),
+		ReadTimeout:       durationOrDefault(h.options.ReadTimeout, defaultReadTimeout),
+		IdleTimeout:       durationOrDefault(h.options.IdleTimeout, defaultIdleTimeout),
+		ReadHeaderTimeout: durationOrDefault(h.options.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		Handler:           hdlr,
+	}
+}
+
+// defaultExpirationPresets are used when ServerOptions.ExpirationPresets is
+// empty. Presets outside of the configured bounds are skipped.
+var defaultExpirationPresets = []string{"never", "10m", "30m", "1h", "3h", "6h", "12h",
+	"1d", "3d", "1w", "2w", "1M", "6M", "1y", "3y"}
+
+// expirationUnits maps expiration units to their names.
+var expirationUnits = map[byte]string{'m': "minute", 'h': "hour", 'd': "day", 'w': "week", 'M': "month", 'y': "year"}
+
+// expirationLabel returns a human readable label for an expiration preset,
+// for example "3 days" for "3d".
+func expirationLabel(exp string) string {
+	if exp == "never" {
+		return "Never"
+	}
+	n, unit := exp[:len(exp)-1], expirationUnits[exp[len(exp)-1]]
+	if n != "1" {
+		unit += "s"
+	}
+	return n + " " + unit
+}
+
+// expirationPresets validates the configured expiration presets against the
+// service and returns them as dropdown options.
+func (h *Server) expirationPresets() ([]page.Expiration, error) {
+	presets := h.options.ExpirationPresets
+	strict := len(presets) > 0
+	if !strict {
+		presets = defaultExpirationPresets
+	}
+	var res []page.Expiration
+	for _, exp := range presets {
+		if err := h.service.ValidateExpiration(exp); err != nil {
+			if strict {
+				return nil, fmt.Errorf("invalid expiration preset %q: %w", exp, err)
+			}
+			continue
+		}
+		res = append(res, page.Expiration{Value: exp, Label: expirationLabel(exp)})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no expiration presets within the allowed bounds")
+	}
+	return res, nil
+}
+
 // ListenAndServe starts an HTTP server and binds it to the provided address.
-// You have to call New() first to initialise the WebServer.
+// The server serves HTTPS if TLSCert and TLSKey or AutocertDomains are set,
+// along with a plain HTTP listener that redirects to it if RedirectHTTPPort
+// is set. You have to call New() first to initialise the WebServer.
 func (h *Server) ListenAndServe() error {
-	var hdlr http.Handler
+	ln, err := net.Listen("tcp", h.options.Addr)
+	if err != nil {
+		return fmt.Errorf("WebServer.ListenAndServer: %w", err)
+	}
+	redirectLn, err := h.listenRedirect()
+	if err != nil {
+		ln.Close()
+		return fmt.Errorf("WebServer.ListenAndServer: http redirect: %w", err)
+	}
+	return h.serve(ln, redirectLn)
+}
+
+// serve accepts the connections on the listener, over TLS if TLSCert and
+// TLSKey or AutocertDomains are set. Requests on redirectLn are redirected
+// to HTTPS unless it is nil.
+func (h *Server) serve(ln, redirectLn net.Listener) error {
+	var hdlr http.Handler = h.router
 	var w io.Writer
 	var err error
 	if h.options.LogFile == "" {
@@ -142,30 +502,192 @@ func (h *Server) ListenAndServe() error {
 	} else {
 		w, err = os.OpenFile(h.options.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 		if err != nil {
+			ln.Close()
+			if redirectLn != nil {
+				redirectLn.Close()
+			}
 			return fmt.Errorf("WebServer.ListenAndServer: cannot open log file: [%s]: %w", h.options.LogFile, err)
 		}
 	}
+	if h.options.EnableCompression {
+		hdlr = h.compress(hdlr)
+	}
 	if h.options.LogMode == "debug" {
-		hdlr = handlers.CustomLoggingHandler(w, h.router, dbgLogFormatter)
+		hdlr = handlers.CustomLoggingHandler(w, hdlr, dbgLogFormatter)
 	} else {
-		hdlr = handlers.CombinedLoggingHandler(w, h.router)
+		hdlr = handlers.CombinedLoggingHandler(w, hdlr)
 	}
-	h.server = &http.Server{
-		Addr:         h.options.Addr,
-		WriteTimeout: h.options.WriteTimeout,
-		ReadTimeout:  h.options.ReadTimeout,
-		IdleTimeout:  h.options.IdleTimeout,
-		Handler:      hdlr,
+	if h.options.TrustProxyHeaders {
+		hdlr = h.proxyHeaders(hdlr) // before logging to log the real client address
 	}
+	h.server = h.httpServer(hdlr)
+	h.log.Logf("INFO http timeouts: read=%s, read-header=%s, write=%s, idle=%s",
+		h.server.ReadTimeout, h.server.ReadHeaderTimeout, h.server.WriteTimeout, h.server.IdleTimeout)
 
-	return h.server.ListenAndServe()
+	var acm *autocert.Manager
+	if len(h.options.AutocertDomains) > 0 {
+		acm = h.autocertManager()
+		h.server.TLSConfig = acm.TLSConfig()
+		h.log.Logf("INFO getting certificates for %s with autocert", strings.Join(h.options.AutocertDomains, ", "))
+	}
+	if redirectLn != nil {
+		h.serveRedirect(redirectLn, ln.Addr(), acm)
+	}
+	switch {
+	case acm != nil:
+		return h.server.ServeTLS(ln, "", "")
+	case h.options.TLSCert != "":
+		return h.server.ServeTLS(ln, h.options.TLSCert, h.options.TLSKey)
+	}
+	return h.server.Serve(ln)
 }
 
-// Shutdown gracefully shutdown the server with the givem context.
+// Shutdown gracefully shutdown the server with the givem context. New
+// requests are answered with 503 while the requests in flight are given
+// until the context expires to finish.
 func (h *Server) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for h.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			h.log.Logf("WARN shutdown timed out with %d requests in flight", h.inFlight.Load())
+			return h.closeServer(ctx)
+		case <-ticker.C:
+		}
+	}
+	return h.closeServer(ctx)
+}
+
+// Close releases the resources held by the service, such as the store
+// connections, after waiting for the pending webhook notifications and
+// emails. Call it after Shutdown.
+func (h *Server) Close() error {
+	h.closeOnce.Do(func() { close(h.stopPurge) })
+	h.webhooks.Wait()
+	h.mail.Wait()
+	return h.service.Close()
+}
+
+func (h *Server) closeServer(ctx context.Context) error {
+	if h.redirect != nil {
+		if err := h.redirect.Shutdown(ctx); err != nil {
+			h.log.Logf("WARN http redirect listener forced to shutdown: %v", err)
+		}
+	}
+	if h.server == nil {
+		return nil
+	}
 	return h.server.Shutdown(ctx)
 }
 
+// defaultContentSecurityPolicy allows scripts and styles from the server
+// itself and the Bootstrap CDN. Inline scripts and styles are used by the
+// templates, avatars may come from the auth provider.
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: https:; " +
+	"object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+// securityHeaders sets headers that stop browsers from sniffing content
+// types, framing the pages and loading resources from unexpected places.
+func (h *Server) securityHeaders(next http.Handler) http.Handler {
+	csp := h.options.ContentSecurityPolicy
+	if csp == "" {
+		csp = defaultContentSecurityPolicy
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		w.Header().Set("Content-Security-Policy", csp)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultBotUserAgents are used when ServerOptions.BotUserAgents is empty.
+var defaultBotUserAgents = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit",
+	"embedly", "preview", "curl", "wget", "python-requests", "go-http-client"}
+
+// countView reports whether the request should count as a paste view. HEAD
+// requests and known bots, including link previews in messengers, don't
+// count.
+func (h *Server) countView(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	bots := h.options.BotUserAgents
+	if len(bots) == 0 {
+		bots = defaultBotUserAgents
+	}
+	ua := strings.ToLower(r.UserAgent())
+	for _, bot := range bots {
+		if bot != "" && strings.Contains(ua, strings.ToLower(bot)) {
+			return false
+		}
+	}
+	return true
+}
+
+// firstHeaderValue returns the first, i.e. the original, value of a comma
+// separated header that proxies append to.
+func firstHeaderValue(r *http.Request, name string) string {
+	return strings.TrimSpace(strings.Split(r.Header.Get(name), ",")[0])
+}
+
+// proxyHeaders replaces the remote address of the request with the client
+// address from X-Forwarded-For or X-Real-IP. It must only be used when the
+// server is behind a trusted reverse proxy, otherwise clients can spoof
+// their address.
+func (h *Server) proxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := firstHeaderValue(r, "X-Forwarded-For")
+		if ip == "" {
+			ip = strings.TrimSpace(r.Header.Get("X-Real-IP"))
+		}
+		if net.ParseIP(ip) != nil {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serverURL returns the base URL of the server, such as https://go-pb.com.
+// Behind a trusted reverse proxy the protocol and the host come from the
+// X-Forwarded-Proto and X-Forwarded-Host headers.
+func (h *Server) serverURL(r *http.Request) string {
+	proto, host := h.options.Proto, h.options.Addr
+	if h.options.TrustProxyHeaders {
+		if p := firstHeaderValue(r, "X-Forwarded-Proto"); p == "http" || p == "https" {
+			proto = p
+		}
+		if fh := firstHeaderValue(r, "X-Forwarded-Host"); fh != "" {
+			host = fh
+		}
+	}
+	return proto + "://" + host
+}
+
+// drain counts requests in flight and, once shutdown begins, answers new
+// requests with 503 Service Unavailable. Health checks are let through so
+// that they can report the draining state.
+func (h *Server) drain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.draining.Load() && r.URL.Path != "/healthz" {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "10")
+			h.showError(w, http.StatusServiceUnavailable, "The server is restarting, please try again in a moment.")
+			return
+		}
+		h.inFlight.Add(1)
+		defer h.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // New returns an instance of the WebServer with initialised middleware,
 // loaded templates and routes. You can call ListenAndServe on a newly
 // created instance to initialise the HTTP server and start handling incoming
@@ -174,26 +696,101 @@ func New(l *lgr.Logger, opts ServerOptions) *Server {
 	var handler Server
 	handler.log = l
 	handler.options = opts
+	handler.metrics = newMetrics()
+	handler.stopPurge = make(chan struct{})
+	handler.idempotency = newIdempotencyKeys(idempotencyTTL, maxIdempotencyKeys)
+	handler.readOnly.Store(opts.ReadOnly)
+
+	if err := opts.validate(); err != nil {
+		handler.log.Logf("FATAL invalid options: %v", err)
+	}
 
 	// Load template
-	tpl, err := template.ParseGlob(handler.options.Templates + "/*.html")
+	handler.assets = handler.resourceFS(opts.Assets, "assets")
+	handler.templatesFS = handler.resourceFS(opts.Templates, "templates")
+	tpl, err := handler.loadTemplates()
 	if err != nil {
 		handler.log.Logf("FATAL error loading templates: %v", err)
 	}
 	handler.log.Logf("INFO loaded %d templates", len(tpl.Templates()))
 	handler.templates = tpl
+	if opts.HotReloadTemplates {
+		if opts.LogMode == "debug" {
+			handler.log.Logf("INFO templates are reloaded on every page")
+		} else {
+			handler.log.Logf("WARN templates hot reload is only available in debug mode")
+		}
+	}
+
+	if opts.LandingMarkdownFile != "" {
+		md, err := os.ReadFile(opts.LandingMarkdownFile)
+		if err != nil {
+			handler.log.Logf("FATAL error loading landing page: %v", err)
+		}
+		handler.landing = service.RenderMarkdown(string(md))
+	}
+
+	if opts.URLKey != "" {
+		store.SetURLKey(opts.URLKey)
+		handler.log.Logf("INFO paste IDs in the URLs are obfuscated")
+	}
+	store.SetIDBits(opts.IDBits)
+
+	handler.trusted, _ = parseCIDRs(opts.TrustedCIDRs) // checked by validate
+
+	handler.blocked, _ = parseBlockedPatterns(opts.BlockedPatterns) // checked by validate
 
 	// Initialise the service
+	svcOpts := []service.Option{
+		service.WithExpirationBounds(opts.MinExpiration, opts.MaxExpiration),
+		service.WithAnonExpiration(opts.AnonMaxExpiration),
+		service.WithPasteLimits(opts.MaxPastesPerUser, opts.MaxAnonymousPastes),
+		service.WithCountOwnerViews(!opts.SkipOwnerViews),
+		service.WithReportLimit(opts.MaxReports),
+		service.WithTitleLimit(opts.MaxTitleLength, opts.TruncateTitles),
+		service.WithFirstUserAdmin(opts.FirstUserAdmin),
+		service.WithLoginLockout(opts.LoginMaxFailures, opts.LoginWindow, opts.LoginLockout),
+		service.WithRecentPastesLimit(opts.RecentPastes),
+		service.WithInstanceStats(opts.StatsTTL, opts.StatsAllSyntaxes),
+		service.WithTrustedNetworks(handler.trusted),
+	}
+	svcOpts = append(svcOpts, service.WithMailer(handler.mailer()))
+	if opts.TrashRetention > 0 {
+		svcOpts = append(svcOpts, service.WithTrash(opts.TrashRetention))
+	}
+	if opts.BcryptCost != 0 {
+		svcOpts = append(svcOpts, service.WithBcryptCost(opts.BcryptCost))
+	}
+	if opts.BodyEncoding == "convert" || opts.BodyEncoding == "replace" {
+		svcOpts = append(svcOpts, service.WithEncodingDetection(opts.BodyEncoding == "replace"))
+	}
+	if opts.SkipSecretScan {
+		svcOpts = append(svcOpts, service.WithSecretPatterns(nil))
+	} else if len(opts.SecretPatterns) > 0 {
+		patterns, _ := service.ParseSecretPatterns(opts.SecretPatterns) // checked by validate
+		svcOpts = append(svcOpts, service.WithSecretPatterns(patterns))
+	}
+	if opts.EnableLocalAuth {
+		svcOpts = append(svcOpts, service.WithPasswordReset(strings.TrimSuffix(opts.AuthURL, "/")+"/u/reset"))
+	}
+	if opts.EnableLocalAuth && opts.RequireEmailVerification {
+		svcOpts = append(svcOpts, service.WithEmailVerification(strings.TrimSuffix(opts.AuthURL, "/")+"/u/verify"))
+	}
 	switch opts.DBType {
 	case "disk":
-		handler.service, err = service.NewWithDiskDB(&opts.DiskConfig)
+		handler.service, err = service.NewWithDiskDB(&opts.DiskConfig, svcOpts...)
 		if err != nil {
 			handler.log.Logf("FATAL error creating Disk storage service: %v", err)
 		}
 	case "memory":
-		handler.service = service.NewWithMemDB()
+		handler.service = service.NewWithMemDB(svcOpts...)
 	case "postgres":
-		handler.service, err = service.NewWithPostgres(opts.DBConn)
+		pool := store.PostgresPool{
+			MaxOpenConns:    opts.DBMaxOpenConns,
+			MaxIdleConns:    opts.DBMaxIdleConns,
+			ConnMaxLifetime: opts.DBConnMaxLifetime,
+		}
+		handler.service, err = service.NewWithPostgres(opts.DBConn, pool, svcOpts...)
 		if err != nil {
 			handler.log.Logf("FATAL error creating Postgres service: %v", err)
 		}
@@ -201,31 +798,88 @@ func New(l *lgr.Logger, opts ServerOptions) *Server {
 		handler.log.Logf("FATAL unknown store type: %v", opts.DBType)
 	}
 
+	handler.expirations, err = handler.expirationPresets()
+	if err != nil {
+		handler.log.Logf("FATAL %v", err)
+	}
+	if opts.DefaultExpiration != "" {
+		if err = handler.service.ValidateExpiration(opts.DefaultExpiration); err != nil {
+			handler.log.Logf("FATAL invalid default expiration %q: %v", opts.DefaultExpiration, err)
+		}
+	}
+
+	// Prepare the API description, checking it for typos
+	if handler.openAPIDoc, err = json.Marshal(handler.openAPI()); err == nil {
+		err = validateOpenAPI(handler.openAPIDoc)
+	}
+	if err != nil {
+		handler.log.Logf("FATAL invalid API description: %v", err)
+	}
+
+	if opts.TrashRetention > 0 {
+		go handler.purgeTrash()
+	}
+
 	// Initialise the router
 	handler.router = mux.NewRouter()
+	handler.router.Use(handler.requestID)
+	handler.router.Use(handler.securityHeaders)
+	handler.router.Use(handler.drain)
+	handler.router.Use(handler.readOnlyGuard)
+
+	// Metrics
+	if handler.options.EnableMetrics {
+		handler.router.Use(handler.metrics.middleware)
+		handler.router.HandleFunc("/metrics", handler.handleGetMetrics).Methods("GET")
+	}
 
 	// Templates and static files
-	handler.router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir(handler.options.Assets))))
+	handler.router.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.FS(handler.assets))))
+	handler.router.HandleFunc("/favicon.ico", handler.faviconHandler()).Methods("GET", "HEAD")
+	handler.router.HandleFunc("/robots.txt", handler.handleGetRobots).Methods("GET", "HEAD")
+	handler.logo = handler.logoURL()
+	if filepath.IsAbs(handler.options.Logo) {
+		handler.router.HandleFunc("/logo", serveFile(handler.options.Logo)).Methods("GET", "HEAD")
+	}
+
+	// Avatar store
+	var avatarStore avatar.Store
+	if opts.AvatarS3Bucket != "" {
+		avatarStore = newS3AvatarStore(opts.AvatarS3Endpoint, opts.AvatarS3Region, opts.AvatarS3Bucket,
+			opts.AvatarS3AccessKey, opts.AvatarS3SecretKey)
+	} else {
+		if err = os.MkdirAll(opts.AvatarDir, 0o750); err != nil {
+			handler.log.Logf("FATAL error creating avatar directory: %v", err)
+		}
+		avatarStore = avatar.NewLocalFS(opts.AvatarDir)
+	}
+	handler.log.Logf("INFO using avatar store: %s", avatarStore)
 
 	// Auth middleware
 	authSvc := auth.NewService(auth.Opts{
 		SecretReader: token.SecretFunc(func(id string) (string, error) { // secret key for JWT
-			return handler.options.AuthSecret, nil
+			return handler.options.cookieSecret(), nil
 		}),
-		TokenDuration:  handler.options.AuthTokenDuration,
-		CookieDuration: handler.options.AuthCookieDuration,
-		Issuer:         handler.options.AuthIssuer,
-		URL:            handler.options.AuthURL,
-		DisableXSRF:    true,
-		AvatarStore:    avatar.NewLocalFS(".tmp"),
-		Logger:         handler.log, // optional logger for auth library
+		JWTCookieDomain: handler.options.CookieDomain,
+		ClaimsUpd:       token.ClaimsUpdFunc(handler.updateClaims),
+		SecureCookies:   handler.options.Proto == "https",
+		TokenDuration:   handler.options.AuthTokenDuration,
+		CookieDuration:  handler.options.AuthCookieDuration,
+		Issuer:          handler.options.AuthIssuer,
+		URL:             handler.options.AuthURL,
+		DisableXSRF:     true,
+		AvatarStore:     avatarStore,
+		Logger:          handler.log, // optional logger for auth library
 	})
-	authSvc.AddProvider("github", handler.options.GitHubCID, handler.options.GitHubCSEC)
-	authSvc.AddProvider("google", handler.options.GoogleCID, handler.options.GoogleCSEC)
-	authSvc.AddProvider("twitter", handler.options.TwitterCID, handler.options.TwitterCSEC)
+	handler.auth = authSvc
+	handler.addAuthProviders(authSvc)
+	if opts.EnableLocalAuth {
+		handler.providers = append(handler.providers, "local")
+	}
 
-	if opts.LogMode == "debug" {
+	if opts.EnableDevAuth {
 		authSvc.AddProvider("dev", "", "") // dev auth, runs dev oauth2 server on :8084
+		handler.providers = append(handler.providers, "dev")
 
 		go func() {
 			devAuthServer, err := authSvc.DevAuth()
@@ -240,20 +894,63 @@ func New(l *lgr.Logger, opts ServerOptions) *Server {
 	m := authSvc.Middleware()
 	handler.router.Use(m.Trace)
 	authRoutes, avaRoutes := authSvc.Handlers()
-	handler.router.PathPrefix("/auth").Handler(authRoutes)
+	handler.router.PathPrefix("/auth").Handler(handler.metrics.countLogins(authRoutes))
 	handler.router.PathPrefix("/avatar").Handler(avaRoutes)
 
 	// Define routes
 	handler.router.HandleFunc("/", handler.handleGetHomePage).Methods("GET")
 	handler.router.HandleFunc("/p/", handler.handlePostPaste).Methods("POST")
 	handler.router.HandleFunc("/p/", handler.handleGetHomePage).Methods("GET")
-	handler.router.HandleFunc("/p/{id}", handler.handleGetPastePage).Methods("GET")
+	handler.router.HandleFunc("/p/{id}", handler.handleGetPastePage).Methods("GET", "HEAD")
 	handler.router.HandleFunc("/p/{id}", handler.handleGetPastePage).Methods("POST")
+	handler.router.HandleFunc("/p/{id}/raw", handler.handleGetPasteRaw).Methods("GET", "HEAD")
+	handler.router.HandleFunc("/p/{id}/download", handler.handleGetPasteDownload).Methods("GET", "HEAD")
+	handler.router.HandleFunc("/p/{id}/embed", handler.handleGetPasteEmbed).Methods("GET")
+	handler.router.HandleFunc("/p/{id}/stats", handler.handleGetPasteStats).Methods("GET")
+	handler.router.HandleFunc("/p/{id}/status", handler.handleGetPasteStatus).Methods("GET")
+	handler.router.HandleFunc("/p/{id}/report", handler.handlePostReport).Methods("POST")
 	handler.router.HandleFunc("/l/", handler.handleGetPastesList).Methods("GET")
+	handler.router.HandleFunc("/l/delete", handler.handlePostDeletePastes).Methods("POST")
+	handler.router.HandleFunc("/l/trash", handler.handleGetTrash).Methods("GET")
+	handler.router.HandleFunc("/p/{id}/restore", handler.handlePostRestore).Methods("POST")
+	handler.router.HandleFunc("/p/{id}/transfer", handler.handlePostTransfer).Methods("POST")
+	handler.router.HandleFunc("/p/{id}/claim", handler.handlePostClaim).Methods("POST")
+	handler.router.HandleFunc("/p/{id}/extend", handler.handlePostExtend).Methods("POST")
+	if opts.EnableComments {
+		handler.router.HandleFunc("/p/{id}/comment", handler.handlePostComment).Methods("POST")
+		handler.router.HandleFunc("/p/{id}/comment/{cid}/delete", handler.handlePostDeleteComment).Methods("POST")
+	}
 	handler.router.HandleFunc("/a/", handler.handleGetArchive).Methods("GET")
+	handler.router.HandleFunc("/diff", handler.handleGetDiff).Methods("GET", "POST")
+	handler.router.HandleFunc("/trending", handler.handleGetTrending).Methods("GET")
+	handler.router.HandleFunc("/healthz", handler.handleGetHealth).Methods("GET")
+	if opts.EnableLocalAuth {
+		handler.router.HandleFunc("/u/register", handler.handleGetRegister).Methods("GET")
+		handler.router.HandleFunc("/u/register", handler.handlePostRegister).Methods("POST")
+		handler.router.HandleFunc("/u/login", handler.handleGetLogin).Methods("GET")
+		handler.router.HandleFunc("/u/login", handler.handlePostLogin).Methods("POST")
+		handler.router.HandleFunc("/u/verify", handler.handleGetVerify).Methods("GET")
+		handler.router.HandleFunc("/u/forgot", handler.handleGetForgot).Methods("GET")
+		handler.router.HandleFunc("/u/forgot", handler.handlePostForgot).Methods("POST")
+		handler.router.HandleFunc("/u/reset", handler.handleGetReset).Methods("GET")
+		handler.router.HandleFunc("/u/reset", handler.handlePostReset).Methods("POST")
+	}
+	handler.router.HandleFunc("/u/delete", handler.handleGetDeleteUser).Methods("GET")
+	handler.router.HandleFunc("/u/delete", handler.handlePostDeleteUser).Methods("POST")
+	handler.router.HandleFunc("/admin", handler.handleGetAdmin).Methods("GET")
+	handler.router.HandleFunc("/admin/pastes/{id}/delete", handler.handlePostAdminDeletePaste).Methods("POST")
+	handler.router.HandleFunc("/admin/users/{id}/delete", handler.handlePostAdminDeleteUser).Methods("POST")
+	handler.router.HandleFunc("/admin/users/{id}/admin", handler.handlePostAdminUserAdmin).Methods("POST")
+	handler.router.HandleFunc("/admin/readonly", handler.handlePostAdminReadOnly).Methods("POST")
+	handler.router.HandleFunc("/api/v1/openapi.json", handler.handleGetOpenAPI).Methods("GET")
+	handler.router.HandleFunc("/api/v1/paste", handler.idempotent(handler.handleAPIPostPaste)).Methods("POST")
+	handler.router.HandleFunc("/api/v1/paste/{id}", handler.handleAPIGetPaste).Methods("GET")
+	handler.router.HandleFunc("/api/v1/paste/{id}", handler.handleAPIDeletePaste).Methods("DELETE")
+	handler.router.HandleFunc("/api/v1/paste/{id}/meta", handler.handleAPIGetPasteMeta).Methods("GET")
+	handler.router.HandleFunc("/api/v1/stats", handler.handleAPIGetStats).Methods("GET")
 
 	// Common error routes
-	handler.router.NotFoundHandler = handler.router.NewRoute().BuildOnly().HandlerFunc(handler.notFound).GetHandler()
+	handler.router.NotFoundHandler = handler.requestID(handler.securityHeaders(handler.router.NewRoute().BuildOnly().HandlerFunc(handler.notFound).GetHandler()))
 
 	return &handler
 }
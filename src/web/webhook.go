@@ -0,0 +1,101 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iliafrenkel/go-pb/src/store"
+)
+
+const (
+	webhookTimeout  = 5 * time.Second // timeout of a single webhook request
+	webhookAttempts = 3               // number of attempts before giving up
+	webhookBackoff  = time.Second     // delay before the first retry, doubles after each attempt
+)
+
+// webhookPayload is the JSON body sent to the webhook when a paste is
+// created. The body is only sent for pastes that anyone with the link can
+// read, i.e. not private, not protected by a password and not deleted after
+// read. The URL of the latter isn't sent either, fetching it, e.g. to unfurl
+// the link in a chat, would burn the paste.
+type webhookPayload struct {
+	Event           string    `json:"event"`
+	URL             string    `json:"url,omitempty"`
+	Title           string    `json:"title"`
+	Syntax          string    `json:"syntax"`
+	Privacy         string    `json:"privacy"`
+	DeleteAfterRead bool      `json:"delete_after_read"`
+	User            string    `json:"user"`
+	Created         time.Time `json:"created"`
+	Expires         time.Time `json:"expires"`
+	Body            string    `json:"body,omitempty"`
+}
+
+// notifyPasteCreated posts the new paste to the configured webhook. The
+// request is sent in the background and failures are only logged, so that
+// they never block paste creation.
+func (h *Server) notifyPasteCreated(r *http.Request, p store.Paste) {
+	if h.options.WebhookURL == "" {
+		return
+	}
+	payload := webhookPayload{
+		Event:           "paste.created",
+		Title:           p.Title,
+		Syntax:          p.Syntax,
+		Privacy:         p.Privacy,
+		DeleteAfterRead: p.DeleteAfterRead,
+		User:            p.User.Name,
+		Created:         p.CreatedAt,
+		Expires:         p.Expires,
+	}
+	if !p.DeleteAfterRead {
+		payload.URL = h.pasteURL(r, p)
+	}
+	if p.Privacy != "private" && p.Password == "" && !p.DeleteAfterRead {
+		payload.Body = p.Body
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		h.log.Logf("ERROR notifyPasteCreated: failed to encode payload: %v", err)
+		return
+	}
+
+	h.webhooks.Add(1)
+	go func() {
+		defer h.webhooks.Done()
+		if err := h.postWebhook(data); err != nil {
+			h.log.Logf("WARN webhook for paste %s failed: %v", p.URL(), err)
+		}
+	}()
+}
+
+// postWebhook sends the payload to the webhook retrying failed attempts.
+func (h *Server) postWebhook(data []byte) error {
+	client := http.Client{Timeout: webhookTimeout}
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var resp *http.Response
+		resp, err = client.Post(h.options.WebhookURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+			return nil
+		}
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return fmt.Errorf("%d attempts: %w", webhookAttempts, err)
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Ilia Frenkel. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE.txt file.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-pkgz/lgr"
+)
+
+// New pastes are posted to the webhook, protected pastes without the body
+// and burners without the URL either
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var got []webhookPayload
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	log := lgr.New(lgr.Debug, lgr.CallerFile, lgr.CallerFunc, lgr.Msec, lgr.LevelBraces)
+	opts := testServerOptions()
+	opts.WebhookURL = hook.URL
+	srv := New(log, opts)
+
+	form := url.Values{}
+	form.Add("title", "Public paste")
+	form.Add("body", "Public body")
+	form.Add("privacy", "public")
+	r, _ := http.NewRequest("POST", "/p/", strings.NewReader(form.Encode()))
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	srv.router.ServeHTTP(httptest.NewRecorder(), r)
+
+	r, _ = http.NewRequest("POST", "/api/v1/paste",
+		strings.NewReader(`{"title":"Protected paste","body":"Secret body","privacy":"public","password":"pwd","expires":"never"}`))
+	r.Header.Set("Content-Type", "application/json")
+	srv.router.ServeHTTP(httptest.NewRecorder(), r)
+
+	r, _ = http.NewRequest("POST", "/api/v1/paste",
+		strings.NewReader(`{"title":"Burner paste","body":"Burner body","privacy":"public","delete_after_read":true,"expires":"never"}`))
+	r.Header.Set("Content-Type", "application/json")
+	srv.router.ServeHTTP(httptest.NewRecorder(), r)
+
+	srv.webhooks.Wait()
+	if len(got) != 3 {
+		t.Fatalf("Webhook should be called 3 times, got %d", len(got))
+	}
+	for _, p := range got {
+		if p.Event != "paste.created" {
+			t.Errorf("Payload should describe the new paste, got %+v", p)
+		}
+		if p.Title == "Burner paste" {
+			if p.URL != "" || p.Body != "" || !p.DeleteAfterRead {
+				t.Errorf("Payload of a burner paste should have neither the URL nor the body, got %+v", p)
+			}
+			continue
+		}
+		if !strings.Contains(p.URL, "/p/") {
+			t.Errorf("Payload should have the URL of the new paste, got %+v", p)
+		}
+		switch p.Title {
+		case "Public paste":
+			if p.Body != "Public body" {
+				t.Errorf("Payload of a public paste should have the body, got [%s]", p.Body)
+			}
+		case "Protected paste":
+			if p.Body != "" {
+				t.Errorf("Payload of a protected paste should not have the body, got [%s]", p.Body)
+			}
+		default:
+			t.Errorf("Unexpected payload %+v", p)
+		}
+	}
+}